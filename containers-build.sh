@@ -1,4 +1,5 @@
-docker build -t rest ./rest
-docker build -t worker ./worker
+BUILD_ARGS="--build-arg VERSION=$(git describe --tags --always --dirty 2>/dev/null || echo dev) --build-arg GIT_SHA=$(git rev-parse HEAD 2>/dev/null) --build-arg BUILD_TIME=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+docker build $BUILD_ARGS -t rest ./rest
+docker build $BUILD_ARGS -t worker ./worker
 docker build -t grafana ./grafana
 docker build -t prometheus ./prometheus
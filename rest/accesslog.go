@@ -0,0 +1,118 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// --- Access Log ---
+
+// The access log is one JSON line per finished HTTP request, apart from the application log:
+// ACCESS_LOG turns it on and off at runtime, ACCESS_LOG_FILE sends it to a file instead of stdout.
+
+var accessLogMode = stringTunable("ACCESS_LOG", "off", "on", "off")
+
+// localsMessage is the fiber local holding the job a request created, for the access log.
+const localsMessage = "message"
+
+// accessEntry is one access log line. Stages are the time spent reaching each stage from the
+// previous one, in the order they happened.
+type accessEntry struct {
+	Time          string       `json:"time"`
+	Method        string       `json:"method"`
+	Path          string       `json:"path"`
+	Status        int          `json:"status"`
+	Client        string       `json:"client"`
+	UserAgent     string       `json:"user_agent,omitempty"`
+	RequestBytes  int          `json:"request_bytes"`
+	ResponseBytes int          `json:"response_bytes"`
+	DurationMs    float64      `json:"duration_ms"`
+	RequestID     string       `json:"request_id,omitempty"`
+	TraceID       string       `json:"trace_id,omitempty"`
+	Tenant        string       `json:"tenant,omitempty"`
+	JobType       string       `json:"job_type,omitempty"`
+	Stages        []stageTimer `json:"stages,omitempty"`
+}
+
+type stageTimer struct {
+	Name string  `json:"name"`
+	Ms   float64 `json:"ms"`
+}
+
+var accessLog = struct {
+	sync.Mutex
+	out io.Writer
+}{out: os.Stdout}
+
+// initAccessLog opens ACCESS_LOG_FILE when set.
+func initAccessLog() error {
+	path := envString("ACCESS_LOG_FILE", "")
+	if path == "" {
+		return nil
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	accessLog.out = file
+	return nil
+}
+
+// accessLogger is the fiber middleware writing the access log.
+func accessLogger(c *fiber.Ctx) error {
+	if accessLogMode.Get() != "on" {
+		return c.Next()
+	}
+	start := clock.Now()
+	err := c.Next()
+
+	// Errors are turned into responses after the middlewares ran, so take the status from them
+	status := c.Response().StatusCode()
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		status = fiberErr.Code
+	} else if err != nil {
+		status = fiber.StatusInternalServerError
+	}
+	responseBytes := len(c.Response().Body())
+	if c.Response().IsBodyStream() {
+		responseBytes = -1
+	}
+
+	entry := accessEntry{
+		Time:          start.UTC().Format(time.RFC3339Nano),
+		Method:        c.Method(),
+		Path:          c.Path(),
+		Status:        status,
+		Client:        clientIP(c),
+		UserAgent:     c.Get(fiber.HeaderUserAgent),
+		RequestBytes:  len(c.Request().Body()),
+		ResponseBytes: responseBytes,
+		DurationMs:    float64(since(start).Nanoseconds()) / 1_000_000,
+	}
+	if msg, ok := c.Locals(localsMessage).(*Message); ok {
+		entry.RequestID, entry.TraceID = msg.RequestID, msg.TraceID
+		entry.Tenant, entry.JobType = msg.Tenant, msg.JobType
+		stages := msg.Meta.Stages
+		for i := 1; i < len(stages); i++ {
+			entry.Stages = append(entry.Stages, stageTimer{
+				Name: stages[i].Name,
+				Ms:   float64(stages[i].TsNs-stages[i-1].TsNs) / 1_000_000,
+			})
+		}
+	}
+
+	line, marshalErr := codec.Marshal(entry)
+	if marshalErr == nil {
+		accessLog.Lock()
+		fmt.Fprintf(accessLog.out, "%s\n", line)
+		accessLog.Unlock()
+	}
+	return err
+}
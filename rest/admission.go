@@ -0,0 +1,105 @@
+package gateway
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go-async-proxy/metrics"
+)
+
+// --- Admission Control ---
+
+// A job that can't finish within WAIT_TIMEOUT only ends in a 504 after holding a connection
+// and costing a worker its time. With ADMISSION_CONTROL=on the gateway predicts, before the
+// push, when the job would complete: its place at the tail of its queue divided by the queue's
+// drain rate, plus the mean processing time of this replica's recent jobs. A prediction past
+// WAIT_TIMEOUT is answered 503 with the estimate, so the client can back off or come back
+// later. "observe" only counts the requests that would have been rejected. Nothing is
+// predicted while the drain rate is unknown, e.g. right after startup or on an idle queue.
+
+const (
+	admissionOff     = "off"
+	admissionObserve = "observe"
+	admissionOn      = "on"
+)
+
+var admissionControl = stringTunable("ADMISSION_CONTROL", admissionOff, admissionOff, admissionObserve, admissionOn)
+
+// admissionEstimate is when a job pushed now would complete.
+type admissionEstimate struct {
+	queueEstimate
+	Processing time.Duration
+}
+
+// Completion is the predicted time from push to result.
+func (e admissionEstimate) Completion() time.Duration {
+	return e.EstimatedWait + e.Processing
+}
+
+// predictCompletion estimates a job pushed now at the tail of queue, ok is false while the
+// queue's drain rate is unknown.
+func predictCompletion(queue string) (admissionEstimate, bool) {
+	ctxTimeout, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	length, err := rdb.LLen(ctxTimeout, queue).Result()
+	cancel()
+	if err != nil {
+		return admissionEstimate{}, false
+	}
+	estimate := admissionEstimate{queueEstimate: newQueueEstimate(queue, length+1)}
+	if estimate.EstimatedWait == 0 {
+		return admissionEstimate{}, false
+	}
+	estimate.Processing = time.Duration(meanStageSample("pull_to_push") * float64(time.Millisecond))
+	return estimate, true
+}
+
+// meanStageSample averages this replica's recent samples of a dashboard stage, in ms.
+func meanStageSample(name string) float64 {
+	stageSamples.Lock()
+	defer stageSamples.Unlock()
+	samples := stageSamples.values[name]
+	if len(samples) == 0 {
+		return 0
+	}
+	total := 0.0
+	for _, value := range samples {
+		total += value
+	}
+	return total / float64(len(samples))
+}
+
+// admissionRejected reports whether msg should be refused because it is not expected to
+// complete within WAIT_TIMEOUT, with the prediction.
+func admissionRejected(msg *Message) (admissionEstimate, bool) {
+	mode := admissionControl.Get()
+	if mode == admissionOff {
+		return admissionEstimate{}, false
+	}
+	estimate, ok := predictCompletion(jobQueueFor(msg))
+	if !ok || estimate.Completion() <= waitTimeout.Get() {
+		return admissionEstimate{}, false
+	}
+	metrics.CounterAdmissionRejections.WithLabelValues(mode).Inc()
+	return estimate, mode == admissionOn
+}
+
+// respondNotAdmitted answers 503 with the prediction and a Retry-After of how much it overruns
+// the wait timeout.
+func respondNotAdmitted(c *fiber.Ctx, msg *Message, estimate admissionEstimate) error {
+	timeout := waitTimeout.Get()
+	jobLogger(msg).Info("Not admitted", "predicted_ms", estimate.Completion().Milliseconds(), "timeout", timeout)
+	retry := max(int((estimate.Completion() - timeout).Seconds()), 1)
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retry))
+	return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+		"status":              "rejected",
+		"message":             "The job is not expected to complete within the wait timeout, please try again later.",
+		"queue_position":      estimate.Position,
+		"estimated_wait_ms":   estimate.EstimatedWait.Milliseconds(),
+		"processing_ms":       estimate.Processing.Milliseconds(),
+		"predicted_ms":        estimate.Completion().Milliseconds(),
+		"wait_timeout_ms":     timeout.Milliseconds(),
+		"retry_after_seconds": retry,
+	})
+}
@@ -0,0 +1,86 @@
+package gateway
+
+import (
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"time"
+
+	"go-async-proxy/metrics"
+)
+
+// --- Worker Affinity ---
+
+// With AFFINITY_PARTITIONS=n, a job sent with X-Affinity-Key (a customer ID, say) is pushed to
+// partition hash(key) mod n of its queue, <queue>:affinity:<partition>, instead of its tenant
+// queue. Workers split the partitions among themselves, so every job of a key lands on the same
+// worker while the fleet is stable, and handlers can keep warm caches per key. Both services
+// must agree on n.
+
+var affinityPartitions = envInt("AFFINITY_PARTITIONS", 0)
+
+func affinityQueueKey(queue string, partition int) string {
+	return queue + ":affinity:" + strconv.Itoa(partition)
+}
+
+// affinityPartition returns the partition of key, false when the job has no affinity.
+func affinityPartition(key string) (int, bool) {
+	if affinityPartitions == 0 || key == "" {
+		return 0, false
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(affinityPartitions)), true
+}
+
+// affinityQueues lists the partitions of queue.
+func affinityQueues(queue string) []string {
+	queues := make([]string, affinityPartitions)
+	for p := range queues {
+		queues[p] = affinityQueueKey(queue, p)
+	}
+	return queues
+}
+
+// affinityPushes counts the jobs this replica pushed to every partition since the last sample.
+var affinityPushes = struct {
+	sync.Mutex
+	byPartition []int
+}{}
+
+func countAffinityPush(partition int) {
+	metrics.CounterAffinityJobs.WithLabelValues(strconv.Itoa(partition)).Inc()
+	affinityPushes.Lock()
+	if affinityPushes.byPartition == nil {
+		affinityPushes.byPartition = make([]int, affinityPartitions)
+	}
+	affinityPushes.byPartition[partition]++
+	affinityPushes.Unlock()
+}
+
+// startAffinitySampler publishes how unevenly the keys spread over the partitions: the busiest
+// partition's pushes over the mean, 1 when even and n when one partition takes everything.
+func startAffinitySampler() {
+	if affinityPartitions == 0 {
+		return
+	}
+	go func() {
+		for {
+			time.Sleep(queueSampleInterval.Get())
+			affinityPushes.Lock()
+			pushes := affinityPushes.byPartition
+			affinityPushes.byPartition = nil
+			affinityPushes.Unlock()
+
+			total, busiest := 0, 0
+			for _, n := range pushes {
+				total += n
+				busiest = max(busiest, n)
+			}
+			if total == 0 {
+				continue
+			}
+			metrics.GaugeAffinitySkew.Set(float64(busiest) * float64(affinityPartitions) / float64(total))
+		}
+	}()
+}
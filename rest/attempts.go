@@ -0,0 +1,37 @@
+package gateway
+
+import "strings"
+
+// --- Attempts ---
+
+// Attempt is one run of the worker's handler on a job; the worker records every one of them.
+type Attempt struct {
+	WorkerID string `json:"worker_id" xml:"worker_id,attr"`
+	StartNs  int64  `json:"start_ns" xml:"start_ns,attr"`
+	EndNs    int64  `json:"end_ns" xml:"end_ns,attr"`
+	Error    string `json:"error,omitempty" xml:",chardata"`
+}
+
+const (
+	retriedInclude = "include"
+	retriedExclude = "exclude"
+)
+
+// retried reports whether the worker needed more than one attempt.
+func (m *Meta) retried() bool {
+	return len(m.Attempts) > 1
+}
+
+// lastError is the error of the final attempt, "" when the job succeeded.
+func (m *Meta) lastError() string {
+	if len(m.Attempts) == 0 {
+		return ""
+	}
+	return m.Attempts[len(m.Attempts)-1].Error
+}
+
+// expired reports whether the worker answered the job unprocessed because it waited in the
+// queue past the worker's MAX_QUEUE_AGE.
+func (m *Meta) expired() bool {
+	return strings.HasPrefix(m.lastError(), "expired:")
+}
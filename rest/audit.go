@@ -0,0 +1,170 @@
+package gateway
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Audit Log ---
+
+// Every administrative change (maintenance, control commands such as drains, session drops,
+// module uploads, workflow definitions) is appended to the validate:audit stream with who
+// made it, from where, its parameters and the status it was answered with, refused attempts
+// included. JSON bodies are kept up to AUDIT_BODY_BYTES, other bodies (modules) only by size.
+// The stream is capped at AUDIT_MAX_ENTRIES and read with GET /admin/audit.
+//
+// The actor is the operator the caller authenticated as: the ADMIN_TOKENS secret maps
+// operators to bearer tokens ("alice=token,bob=token"), and with it set every /admin and
+// /modules request needs "Authorization: Bearer <token>" or is refused with a 401. Without
+// ADMIN_TOKENS those routes are open and entries have no actor. Changes of the validate:config
+// overrides are recorded too, once for the fleet, with the actor config:<CONFIG_SOURCE>; who
+// edited the hash is not known to the gateway.
+
+const auditKey = "validate:audit"
+
+var (
+	auditBodyBytes  = envInt("AUDIT_BODY_BYTES", 4<<10)
+	auditMaxEntries = int64(envInt("AUDIT_MAX_ENTRIES", 100000))
+)
+
+// administrative reports whether the request is for an administrative route. Routes match
+// whatever the case of the path, so the prefixes must too.
+func administrative(c *fiber.Ctx) bool {
+	path := strings.ToLower(c.Path())
+	return strings.HasPrefix(path, "/admin/") || strings.HasPrefix(path, "/modules/")
+}
+
+// audited reports whether the request changes administrative state.
+func audited(c *fiber.Ctx) bool {
+	if c.Method() == fiber.MethodGet || c.Method() == fiber.MethodHead || c.Method() == fiber.MethodOptions {
+		return false
+	}
+	return administrative(c)
+}
+
+// adminActor returns the operator whose ADMIN_TOKENS token the caller presented, and false
+// when admin tokens are set and the caller presented none of them.
+func adminActor(c *fiber.Ctx) (string, bool) {
+	tokens := secret("ADMIN_TOKENS", "")
+	if tokens == "" {
+		return "", true
+	}
+	presented, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	if !ok || presented == "" {
+		return "", false
+	}
+	for _, pair := range strings.Split(tokens, ",") {
+		actor, token, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok && subtle.ConstantTimeCompare([]byte(token), []byte(presented)) == 1 {
+			return actor, true
+		}
+	}
+	return "", false
+}
+
+// adminGuard refuses administrative requests without a valid admin token; it runs inside
+// auditLogger, so refusals are audited.
+func adminGuard(c *fiber.Ctx) error {
+	if !administrative(c) {
+		return c.Next()
+	}
+	if _, ok := adminActor(c); !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "Missing or invalid admin token")
+	}
+	return c.Next()
+}
+
+// auditLogger records administrative requests once they were answered.
+func auditLogger(c *fiber.Ctx) error {
+	if !audited(c) {
+		return c.Next()
+	}
+	err := c.Next()
+	status := c.Response().StatusCode()
+	if fiberErr, ok := err.(*fiber.Error); ok {
+		status = fiberErr.Code
+	} else if err != nil {
+		status = fiber.StatusInternalServerError
+	}
+
+	body := c.Body()
+	var params string
+	if !strings.HasPrefix(string(c.Request().Header.ContentType()), fiber.MIMEApplicationJSON) && len(body) > 0 {
+		params = fmt.Sprintf("(%d bytes of %s)", len(body), c.Get(fiber.HeaderContentType, "unknown type"))
+	} else if params = redact(redactAudit, string(body)); len(params) > auditBodyBytes {
+		params = params[:auditBodyBytes] + "..."
+	}
+	actor, _ := adminActor(c)
+	entry := map[string]any{
+		"actor":   actor,
+		"key_id":  usageKeyID(c),
+		"client":  clientIP(c),
+		"method":  c.Method(),
+		"path":    c.Path(),
+		"query":   redact(redactAudit, string(c.Request().URI().QueryString())),
+		"params":  params,
+		"status":  status,
+		"tenant":  c.Get("X-Tenant"),
+		"gateway": instanceID,
+	}
+	appendAudit(entry)
+	return err
+}
+
+// appendAudit adds entry to the audit stream.
+func appendAudit(entry map[string]any) {
+	if err := rdb.XAdd(ctx, &redis.XAddArgs{Stream: auditKey, MaxLen: auditMaxEntries, Approx: true, Values: entry}).Err(); err != nil {
+		slog.Error("Cannot record audit entry", "path", entry["path"], "error", err)
+	}
+}
+
+// auditHandler serves GET /admin/audit?actor=&path=&cursor=&limit=, newest first. cursor is
+// the next_cursor of the previous page.
+func auditHandler(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 50)
+	if limit <= 0 || limit > maxJobsPageSize {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("'limit' must be within 1..%d", maxJobsPageSize))
+	}
+	start := "+"
+	if cursor := c.Query("cursor"); cursor != "" {
+		start = "(" + cursor
+	}
+	actor, pathPrefix := c.Query("actor"), c.Query("path")
+
+	entries := []map[string]any{}
+	nextCursor := ""
+	// Filtering happens client side of the stream, so keep reading pages until full
+	for len(entries) < limit {
+		page, err := rdb.XRevRangeN(ctx, auditKey, start, "-", int64(limit)).Result()
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Failed to read audit log")
+		}
+		for _, message := range page {
+			start, nextCursor = "("+message.ID, message.ID
+			if path, _ := message.Values["path"].(string); !strings.HasPrefix(path, pathPrefix) {
+				continue
+			}
+			if actor != "" && message.Values["actor"] != actor {
+				continue
+			}
+			entry := map[string]any{"id": message.ID}
+			for field, value := range message.Values {
+				entry[field] = value
+			}
+			entries = append(entries, entry)
+			if len(entries) == limit {
+				break
+			}
+		}
+		if len(page) < limit {
+			nextCursor = ""
+			break
+		}
+	}
+	return c.JSON(fiber.Map{"entries": entries, "next_cursor": nextCursor})
+}
@@ -0,0 +1,96 @@
+package gateway
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestAuditActorIsTheAuthenticatedOperator(t *testing.T) {
+	app, srv := startTestGateway(t)
+	t.Setenv("ADMIN_TOKENS", "alice=alice-token,bob=bob-token")
+
+	for _, tc := range []struct {
+		authorization string
+		status        int
+		actor         string
+	}{
+		{"Bearer bob-token", fiber.StatusNotFound, "bob"},
+		{"", fiber.StatusUnauthorized, ""},
+		{"Bearer guess", fiber.StatusUnauthorized, ""},
+	} {
+		req := httptest.NewRequest(fiber.MethodDelete, "/admin/workflows/nightly", nil)
+		req.Header.Set("X-Actor", "alice")
+		if tc.authorization != "" {
+			req.Header.Set(fiber.HeaderAuthorization, tc.authorization)
+		}
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Fatalf("Authorization %q: status %d, want %d", tc.authorization, resp.StatusCode, tc.status)
+		}
+
+		entries, _ := srv.Client.XRevRangeN(ctx, auditKey, "+", "-", 1).Result()
+		if len(entries) != 1 || entries[0].Values["actor"] != tc.actor {
+			t.Fatalf("Authorization %q: audited %v, want actor %q whatever X-Actor says", tc.authorization, entries, tc.actor)
+		}
+	}
+}
+
+func TestConfigChangesAreAuditedOnceForTheFleet(t *testing.T) {
+	_, srv := startTestGateway(t)
+	t.Cleanup(func() {
+		srv.Client.Del(ctx, configKey)
+		reloadConfig()
+		lastOverrides = nil
+	})
+	lastOverrides = nil
+	reloadConfig()
+
+	srv.Client.HSet(ctx, configKey, "BULK_STATUS_MAX", "7")
+	previous := lastOverrides
+	reloadConfig()
+	// A second gateway applying the same change
+	lastOverrides = previous
+	reloadConfig()
+
+	if bulkStatusMax.Get() != 7 {
+		t.Fatalf("BULK_STATUS_MAX = %d, want the override applied", bulkStatusMax.Get())
+	}
+	entries, _ := srv.Client.XRange(ctx, auditKey, "-", "+").Result()
+	if len(entries) != 1 {
+		t.Fatalf("audited %v, want the change once", entries)
+	}
+	if values := entries[0].Values; values["actor"] != "config:redis" || values["params"] != `{"BULK_STATUS_MAX":"7"}` {
+		t.Fatalf("audit entry = %v, want the BULK_STATUS_MAX change by config:redis", values)
+	}
+}
+
+func TestMixedCaseAdminPathsAreGuardedAndAudited(t *testing.T) {
+	app, srv := startTestGateway(t)
+	t.Setenv("ADMIN_TOKENS", "alice=alice-token")
+
+	for _, tc := range []struct{ method, path string }{
+		{fiber.MethodPut, "/Admin/maintenance"},
+		{fiber.MethodDelete, "/ADMIN/tenants/acme/data"},
+		{fiber.MethodDelete, "/Modules/uppercase"},
+	} {
+		before, _ := srv.Client.XLen(ctx, auditKey).Result()
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != fiber.StatusUnauthorized {
+			t.Errorf("%s %s: status %d without a token, want 401", tc.method, tc.path, resp.StatusCode)
+		}
+		if after, _ := srv.Client.XLen(ctx, auditKey).Result(); after != before+1 {
+			t.Errorf("%s %s: %d audit entries added, want 1", tc.method, tc.path, after-before)
+		}
+	}
+}
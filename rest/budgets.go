@@ -0,0 +1,136 @@
+package gateway
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go-async-proxy/metrics"
+)
+
+// --- Stage Budgets ---
+
+// STAGE_BUDGETS ("stage=duration,...", e.g. "queue_wait=200ms,processing=2s") gives stages of
+// the pipeline a latency budget. An overrun is always counted in
+// rest_stage_budget_exceeded_total{stage}; STAGE_BUDGET_POLICY decides what else happens:
+// "count" nothing, "flag" names the stages in X-Stage-Budget-Exceeded and an annotation, and
+// "fail" also fast-fails the stages that can still be cut short: the gateway stage before the
+// push, and the queue wait, which workers enforce when they pull the job.
+
+const (
+	budgetGateway    = "gateway"    // rest_request_received -> rest_request_pushed
+	budgetQueueWait  = "queue_wait" // rest_request_pushed -> worker_request_pulled
+	budgetProcessing = "processing" // worker_request_pulled -> worker_response_pushed
+	budgetReply      = "reply"      // worker_response_pushed -> rest_response_pulled
+	budgetTotal      = "total"      // rest_request_received -> rest_response_pulled
+
+	budgetPolicyCount = "count"
+	budgetPolicyFlag  = "flag"
+	budgetPolicyFail  = "fail"
+)
+
+// budgetStages lists the stages with their first and last event, in pipeline order.
+var budgetStages = []struct{ name, from, to string }{
+	{budgetGateway, stageRestRequestReceived, stageRestRequestPushed},
+	{budgetQueueWait, stageRestRequestPushed, stageWorkerRequestPulled},
+	{budgetProcessing, stageWorkerRequestPulled, stageWorkerResponsePushed},
+	{budgetReply, stageWorkerResponsePushed, stageRestResponsePulled},
+	{budgetTotal, stageRestRequestReceived, stageRestResponsePulled},
+}
+
+var (
+	// stageBudgets is the raw STAGE_BUDGETS, validated by parseStageBudgets.
+	stageBudgets = newTunable("STAGE_BUDGETS", envString("STAGE_BUDGETS", ""), func(raw string) (string, error) {
+		_, err := parseStageBudgets(raw)
+		return raw, err
+	})
+
+	// stageBudgetPolicy is "count", "flag" or "fail".
+	stageBudgetPolicy = stringTunable("STAGE_BUDGET_POLICY", budgetPolicyCount, budgetPolicyCount, budgetPolicyFlag, budgetPolicyFail)
+)
+
+// parseStageBudgets reads "stage=duration,stage=duration".
+func parseStageBudgets(raw string) (map[string]time.Duration, error) {
+	budgets := map[string]time.Duration{}
+	for _, pair := range strings.Split(raw, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !slices.ContainsFunc(budgetStages, func(s struct{ name, from, to string }) bool { return s.name == name }) {
+			return nil, fmt.Errorf("invalid stage budget %q: want stage=duration with stage one of gateway, queue_wait, processing, reply, total", pair)
+		}
+		budget, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || budget <= 0 {
+			return nil, fmt.Errorf("invalid stage budget %q: the duration must be positive", pair)
+		}
+		budgets[name] = budget
+	}
+	return budgets, nil
+}
+
+// stageBudget returns the budget of the stage, 0 when it has none.
+func stageBudget(name string) time.Duration {
+	budgets, _ := parseStageBudgets(stageBudgets.Get())
+	return budgets[name]
+}
+
+// checkGatewayBudget fast-fails a request that used up its gateway budget before the push,
+// under the fail policy.
+func checkGatewayBudget(msg *Message) error {
+	budget := stageBudget(budgetGateway)
+	if budget == 0 || stageBudgetPolicy.Get() != budgetPolicyFail {
+		return nil
+	}
+	if since(time.Unix(0, msg.Meta.At(stageRestRequestReceived))) <= budget {
+		return nil
+	}
+	metrics.CounterStageBudgetExceeded.WithLabelValues(budgetGateway).Inc()
+	return fiber.NewError(fiber.StatusServiceUnavailable, "Stage budget exceeded: gateway")
+}
+
+// setQueueDeadline tells the worker when to give up on the job instead of processing it, under
+// the fail policy. It must be called once the job is marked pushed.
+func setQueueDeadline(msg *Message) {
+	budget := stageBudget(budgetQueueWait)
+	if budget == 0 || stageBudgetPolicy.Get() != budgetPolicyFail {
+		return
+	}
+	msg.QueueDeadlineNs = msg.Meta.At(stageRestRequestPushed) + budget.Nanoseconds()
+}
+
+// checkStageBudgets counts the stages of a finished job that overran their budget and, unless
+// the policy only counts, flags them on the response.
+func checkStageBudgets(c *fiber.Ctx, msg *Message) {
+	budgets, _ := parseStageBudgets(stageBudgets.Get())
+	if len(budgets) == 0 {
+		return
+	}
+	var exceeded []string
+	for _, stage := range budgetStages {
+		budget, ok := budgets[stage.name]
+		if !ok {
+			continue
+		}
+		from, to := msg.Meta.At(stage.from), msg.Meta.At(stage.to)
+		if from == 0 || to == 0 || time.Duration(to-from) <= budget {
+			continue
+		}
+		metrics.CounterStageBudgetExceeded.WithLabelValues(stage.name).Inc()
+		exceeded = append(exceeded, stage.name)
+	}
+	if len(exceeded) == 0 || stageBudgetPolicy.Get() == budgetPolicyCount {
+		return
+	}
+	c.Set("X-Stage-Budget-Exceeded", strings.Join(exceeded, ","))
+	msg.Annotations = append(msg.Annotations, Annotation{Key: "stage_budget_exceeded", Value: strings.Join(exceeded, ",")})
+}
+
+// queueDeadlineMissed reports whether a worker failed the job unprocessed because it was pulled
+// after its queue deadline.
+func queueDeadlineMissed(msg *Message) bool {
+	return msg.QueueDeadlineNs > 0 && msg.Meta.At(stageWorkerRequestPulled) > msg.QueueDeadlineNs
+}
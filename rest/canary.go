@@ -0,0 +1,137 @@
+package gateway
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"go-async-proxy/metrics"
+)
+
+// --- Canary Routing ---
+
+// canaryFlag is the feature flag whose rollout is the share of jobs routed to the canary queue
+// instead of the main one, e.g. HSET validate:flags canary 5.
+const canaryFlag = "canary"
+
+const (
+	cohortStable = "stable"
+	cohortCanary = "canary"
+
+	outcomeCompleted = "completed"
+	outcomeFailed    = "failed"
+	outcomeTimeout   = "timeout"
+)
+
+var (
+	// canaryQueueKey is consumed by the canary workers (QUEUE_KEY on the worker).
+	canaryQueueKey = envString("CANARY_QUEUE", "validate:queue:canary")
+
+	// canaryMaxFailureRate is the SLO: a canary failing more than this share of its jobs is disabled.
+	canaryMaxFailureRate = envFloat("CANARY_MAX_FAILURE_RATE", 0.05)
+
+	// canaryMaxLatencyRatio disables a canary whose mean roundtrip is this many times the stable one.
+	canaryMaxLatencyRatio = envFloat("CANARY_MAX_LATENCY_RATIO", 2)
+
+	// canaryMinJobs is how many canary jobs a window needs before the SLO is judged.
+	canaryMinJobs = envInt("CANARY_MIN_JOBS", 20)
+)
+
+// cohortOf tells whether msg went through the canary workers.
+func cohortOf(msg *Message) string {
+	if msg.hasFlag(canaryFlag) {
+		return cohortCanary
+	}
+	return cohortStable
+}
+
+// baseQueueFor picks the queue msg is pushed to, before tenant queues.
+func baseQueueFor(msg *Message) string {
+	queue := queueKey
+	if msg.hasFlag(canaryFlag) {
+		queue = canaryQueueKey
+	}
+	if msg.Region != "" {
+		return regionQueueKey(queue, msg.Region)
+	}
+	return queue
+}
+
+// jobQueueFor picks the queue msg is pushed to: its affinity partition, else its tenant queue.
+func jobQueueFor(msg *Message) string {
+	base := baseQueueFor(msg)
+	if partition, ok := affinityPartition(msg.Affinity); ok {
+		return affinityQueueKey(base, partition)
+	}
+	return tenantQueueFor(base, msg.Tenant)
+}
+
+// cohortWindow accumulates this replica's outcomes per cohort until the next SLO check.
+var cohortWindow = struct {
+	sync.Mutex
+	jobs, failures map[string]int
+	roundtripMs    map[string]float64
+}{jobs: map[string]int{}, failures: map[string]int{}, roundtripMs: map[string]float64{}}
+
+// recordCohort counts the outcome of msg for its cohort; roundtripMs is only set for completed jobs.
+func recordCohort(msg *Message, outcome string, roundtripMs float64) {
+	cohort := cohortOf(msg)
+	metrics.CounterCohortOutcomes.WithLabelValues(cohort, outcome).Inc()
+
+	cohortWindow.Lock()
+	defer cohortWindow.Unlock()
+	cohortWindow.jobs[cohort]++
+	if outcome != outcomeCompleted {
+		cohortWindow.failures[cohort]++
+		return
+	}
+	cohortWindow.roundtripMs[cohort] += roundtripMs
+}
+
+// checkCanarySLO judges the last window and turns the canary flag off fleet-wide when the canary
+// fails too often or is much slower than the stable cohort.
+func checkCanarySLO() {
+	cohortWindow.Lock()
+	jobs, failures, roundtrip := cohortWindow.jobs, cohortWindow.failures, cohortWindow.roundtripMs
+	cohortWindow.jobs, cohortWindow.failures, cohortWindow.roundtripMs = map[string]int{}, map[string]int{}, map[string]float64{}
+	cohortWindow.Unlock()
+
+	if jobs[cohortCanary] < canaryMinJobs {
+		return
+	}
+	var reason string
+	failureRate := float64(failures[cohortCanary]) / float64(jobs[cohortCanary])
+	if failureRate > canaryMaxFailureRate {
+		reason = fmt.Sprintf("failure rate %.3f above %.3f", failureRate, canaryMaxFailureRate)
+	}
+	canaryOK, stableOK := jobs[cohortCanary]-failures[cohortCanary], jobs[cohortStable]-failures[cohortStable]
+	if reason == "" && canaryOK > 0 && stableOK > 0 {
+		canaryMean := roundtrip[cohortCanary] / float64(canaryOK)
+		stableMean := roundtrip[cohortStable] / float64(stableOK)
+		if canaryMean > stableMean*canaryMaxLatencyRatio {
+			reason = fmt.Sprintf("mean roundtrip %.1fms above %.1fx the stable %.1fms", canaryMean, canaryMaxLatencyRatio, stableMean)
+		}
+	}
+	if reason == "" {
+		return
+	}
+
+	slog.Warn("Disabling canary route", "reason", reason)
+	if err := rdb.HSet(ctx, flagsKey, canaryFlag, 0).Err(); err != nil {
+		slog.Error("Cannot disable the canary flag", "error", err)
+		return
+	}
+	metrics.CounterCanaryDisabled.Inc()
+	reloadFlags()
+}
+
+// startCanaryGuard checks the canary SLO every CANARY_CHECK_INTERVAL.
+func startCanaryGuard() {
+	interval := envDuration("CANARY_CHECK_INTERVAL", time.Minute)
+	go func() {
+		for range time.Tick(interval) {
+			checkCanarySLO()
+		}
+	}()
+}
@@ -0,0 +1,145 @@
+package gateway
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go-async-proxy/metrics"
+)
+
+// --- Chunked Results ---
+
+// ChunkRef describes a result the worker published in chunks: chunk n is stored at Key:n.
+// Checksums holds the CRC-32 of every chunk and SHA256 the digest of the whole content.
+type ChunkRef struct {
+	Key       string   `json:"key" xml:"key,attr"`
+	Count     int      `json:"count" xml:"count,attr"`
+	Size      int64    `json:"size" xml:"size,attr"`
+	SHA256    string   `json:"sha256" xml:"sha256,attr"`
+	Checksums []uint32 `json:"checksums" xml:"checksum"`
+}
+
+func chunkKey(requestId string) string {
+	return "validate:result:" + requestId
+}
+
+// readChunk fetches chunk n and checks it against its CRC-32.
+func readChunk(ref *ChunkRef, n int) ([]byte, error) {
+	if n >= len(ref.Checksums) {
+		return nil, fmt.Errorf("chunk %d has no checksum", n)
+	}
+	chunk, err := rdb.Get(ctx, ref.Key+":"+strconv.Itoa(n)).Bytes()
+	if err != nil {
+		return nil, fmt.Errorf("chunk %d: %w", n, err)
+	}
+	if crc32.ChecksumIEEE(chunk) != ref.Checksums[n] {
+		return nil, fmt.Errorf("chunk %d: checksum mismatch", n)
+	}
+	return chunk, nil
+}
+
+// assembleChunks reads the chunks back into msg.Data.Content, verifying every chunk and the
+// whole content.
+func assembleChunks(msg *Message) error {
+	ref := msg.Data.Chunks
+	var content strings.Builder
+	content.Grow(int(ref.Size))
+	digest := sha256.New()
+	for n := range ref.Count {
+		chunk, err := readChunk(ref, n)
+		if err != nil {
+			metrics.CounterChunkFailures.Inc()
+			return err
+		}
+		content.Write(chunk)
+		digest.Write(chunk)
+	}
+	if int64(content.Len()) != ref.Size || hex.EncodeToString(digest.Sum(nil)) != ref.SHA256 {
+		metrics.CounterChunkFailures.Inc()
+		return fmt.Errorf("assembled result does not match its digest")
+	}
+	msg.Data.Content, msg.Data.Chunks = content.String(), nil
+	return nil
+}
+
+// chunkKeys lists the keys of every chunk of ref.
+func chunkKeys(ref *ChunkRef) []string {
+	keys := make([]string, ref.Count)
+	for n := range keys {
+		keys[n] = ref.Key + ":" + strconv.Itoa(n)
+	}
+	return keys
+}
+
+// releaseChunks deletes the chunk keys once the result was handed over.
+func releaseChunks(ref *ChunkRef) {
+	_ = rdb.Del(ctx, chunkKeys(ref)...)
+}
+
+// respondChunked answers with a chunked result. Clients accepting application/octet-stream get
+// the raw content streamed chunk by chunk, and text results their digest in X-Content-SHA256;
+// a chunk failing its checksum aborts the stream. Text results are only streamed without result
+// middlewares, which need the whole content. Everything else is assembled and negotiated
+// as usual. release deletes the chunks afterwards.
+func respondChunked(c *fiber.Ctx, msg *Message, release bool) error {
+	ref := msg.Data.Chunks
+	setServerTiming(c, msg)
+	if c.Get(fiber.HeaderAccept) == fiber.MIMEOctetStream && (msg.Data.Binary || len(resultMiddlewares) == 0) {
+		c.Set(fiber.HeaderContentType, fiber.MIMEOctetStream)
+		c.Set("X-Request-ID", msg.RequestID)
+		c.Set("X-Result", strconv.FormatBool(msg.Data.Result))
+		binary := msg.Data.Binary
+		if !binary {
+			c.Set("X-Content-SHA256", ref.SHA256)
+		}
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			if release {
+				defer releaseChunks(ref)
+			}
+			digest := sha256.New()
+			for n := range ref.Count {
+				chunk, err := readChunk(ref, n)
+				if err != nil {
+					metrics.CounterChunkFailures.Inc()
+					jobLogger(msg).Error("Aborting chunked result", "error", err)
+					return
+				}
+				digest.Write(chunk)
+				if binary {
+					// Chunks are whole base64 quanta
+					if chunk, err = base64.StdEncoding.AppendDecode(nil, chunk); err != nil {
+						jobLogger(msg).Error("Aborting chunked result", "error", err)
+						return
+					}
+				}
+				if _, err := w.Write(chunk); err != nil {
+					return
+				}
+				if err := w.Flush(); err != nil {
+					return
+				}
+			}
+			if hex.EncodeToString(digest.Sum(nil)) != ref.SHA256 {
+				metrics.CounterChunkFailures.Inc()
+				jobLogger(msg).Error("Streamed result does not match its digest")
+			}
+		})
+		return nil
+	}
+
+	if release {
+		defer releaseChunks(ref)
+	}
+	if err := assembleChunks(msg); err != nil {
+		jobLogger(msg).Error("Cannot assemble result", "error", err)
+		return fiber.NewError(fiber.StatusBadGateway, "Corrupt chunked result")
+	}
+	return respondMessage(c, msg)
+}
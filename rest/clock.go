@@ -0,0 +1,33 @@
+package gateway
+
+import (
+	"time"
+)
+
+// --- Clock ---
+
+// Clock tells the time. Everything timestamping or timing the pipeline reads clock instead of
+// the time package, so tests can replace it, and CLOCK_SKEW (a tunable, negative values run
+// behind) warps it to rehearse skewed hosts. Timers and sleeps still run on real time.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the wall clock shifted by CLOCK_SKEW.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now().Add(clockSkew.Get())
+}
+
+var (
+	clock Clock = systemClock{}
+
+	// clockSkew shifts the clock, for fault injection; 0 in production.
+	clockSkew = newTunable("CLOCK_SKEW", envDuration("CLOCK_SKEW", 0), time.ParseDuration)
+)
+
+// since is time.Since on clock.
+func since(t time.Time) time.Duration {
+	return clock.Now().Sub(t)
+}
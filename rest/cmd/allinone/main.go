@@ -0,0 +1,46 @@
+// Command allinone runs the whole pipeline in one process, for laptops and demos without
+// Docker: an in-memory Redis (miniredis), a worker with -workers consumer goroutines and the
+// gateway on :3000, with the same endpoints and metrics as a deployment (the worker's on
+// METRICS_ADDR, :9100 by default; both services share the process's registry, so either
+// endpoint serves every metric). Every other setting is read from the environment as usual.
+// It is a module of its own, so the gateway and the worker don't depend on miniredis:
+//
+//	cd rest/cmd/allinone && go run . -workers 4
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	gateway "go-async-proxy"
+	worker "go-async-worker"
+)
+
+func main() {
+	workers := flag.Int("workers", 2, "how many jobs the worker processes side by side")
+	redisAddr := flag.String("redis", "127.0.0.1:6379", "address the in-memory Redis listens on")
+	flag.Parse()
+
+	broker := miniredis.NewMiniRedis()
+	if err := broker.StartAddr(*redisAddr); err != nil {
+		log.Fatalf("Cannot start in-memory redis error: %v", err)
+	}
+	// miniredis only expires keys when told time passed
+	go func() {
+		for range time.Tick(time.Second) {
+			broker.FastForward(time.Second)
+		}
+	}()
+	log.Printf("In-memory redis on %s", broker.Addr())
+
+	// Both services read these when they start, not when the process starts
+	os.Setenv("REDIS_ADDR", broker.Addr())
+	os.Setenv("WORKER_CONCURRENCY", strconv.Itoa(*workers))
+
+	go worker.Main()
+	gateway.Main()
+}
@@ -0,0 +1,250 @@
+// Command gen-dashboards emits the Grafana dashboards and the Prometheus alert rules of the
+// REST service and the worker from their metric catalogs (go-async-proxy/metrics and
+// worker.MetricSpecs), so neither drifts from the instrumentation. Every metric gets a panel;
+// only the counters of rateAlerts and the histograms' bucket saturation alert. It is a module
+// of its own, like cmd/allinone, as it reads both services. Run it after changing any metric:
+//
+//	cd rest/cmd/gen-dashboards && go run .
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"go-async-proxy/metrics"
+	worker "go-async-worker"
+)
+
+var quantiles = []float64{0.5, 0.95, 0.99}
+
+// rateAlert fires when the rate of a counter stays above perSecond for 5 minutes; 0 fires on
+// any increase.
+type rateAlert struct {
+	metric    string
+	perSecond float64
+	severity  string
+}
+
+// rateAlerts are the counters worth an alert. The others only get a panel: failure cache
+// hits and stores, for one, count the cache working, not a failure.
+var rateAlerts = []rateAlert{
+	{"rest_failure_total", 1, "critical"},
+	{"rest_replication_failures_total", 0.1, "warning"},
+	{"rest_late_webhook_failures_total", 0.1, "warning"},
+	{"rest_chunk_failures_total", 0, "critical"},
+	{"rest_secret_refresh_failures_total", 0, "warning"},
+	{"worker_panics_total", 0, "critical"},
+	{"worker_secret_refresh_failures_total", 0, "warning"},
+}
+
+// service is what is generated for one service's catalog.
+type service struct {
+	uid, title, group string
+	specs             []metrics.Spec
+	dashboard, alerts string
+}
+
+func main() {
+	restDashboard := flag.String("dashboard", "../../../grafana/provisioning/dashboards/rest-service-generated.json", "where to write the REST service's Grafana dashboard JSON")
+	restAlerts := flag.String("alerts", "../../../prometheus/rest-alerts.yml", "where to write the REST service's Prometheus alert rules")
+	workerDashboard := flag.String("worker-dashboard", "../../../grafana/provisioning/dashboards/worker-generated.json", "where to write the worker's Grafana dashboard JSON")
+	workerAlerts := flag.String("worker-alerts", "../../../prometheus/worker-alerts.yml", "where to write the worker's Prometheus alert rules")
+	flag.Parse()
+
+	var workerSpecs []metrics.Spec
+	for _, spec := range worker.MetricSpecs() {
+		workerSpecs = append(workerSpecs, metrics.Spec(spec))
+	}
+	services := []service{
+		{uid: "rest-service-generated", title: "REST Service (generated)", group: "rest-service", specs: metrics.Specs(), dashboard: *restDashboard, alerts: *restAlerts},
+		{uid: "worker-generated", title: "Worker (generated)", group: "worker", specs: workerSpecs, dashboard: *workerDashboard, alerts: *workerAlerts},
+	}
+	if err := checkRateAlerts(services); err != nil {
+		log.Fatalf("Invalid alert list error: %v", err)
+	}
+
+	for _, svc := range services {
+		dashboard, err := json.MarshalIndent(buildDashboard(svc), "", "  ")
+		if err != nil {
+			log.Fatalf("Cannot encode dashboard error: %v", err)
+		}
+		if err := os.WriteFile(svc.dashboard, append(dashboard, '\n'), 0o644); err != nil {
+			log.Fatalf("Cannot write dashboard error: %v", err)
+		}
+		if err := os.WriteFile(svc.alerts, []byte(buildAlerts(svc)), 0o644); err != nil {
+			log.Fatalf("Cannot write alerts error: %v", err)
+		}
+		fmt.Printf("Generated %s and %s from %d metrics\n", svc.dashboard, svc.alerts, len(svc.specs))
+	}
+}
+
+// checkRateAlerts makes sure every alerting metric is a counter of a catalog, so a renamed
+// metric can't silently lose its alert.
+func checkRateAlerts(services []service) error {
+	kinds := map[string]string{}
+	for _, svc := range services {
+		for _, spec := range svc.specs {
+			kinds[spec.Name] = spec.Kind
+		}
+	}
+	for _, alert := range rateAlerts {
+		if kind := kinds[alert.metric]; kind != "counter" {
+			return fmt.Errorf("%s is not a counter of any catalog", alert.metric)
+		}
+	}
+	return nil
+}
+
+// --- Dashboard ---
+
+type target struct {
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat"`
+	RefID        string `json:"refId"`
+}
+
+func buildDashboard(svc service) map[string]any {
+	var panels []map[string]any
+	y := 0
+	for _, row := range []struct{ kind, title string }{
+		{"counter", "Counters"},
+		{"gauge", "Gauges"},
+		{"histogram", "Latency histograms"},
+	} {
+		kind := row.kind
+		panels = append(panels, map[string]any{
+			"id":        len(panels) + 1,
+			"type":      "row",
+			"title":     row.title,
+			"collapsed": false,
+			"panels":    []any{},
+			"gridPos":   map[string]int{"h": 1, "w": 24, "x": 0, "y": y},
+		})
+		y++
+
+		column := 0
+		for _, spec := range svc.specs {
+			if spec.Kind != kind {
+				continue
+			}
+			panel := map[string]any{
+				"id":          len(panels) + 1,
+				"type":        "timeseries",
+				"title":       spec.Name,
+				"description": spec.Help,
+				"datasource":  "prometheus",
+				"targets":     targetsFor(spec),
+				"gridPos":     map[string]int{"h": 8, "w": 12, "x": column * 12, "y": y},
+			}
+			if spec.Kind == "histogram" {
+				panel["fieldConfig"] = map[string]any{"defaults": map[string]any{"unit": "ms"}}
+			}
+			panels = append(panels, panel)
+
+			column = (column + 1) % 2
+			if column == 0 {
+				y += 8
+			}
+		}
+		if column != 0 {
+			y += 8
+		}
+	}
+
+	return map[string]any{
+		"uid":           svc.uid,
+		"title":         svc.title,
+		"description":   "Generated by rest/cmd/gen-dashboards from the metric catalog. Do not edit by hand.",
+		"editable":      false,
+		"schemaVersion": 39,
+		"refresh":       "30s",
+		"time":          map[string]string{"from": "now-1h", "to": "now"},
+		"panels":        panels,
+	}
+}
+
+func targetsFor(spec metrics.Spec) []target {
+	by := ""
+	legend := spec.Name
+	if len(spec.Labels) > 0 {
+		by = " by (" + strings.Join(spec.Labels, ", ") + ")"
+		legend = "{{" + strings.Join(spec.Labels, "}} {{") + "}}"
+	}
+
+	switch spec.Kind {
+	case "counter":
+		return []target{{Expr: fmt.Sprintf("sum(rate(%s[1m]))%s", spec.Name, by), LegendFormat: legend, RefID: "A"}}
+	case "gauge":
+		return []target{{Expr: fmt.Sprintf("max(%s)%s", spec.Name, by), LegendFormat: legend, RefID: "A"}}
+	}
+
+	labelLegend := ""
+	if len(spec.Labels) > 0 {
+		labelLegend = legend
+	}
+	targets := make([]target, 0, len(quantiles))
+	for i, q := range quantiles {
+		targets = append(targets, target{
+			Expr:         histogramQuantile(spec, q),
+			LegendFormat: strings.TrimSpace(fmt.Sprintf("p%g %s", q*100, labelLegend)),
+			RefID:        string(rune('A' + i)),
+		})
+	}
+	return targets
+}
+
+func histogramQuantile(spec metrics.Spec, q float64) string {
+	by := strings.Join(append([]string{"le"}, spec.Labels...), ", ")
+	return fmt.Sprintf("histogram_quantile(%g, sum(rate(%s_bucket[5m])) by (%s))", q, spec.Name, by)
+}
+
+// --- Alerts ---
+
+func buildAlerts(svc service) string {
+	var b strings.Builder
+	b.WriteString("# Generated by rest/cmd/gen-dashboards from the metric catalog. Do not edit by hand.\n")
+	fmt.Fprintf(&b, "groups:\n  - name: %s\n    rules:\n", svc.group)
+
+	alerts := map[string]rateAlert{}
+	for _, alert := range rateAlerts {
+		alerts[alert.metric] = alert
+	}
+	for _, spec := range svc.specs {
+		if spec.Kind == "histogram" && len(spec.Buckets) > 0 {
+			// Observations beyond the largest bucket make every quantile above it meaningless
+			top := spec.Buckets[len(spec.Buckets)-1]
+			writeAlert(&b, alertName(spec.Name)+"BucketsSaturated",
+				fmt.Sprintf("%s > %g", histogramQuantile(spec, 0.99), top), "10m", "warning",
+				fmt.Sprintf("p99 of %s is beyond its largest bucket (%g ms); extend its buckets", spec.Name, top))
+		}
+		if alert, ok := alerts[spec.Name]; ok {
+			writeAlert(&b, alertName(spec.Name)+"High",
+				fmt.Sprintf("sum(rate(%s[5m])) > %g", spec.Name, alert.perSecond), "5m", alert.severity,
+				fmt.Sprintf("%s is above %g/s: %s", spec.Name, alert.perSecond, spec.Help))
+		}
+	}
+	return b.String()
+}
+
+func writeAlert(b *strings.Builder, name, expr, duration, severity, summary string) {
+	fmt.Fprintf(b, "      - alert: %s\n", name)
+	fmt.Fprintf(b, "        expr: %s\n", expr)
+	fmt.Fprintf(b, "        for: %s\n", duration)
+	fmt.Fprintf(b, "        labels:\n          severity: %s\n", severity)
+	fmt.Fprintf(b, "        annotations:\n          summary: %q\n", summary)
+}
+
+// alertName turns rest_failure_total into RestFailureTotal.
+func alertName(metric string) string {
+	parts := strings.Split(metric, "_")
+	for i, part := range parts {
+		if part != "" {
+			parts[i] = strings.ToUpper(part[:1]) + part[1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
@@ -0,0 +1,117 @@
+// Command replay-fixtures submits the fixtures recorded with FIXTURE_DIR to a running gateway
+// and compares every result with the recorded one, so real traffic becomes a regression test
+// for the handler and worker code. It exits non-zero when any result differs:
+//
+//	go run ./cmd/replay-fixtures -dir fixtures -url http://localhost:3000
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+)
+
+// fixture mirrors the gateway's Fixture, keeping the fields the comparison needs.
+type fixture struct {
+	RequestID string `json:"request_id"`
+	JobType   string `json:"job_type"`
+	Redacted  bool   `json:"redacted"`
+	Request   data   `json:"request"`
+	Response  data   `json:"response"`
+}
+
+type data struct {
+	Content string `json:"content"`
+	Binary  bool   `json:"binary"`
+	Result  bool   `json:"result"`
+	Rules   []struct {
+		ID      string `json:"id"`
+		Passed  bool   `json:"passed"`
+		Message string `json:"message"`
+	} `json:"rules"`
+}
+
+func main() {
+	dir := flag.String("dir", "fixtures", "directory holding the recorded fixtures")
+	gateway := flag.String("url", "http://localhost:3000", "base URL of the gateway")
+	timeout := flag.Duration("timeout", time.Minute, "timeout of every replayed request")
+	flag.Parse()
+
+	paths, err := filepath.Glob(filepath.Join(*dir, "*.json"))
+	if err != nil {
+		log.Fatalf("Cannot list fixtures error: %v", err)
+	}
+	client := &http.Client{Timeout: *timeout}
+
+	failed := 0
+	for _, path := range paths {
+		if err := replay(client, *gateway, path); err != nil {
+			failed++
+			fmt.Printf("FAIL %s: %v\n", filepath.Base(path), err)
+			continue
+		}
+		fmt.Printf("ok   %s\n", filepath.Base(path))
+	}
+	fmt.Printf("%d fixtures, %d failed\n", len(paths), failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// replay submits one fixture and compares the result with the recorded one.
+func replay(client *http.Client, gateway, path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var f fixture
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return fmt.Errorf("invalid fixture: %w", err)
+	}
+
+	query := url.Values{"content": {f.Request.Content}}
+	if f.Request.Binary {
+		query.Set("encoding", "base64")
+	}
+	if f.JobType != "" {
+		query.Set("type", f.JobType)
+	}
+	resp, err := client.Get(gateway + "/validate?" + query.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("gateway responded %d: %s", resp.StatusCode, body)
+	}
+
+	var result struct {
+		Data data `json:"data"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	got := result.Data
+	if f.Redacted {
+		// The handler saw the redacted content, so only the verdict is comparable
+		got.Content = f.Response.Content
+	}
+	if !reflect.DeepEqual(got, f.Response) {
+		want, _ := json.Marshal(f.Response)
+		have, _ := json.Marshal(got)
+		return fmt.Errorf("result differs\n  want %s\n  got  %s", want, have)
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+// Command rest is the gateway: it answers the REST API synchronously and hands the jobs to the
+// workers through Redis, see the gateway package for its configuration.
+package main
+
+import gateway "go-async-proxy"
+
+func main() {
+	gateway.Main()
+}
@@ -0,0 +1,5 @@
+//go:build !codec_std
+
+package gateway
+
+const defaultCodec = "jsoniter"
@@ -0,0 +1,61 @@
+package gateway
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// The codec benchmarks run on the golden envelopes, see envelope_test.go; compare them with
+// go test -bench Codec -benchmem, e.g. before changing the default codec.
+
+func BenchmarkCodecMarshal(b *testing.B) {
+	msg := goldenRequest()
+	for name, c := range codecs {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := c.Marshal(msg); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkCodecUnmarshal(b *testing.B) {
+	payload, err := stdCodec{}.Marshal(goldenRequest())
+	if err != nil {
+		b.Fatal(err)
+	}
+	for name, c := range codecs {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(payload)))
+			for i := 0; i < b.N; i++ {
+				var msg Message
+				if err := c.Unmarshal(payload, &msg); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkCodecMsgpack encodes a result the way respondMessage does for Accept:
+// application/msgpack.
+func BenchmarkCodecMsgpack(b *testing.B) {
+	msg := goldenRequest()
+	var buf bytes.Buffer
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		enc := msgpack.NewEncoder(&buf)
+		enc.SetCustomStructTag("json")
+		enc.SetOmitEmpty(true)
+		if err := enc.Encode(msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
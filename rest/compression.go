@@ -0,0 +1,64 @@
+package gateway
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// --- Response Compression ---
+
+// Responses are compressed with brotli or gzip, whichever the client's Accept-Encoding
+// prefers, at COMPRESSION_LEVEL: speed, default or best, "off" disables it. Only bodies of at
+// least COMPRESSION_MIN_BYTES whose Content-Type starts with one of COMPRESSION_TYPES (comma
+// separated) are compressed; streamed bodies, such as chunked results, are compressed whatever
+// their size. Error responses written by fiber's error handler stay uncompressed.
+
+const (
+	compressionOff     = "off"
+	compressionSpeed   = "speed"
+	compressionDefault = "default"
+	compressionBest    = "best"
+)
+
+var compressionLevel = stringTunable("COMPRESSION_LEVEL", compressionDefault, compressionOff, compressionSpeed, compressionDefault, compressionBest)
+
+// newCompressionHandler returns the compression middleware.
+func newCompressionHandler() fiber.Handler {
+	noop := func(*fasthttp.RequestCtx) {}
+	compressors := map[string]fasthttp.RequestHandler{
+		compressionSpeed:   fasthttp.CompressHandlerBrotliLevel(noop, fasthttp.CompressBrotliBestSpeed, fasthttp.CompressBestSpeed),
+		compressionDefault: fasthttp.CompressHandlerBrotliLevel(noop, fasthttp.CompressBrotliDefaultCompression, fasthttp.CompressDefaultCompression),
+		compressionBest:    fasthttp.CompressHandlerBrotliLevel(noop, fasthttp.CompressBrotliBestCompression, fasthttp.CompressBestCompression),
+	}
+	minBytes := envInt("COMPRESSION_MIN_BYTES", 1024)
+	var types []string
+	for _, contentType := range strings.Split(envString("COMPRESSION_TYPES", "application/json,application/xml,text/plain,text/html,text/csv"), ",") {
+		if contentType = strings.TrimSpace(contentType); contentType != "" {
+			types = append(types, contentType)
+		}
+	}
+
+	compressible := func(resp *fasthttp.Response) bool {
+		if !resp.IsBodyStream() && len(resp.Body()) < minBytes {
+			return false
+		}
+		contentType := string(resp.Header.ContentType())
+		for _, prefix := range types {
+			if strings.HasPrefix(contentType, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	return func(c *fiber.Ctx) error {
+		if err := c.Next(); err != nil {
+			return err
+		}
+		if compressor, ok := compressors[compressionLevel.Get()]; ok && compressible(c.Response()) {
+			compressor(c.Context())
+		}
+		return nil
+	}
+}
@@ -0,0 +1,200 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// --- Control Channel ---
+
+// POST /admin/control broadcasts a command to every worker and gateway on a Pub/Sub channel.
+// Every receiver acknowledges in validate:control:ack:<id> with "ok", "ignored" (the command
+// doesn't concern it) or "error: ...", which GET /admin/control/:id reports next to the
+// number of receivers the command reached.
+
+// controlChannel carries ControlMessages to every worker and gateway.
+const controlChannel = "validate:control"
+
+// controlAckTTL bounds how long commands and their acknowledgments are kept.
+const controlAckTTL = time.Hour
+
+const (
+	controlDrain             = "drain"
+	controlResume            = "resume"
+	controlReloadConfig      = "reload_config"
+	controlFlushCaches       = "flush_caches"
+	controlLogLevel          = "log_level"
+	controlInvalidateSession = "invalidate_session"
+)
+
+var controlActions = []string{controlDrain, controlResume, controlReloadConfig, controlFlushCaches, controlLogLevel, controlInvalidateSession}
+
+// ControlMessage is an instruction to the whole fleet. Key selects an affinity key
+// (invalidate_session, "" means every key), Value carries the level of log_level.
+type ControlMessage struct {
+	ID     string `json:"id"`
+	Action string `json:"action"`
+	Key    string `json:"key,omitempty"`
+	Value  string `json:"value,omitempty"`
+}
+
+// controlRecord is what GET /admin/control/:id reports about a sent command.
+type controlRecord struct {
+	Command   ControlMessage `json:"command"`
+	Receivers int64          `json:"receivers"`
+	SentAtMs  int64          `json:"sent_at_ms"`
+}
+
+func controlKey(id string) string {
+	return "validate:control:" + id
+}
+
+func controlAckKey(id string) string {
+	return "validate:control:ack:" + id
+}
+
+// errControlIgnored acknowledges a command that only concerns workers.
+var errControlIgnored = errors.New("ignored")
+
+// draining fails the readiness check, so the load balancer stops sending new requests while
+// the requests in progress finish.
+var draining atomic.Bool
+
+// startControlListener applies the control messages and acknowledges them.
+func startControlListener() error {
+	sub := rdb.Subscribe(ctx, controlChannel)
+	if _, err := sub.Receive(ctx); err != nil {
+		return err
+	}
+	go func() {
+		for m := range sub.Channel() {
+			var control ControlMessage
+			if err := codec.Unmarshal([]byte(m.Payload), &control); err != nil {
+				slog.Warn("Invalid control message", "error", err)
+				continue
+			}
+			result := "ok"
+			if err := applyControl(control); errors.Is(err, errControlIgnored) {
+				result = err.Error()
+			} else if err != nil {
+				result = "error: " + err.Error()
+			}
+			slog.Info("Control message", "id", control.ID, "action", control.Action, "result", result)
+			if control.ID == "" {
+				continue
+			}
+			pipe := rdb.Pipeline()
+			pipe.HSet(ctx, controlAckKey(control.ID), "gateway:"+instanceID, result)
+			pipe.Expire(ctx, controlAckKey(control.ID), controlAckTTL)
+			if _, err := pipe.Exec(ctx); err != nil {
+				slog.Warn("Cannot acknowledge control message", "id", control.ID, "error", err)
+			}
+		}
+	}()
+	return nil
+}
+
+func applyControl(control ControlMessage) error {
+	switch control.Action {
+	case controlDrain:
+		draining.Store(true)
+	case controlResume:
+		draining.Store(false)
+	case controlReloadConfig:
+		reloadConfig()
+		reloadFlags()
+		loadMaintenance()
+	case controlFlushCaches:
+		return flushFailureCache()
+	case controlLogLevel:
+		// Holds until the config changes
+		if _, err := logLevel.apply(control.Value); err != nil {
+			return err
+		}
+	case controlInvalidateSession:
+		return errControlIgnored
+	default:
+		return fmt.Errorf("unknown action %q", control.Action)
+	}
+	return nil
+}
+
+// sendControl broadcasts control under a new ID and records it for GET /admin/control/:id.
+func sendControl(control ControlMessage) (controlRecord, error) {
+	control.ID = uuid.NewString()
+	payload, err := codec.Marshal(control)
+	if err != nil {
+		return controlRecord{}, err
+	}
+	receivers, err := rdb.Publish(ctx, controlChannel, payload).Result()
+	if err != nil {
+		return controlRecord{}, err
+	}
+	record := controlRecord{Command: control, Receivers: receivers, SentAtMs: clock.Now().UnixMilli()}
+	if raw, err := codec.Marshal(record); err == nil {
+		_ = rdb.Set(ctx, controlKey(control.ID), raw, controlAckTTL).Err()
+	}
+	return record, nil
+}
+
+// controlHandler sends the command in the body, {"action", "key", "value"}.
+func controlHandler(c *fiber.Ctx) error {
+	var control ControlMessage
+	if err := codec.Unmarshal(c.Body(), &control); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid command")
+	}
+	if !slices.Contains(controlActions, control.Action) {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Unknown action %q", control.Action))
+	}
+	if control.Action == controlLogLevel {
+		if _, err := logLevel.parse(control.Value); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+	}
+	record, err := sendControl(control)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to publish on the control channel")
+	}
+	return c.Status(fiber.StatusAccepted).JSON(record)
+}
+
+// controlStatusHandler reports a sent command and who acknowledged it.
+func controlStatusHandler(c *fiber.Ctx) error {
+	id := c.Params("id")
+	raw, err := rdb.Get(ctx, controlKey(id)).Bytes()
+	if err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "Unknown command")
+	}
+	var record controlRecord
+	if err := codec.Unmarshal(raw, &record); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to decode command")
+	}
+	acks, err := rdb.HGetAll(ctx, controlAckKey(id)).Result()
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to read acknowledgments")
+	}
+	return c.JSON(fiber.Map{
+		"command":    record.Command,
+		"receivers":  record.Receivers,
+		"sent_at_ms": record.SentAtMs,
+		"acks":       acks,
+		"pending":    max(record.Receivers-int64(len(acks)), 0),
+	})
+}
+
+// invalidateSessionsHandler drops the session of :key on every worker, or all sessions
+// without :key.
+func invalidateSessionsHandler(c *fiber.Ctx) error {
+	record, err := sendControl(ControlMessage{Action: controlInvalidateSession, Key: c.Params("key")})
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to publish on the control channel")
+	}
+	return c.Status(fiber.StatusAccepted).JSON(record)
+}
@@ -0,0 +1,44 @@
+package gateway
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+)
+
+// --- CORS ---
+
+// CORS_ORIGINS lets browser clients (demo UIs, SPAs) on other origins call the gateway: a comma
+// separated list of origins, or "*" for any, "" (the default) sends no CORS headers at all.
+// Preflight OPTIONS requests are answered by the middleware before any route sees them.
+// CORS_HEADERS replaces the request headers browsers may send, CORS_MAX_AGE is how long they
+// may cache a preflight.
+
+// corsDefaultHeaders are the request headers the endpoints read.
+var corsDefaultHeaders = []string{
+	fiber.HeaderContentType, fiber.HeaderAccept, fiber.HeaderAuthorization, "traceparent",
+	"X-Tenant", "X-API-Key", "X-Affinity-Key", "X-Failure-Cache", "X-Team", "X-Cost-Center", "X-Nonce", "X-Depends-On", "X-Data-Region",
+}
+
+// corsExposedHeaders are the response headers browser scripts may read.
+var corsExposedHeaders = []string{
+	fiber.HeaderRetryAfter, fiber.HeaderETag, "Server-Timing", "X-Request-ID", "X-Trace-ID", "X-Result", "X-Content-SHA256",
+	"X-Queue-Position", "X-Estimated-Wait-Ms", "X-Failure-Cache", "X-Stage-Budget-Exceeded", "X-Nonce-Replayed", "X-Timeout-Stage",
+}
+
+// newCORSHandler returns the CORS middleware, nil when CORS_ORIGINS is unset.
+func newCORSHandler() fiber.Handler {
+	origins := envString("CORS_ORIGINS", "")
+	if origins == "" {
+		return nil
+	}
+	return cors.New(cors.Config{
+		AllowOrigins:  origins,
+		AllowMethods:  strings.Join([]string{fiber.MethodGet, fiber.MethodPost, fiber.MethodPut, fiber.MethodDelete, fiber.MethodHead}, ","),
+		AllowHeaders:  envString("CORS_HEADERS", strings.Join(corsDefaultHeaders, ",")),
+		ExposeHeaders: strings.Join(corsExposedHeaders, ","),
+		MaxAge:        int(envDuration("CORS_MAX_AGE", 10*time.Minute).Seconds()),
+	})
+}
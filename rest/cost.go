@@ -0,0 +1,90 @@
+package gateway
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/gofiber/fiber/v2"
+	"go-async-proxy/metrics"
+)
+
+// --- Cost Attribution ---
+
+// Jobs carry the team and cost center they are billed to. The labels come from COST_LABELS
+// for the caller's API key, else from the X-Team and X-Cost-Center headers. They travel with
+// the job to the worker and back, and label the cost metrics of both services and the
+// analytics export.
+
+const costUnattributed = "unattributed"
+
+// CostLabels attribute a job's cost.
+type CostLabels struct {
+	Team       string `json:"team,omitempty"`
+	CostCenter string `json:"cost_center,omitempty"`
+}
+
+// costLabelsByKey holds the labels of every API key, from COST_LABELS (a JSON object of key id,
+// as shown by GET /usage, to CostLabels). The raw keys never appear in the configuration.
+var costLabelsByKey = map[string]CostLabels{}
+
+// costLabelPattern bounds header supplied values, which end up as metric labels.
+var costLabelPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+func initCostLabels() error {
+	raw := envString("COST_LABELS", "")
+	if raw == "" {
+		return nil
+	}
+	if err := codec.Unmarshal([]byte(raw), &costLabelsByKey); err != nil {
+		return fmt.Errorf("invalid COST_LABELS: %w", err)
+	}
+	for keyID, labels := range costLabelsByKey {
+		for _, value := range []string{labels.Team, labels.CostCenter} {
+			if value != "" && !costLabelPattern.MatchString(value) {
+				return fmt.Errorf("invalid COST_LABELS for %q: %q must match %s", keyID, value, costLabelPattern)
+			}
+		}
+	}
+	return nil
+}
+
+// costLabelsFor returns the labels of the caller, nil when there are none. The API key's
+// labels win over the headers, which the caller controls.
+func costLabelsFor(c *fiber.Ctx) *CostLabels {
+	if labels, ok := costLabelsByKey[usageKeyID(c)]; ok {
+		return &labels
+	}
+	var labels CostLabels
+	if team := c.Get("X-Team"); costLabelPattern.MatchString(team) {
+		labels.Team = team
+	}
+	if costCenter := c.Get("X-Cost-Center"); costLabelPattern.MatchString(costCenter) {
+		labels.CostCenter = costCenter
+	}
+	if labels == (CostLabels{}) {
+		return nil
+	}
+	return &labels
+}
+
+// values returns the metric label values, unattributed for missing ones.
+func (l *CostLabels) values() (team, costCenter string) {
+	team, costCenter = costUnattributed, costUnattributed
+	if l != nil && l.Team != "" {
+		team = l.Team
+	}
+	if l != nil && l.CostCenter != "" {
+		costCenter = l.CostCenter
+	}
+	return team, costCenter
+}
+
+// recordCost counts a finished job and the worker time it took against its labels.
+func recordCost(msg *Message, outcome string) {
+	team, costCenter := msg.Cost.values()
+	metrics.CounterCostJobs.WithLabelValues(team, costCenter, outcome).Inc()
+	pulled, pushed := msg.Meta.At(stageWorkerRequestPulled), msg.Meta.At(stageWorkerResponsePushed)
+	if pulled > 0 && pushed > pulled {
+		metrics.CounterCostProcessingMs.WithLabelValues(team, costCenter).Add(float64(pushed-pulled) / 1e6)
+	}
+}
@@ -0,0 +1,99 @@
+package gateway
+
+import (
+	"embed"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/filesystem"
+)
+
+// --- Dashboard ---
+
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+const (
+	dlqKey            = "validate:dlq"
+	stageSampleWindow = 60
+	dashboardListSize = 20
+)
+
+var stageNames = []string{"request_to_push", "push_to_pull", "pull_to_push", "push_to_pull_back", "pull_to_response", "roundtrip"}
+
+// stageSamples keeps the last stageSampleWindow per-stage durations (ms) of this replica for sparklines.
+var stageSamples = struct {
+	sync.Mutex
+	values map[string][]float64
+}{values: map[string][]float64{}}
+
+func recordStageSamples(durationsMs ...float64) {
+	stageSamples.Lock()
+	defer stageSamples.Unlock()
+	for i, value := range durationsMs {
+		samples := append(stageSamples.values[stageNames[i]], value)
+		if len(samples) > stageSampleWindow {
+			samples = samples[len(samples)-stageSampleWindow:]
+		}
+		stageSamples.values[stageNames[i]] = samples
+	}
+}
+
+func registerDashboard(app *fiber.App) {
+	route(app, fiber.MethodGet, "/dashboard/api/state", dashboardStateHandler, apiOperation{
+		Summary:   "Pipeline state rendered by the dashboard",
+		Responses: map[int]string{200: "Queues, fleet, recent jobs, stage samples and DLQ head"},
+	})
+	app.Use("/dashboard", filesystem.New(filesystem.Config{
+		Root:       http.FS(dashboardFiles),
+		PathPrefix: "dashboard",
+		Index:      "index.html",
+	}))
+}
+
+// dashboardStateHandler returns everything the dashboard page renders in a single call.
+func dashboardStateHandler(c *fiber.Ctx) error {
+	pipe := rdb.Pipeline()
+	queued := pipe.LLen(ctx, queueKey)
+	dlqSize := pipe.LLen(ctx, dlqKey)
+	dlq := pipe.LRange(ctx, dlqKey, 0, dashboardListSize-1)
+	recent := pipe.ZRevRange(ctx, jobsIndexKey, 0, dashboardListSize-1)
+	_, _ = pipe.Exec(ctx)
+
+	jobs := make([]JobInfo, 0, dashboardListSize)
+	for _, id := range recent.Val() {
+		fields, err := rdb.HGetAll(ctx, jobInfoKey(id)).Result()
+		if err != nil || len(fields) == 0 {
+			continue
+		}
+		jobs = append(jobs, JobInfo{RequestID: id, Status: fields["status"], Queue: fields["queue"], Tenant: fields["tenant"]})
+	}
+
+	stageSamples.Lock()
+	stages := make(map[string][]float64, len(stageSamples.values))
+	for name, values := range stageSamples.values {
+		stages[name] = append([]float64(nil), values...)
+	}
+	stageSamples.Unlock()
+
+	return c.JSON(fiber.Map{
+		"queues":   fiber.Map{queueKey: queued.Val(), dlqKey: dlqSize.Val()},
+		"gateways": liveInstances("validate:gateway:"),
+		"workers":  liveInstances("validate:worker:"),
+		"jobs":     jobs,
+		"stages":   stages,
+		"dlq":      dlq.Val(),
+	})
+}
+
+// liveInstances lists the heartbeat keys under prefix, i.e. the live members of a fleet.
+func liveInstances(prefix string) []string {
+	instances := []string{}
+	iter := rdb.Scan(ctx, 0, prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		instances = append(instances, strings.TrimPrefix(iter.Val(), prefix))
+	}
+	return instances
+}
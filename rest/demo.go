@@ -0,0 +1,22 @@
+package gateway
+
+import (
+	_ "embed"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// --- Demo UI ---
+
+// The page at / submits content to /validate and draws the stage timestamps of the answer's
+// Meta as a timeline, to show the synchronous call riding on the asynchronous pipeline.
+
+//go:embed demo/index.html
+var demoPage []byte
+
+func registerDemo(app *fiber.App) {
+	route(app, fiber.MethodGet, "/", func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+		return c.Send(demoPage)
+	}, apiOperation{Summary: "Demo page submitting content and showing its roundtrip stage by stage", Responses: map[int]string{200: "HTML page"}})
+}
@@ -0,0 +1,145 @@
+package gateway
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+	"go-async-proxy/metrics"
+)
+
+// --- Job Dependencies ---
+
+// A submission with X-Depends-On: <request_id>,... is held in the "waiting" status until every
+// job it names completed without error, then queued as usual; the wait counts against
+// WAIT_TIMEOUT. A prerequisite that failed, is unknown or does not complete in time fails the
+// dependent with 424. The hold leaves DEPENDENCY_RESERVE of WAIT_TIMEOUT to the dependent job
+// itself: it waits at most WAIT_TIMEOUT minus the reserve, and a request with less than the
+// reserve left is rejected with 424 before anything is held, rather than queued only to time
+// out. The tracker lives in the job index: the dependent's info hash lists its
+// prerequisites under depends_on, a prerequisite's records the error its result carried, and
+// the job change notifications of the long polls wake the waiting dependents. Request IDs are
+// minted by the gateway at submit, so a job can only name jobs submitted before it and the
+// graph can't form cycles. The hold itself runs in the request's goroutine: a gateway that
+// dies while holding leaves the dependent indexed as waiting, and its caller gets an error.
+
+const (
+	jobStatusWaiting = "waiting"
+
+	// maxDependencies caps the prerequisites of one job.
+	maxDependencies = 32
+)
+
+// dependencyReserve is the part of WAIT_TIMEOUT a dependent keeps for being processed once
+// its prerequisites completed.
+var dependencyReserve = durationTunable("DEPENDENCY_RESERVE", 30*time.Second)
+
+// parseDependencies reads X-Depends-On.
+func parseDependencies(c *fiber.Ctx) ([]string, error) {
+	var ids []string
+	for _, id := range strings.Split(c.Get("X-Depends-On"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) > maxDependencies {
+		return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("At most %d jobs in X-Depends-On", maxDependencies))
+	}
+	return ids, nil
+}
+
+// indexWaitingJob lists msg in the job index as waiting for its prerequisites, under the queue
+// it will be pushed to; pushToQueue indexes it again once it is.
+func indexWaitingJob(msg *Message) error {
+	receivedMs := msg.Meta.At(stageRestRequestReceived) / int64(time.Millisecond)
+	pipe := rdb.TxPipeline()
+	indexJob(pipe, msg, jobQueueFor(msg))
+	pipe.HSet(ctx, jobInfoKey(msg.RequestID), "status", jobStatusWaiting, "depends_on", strings.Join(msg.DependsOn, ","))
+	pipe.ZRem(ctx, jobsByStatusKey(jobStatusPending), msg.RequestID)
+	pipe.ZAdd(ctx, jobsByStatusKey(jobStatusWaiting), redis.Z{Score: float64(receivedMs), Member: msg.RequestID})
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// recordJobError keeps the error a job's result carried in its info hash, for its dependents.
+// It must be called before the job's status moves to completed or late.
+func recordJobError(requestId, failure string) {
+	if failure == "" {
+		return
+	}
+	_ = rdb.HSet(ctx, jobInfoKey(requestId), "error", failure).Err()
+}
+
+// dependencyError explains why a dependent can't run.
+type dependencyError struct {
+	requestId string
+	reason    string
+}
+
+func (e *dependencyError) Error() string {
+	return fmt.Sprintf("dependency %s %s", e.requestId, e.reason)
+}
+
+// awaitDependencies blocks until every prerequisite of msg completed without error, or one of
+// them can't anymore, or timeout passed.
+func awaitDependencies(msg *Message, timeout time.Duration) error {
+	deadline := clock.Now().Add(timeout)
+	for _, id := range msg.DependsOn {
+		for {
+			state, known := readJobState(id)
+			if !known {
+				return &dependencyError{requestId: id, reason: "is unknown"}
+			}
+			switch {
+			case state.status == jobStatusCompleted || state.status == jobStatusLate:
+				failure, _ := rdb.HGet(ctx, jobInfoKey(id), "error").Result()
+				if failure != "" {
+					return &dependencyError{requestId: id, reason: "failed: " + failure}
+				}
+			case state.status == jobStatusFailed:
+				return &dependencyError{requestId: id, reason: "failed"}
+			case state.inFlight() || state.stored:
+				// A stored late result is taken for completed once the janitor swept it
+				remaining := deadline.Sub(clock.Now())
+				if remaining <= 0 {
+					return &dependencyError{requestId: id, reason: "did not complete in time"}
+				}
+				awaitJobChange(id, state, remaining)
+				continue
+			default:
+				return &dependencyError{requestId: id, reason: "ended as " + state.status}
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// holdForDependencies indexes msg as waiting and holds it until its prerequisites completed.
+// It returns the time it waited, or fails the job with 424.
+func holdForDependencies(keyID string, msg *Message) (time.Duration, error) {
+	started := clock.Now()
+	hold := waitTimeout.Get() - since(time.Unix(0, msg.Meta.At(stageRestRequestReceived))) - dependencyReserve.Get()
+	if hold <= 0 {
+		metrics.CounterDependencyFailures.Inc()
+		recordOutcome(msg, outcomeFailed)
+		recordUsage(keyID, msg, nil, outcomeFailed)
+		return 0, fiber.NewError(fiber.StatusFailedDependency, "Less than DEPENDENCY_RESERVE of WAIT_TIMEOUT left to wait for dependencies")
+	}
+	if err := indexWaitingJob(msg); err != nil {
+		return 0, fiber.NewError(fiber.StatusInternalServerError, "Failed to index job")
+	}
+	if err := awaitDependencies(msg, hold); err != nil {
+		metrics.CounterDependencyFailures.Inc()
+		jobLogger(msg).Info("Dependency not met", "error", err)
+		recordOutcome(msg, outcomeFailed)
+		recordUsage(keyID, msg, nil, outcomeFailed)
+		setJobStatus(msg.RequestID, jobStatusFailed)
+		storeJob(msg, jobStatusFailed)
+		return 0, fiber.NewError(fiber.StatusFailedDependency, err.Error())
+	}
+	setJobStatus(msg.RequestID, jobStatusPending)
+	return clock.Now().Sub(started), nil
+}
@@ -0,0 +1,26 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestDependentWithoutReserveLeftIsRejectedUpFront(t *testing.T) {
+	app, srv := startTestGateway(t)
+	if _, err := waitTimeout.apply("20s"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _, _ = waitTimeout.apply("") })
+
+	status, body := get(t, app, "/validate?content=hello", "X-Depends-On", "req-1")
+	if status != fiber.StatusFailedDependency {
+		t.Fatalf("status = %d, body %s, want 424", status, body)
+	}
+	if held, _ := srv.Client.ZCard(ctx, jobsByStatusKey(jobStatusWaiting)).Result(); held != 0 {
+		t.Fatalf("%d jobs held that could never run in time", held)
+	}
+	if queued, _ := srv.Client.LLen(ctx, queueKey).Result(); queued != 0 {
+		t.Fatalf("%d jobs queued", queued)
+	}
+}
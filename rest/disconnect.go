@@ -0,0 +1,127 @@
+package gateway
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go-async-proxy/metrics"
+)
+
+// --- Client Disconnects ---
+
+// A caller that hangs up while waiting for its result used to go unnoticed: the gateway kept
+// waiting and answered into a closed connection. While a request waits, its connection is
+// checked every DISCONNECT_CHECK_INTERVAL (Unix only, and only for replies handed over by the
+// dispatcher, not in the "key" reply mode), a disconnect is counted in
+// rest_client_disconnects_total{policy} and handled by CLIENT_DISCONNECT_POLICY:
+//
+//	ignore   keep waiting as before (default)
+//	cancel   stop waiting; a worker that did not start the job yet answers it as cancelled
+//	store    stop waiting and store the result for GET /jobs/:id once it arrives
+//	webhook  stop waiting and post the result to the request's callback or LATE_RESULT_WEBHOOK
+//
+// Apart from ignore, the job's result becomes a late result whose policy the disconnect
+// decides, and what became of it is counted in rest_disconnected_jobs_total{policy,outcome}.
+// The disconnected request is logged with status 499.
+
+const (
+	disconnectIgnore  = "ignore"
+	disconnectCancel  = "cancel"
+	disconnectStore   = "store"
+	disconnectWebhook = "webhook"
+
+	// statusClientClosedRequest is what access logs show for a request whose caller hung up.
+	statusClientClosedRequest = 499
+)
+
+var (
+	clientDisconnectPolicy  = stringTunable("CLIENT_DISCONNECT_POLICY", disconnectIgnore, disconnectIgnore, disconnectCancel, disconnectStore, disconnectWebhook)
+	disconnectCheckInterval = durationTunable("DISCONNECT_CHECK_INTERVAL", time.Second)
+)
+
+var errClientGone = errors.New("client disconnected")
+
+// watchClient returns a channel closed once the caller of c hung up and the policy gives up on
+// the job, and a func to stop watching that must be called before the handler returns.
+func watchClient(c *fiber.Ctx, msg *Message, reply <-chan *Message) (<-chan struct{}, func()) {
+	conn := c.Context().Conn()
+	gone, stop := make(chan struct{}), make(chan struct{})
+	if reply == nil || conn == nil {
+		return gone, func() {}
+	}
+	go func() {
+		ticker := time.NewTicker(disconnectCheckInterval.Get())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			if !connClosed(conn) {
+				continue
+			}
+			policy := clientDisconnectPolicy.Get()
+			metrics.CounterClientDisconnects.WithLabelValues(policy).Inc()
+			jobLogger(msg).Info("Client disconnected while waiting", "policy", policy)
+			if policy != disconnectIgnore {
+				abandonJob(msg.RequestID, policy)
+				close(gone)
+			}
+			return
+		}
+	}()
+	return gone, func() { close(stop) }
+}
+
+// abandonJob records the disconnect policy in the job's info hash, before the waiter is
+// released, so the late result is handled by it.
+func abandonJob(requestId, policy string) {
+	fields := []any{"disconnect_policy", policy}
+	if policy == disconnectCancel {
+		// Read by the worker when it claims the job
+		fields = append(fields, "cancelled", 1)
+	}
+	_ = rdb.HSet(ctx, jobInfoKey(requestId), fields...).Err()
+}
+
+// respondClientGone finishes a request whose caller hung up; nobody reads the answer.
+func respondClientGone(c *fiber.Ctx, msg *Message) error {
+	setJobStatus(msg.RequestID, jobStatusTimeout)
+	storeJob(msg, jobStatusTimeout)
+	return c.SendStatus(statusClientClosedRequest)
+}
+
+// latePolicyFor is the late result policy of a job: the one its disconnect policy implies,
+// or LATE_RESULT_POLICY. disconnected is the disconnect policy, "" when the caller didn't
+// hang up.
+func latePolicyFor(requestId string) (policy, disconnected string) {
+	disconnected, _ = rdb.HGet(ctx, jobInfoKey(requestId), "disconnect_policy").Result()
+	switch disconnected {
+	case disconnectCancel:
+		return latePolicyDiscard, disconnected
+	case disconnectStore:
+		return latePolicyStore, disconnected
+	case disconnectWebhook:
+		return latePolicyWebhook, disconnected
+	}
+	return lateResultPolicy.Get(), ""
+}
+
+// countDisconnectedJob counts what became of the job of a caller that hung up.
+func countDisconnectedJob(disconnected string, msg *Message, delivered bool) {
+	outcome := "discarded"
+	switch {
+	case strings.HasPrefix(msg.Meta.lastError(), "cancelled:"):
+		outcome = "cancelled"
+	case disconnected == disconnectStore:
+		outcome = "stored"
+	case disconnected == disconnectWebhook && delivered:
+		outcome = "delivered"
+	case disconnected == disconnectWebhook:
+		outcome = "webhook_failed"
+	}
+	metrics.CounterDisconnectedJobs.WithLabelValues(disconnected, outcome).Inc()
+}
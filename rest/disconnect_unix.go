@@ -0,0 +1,29 @@
+//go:build unix
+
+package gateway
+
+import (
+	"net"
+	"syscall"
+)
+
+// connClosed peeks at the connection without consuming anything: Go sockets are non-blocking,
+// so EAGAIN means the caller is still there, while a zero-byte read (EOF) or a reset means
+// it hung up. Connections without a file descriptor (e.g. TLS) always look open.
+func connClosed(conn net.Conn) bool {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return false
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return false
+	}
+	closed := false
+	_ = raw.Control(func(fd uintptr) {
+		var buf [1]byte
+		n, _, err := syscall.Recvfrom(int(fd), buf[:], syscall.MSG_PEEK)
+		closed = (n == 0 && err == nil) || err == syscall.ECONNRESET
+	})
+	return closed
+}
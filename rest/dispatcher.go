@@ -0,0 +1,209 @@
+package gateway
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Reply Dispatcher ---
+
+// In "instance" reply mode every job carries reply_to = this instance's reply list, so
+// workers answer straight to the replica holding the open HTTP connection. A single
+// dispatcher goroutine drains that list and hands replies to the local waiters, instead
+// of one blocking Redis connection per in-flight request. "pubsub" mode does the same over
+// a per-instance channel, which saves the list churn and notifies without polling.
+
+const (
+	replyModeInstance = "instance"
+	replyModePubSub   = "pubsub"
+	replyModeKey      = "key"
+)
+
+func replyKey(instance string) string {
+	return fmt.Sprintf("validate:reply:%s", instance)
+}
+
+type replyDispatcher struct {
+	mu      sync.Mutex
+	waiters map[string]chan *Message
+	// watchers are the long polls waiting for a job to change, see awaitJobChange.
+	watchers map[string][]chan struct{}
+}
+
+var dispatcher = &replyDispatcher{waiters: map[string]chan *Message{}, watchers: map[string][]chan struct{}{}}
+
+// register must be called before the job is pushed, so a fast reply always finds its waiter.
+// Returns nil in "key" reply mode, where each request blocks on its own response key.
+func (d *replyDispatcher) register(requestId string) chan *Message {
+	if replyMode == replyModeKey {
+		return nil
+	}
+	reply := make(chan *Message, 1)
+	d.mu.Lock()
+	d.waiters[requestId] = reply
+	d.mu.Unlock()
+	return reply
+}
+
+func (d *replyDispatcher) cancel(requestId string) {
+	d.mu.Lock()
+	delete(d.waiters, requestId)
+	d.mu.Unlock()
+}
+
+// deliver routes a reply that arrived on source to its waiter. Replies nobody waits for
+// anymore are late results, and the second result of a replicated job is dropped.
+func (d *replyDispatcher) deliver(source *redis.Client, payload []byte) {
+	var msg Message
+	if err := codec.Unmarshal(payload, &msg); err != nil {
+		slog.Warn("Invalid reply", "error", err)
+		return
+	}
+	if !claimResult(source, &msg) {
+		return
+	}
+	if err := adoptChunks(source, msg.Data.Chunks); err != nil {
+		jobLogger(&msg).Error("Cannot copy replica chunks", "error", err)
+		return
+	}
+
+	d.mu.Lock()
+	reply, ok := d.waiters[msg.RequestID]
+	delete(d.waiters, msg.RequestID)
+	d.mu.Unlock()
+
+	if !ok {
+		queueLateResult(msg.RequestID, payload)
+		return
+	}
+	reply <- &msg
+}
+
+// Late results go through the lateResults backlog to their own goroutines, so a slow late
+// result webhook never holds up the replies of the requests still waiting. When the backlog is
+// full, the result is parked on its response key for the janitor to handle.
+const (
+	lateResultWorkers = 4
+	lateResultBacklog = 1000
+)
+
+type lateResult struct {
+	requestId string
+	payload   []byte
+}
+
+var (
+	lateResults        = make(chan lateResult, lateResultBacklog)
+	lateResultHandlers sync.Once
+)
+
+// startLateResultHandlers starts the goroutines handling lateResults, once.
+func startLateResultHandlers() {
+	lateResultHandlers.Do(func() {
+		for i := 0; i < lateResultWorkers; i++ {
+			go func() {
+				for late := range lateResults {
+					handleLateResult(late.requestId, late.payload)
+				}
+			}()
+		}
+	})
+}
+
+// queueLateResult hands a reply nobody waits for to the late result handlers.
+func queueLateResult(requestId string, payload []byte) {
+	select {
+	case lateResults <- lateResult{requestId: requestId, payload: payload}:
+		return
+	default:
+	}
+	pipe := rdb.TxPipeline()
+	pipe.RPush(ctx, responseKey(requestId), payload)
+	pipe.Expire(ctx, responseKey(requestId), jobResultTTL.Get())
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.Error("Cannot park late result", "request_id", requestId, "error", err)
+	}
+}
+
+func (d *replyDispatcher) run(source *redis.Client) {
+	key := replyKey(instanceID)
+	for {
+		result, err := source.BLPop(ctx, 5*time.Second, key).Result()
+		if err != nil {
+			if err != redis.Nil {
+				slog.Error("Reply dispatcher failed", "error", err)
+				time.Sleep(time.Second)
+			}
+			continue
+		}
+		d.deliver(source, []byte(result[1]))
+	}
+}
+
+// runPubSub must be subscribed before the first job is pushed, hence the synchronous Receive.
+func (d *replyDispatcher) runPubSub(source *redis.Client) error {
+	sub := source.Subscribe(ctx, replyKey(instanceID))
+	if _, err := sub.Receive(ctx); err != nil {
+		return err
+	}
+
+	go func() {
+		for m := range sub.Channel() {
+			d.deliver(source, []byte(m.Payload))
+		}
+	}()
+	return nil
+}
+
+// startReplyDispatcher listens on the primary Redis and, with replication, on the replica.
+func startReplyDispatcher() error {
+	startLateResultHandlers()
+	for _, source := range []*redis.Client{rdb, replicaRdb} {
+		if source == nil {
+			continue
+		}
+		switch replyMode {
+		case replyModeInstance:
+			go dispatcher.run(source)
+		case replyModePubSub:
+			if err := dispatcher.runPubSub(source); err != nil {
+				return err
+			}
+		case replyModeKey:
+		default:
+			return fmt.Errorf("unknown reply mode %q", replyMode)
+		}
+	}
+	return nil
+}
+
+// sweepDeadReplyQueues hands replies that landed on the list of a crashed instance to the
+// late result policy, since no one will ever dispatch them.
+func sweepDeadReplyQueues() {
+	prefix := replyKey("")
+	iter := rdb.Scan(ctx, 0, prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		alive, err := rdb.Exists(ctx, heartbeatKey(strings.TrimPrefix(key, prefix))).Result()
+		if err != nil || alive > 0 {
+			continue
+		}
+
+		items, err := collectResponseScript.Run(ctx, rdb, []string{key}).StringSlice()
+		if err != nil {
+			continue
+		}
+		for _, item := range items {
+			var msg Message
+			if err := codec.Unmarshal([]byte(item), &msg); err != nil {
+				continue
+			}
+			handleLateResult(msg.RequestID, []byte(item))
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSlowLateResultWebhookDoesNotDelayReplies(t *testing.T) {
+	startTestGateway(t)
+	startLateResultHandlers()
+	// Set back to key mode by startTestGateway's cleanup
+	replyMode = replyModeInstance
+	release := make(chan struct{})
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(webhook.Close)
+	for tun, raw := range map[*tunable[string]]string{lateResultPolicy: latePolicyWebhook, lateResultWebhook: webhook.URL} {
+		if _, err := tun.apply(raw); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _, _ = tun.apply("") })
+	}
+
+	late := &Message{RequestID: "req-late"}
+	pipe := rdb.TxPipeline()
+	indexJob(pipe, late, queueKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// The late result is handled to the end before the test's Redis goes away
+	t.Cleanup(func() {
+		close(release)
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if state, _ := readJobState(late.RequestID); state.status == jobStatusLate {
+				return
+			}
+		}
+		t.Error("the late result was never handled")
+	})
+
+	reply := dispatcher.register("req-waiting")
+	defer dispatcher.cancel("req-waiting")
+	go func() {
+		for _, msg := range []*Message{late, {RequestID: "req-waiting"}} {
+			payload, _ := codec.Marshal(msg)
+			dispatcher.deliver(rdb, payload)
+		}
+	}()
+	select {
+	case msg := <-reply:
+		if msg.RequestID != "req-waiting" {
+			t.Fatalf("got the reply of %s", msg.RequestID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the reply waited for the late result's webhook")
+	}
+}
@@ -0,0 +1,40 @@
+package gateway
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// --- Dry Run ---
+
+// With ?dry_run=true a submission goes through every check a real one does (shedding, size
+// limits, encoding, flags, the request middlewares, the failure cache, the gateway budget and
+// admission control) and answers with the message as it would be enqueued, where, and the
+// estimated wait, without storing, accounting or enqueuing anything. It lets clients test their
+// integration without creating jobs. A failure cache hit or a rejection is answered like for a
+// real submission. An X-Nonce is looked up but not claimed: when it is taken, attaches_to names
+// the job a real submission would attach to. Dependencies are not awaited.
+
+func isDryRun(c *fiber.Ctx) bool {
+	return c.QueryBool("dry_run")
+}
+
+func respondDryRun(c *fiber.Ctx, keyID string, msg *Message) error {
+	queue := jobQueueFor(msg)
+	length, err := rdb.LLen(ctx, queue).Result()
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to read the job queue")
+	}
+	estimate := newQueueEstimate(queue, length+1)
+	body := fiber.Map{
+		"dry_run": true,
+		"queue":   queue,
+		"message": msg,
+	}
+	if nonce := c.Get("X-Nonce"); nonce != "" {
+		if original, err := rdb.Get(ctx, nonceKey(keyID, nonce)).Result(); err == nil {
+			body["attaches_to"] = original
+		}
+	}
+	setQueueHeaders(c, estimate)
+	return c.JSON(addQueueFields(body, estimate))
+}
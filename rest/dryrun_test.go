@@ -0,0 +1,34 @@
+package gateway
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestDryRunLooksUpTheNonceWithoutClaimingIt(t *testing.T) {
+	app, srv := startTestGateway(t)
+
+	status, body := get(t, app, "/validate?content=hello&dry_run=true", "X-Nonce", "nonce-1")
+	if status != fiber.StatusOK {
+		t.Fatalf("status = %d, body %s", status, body)
+	}
+	if claimed, _ := srv.Client.Exists(ctx, nonceKey(usageAnonymous, "nonce-1")).Result(); claimed != 0 {
+		t.Fatal("a dry run claimed the nonce")
+	}
+
+	if _, _, err := claimNonce(usageAnonymous, "nonce-1", "req-original"); err != nil {
+		t.Fatal(err)
+	}
+	_, body = get(t, app, "/validate?content=hello&dry_run=true", "X-Nonce", "nonce-1")
+	var answer struct {
+		AttachesTo string `json:"attaches_to"`
+	}
+	if err := json.Unmarshal(body, &answer); err != nil || answer.AttachesTo != "req-original" {
+		t.Fatalf("attaches_to = %q, body %s", answer.AttachesTo, body)
+	}
+	if queued, _ := srv.Client.LLen(ctx, queueKey).Result(); queued != 0 {
+		t.Fatalf("%d jobs queued by dry runs", queued)
+	}
+}
@@ -0,0 +1,157 @@
+package gateway
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"go-async-proxy/metrics"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// --- Request Middlewares ---
+
+// RequestMiddleware enriches or rewrites a message before it is enqueued. The whole chain is
+// one pipeline stage, marked rest_request_enriched when it ends.
+type RequestMiddleware func(c *fiber.Ctx, msg *Message) error
+
+type namedRequestMiddleware struct {
+	name string
+	run  RequestMiddleware
+}
+
+var requestMiddlewareFactories = map[string]func() (RequestMiddleware, error){
+	"normalize": newNormalizeMiddleware,
+	"scrub_pii": newScrubPIIMiddleware,
+	"geo":       newGeoMiddleware,
+}
+
+// requestMiddlewares is the chain picked by REQUEST_MIDDLEWARES, applied in order.
+var requestMiddlewares []namedRequestMiddleware
+
+// initRequestMiddlewares builds the chain from REQUEST_MIDDLEWARES, a comma separated list of
+// middleware names (e.g. "geo,normalize,scrub_pii").
+func initRequestMiddlewares() error {
+	for _, name := range strings.Split(envString("REQUEST_MIDDLEWARES", ""), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		factory, ok := requestMiddlewareFactories[name]
+		if !ok {
+			return fmt.Errorf("unknown request middleware %q", name)
+		}
+		middleware, err := factory()
+		if err != nil {
+			return fmt.Errorf("request middleware %q: %w", name, err)
+		}
+		requestMiddlewares = append(requestMiddlewares, namedRequestMiddleware{name: name, run: middleware})
+	}
+	return nil
+}
+
+// enrichMessage runs the chain, timing every middleware separately.
+func enrichMessage(c *fiber.Ctx, msg *Message) error {
+	for _, middleware := range requestMiddlewares {
+		started := clock.Now()
+		err := middleware.run(c, msg)
+		metrics.DurationEnrichmentMs.WithLabelValues(middleware.name).
+			Observe(float64(since(started).Microseconds()) / 1000)
+		if err != nil {
+			return err
+		}
+	}
+	msg.Meta.Mark(stageRestRequestEnriched)
+	return nil
+}
+
+// newNormalizeMiddleware trims text content and collapses every run of whitespace to one space.
+func newNormalizeMiddleware() (RequestMiddleware, error) {
+	return func(_ *fiber.Ctx, msg *Message) error {
+		if msg.Data.Binary {
+			return nil
+		}
+		msg.Data.Content = strings.Join(strings.Fields(msg.Data.Content), " ")
+		return nil
+	}, nil
+}
+
+// piiPatterns are scrubbed by default: e-mail addresses, card-like digit runs and phone numbers.
+var piiPatterns = []string{
+	`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,
+	`\b(?:\d[ -]?){13,19}\b`,
+	`\+?\d[\d ()-]{7,}\d`,
+}
+
+// newScrubPIIMiddleware replaces PII in text content with PII_MASK before it reaches Redis or a
+// worker. PII_PATTERNS (a JSON array of regular expressions) replaces the default patterns.
+func newScrubPIIMiddleware() (RequestMiddleware, error) {
+	sources := piiPatterns
+	if raw := envString("PII_PATTERNS", ""); raw != "" {
+		if err := codec.Unmarshal([]byte(raw), &sources); err != nil {
+			return nil, fmt.Errorf("invalid PII_PATTERNS: %w", err)
+		}
+	}
+	patterns := make([]*regexp.Regexp, 0, len(sources))
+	for _, source := range sources {
+		pattern, err := regexp.Compile(source)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PII pattern %q: %w", source, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	mask := envString("PII_MASK", "[PII]")
+
+	return func(_ *fiber.Ctx, msg *Message) error {
+		if msg.Data.Binary {
+			return nil
+		}
+		for _, pattern := range patterns {
+			msg.Data.Content = pattern.ReplaceAllLiteralString(msg.Data.Content, mask)
+		}
+		return nil
+	}, nil
+}
+
+// newGeoMiddleware sets msg.Geo from the GEO_HEADER a CDN or load balancer adds (CF-IPCountry by
+// default), falling back to the GEO_NETWORKS table ("cidr=region,cidr=region") for the client IP.
+func newGeoMiddleware() (RequestMiddleware, error) {
+	header := envString("GEO_HEADER", "CF-IPCountry")
+
+	type network struct {
+		ipNet  *net.IPNet
+		region string
+	}
+	var networks []network
+	for _, pair := range strings.Split(envString("GEO_NETWORKS", ""), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		cidr, region, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid GEO_NETWORKS entry %q", pair)
+		}
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid GEO_NETWORKS entry %q: %w", pair, err)
+		}
+		networks = append(networks, network{ipNet: ipNet, region: strings.TrimSpace(region)})
+	}
+
+	return func(c *fiber.Ctx, msg *Message) error {
+		if region := c.Get(header); region != "" {
+			msg.Geo = region
+			return nil
+		}
+		ip := net.ParseIP(clientIP(c))
+		for _, n := range networks {
+			if ip != nil && n.ipNet.Contains(ip) {
+				msg.Geo = n.region
+				return nil
+			}
+		}
+		return nil
+	}, nil
+}
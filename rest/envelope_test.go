@@ -0,0 +1,130 @@
+package gateway
+
+import (
+	"bytes"
+	stdjson "encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// The envelope golden files are shared with the worker, whose tests read what the gateway
+// writes and write what the gateway reads. Run go test -update in either module after an
+// intended envelope change, and check the diff.
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+const envelopeDir = "../testdata/envelope"
+
+// goldenRequest is the job the gateway pushes, with every field the worker reads.
+func goldenRequest() *Message {
+	msg := &Message{
+		RequestID:       "7f9c2ba4-e88f-4b1a-9d2c-3a1f0e6b5d4c",
+		TraceID:         "4bf92f3577b34da6a3ce929d0e0e4736",
+		ReplyTo:         "validate:replies:gw-1",
+		ReplyVia:        "pubsub",
+		Tenant:          "acme",
+		JobType:         "uppercase",
+		Geo:             "DE",
+		Region:          "eu",
+		Flags:           []string{"new-rules"},
+		Cost:            &CostLabels{Team: "payments", CostCenter: "cc-42"},
+		Affinity:        "session-9",
+		DependsOn:       []string{"a1b2c3"},
+		QueueDeadlineNs: 1700000030000000000,
+		Data:            Data{Content: "héllo wörld"},
+	}
+	msg.Meta.MarkAt(stageRestRequestReceived, 1700000000000000000)
+	msg.Meta.MarkAt(stageRestRequestEnriched, 1700000000000100000)
+	msg.Meta.MarkAt(stageRestRequestPushed, 1700000000000200000)
+	return msg
+}
+
+// checkGolden compares the JSON got to the golden file name, ignoring key order and spacing,
+// or rewrites the file with -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join(envelopeDir, name)
+	if *update {
+		pretty, _ := stdjson.MarshalIndent(decodeJSON(t, got), "", "  ")
+		if err := os.WriteFile(path, append(pretty, '\n'), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decodeJSON(t, got), decodeJSON(t, want)) {
+		t.Fatalf("envelope differs from %s:\n got: %s\nwant: %s", path, got, want)
+	}
+}
+
+// decodeJSON decodes data generically, keeping numbers exact: timestamps do not fit a float64.
+func decodeJSON(t *testing.T, data []byte) any {
+	t.Helper()
+	dec := stdjson.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestRequestEnvelopeGolden(t *testing.T) {
+	for name, c := range codecs {
+		t.Run(name, func(t *testing.T) {
+			payload, err := c.Marshal(goldenRequest())
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, "request.json", payload)
+		})
+	}
+}
+
+func TestResponseEnvelopeDecodes(t *testing.T) {
+	stages := []StageEvent{
+		{Name: stageRestRequestReceived, TsNs: 1700000000000000000},
+		{Name: stageRestRequestEnriched, TsNs: 1700000000000100000},
+		{Name: stageRestRequestPushed, TsNs: 1700000000000200000},
+		{Name: stageWorkerRequestPulled, TsNs: 1700000000005000000},
+		{Name: stageWorkerResponsePushed, TsNs: 1700000000007000000},
+	}
+	// response_legacy.json is what a worker wrote before Meta had stages.
+	for _, file := range []string{"response.json", "response_legacy.json"} {
+		payload, err := os.ReadFile(filepath.Join(envelopeDir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, c := range codecs {
+			t.Run(file+"/"+name, func(t *testing.T) {
+				var msg Message
+				if err := c.Unmarshal(payload, &msg); err != nil {
+					t.Fatal(err)
+				}
+				if msg.RequestID != goldenRequest().RequestID || msg.Data.Content != "HÉLLO WÖRLD" || !msg.Data.Result {
+					t.Fatalf("got request_id=%q content=%q result=%v", msg.RequestID, msg.Data.Content, msg.Data.Result)
+				}
+				if !reflect.DeepEqual(msg.Meta.Stages, stages) {
+					t.Fatalf("stages = %+v, want %+v", msg.Meta.Stages, stages)
+				}
+				if file == "response_legacy.json" {
+					return
+				}
+				if msg.Worker == nil || msg.Worker.InstanceID != "worker-1" {
+					t.Fatalf("worker = %+v, want instance worker-1", msg.Worker)
+				}
+				if msg.Cost == nil || *msg.Cost != *goldenRequest().Cost || msg.TraceID != goldenRequest().TraceID {
+					t.Fatalf("cost = %+v, trace_id = %q: not round-tripped", msg.Cost, msg.TraceID)
+				}
+				if len(msg.Meta.Attempts) != 1 || msg.Meta.Attempts[0].WorkerID != "worker-1" {
+					t.Fatalf("attempts = %+v, want one by worker-1", msg.Meta.Attempts)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package gateway
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Data Erasure ---
+
+// DELETE /admin/tenants/:tenant/data erases what the gateway keeps about a tenant, for
+// erasure requests: every job of the tenant's index with its info hash, stored result and
+// chunks, response and callback keys, its entries in every index (the queue it was pushed to
+// included) and its recorded fixture, the tenant's WASM modules, workflow runs and dead
+// letters on the workers' DLQ, the audit entries and slow request traces naming the tenant,
+// and its rows in the job store. The answer
+// reports how many of each were deleted.
+//
+// Retained: jobs still in a queue are not touched and are indexed again as they complete, and
+// job store writes and workflow runs still in flight when the erasure runs are written
+// afterwards, so erase again once they are done. Records already sent to EVENT_SINK are in the
+// sink's hands (for ClickHouse, ALTER TABLE ... DELETE WHERE tenant = ...; EVENT_FILE lines go
+// with the file's rotation). Failure cache entries hold only an error, under a digest that
+// can't be traced back to the tenant, and expire within FAILURE_CACHE_TTL. The erasure itself
+// stays in the audit log.
+
+// erasureBatch is how many jobs are deleted per Redis round trip.
+const erasureBatch = 500
+
+// erasureReport counts what an erasure deleted.
+type erasureReport struct {
+	Tenant       string `json:"tenant"`
+	Jobs         int    `json:"jobs"`
+	RedisKeys    int64  `json:"redis_keys"`
+	AuditEntries int64  `json:"audit_entries"`
+	Traces       int64  `json:"traces"`
+	Fixtures     int    `json:"fixtures"`
+	Modules      int64  `json:"modules"`
+	WorkflowRuns int64  `json:"workflow_runs"`
+	DeadLetters  int64  `json:"dead_letters"`
+	StoredJobs   int64  `json:"stored_jobs"`
+	DurationMs   int64  `json:"duration_ms"`
+}
+
+// eraseTenantHandler serves DELETE /admin/tenants/:tenant/data.
+func eraseTenantHandler(c *fiber.Ctx) error {
+	started := clock.Now()
+	report := erasureReport{Tenant: c.Params("tenant")}
+	if err := eraseTenantJobs(&report); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to erase jobs")
+	}
+	var err error
+	if report.AuditEntries, err = eraseStreamEntries(auditKey, func(values map[string]any) bool {
+		return values["tenant"] == report.Tenant
+	}); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to erase audit entries")
+	}
+	if report.Traces, err = eraseStreamEntries(slowRequestsKey, func(values map[string]any) bool {
+		raw, _ := values["trace"].(string)
+		var trace requestTrace
+		return codec.Unmarshal([]byte(raw), &trace) == nil && trace.Tenant == report.Tenant
+	}); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to erase traces")
+	}
+	if report.Modules, err = eraseTenantModules(report.Tenant); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to erase modules")
+	}
+	if report.WorkflowRuns, err = eraseTenantWorkflowRuns(report.Tenant); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to erase workflow runs")
+	}
+	if report.DeadLetters, err = eraseTenantDeadLetters(report.Tenant); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to erase dead letters")
+	}
+	if jobStore.db != nil {
+		ctxTimeout, cancel := context.WithTimeout(ctx, time.Minute)
+		defer cancel()
+		result, err := jobStore.db.ExecContext(ctxTimeout, `DELETE FROM jobs WHERE tenant = $1`, report.Tenant)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Failed to erase stored jobs")
+		}
+		report.StoredJobs, _ = result.RowsAffected()
+	}
+	report.DurationMs = since(started).Milliseconds()
+	loggerFrom(c.UserContext()).Info("Erased tenant data", "tenant", report.Tenant, "jobs", report.Jobs, "stored_jobs", report.StoredJobs)
+	return c.JSON(report)
+}
+
+// eraseTenantJobs deletes the tenant's indexed jobs and their keys, batch by batch.
+func eraseTenantJobs(report *erasureReport) error {
+	tenantKey := jobsByTenantKey(report.Tenant)
+	statuses := []string{jobStatusWaiting, jobStatusPending, jobStatusCompleted, jobStatusTimeout, jobStatusFailed, jobStatusLate}
+	for {
+		ids, err := rdb.ZRange(ctx, tenantKey, 0, erasureBatch-1).Result()
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		// Stored results may reference chunks, which go with them, and every job leaves the
+		// index of the queue it was pushed to
+		read := rdb.Pipeline()
+		stored := read.MGet(ctx, keysOf(ids, jobKey)...)
+		queues := make([]*redis.StringCmd, len(ids))
+		for i, id := range ids {
+			queues[i] = read.HGet(ctx, jobInfoKey(id), "queue")
+		}
+		if _, err := read.Exec(ctx); err != nil && err != redis.Nil {
+			return err
+		}
+		payloads := stored.Val()
+		var keys []string
+		for i, id := range ids {
+			keys = append(keys, jobKey(id), jobInfoKey(id), responseKey(id), callbackKey(id))
+			if payload, ok := payloads[i].(string); ok {
+				if msg, err := decodeResult([]byte(payload)); err == nil && msg.Data.Chunks != nil {
+					keys = append(keys, chunkKeys(msg.Data.Chunks)...)
+				}
+			}
+		}
+
+		members := make([]any, len(ids))
+		byQueue := map[string][]any{}
+		for i, id := range ids {
+			members[i] = id
+			if queue := queues[i].Val(); queue != "" {
+				byQueue[queue] = append(byQueue[queue], id)
+			}
+		}
+		pipe := rdb.TxPipeline()
+		deleted := pipe.Del(ctx, keys...)
+		pipe.ZRem(ctx, jobsIndexKey, members...)
+		for queue, queued := range byQueue {
+			pipe.ZRem(ctx, jobsByQueueKey(queue), queued...)
+		}
+		for _, status := range statuses {
+			pipe.ZRem(ctx, jobsByStatusKey(status), members...)
+		}
+		pipe.ZRem(ctx, tenantKey, members...)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+		report.Jobs += len(ids)
+		report.Fixtures += eraseFixtures(ids)
+		report.RedisKeys += deleted.Val()
+	}
+}
+
+// eraseFixtures deletes the fixtures recorded for ids and returns how many there were.
+func eraseFixtures(ids []string) int {
+	if fixtureDir == "" {
+		return 0
+	}
+	erased := 0
+	for _, id := range ids {
+		if os.Remove(filepath.Join(fixtureDir, id+".json")) == nil {
+			erased++
+		}
+	}
+	return erased
+}
+
+// eraseTenantModules deletes the tenant's WASM modules and their digests.
+func eraseTenantModules(tenant string) (int64, error) {
+	fields, err := rdb.HKeys(ctx, wasmDigestsKey).Result()
+	if err != nil {
+		return 0, err
+	}
+	var owned []string
+	for _, field := range fields {
+		if strings.HasPrefix(field, tenant+"/") {
+			owned = append(owned, field)
+		}
+	}
+	if len(owned) == 0 {
+		return 0, nil
+	}
+	pipe := rdb.TxPipeline()
+	deleted := pipe.HDel(ctx, wasmModulesKey, owned...)
+	pipe.HDel(ctx, wasmDigestsKey, owned...)
+	_, err = pipe.Exec(ctx)
+	return deleted.Val(), err
+}
+
+// eraseTenantWorkflowRuns deletes the tenant's workflow runs, scanning them batch by batch.
+func eraseTenantWorkflowRuns(tenant string) (int64, error) {
+	var erased int64
+	var cursor uint64
+	for {
+		keys, next, err := rdb.Scan(ctx, cursor, workflowRunKey("*"), erasureBatch).Result()
+		if err != nil {
+			return erased, err
+		}
+		if len(keys) > 0 {
+			payloads, err := rdb.MGet(ctx, keys...).Result()
+			if err != nil {
+				return erased, err
+			}
+			var owned []string
+			for i, payload := range payloads {
+				raw, _ := payload.(string)
+				var run WorkflowRun
+				if codec.Unmarshal([]byte(raw), &run) == nil && run.Tenant == tenant {
+					owned = append(owned, keys[i])
+				}
+			}
+			if len(owned) > 0 {
+				deleted, err := rdb.Del(ctx, owned...).Result()
+				if err != nil {
+					return erased, err
+				}
+				erased += deleted
+			}
+		}
+		if cursor = next; cursor == 0 {
+			return erased, nil
+		}
+	}
+}
+
+// eraseTenantDeadLetters removes the tenant's entries from the workers' DLQ, which the
+// workers cap at DLQ_MAX_LENGTH, so it is read whole.
+func eraseTenantDeadLetters(tenant string) (int64, error) {
+	entries, err := rdb.LRange(ctx, dlqKey, 0, -1).Result()
+	if err != nil {
+		return 0, err
+	}
+	pipe := rdb.Pipeline()
+	var removed []*redis.IntCmd
+	for _, entry := range entries {
+		var letter struct {
+			Message *Message `json:"message"`
+		}
+		if codec.Unmarshal([]byte(entry), &letter) == nil && letter.Message != nil && letter.Message.Tenant == tenant {
+			// Identical entries go with the first LRem, the others remove nothing
+			removed = append(removed, pipe.LRem(ctx, dlqKey, 0, entry))
+		}
+	}
+	if len(removed) == 0 {
+		return 0, nil
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	var erased int64
+	for _, cmd := range removed {
+		erased += cmd.Val()
+	}
+	return erased, nil
+}
+
+func keysOf(ids []string, key func(string) string) []string {
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = key(id)
+	}
+	return keys
+}
+
+// eraseStreamEntries deletes the entries of stream matching match and returns how many.
+func eraseStreamEntries(stream string, match func(values map[string]any) bool) (int64, error) {
+	var erased int64
+	start := "-"
+	for {
+		entries, err := rdb.XRangeN(ctx, stream, start, "+", erasureBatch).Result()
+		if err != nil && err != redis.Nil {
+			return erased, err
+		}
+		var ids []string
+		for _, entry := range entries {
+			start = "(" + entry.ID
+			if match(entry.Values) {
+				ids = append(ids, entry.ID)
+			}
+		}
+		if len(ids) > 0 {
+			deleted, err := rdb.XDel(ctx, stream, ids...).Result()
+			if err != nil {
+				return erased, err
+			}
+			erased += deleted
+		}
+		if len(entries) < erasureBatch {
+			return erased, nil
+		}
+	}
+}
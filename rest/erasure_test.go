@@ -0,0 +1,75 @@
+package gateway
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestErasureCoversModulesWorkflowRunsFixturesAndDeadLetters(t *testing.T) {
+	app, srv := startTestGateway(t)
+	srv.Work(t, queueKey, upperCase)
+	prevDir := fixtureDir
+	fixtureDir = t.TempDir()
+	t.Cleanup(func() { fixtureDir = prevDir })
+
+	status, body := get(t, app, "/validate?content=hello", "X-Tenant", "acme")
+	if status != fiber.StatusOK {
+		t.Fatalf("status = %d, body %s", status, body)
+	}
+	var result Message
+	if err := json.Unmarshal(body, &result); err != nil {
+		t.Fatal(err)
+	}
+	fixture := filepath.Join(fixtureDir, result.RequestID+".json")
+	if _, err := os.Stat(fixture); err != nil {
+		t.Fatalf("no fixture recorded: %v", err)
+	}
+	for _, field := range []string{"acme/lint", "acmecorp/lint"} {
+		srv.Client.HSet(ctx, wasmModulesKey, field, "\x00asm")
+		srv.Client.HSet(ctx, wasmDigestsKey, field, "digest")
+	}
+	for id, tenant := range map[string]string{"run-1": "acme", "run-2": "other"} {
+		payload, _ := codec.Marshal(&WorkflowRun{ID: id, Tenant: tenant, Status: workflowCompleted})
+		srv.Client.Set(ctx, workflowRunKey(id), payload, 0)
+	}
+	for _, tenant := range []string{"acme", "other"} {
+		letter, _ := codec.Marshal(fiber.Map{"message": &Message{RequestID: "dead-" + tenant, Tenant: tenant}, "error": "panic"})
+		srv.Client.RPush(ctx, dlqKey, letter)
+	}
+
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodDelete, "/admin/tenants/acme/data", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ = io.ReadAll(resp.Body)
+	var report erasureReport
+	if err := json.Unmarshal(body, &report); err != nil || resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("status %d, body %s", resp.StatusCode, body)
+	}
+	if report.Jobs != 1 || report.Fixtures != 1 || report.Modules != 1 || report.WorkflowRuns != 1 || report.DeadLetters != 1 {
+		t.Fatalf("report = %+v, want one job, fixture, module, workflow run and dead letter", report)
+	}
+	if _, err := os.Stat(fixture); !os.IsNotExist(err) {
+		t.Fatalf("fixture still there: %v", err)
+	}
+	if fields, _ := srv.Client.HKeys(ctx, wasmModulesKey).Result(); len(fields) != 1 || fields[0] != "acmecorp/lint" {
+		t.Fatalf("modules left = %v, want acmecorp's only", fields)
+	}
+	if srv.Exists(workflowRunKey("run-1")) || !srv.Exists(workflowRunKey("run-2")) {
+		t.Fatal("want only acme's workflow run erased")
+	}
+	if letters, _ := srv.Client.LRange(ctx, dlqKey, 0, -1).Result(); len(letters) != 1 || !strings.Contains(letters[0], "dead-other") {
+		t.Fatalf("dead letters left = %v, want other's only", letters)
+	}
+	if queued, _ := srv.Client.ZCard(ctx, jobsByQueueKey(queueKey)).Result(); queued != 0 {
+		t.Fatalf("%d jobs left in the queue index", queued)
+	}
+}
@@ -0,0 +1,253 @@
+package gateway
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"go-async-proxy/metrics"
+)
+
+// --- Analytics Event Export ---
+
+// Every finished request (completed, failed or timed out) becomes one stageRecord streamed to
+// the EVENT_SINK, so latency distributions can be analyzed offline with full resolution instead
+// of through histogram buckets. Records are batched off the request path; a full buffer drops
+// records rather than slowing down requests.
+//
+// A matching ClickHouse table:
+//
+//	CREATE TABLE request_stages (
+//		request_id String, tenant String, job_type String, cohort String, outcome String,
+//		worker String, worker_version String, attempts UInt8, enqueue_depth Int64,
+//		received_ms Int64, roundtrip_ms Float64, stage_names Array(String), stage_offsets_ms Array(Float64)
+//	) ENGINE = MergeTree ORDER BY received_ms
+
+var (
+	// eventSinkName is "" (disabled), "clickhouse", "kafka" or "file".
+	eventSinkName = envString("EVENT_SINK", "")
+
+	eventBatch  = envInt("EVENT_BATCH", 500)
+	eventFlush  = envDuration("EVENT_FLUSH", 5*time.Second)
+	eventBuffer = envInt("EVENT_BUFFER", 50000)
+)
+
+// stageRecord is the exported form of one request. Stages are given as offsets from the
+// receive time, in the order they happened.
+type stageRecord struct {
+	RequestID      string    `json:"request_id"`
+	Tenant         string    `json:"tenant"`
+	JobType        string    `json:"job_type"`
+	Cohort         string    `json:"cohort"`
+	Team           string    `json:"team"`
+	CostCenter     string    `json:"cost_center"`
+	Outcome        string    `json:"outcome"`
+	Worker         string    `json:"worker"`
+	WorkerVersion  string    `json:"worker_version"`
+	Attempts       int       `json:"attempts"`
+	EnqueueDepth   int64     `json:"enqueue_depth"`
+	ReceivedMs     int64     `json:"received_ms"`
+	RoundtripMs    float64   `json:"roundtrip_ms"`
+	StageNames     []string  `json:"stage_names"`
+	StageOffsetsMs []float64 `json:"stage_offsets_ms"`
+}
+
+// eventSink writes a batch of records.
+type eventSink func(records []stageRecord) error
+
+var eventSinkFactories = map[string]func() (eventSink, error){
+	"clickhouse": newClickHouseSink,
+	"kafka":      newKafkaSink,
+	"file":       newFileSink,
+}
+
+var eventRecords chan stageRecord
+
+// initEventExport starts the exporter for EVENT_SINK.
+func initEventExport() error {
+	if eventSinkName == "" {
+		return nil
+	}
+	factory, ok := eventSinkFactories[eventSinkName]
+	if !ok {
+		return fmt.Errorf("unknown event sink %q", eventSinkName)
+	}
+	sink, err := factory()
+	if err != nil {
+		return fmt.Errorf("event sink %q: %w", eventSinkName, err)
+	}
+	eventRecords = make(chan stageRecord, eventBuffer)
+	go runEventExport(sink)
+	return nil
+}
+
+// exportStages queues the stage timings of a finished request.
+func exportStages(msg *Message, outcome string) {
+	if eventRecords == nil {
+		return
+	}
+	received := msg.Meta.At(stageRestRequestReceived)
+	record := stageRecord{
+		RequestID:    msg.RequestID,
+		Tenant:       msg.Tenant,
+		JobType:      msg.JobType,
+		Cohort:       cohortOf(msg),
+		Outcome:      outcome,
+		Attempts:     len(msg.Meta.Attempts),
+		EnqueueDepth: msg.Meta.EnqueueDepth,
+		ReceivedMs:   received / int64(time.Millisecond),
+		RoundtripMs:  float64(msg.Meta.RoundtripDurationNs) / 1_000_000,
+	}
+	record.Team, record.CostCenter = msg.Cost.values()
+	if msg.Worker != nil {
+		record.Worker, record.WorkerVersion = msg.Worker.Hostname, msg.Worker.Version
+	}
+	for _, stage := range msg.Meta.Stages {
+		record.StageNames = append(record.StageNames, stage.Name)
+		record.StageOffsetsMs = append(record.StageOffsetsMs, float64(stage.TsNs-received)/1_000_000)
+	}
+
+	select {
+	case eventRecords <- record:
+	default:
+		metrics.CounterEventsDropped.Inc()
+	}
+}
+
+func runEventExport(sink eventSink) {
+	ticker := time.NewTicker(eventFlush)
+	defer ticker.Stop()
+
+	batch := make([]stageRecord, 0, eventBatch)
+	for {
+		select {
+		case record := <-eventRecords:
+			batch = append(batch, record)
+			if len(batch) < eventBatch {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := sink(batch); err != nil {
+			metrics.CounterEventExportErrors.Inc()
+			slog.Error("Cannot export events", "sink", eventSinkName, "events", len(batch), "error", err)
+		}
+		batch = batch[:0]
+	}
+}
+
+// --- Sinks ---
+
+var eventClient = &http.Client{Timeout: 30 * time.Second}
+
+// newClickHouseSink inserts into CLICKHOUSE_TABLE through the ClickHouse HTTP interface at
+// CLICKHOUSE_URL, one JSONEachRow insert per batch.
+func newClickHouseSink() (eventSink, error) {
+	base, err := url.Parse(envString("CLICKHOUSE_URL", "http://clickhouse:8123/"))
+	if err != nil {
+		return nil, err
+	}
+	query := base.Query()
+	query.Set("query", "INSERT INTO "+envString("CLICKHOUSE_TABLE", "request_stages")+" FORMAT JSONEachRow")
+	// Tables created before a field was added keep accepting rows
+	query.Set("input_format_skip_unknown_fields", "1")
+	base.RawQuery = query.Encode()
+	endpoint := base.String()
+	user := envString("CLICKHOUSE_USER", "")
+
+	return func(records []stageRecord) error {
+		body, err := jsonLines(records)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		if user != "" {
+			req.Header.Set("X-ClickHouse-User", user)
+			req.Header.Set("X-ClickHouse-Key", secret("CLICKHOUSE_PASSWORD", ""))
+		}
+		return postEvents(req)
+	}, nil
+}
+
+// newKafkaSink produces to KAFKA_TOPIC through a Kafka REST proxy at KAFKA_REST_URL (Confluent
+// REST API v2), keyed by request id, which keeps a Kafka client out of the gateway.
+func newKafkaSink() (eventSink, error) {
+	topic := envString("KAFKA_TOPIC", "request-stages")
+	endpoint := strings.TrimSuffix(envString("KAFKA_REST_URL", "http://kafka-rest:8082"), "/") + "/topics/" + url.PathEscape(topic)
+
+	type kafkaRecord struct {
+		Key   string      `json:"key"`
+		Value stageRecord `json:"value"`
+	}
+	return func(records []stageRecord) error {
+		body := struct {
+			Records []kafkaRecord `json:"records"`
+		}{Records: make([]kafkaRecord, len(records))}
+		for i, record := range records {
+			body.Records[i] = kafkaRecord{Key: record.RequestID, Value: record}
+		}
+		payload, err := codec.Marshal(body)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+		return postEvents(req)
+	}, nil
+}
+
+// newFileSink appends JSON lines to EVENT_FILE, for shipping by a log collector.
+func newFileSink() (eventSink, error) {
+	file, err := os.OpenFile(envString("EVENT_FILE", "events.jsonl"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return func(records []stageRecord) error {
+		lines, err := jsonLines(records)
+		if err != nil {
+			return err
+		}
+		_, err = file.Write(lines)
+		return err
+	}, nil
+}
+
+// jsonLines encodes records as newline delimited JSON.
+func jsonLines(records []stageRecord) ([]byte, error) {
+	var lines bytes.Buffer
+	for _, record := range records {
+		line, err := codec.Marshal(record)
+		if err != nil {
+			return nil, err
+		}
+		lines.Write(line)
+		lines.WriteByte('\n')
+	}
+	return lines.Bytes(), nil
+}
+
+func postEvents(req *http.Request) error {
+	resp, err := eventClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("sink responded %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package gateway
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+	"go-async-proxy/metrics"
+)
+
+// --- Failure Cache ---
+
+// A payload the handler failed on will most likely fail again, so with FAILURE_CACHE=on the
+// gateway remembers the error of a failed job by the digest of what the handler saw (job type,
+// tenant, content) and answers the same payload with that error for FAILURE_CACHE_TTL, without
+// queueing it. "observe" only counts the requests that would have been answered from the cache.
+// Callers force a real run with X-Failure-Cache: bypass; a successful run forgets the failure.
+// Uploaded files are never cached, their content doesn't go through the gateway's memory.
+
+const (
+	failureCacheOff     = "off"
+	failureCacheObserve = "observe"
+	failureCacheOn      = "on"
+)
+
+var (
+	failureCache    = stringTunable("FAILURE_CACHE", failureCacheOff, failureCacheOff, failureCacheObserve, failureCacheOn)
+	failureCacheTTL = durationTunable("FAILURE_CACHE_TTL", time.Minute)
+)
+
+func failureKey(digest string) string {
+	return "validate:failure:" + digest
+}
+
+// failureDigest identifies the work a job asks for, "" when it can't be cached.
+func failureDigest(msg *Message) string {
+	if msg.Data.File != nil {
+		return ""
+	}
+	h := sha256.New()
+	for _, part := range []string{msg.JobType, msg.Tenant, strconv.FormatBool(msg.Data.Binary), msg.Data.Content} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cachedFailure returns the error a previous run of the same payload failed with, when the
+// request should be answered with it instead of being queued.
+func cachedFailure(c *fiber.Ctx, msg *Message) (string, bool) {
+	mode := failureCache.Get()
+	if mode == failureCacheOff || c.Get("X-Failure-Cache") == "bypass" {
+		return "", false
+	}
+	digest := failureDigest(msg)
+	if digest == "" {
+		return "", false
+	}
+	failure, err := rdb.Get(ctx, failureKey(digest)).Result()
+	if err != nil {
+		return "", false
+	}
+	metrics.CounterFailureCacheHits.WithLabelValues(mode).Inc()
+	return failure, mode == failureCacheOn
+}
+
+// respondCachedFailure answers like a failed run of the job, flagged with X-Failure-Cache: hit.
+func respondCachedFailure(c *fiber.Ctx, keyID string, msg *Message, failure string) error {
+	now := clock.Now().UnixNano()
+	msg.Meta.Attempts = append(msg.Meta.Attempts, Attempt{StartNs: now, EndNs: now, Error: failure})
+	msg.Meta.MarkAt(stageRestResponsePulled, now)
+	msg.Meta.RoundtripDurationNs = now - msg.Meta.At(stageRestRequestReceived)
+	msg.Data.Result = false
+	if !isDryRun(c) {
+		recordOutcome(msg, outcomeFailed)
+		recordUsage(keyID, msg, nil, outcomeFailed)
+		setJobStatus(msg.RequestID, jobStatusFailed)
+		storeJob(msg, jobStatusFailed)
+	}
+	c.Set("X-Failure-Cache", "hit")
+	return respondMessage(c, msg)
+}
+
+// rememberFailure caches the error of a failed job, or forgets the payload's failure once a
+// run succeeds. Jobs failed by their queue_wait budget or expired in the queue say nothing about
+// the payload.
+func rememberFailure(request, result *Message) {
+	if failureCache.Get() == failureCacheOff || queueDeadlineMissed(result) || result.Meta.expired() {
+		return
+	}
+	digest := failureDigest(request)
+	if digest == "" {
+		return
+	}
+	failure := result.Meta.lastError()
+	var err error
+	if failure == "" {
+		err = rdb.Del(ctx, failureKey(digest)).Err()
+	} else {
+		err = rdb.Set(ctx, failureKey(digest), failure, failureCacheTTL.Get()).Err()
+		metrics.CounterFailureCacheStores.Inc()
+	}
+	if err != nil && err != redis.Nil {
+		jobLogger(request).Warn("Cannot update failure cache", "error", err)
+	}
+}
+
+// flushFailureCache forgets every remembered failure.
+func flushFailureCache() error {
+	iter := rdb.Scan(ctx, 0, failureKey("*"), 100).Iterator()
+	for iter.Next(ctx) {
+		if err := rdb.Del(ctx, iter.Val()).Err(); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
@@ -0,0 +1,71 @@
+package gateway
+
+import (
+	"io"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// --- File Uploads ---
+
+// FileRef points the worker at an uploaded file stored in Redis.
+type FileRef struct {
+	Key         string `json:"key" xml:"key,attr"`
+	Name        string `json:"name" xml:"name,attr"`
+	Size        int64  `json:"size" xml:"size,attr"`
+	ContentType string `json:"content_type,omitempty" xml:"content_type,attr,omitempty"`
+}
+
+func fileKey(requestId string) string {
+	return "validate:file:" + requestId
+}
+
+// validateFileHandler serves POST /validate/file. The upload is stored under validate:file:<id>
+// for the worker to stream, and deleted once the result is in; files of abandoned jobs expire
+// with the late result window.
+func validateFileHandler(c *fiber.Ctx) error {
+	if inMaintenance() {
+		return respondMaintenance(c)
+	}
+	if err := rejectWhenShedding(c); err != nil {
+		return err
+	}
+	// Checked before the upload is stored, submitAndWait would only check it after
+	if err := checkResponseFormat(c); err != nil {
+		return err
+	}
+	requestReceived := nowNs()
+	header, err := c.FormFile("file")
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Missing 'file' form field")
+	}
+	if header.Size > int64(maxFileSize) {
+		return fiber.NewError(fiber.StatusRequestEntityTooLarge, "File too large")
+	}
+	file, err := header.Open()
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Unreadable upload")
+	}
+	defer file.Close()
+	content, err := io.ReadAll(io.LimitReader(file, int64(maxFileSize)+1))
+	if err != nil || len(content) > maxFileSize {
+		return fiber.NewError(fiber.StatusRequestEntityTooLarge, "File too large")
+	}
+
+	msg := prepareMessage("", requestReceived)
+	msg.Tenant = c.Get("X-Tenant")
+	msg.JobType = c.Query("type")
+	msg.Data.File = &FileRef{
+		Key:         fileKey(msg.RequestID),
+		Name:        header.Filename,
+		Size:        int64(len(content)),
+		ContentType: header.Header.Get(fiber.HeaderContentType),
+	}
+	if isDryRun(c) {
+		return submitAndWait(c, msg)
+	}
+	if err := rdb.Set(ctx, msg.Data.File.Key, content, waitTimeout.Get()+jobResultTTL.Get()).Err(); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to store the file")
+	}
+	return submitAndWait(c, msg)
+}
@@ -0,0 +1,280 @@
+package gateway
+
+import (
+	"cmp"
+	"container/list"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"go-async-proxy/metrics"
+)
+
+// --- Firewall ---
+
+// A first line of defense in front of everything else, API keys included. IP_DENY and
+// IP_ALLOW are comma separated CIDRs: a client in IP_DENY is refused, and when IP_ALLOW is
+// set a client outside it is refused too. WAF_PATTERNS, a JSON array of regular expressions,
+// refuses submissions whose content matches one of them: the content query param of any
+// request and the first WAF_SCAN_BYTES of the body of /validate requests. Refusals are 403s
+// counted in rest_firewall_blocks_total{reason}.
+//
+// Per client IP the gateway counts refusals and 4xx answers, for up to FIREWALL_TRACKED_IPS
+// clients (the least recently seen is forgotten first); GET /admin/firewall lists the worst
+// offenders. The client IP is the connection's peer, unless the peer is in TRUSTED_PROXIES
+// (comma separated IPs or CIDRs, e.g. traefik's overlay network): then it is the rightmost
+// valid IP of its PROXY_HEADER, X-Forwarded-For by default, that is not a trusted proxy
+// itself. Entries left of it were written by the client, and headers of any other peer are
+// ignored, so clients can't pick their own IP.
+
+const (
+	firewallDenied     = "denied"
+	firewallNotAllowed = "not_allowed"
+	firewallPattern    = "pattern"
+)
+
+var firewall struct {
+	allow, deny    []*net.IPNet
+	patterns       []*regexp.Regexp
+	scanBytes      int
+	trustedProxies []*net.IPNet
+	proxyHeader    string
+}
+
+// ipActivity is what the gateway saw of one client IP.
+type ipActivity struct {
+	IP           string           `json:"ip"`
+	Requests     int64            `json:"requests"`
+	Blocked      map[string]int64 `json:"blocked,omitempty"`
+	ClientErrors int64            `json:"client_errors"`
+	LastSeenMs   int64            `json:"last_seen_ms"`
+}
+
+func (a *ipActivity) anomalies() int64 {
+	total := a.ClientErrors
+	for _, count := range a.Blocked {
+		total += count
+	}
+	return total
+}
+
+var ipActivities = struct {
+	sync.Mutex
+	order *list.List // of *ipActivity, front is the most recently seen
+	byIP  map[string]*list.Element
+	max   int
+}{order: list.New(), byIP: map[string]*list.Element{}}
+
+func parseCIDRs(key string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, cidr := range strings.Split(envString(key, ""), ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s entry %q: %w", key, cidr, err)
+		}
+		networks = append(networks, ipNet)
+	}
+	return networks, nil
+}
+
+// parseProxies returns the comma separated IPs and CIDRs of key, an IP being a network of
+// its own.
+func parseProxies(key string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, proxy := range strings.Split(envString(key, ""), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy == "" {
+			continue
+		}
+		if _, ipNet, err := net.ParseCIDR(proxy); err == nil {
+			proxies = append(proxies, ipNet)
+			continue
+		}
+		ip := net.ParseIP(proxy)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid %s entry %q: not an IP or CIDR", key, proxy)
+		}
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	return proxies, nil
+}
+
+func initFirewall() error {
+	var err error
+	if firewall.allow, err = parseCIDRs("IP_ALLOW"); err != nil {
+		return err
+	}
+	if firewall.deny, err = parseCIDRs("IP_DENY"); err != nil {
+		return err
+	}
+	var sources []string
+	if raw := envString("WAF_PATTERNS", ""); raw != "" {
+		if err := codec.Unmarshal([]byte(raw), &sources); err != nil {
+			return fmt.Errorf("invalid WAF_PATTERNS: %w", err)
+		}
+	}
+	for _, source := range sources {
+		pattern, err := regexp.Compile(source)
+		if err != nil {
+			return fmt.Errorf("invalid WAF pattern %q: %w", source, err)
+		}
+		firewall.patterns = append(firewall.patterns, pattern)
+	}
+	firewall.scanBytes = envInt("WAF_SCAN_BYTES", 64<<10)
+	if firewall.trustedProxies, err = parseProxies("TRUSTED_PROXIES"); err != nil {
+		return err
+	}
+	firewall.proxyHeader = envString("PROXY_HEADER", fiber.HeaderXForwardedFor)
+	ipActivities.max = envInt("FIREWALL_TRACKED_IPS", 10000)
+	return nil
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	return slices.ContainsFunc(networks, func(n *net.IPNet) bool { return n.Contains(ip) })
+}
+
+// clientIP returns the IP of the client of c, looking through trusted proxies.
+func clientIP(c *fiber.Ctx) string {
+	peer := c.Context().RemoteIP()
+	if !containsIP(firewall.trustedProxies, peer) {
+		return peer.String()
+	}
+	// Each proxy appends the peer it got the request from: walk back from the nearest one
+	hops := strings.Split(c.Get(firewall.proxyHeader), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			continue
+		}
+		if !containsIP(firewall.trustedProxies, ip) {
+			return ip.String()
+		}
+		peer = ip
+	}
+	return peer.String()
+}
+
+// firewallVerdict returns why the request must be refused, "" to let it through.
+func firewallVerdict(c *fiber.Ctx) string {
+	ip := net.ParseIP(clientIP(c))
+	if ip != nil && containsIP(firewall.deny, ip) {
+		return firewallDenied
+	}
+	if len(firewall.allow) > 0 && (ip == nil || !containsIP(firewall.allow, ip)) {
+		return firewallNotAllowed
+	}
+	if len(firewall.patterns) == 0 {
+		return ""
+	}
+	content := c.Query("content")
+	var body []byte
+	if strings.HasPrefix(strings.ToLower(c.Path()), "/validate") {
+		body = c.Body()
+		body = body[:min(len(body), firewall.scanBytes)]
+	}
+	for _, pattern := range firewall.patterns {
+		if pattern.MatchString(content) || pattern.Match(body) {
+			return firewallPattern
+		}
+	}
+	return ""
+}
+
+// firewallGuard refuses requests per the firewall rules and tracks every client IP.
+func firewallGuard(c *fiber.Ctx) error {
+	ip := clientIP(c)
+	if verdict := firewallVerdict(c); verdict != "" {
+		metrics.CounterFirewallBlocks.WithLabelValues(verdict).Inc()
+		trackIP(ip, verdict, 0)
+		return fiber.NewError(fiber.StatusForbidden, "Forbidden")
+	}
+	err := c.Next()
+	status := c.Response().StatusCode()
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		status = fiberErr.Code
+	}
+	trackIP(ip, "", status)
+	return err
+}
+
+// trackIP counts a request of ip, refused for blocked or answered with status.
+func trackIP(ip, blocked string, status int) {
+	ipActivities.Lock()
+	defer ipActivities.Unlock()
+	var activity *ipActivity
+	if elem, ok := ipActivities.byIP[ip]; ok {
+		ipActivities.order.MoveToFront(elem)
+		activity = elem.Value.(*ipActivity)
+	} else {
+		if ipActivities.max <= 0 {
+			return
+		}
+		activity = &ipActivity{IP: ip, Blocked: map[string]int64{}}
+		ipActivities.byIP[ip] = ipActivities.order.PushFront(activity)
+		if ipActivities.order.Len() > ipActivities.max {
+			// Forget the least recently seen client to make room
+			oldest := ipActivities.order.Back()
+			ipActivities.order.Remove(oldest)
+			delete(ipActivities.byIP, oldest.Value.(*ipActivity).IP)
+		}
+	}
+	activity.Requests++
+	activity.LastSeenMs = clock.Now().UnixMilli()
+	if blocked != "" {
+		activity.Blocked[blocked]++
+	} else if status >= 400 && status < 500 {
+		activity.ClientErrors++
+	}
+}
+
+// firewallHandler serves GET /admin/firewall: the rules in force and the client IPs with the
+// most refusals and 4xx answers on this replica.
+func firewallHandler(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 50)
+	if limit <= 0 || limit > maxJobsPageSize {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("'limit' must be within 1..%d", maxJobsPageSize))
+	}
+	ipActivities.Lock()
+	offenders := make([]ipActivity, 0, len(ipActivities.byIP))
+	for elem := ipActivities.order.Front(); elem != nil; elem = elem.Next() {
+		if activity := elem.Value.(*ipActivity); activity.anomalies() > 0 {
+			entry := *activity
+			entry.Blocked = maps.Clone(activity.Blocked)
+			offenders = append(offenders, entry)
+		}
+	}
+	tracked := len(ipActivities.byIP)
+	ipActivities.Unlock()
+	slices.SortFunc(offenders, func(a, b ipActivity) int {
+		return cmp.Compare(b.anomalies(), a.anomalies())
+	})
+	offenders = offenders[:min(len(offenders), limit)]
+
+	cidrs := func(networks []*net.IPNet) []string {
+		out := make([]string, len(networks))
+		for i, n := range networks {
+			out[i] = n.String()
+		}
+		return out
+	}
+	return c.JSON(fiber.Map{
+		"allow":       cidrs(firewall.allow),
+		"deny":        cidrs(firewall.deny),
+		"patterns":    len(firewall.patterns),
+		"tracked_ips": tracked,
+		"offenders":   offenders,
+	})
+}
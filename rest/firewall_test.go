@@ -0,0 +1,88 @@
+package gateway
+
+import (
+	"net"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestTrackIPForgetsTheLeastRecentlySeen(t *testing.T) {
+	prevMax := ipActivities.max
+	ipActivities.max = 2
+	t.Cleanup(func() {
+		ipActivities.max = prevMax
+		ipActivities.order.Init()
+		clear(ipActivities.byIP)
+	})
+
+	trackIP("192.0.2.1", "", 200)
+	trackIP("192.0.2.2", "", 200)
+	trackIP("192.0.2.1", "", 404)
+	trackIP("192.0.2.3", "", 200)
+
+	if _, ok := ipActivities.byIP["192.0.2.2"]; ok || len(ipActivities.byIP) != 2 {
+		t.Fatalf("tracked %v, want 192.0.2.2 forgotten", ipActivities.byIP)
+	}
+	if activity := ipActivities.byIP["192.0.2.1"].Value.(*ipActivity); activity.Requests != 2 || activity.ClientErrors != 1 {
+		t.Fatalf("192.0.2.1 = %+v, want 2 requests and 1 client error", activity)
+	}
+}
+
+func TestProxyHeaderIsOnlyTrustedFromTrustedProxies(t *testing.T) {
+	prev := firewall
+	t.Cleanup(func() { firewall = prev })
+	_, denied, _ := net.ParseCIDR("203.0.113.0/24")
+	firewall.deny = []*net.IPNet{denied}
+	firewall.proxyHeader = fiber.HeaderXForwardedFor
+
+	for _, tc := range []struct {
+		trusted string
+		want    int
+	}{
+		{"", fiber.StatusOK},
+		{"10.9.9.9", fiber.StatusOK},
+		{"0.0.0.0/0", fiber.StatusForbidden},
+	} {
+		t.Setenv("TRUSTED_PROXIES", tc.trusted)
+		var err error
+		if firewall.trustedProxies, err = parseProxies("TRUSTED_PROXIES"); err != nil {
+			t.Fatal(err)
+		}
+		app, _ := startTestGateway(t)
+		status, body := get(t, app, "/version", fiber.HeaderXForwardedFor, "203.0.113.7, 10.0.0.1")
+		if status != tc.want {
+			t.Errorf("trusted proxies %v: status %d, body %s, want %d", tc.trusted, status, body, tc.want)
+		}
+	}
+}
+
+func TestClientIPIsTheRightmostUntrustedHop(t *testing.T) {
+	prev := firewall
+	t.Cleanup(func() { firewall = prev })
+	_, denied, _ := net.ParseCIDR("203.0.113.0/24")
+	firewall.deny = []*net.IPNet{denied}
+	firewall.proxyHeader = fiber.HeaderXForwardedFor
+	// The test peer is 0.0.0.0, standing for the proxy in front of the gateway
+	t.Setenv("TRUSTED_PROXIES", "0.0.0.0,10.0.0.0/8")
+	var err error
+	if firewall.trustedProxies, err = parseProxies("TRUSTED_PROXIES"); err != nil {
+		t.Fatal(err)
+	}
+	app, _ := startTestGateway(t)
+
+	for _, tc := range []struct {
+		forwardedFor string
+		want         int
+	}{
+		// A denied client that spoofs the leftmost entry
+		{"198.51.100.1, 203.0.113.7, 10.0.0.1", fiber.StatusForbidden},
+		{"203.0.113.7, 198.51.100.1, 10.0.0.1", fiber.StatusOK},
+		{"203.0.113.7, 10.0.0.2, 10.0.0.1", fiber.StatusForbidden},
+	} {
+		status, body := get(t, app, "/version", fiber.HeaderXForwardedFor, tc.forwardedFor)
+		if status != tc.want {
+			t.Errorf("X-Forwarded-For %q: status %d, body %s, want %d", tc.forwardedFor, status, body, tc.want)
+		}
+	}
+}
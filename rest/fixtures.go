@@ -0,0 +1,81 @@
+package gateway
+
+import (
+	"hash/fnv"
+	"os"
+	"path/filepath"
+)
+
+// --- Fixture Recording ---
+
+// With FIXTURE_DIR set, completed requests are written there as fixtures for
+// cmd/replay-fixtures, which submits them again to a running gateway and compares the results:
+// regression tests built from real traffic. Fixtures copied to testdata/fixtures are replayed by
+// the tests of both services, through app.Test here (replayFixtures) and through the real
+// handler in the worker. Fixtures are sanitized: only the job type and the request and
+// result data are kept, no tenant, trace, API key, cost labels, worker or timings, and the
+// contents are redacted for the fixture sink (see redact.go).
+
+var (
+	// fixtureDir is where fixtures are written; empty turns recording off.
+	fixtureDir = envString("FIXTURE_DIR", "")
+
+	// fixtureSamplePercent is the share of completed requests recorded.
+	fixtureSamplePercent = intTunable("FIXTURE_SAMPLE_PERCENT", 100)
+)
+
+// Fixture is one recorded request and the result it got.
+type Fixture struct {
+	RequestID  string `json:"request_id"`
+	JobType    string `json:"job_type,omitempty"`
+	RecordedMs int64  `json:"recorded_ms"`
+	// Redacted tells redaction changed a content: the replay can't reproduce the recorded
+	// content then, only the result and rules.
+	Redacted bool `json:"redacted,omitempty"`
+	Request  Data `json:"request"`
+	Response Data `json:"response"`
+}
+
+func initFixtures() error {
+	if fixtureDir == "" {
+		return nil
+	}
+	return os.MkdirAll(fixtureDir, 0o755)
+}
+
+// recordFixture writes the request and its result as a fixture when recording is on and the
+// request is sampled. Files and chunked results live in Redis only briefly, so they are left out.
+func recordFixture(request, result *Message) {
+	if fixtureDir == "" || request.Synthetic || request.Data.File != nil || result.Data.Chunks != nil || !fixtureSampled(request.RequestID) {
+		return
+	}
+	fixture := Fixture{
+		RequestID:  request.RequestID,
+		JobType:    request.JobType,
+		RecordedMs: clock.Now().UnixMilli(),
+		Request:    request.Data,
+		Response:   result.Data,
+	}
+	if !request.Data.Binary {
+		fixture.Request.Content = redact(redactFixture, request.Data.Content)
+		fixture.Response.Content = redact(redactFixture, result.Data.Content)
+		fixture.Redacted = fixture.Request.Content != request.Data.Content || fixture.Response.Content != result.Data.Content
+	}
+	payload, err := codec.Marshal(&fixture)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(fixtureDir, request.RequestID+".json"), payload, 0o644)
+	}
+	if err != nil {
+		jobLogger(request).Warn("Cannot record fixture", "error", err)
+	}
+}
+
+func fixtureSampled(requestId string) bool {
+	percent := fixtureSamplePercent.Get()
+	if percent <= 0 {
+		return false
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte("fixture:" + requestId))
+	return int(h.Sum32()%100) < percent
+}
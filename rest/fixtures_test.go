@@ -0,0 +1,91 @@
+package gateway
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The checked-in fixtures are shared with the worker, whose tests replay them through the
+// real handler.
+const fixturesDir = "../testdata/fixtures"
+
+// replayFixtures submits every fixture in fixtureDir to app, the way cmd/replay-fixtures
+// submits them to a running gateway, and fails the test for every result that differs from the
+// recorded one. Recording is off while they replay, so they don't overwrite themselves.
+func replayFixtures(t *testing.T, app *fiber.App) {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join(fixtureDir, "*.json"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no fixtures in %q: %v", fixtureDir, err)
+	}
+	prevDir := fixtureDir
+	fixtureDir = ""
+	defer func() { fixtureDir = prevDir }()
+
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var f Fixture
+			if err := codec.Unmarshal(raw, &f); err != nil {
+				t.Fatalf("invalid fixture: %v", err)
+			}
+
+			query := url.Values{"content": {f.Request.Content}}
+			if f.Request.Binary {
+				query.Set("encoding", "base64")
+			}
+			if f.JobType != "" {
+				query.Set("type", f.JobType)
+			}
+			status, body := get(t, app, "/validate?"+query.Encode())
+			if status != fiber.StatusOK {
+				t.Fatalf("gateway responded %d: %s", status, body)
+			}
+			var result Message
+			if err := codec.Unmarshal(body, &result); err != nil {
+				t.Fatalf("invalid response %s: %v", body, err)
+			}
+			got := result.Data
+			if f.Redacted {
+				// The handler saw the redacted content, so only the verdict is comparable
+				got.Content = f.Response.Content
+			}
+			if !reflect.DeepEqual(got, f.Response) {
+				t.Errorf("result differs\n  want %+v\n  got  %+v", f.Response, got)
+			}
+		})
+	}
+}
+
+func TestReplayCheckedInFixtures(t *testing.T) {
+	app, srv := startTestGateway(t)
+	srv.Work(t, queueKey, upperCase)
+	prevDir := fixtureDir
+	fixtureDir = fixturesDir
+	t.Cleanup(func() { fixtureDir = prevDir })
+
+	replayFixtures(t, app)
+}
+
+func TestRecordedFixturesReplay(t *testing.T) {
+	app, srv := startTestGateway(t)
+	srv.Work(t, queueKey, upperCase)
+	prevDir := fixtureDir
+	fixtureDir = t.TempDir()
+	t.Cleanup(func() { fixtureDir = prevDir })
+
+	for _, content := range []string{"hello", "héllo wörld"} {
+		if status, body := get(t, app, "/validate?"+url.Values{"content": {content}}.Encode()); status != fiber.StatusOK {
+			t.Fatalf("status = %d, body %s", status, body)
+		}
+	}
+	replayFixtures(t, app)
+}
@@ -0,0 +1,120 @@
+package gateway
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"go-async-proxy/metrics"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// --- Feature Flags ---
+
+// flagsKey is the Redis hash of flag rollouts: field = flag name, value = percentage of
+// requests (0-100) the flag is on for. FLAGS ("name=percent,...") gives the startup defaults.
+const flagsKey = "validate:flags"
+
+// flagRollouts maps every known flag to its rollout percentage.
+var flagRollouts atomic.Pointer[map[string]int]
+
+func init() {
+	defaults, err := parseFlags(envString("FLAGS", ""))
+	if err != nil {
+		slog.Warn("Invalid FLAGS", "error", err)
+	}
+	flagRollouts.Store(&defaults)
+}
+
+// parseFlags reads "name=percent,name=percent".
+func parseFlags(raw string) (map[string]int, error) {
+	rollouts := map[string]int{}
+	for _, pair := range strings.Split(raw, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		name, percent, ok := strings.Cut(pair, "=")
+		if !ok {
+			return rollouts, fmt.Errorf("invalid FLAGS entry %q", pair)
+		}
+		if err := setRollout(rollouts, strings.TrimSpace(name), strings.TrimSpace(percent)); err != nil {
+			return rollouts, err
+		}
+	}
+	return rollouts, nil
+}
+
+func setRollout(rollouts map[string]int, name, raw string) error {
+	percent, err := strconv.Atoi(raw)
+	if err != nil || percent < 0 || percent > 100 {
+		return fmt.Errorf("invalid rollout %q for flag %s: must be 0-100", raw, name)
+	}
+	rollouts[name] = percent
+	return nil
+}
+
+// reloadFlags merges the validate:flags hash over the FLAGS defaults. It runs on every config
+// reload; a broken entry is reported and skipped.
+func reloadFlags() {
+	stored, err := rdb.HGetAll(ctx, flagsKey).Result()
+	if err != nil {
+		slog.Error("Flags reload failed, keeping current flags", "error", err)
+		return
+	}
+	rollouts, _ := parseFlags(envString("FLAGS", ""))
+	for name, raw := range stored {
+		if err := setRollout(rollouts, name, raw); err != nil {
+			slog.Warn("Invalid flag rollout", "error", err)
+		}
+	}
+	flagRollouts.Store(&rollouts)
+}
+
+// flagEnabled tells whether flag is on for the request. The same request always lands in the
+// same bucket, so raising a rollout only adds requests.
+func flagEnabled(flag, requestId string) bool {
+	percent := (*flagRollouts.Load())[flag]
+	if percent <= 0 {
+		return false
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(flag + ":" + requestId))
+	return int(h.Sum32()%100) < percent
+}
+
+// decideFlags evaluates every known flag for msg once, at the gateway, and carries the enabled
+// ones in the envelope so the worker takes the same decision.
+func decideFlags(msg *Message) {
+	rollouts := *flagRollouts.Load()
+	names := make([]string, 0, len(rollouts))
+	for name := range rollouts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		enabled := flagEnabled(name, msg.RequestID)
+		metrics.CounterFlagDecisions.WithLabelValues(name, strconv.FormatBool(enabled)).Inc()
+		if enabled {
+			msg.Flags = append(msg.Flags, name)
+		}
+	}
+}
+
+// hasFlag reports whether the gateway turned flag on for this message.
+func (m *Message) hasFlag(flag string) bool {
+	for _, name := range m.Flags {
+		if name == flag {
+			return true
+		}
+	}
+	return false
+}
+
+func flagsHandler(c *fiber.Ctx) error {
+	return c.JSON(*flagRollouts.Load())
+}
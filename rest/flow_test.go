@@ -0,0 +1,55 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateRoundTrip(t *testing.T) {
+	app, srv := startTestGateway(t)
+	srv.Work(t, queueKey, upperCase)
+
+	status, body := get(t, app, "/validate?content=hello")
+	if status != 200 {
+		t.Fatalf("status = %d, body %s", status, body)
+	}
+	var msg Message
+	if err := codec.Unmarshal(body, &msg); err != nil {
+		t.Fatalf("invalid response %s: %v", body, err)
+	}
+	if msg.Data.Content != "HELLO" || !msg.Data.Result {
+		t.Errorf("data = %+v, want HELLO and a passing result", msg.Data)
+	}
+	// Every stage was stamped by the fake clock, which did not move
+	for _, stage := range msg.Meta.Stages {
+		if stage.TsNs != srv.Clock.Now().UnixNano() {
+			t.Errorf("stage %s at %d, want the fake clock's %d", stage.Name, stage.TsNs, srv.Clock.Now().UnixNano())
+		}
+	}
+
+	state, known := readJobState(msg.RequestID)
+	if !known || state.status != jobStatusCompleted {
+		t.Errorf("job status = %q (known %v), want %q", state.status, known, jobStatusCompleted)
+	}
+}
+
+func TestJobInfoExpiresWithTheClock(t *testing.T) {
+	app, srv := startTestGateway(t)
+	srv.Work(t, queueKey, upperCase)
+
+	status, body := get(t, app, "/validate?content=hello")
+	if status != 200 {
+		t.Fatalf("status = %d, body %s", status, body)
+	}
+	var msg Message
+	if err := codec.Unmarshal(body, &msg); err != nil {
+		t.Fatalf("invalid response %s: %v", body, err)
+	}
+	if !srv.Exists(jobInfoKey(msg.RequestID)) {
+		t.Fatalf("job info of %s missing", msg.RequestID)
+	}
+	srv.Clock.Advance(jobResultTTL.Get() + waitTimeout.Get() + time.Minute)
+	if srv.Exists(jobInfoKey(msg.RequestID)) {
+		t.Errorf("job info of %s outlived its TTL", msg.RequestID)
+	}
+}
@@ -0,0 +1,106 @@
+package gateway
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go-async-proxy/metrics"
+)
+
+// --- Timeout Forensics ---
+
+// A 504 says nothing about where the job got stuck. On a timeout the gateway reads back what
+// the worker noted in the job's info hash (worker_stage, worker_stage_ms and worker_id, see
+// the worker's progress reports) and, when no worker touched the job yet, its queue position.
+// The stage it reached is answered as X-Timeout-Stage and in the body, and counted in
+// rest_timeouts_total{stage}:
+//
+//	queued      still in its queue, with its position
+//	claimed     popped by a worker that did not start the handler yet (e.g. a job type limit)
+//	processing  the worker's handler is running
+//	unknown     the job index could not tell
+//
+// A job no longer in its queue that no worker reported on was taken by a worker that does
+// not report progress, and counts as claimed.
+
+const (
+	timeoutQueued     = "queued"
+	timeoutClaimed    = "claimed"
+	timeoutProcessing = "processing"
+	timeoutUnknown    = "unknown"
+)
+
+// timeoutForensics is how far a timed-out job got.
+type timeoutForensics struct {
+	Stage string
+	// WorkerID and Since are set when a worker reported the stage, Since being how long ago.
+	WorkerID string
+	Since    time.Duration
+	// Queue is set while the job is queued.
+	Queue *queueEstimate
+}
+
+// diagnoseTimeout finds the stage the timed-out job requestId reached.
+func diagnoseTimeout(requestId string) timeoutForensics {
+	fields, err := rdb.HMGet(ctx, jobInfoKey(requestId), "worker_stage", "worker_stage_ms", "worker_id").Result()
+	if err != nil {
+		return timeoutForensics{Stage: timeoutUnknown}
+	}
+	if stage, _ := fields[0].(string); stage == timeoutClaimed || stage == timeoutProcessing {
+		forensics := timeoutForensics{Stage: stage}
+		forensics.WorkerID, _ = fields[2].(string)
+		if raw, ok := fields[1].(string); ok {
+			if ms, err := strconv.ParseInt(raw, 10, 64); err == nil {
+				forensics.Since = clock.Now().Sub(time.UnixMilli(ms))
+			}
+		}
+		return forensics
+	}
+	estimate, ok := jobQueueEstimate(requestId)
+	switch {
+	case !ok:
+		return timeoutForensics{Stage: timeoutUnknown}
+	case estimate.Position > 0:
+		return timeoutForensics{Stage: timeoutQueued, Queue: &estimate}
+	default:
+		return timeoutForensics{Stage: timeoutClaimed}
+	}
+}
+
+// String describes the stage for the error message.
+func (f timeoutForensics) String() string {
+	switch {
+	case f.Queue != nil:
+		return fmt.Sprintf("still queued at position %d", f.Queue.Position)
+	case f.WorkerID == "":
+		return f.Stage
+	case f.Stage == timeoutClaimed:
+		return fmt.Sprintf("claimed by worker %s %s ago", f.WorkerID, f.Since.Round(time.Millisecond))
+	default:
+		return fmt.Sprintf("processing on worker %s for %s", f.WorkerID, f.Since.Round(time.Millisecond))
+	}
+}
+
+// respondTimeout diagnoses the timed-out msg, counts it by stage and sets X-Timeout-Stage.
+func respondTimeout(c *fiber.Ctx, msg *Message) timeoutForensics {
+	forensics := diagnoseTimeout(msg.RequestID)
+	metrics.CounterTimeouts.WithLabelValues(forensics.Stage).Inc()
+	jobLogger(msg).Info("Timed out", "stage", forensics.Stage, "worker", forensics.WorkerID)
+	c.Set("X-Timeout-Stage", forensics.Stage)
+	return forensics
+}
+
+// addTimeoutFields adds the stage to a timed-out job's JSON body.
+func addTimeoutFields(body fiber.Map, forensics timeoutForensics) fiber.Map {
+	body["stage"] = forensics.Stage
+	if forensics.WorkerID != "" {
+		body["worker_id"] = forensics.WorkerID
+		body["stage_since_ms"] = forensics.Since.Milliseconds()
+	}
+	if forensics.Queue != nil {
+		addQueueFields(body, *forensics.Queue)
+	}
+	return body
+}
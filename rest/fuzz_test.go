@@ -0,0 +1,73 @@
+package gateway
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// addEnvelopeSeeds seeds f with the golden envelopes, see envelope_test.go.
+func addEnvelopeSeeds(f *testing.F) {
+	files, _ := filepath.Glob(filepath.Join(envelopeDir, "*.json"))
+	for _, file := range files {
+		payload, err := os.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(payload)
+	}
+	f.Add([]byte(`{"meta":{"stages":null},"data":{"content":""}}`))
+	f.Add([]byte(`{"request_id":1}`))
+}
+
+// FuzzDecodeResult feeds arbitrary queue payloads to the response decoder, under every codec:
+// a worker's bytes must never crash the gateway, and what decodes must encode again.
+// Fuzz with -fuzzminimizetime=5s: the golden seeds are large, and minimizing every new input
+// for the default minute stalls the run.
+func FuzzDecodeResult(f *testing.F) {
+	addEnvelopeSeeds(f)
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		prev := codec
+		defer func() { codec = prev }()
+		for _, c := range codecs {
+			codec = c
+			msg, err := decodeResult(payload)
+			if err != nil {
+				continue
+			}
+			if _, err := codec.Marshal(msg); err != nil {
+				t.Fatalf("%s: decoded %q but cannot encode it again: %v", c.Name(), payload, err)
+			}
+		}
+	})
+}
+
+// FuzzDecodeMsgpack feeds arbitrary bytes to a msgpack decoder set up like the encoder of
+// respondMessage, so clients reading Accept: application/msgpack can trust the format.
+func FuzzDecodeMsgpack(f *testing.F) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.SetOmitEmpty(true)
+	if err := enc.Encode(goldenRequest()); err != nil {
+		f.Fatal(err)
+	}
+	f.Add(buf.Bytes())
+	f.Add([]byte{0x80})
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		dec := msgpack.NewDecoder(bytes.NewReader(payload))
+		dec.SetCustomStructTag("json")
+		var msg Message
+		if dec.Decode(&msg) != nil {
+			return
+		}
+		enc := msgpack.NewEncoder(&bytes.Buffer{})
+		enc.SetCustomStructTag("json")
+		if err := enc.Encode(&msg); err != nil {
+			t.Fatalf("decoded %x but cannot encode it again: %v", payload, err)
+		}
+	})
+}
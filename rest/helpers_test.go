@@ -0,0 +1,47 @@
+package gateway
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"go-async-proxy/redistest"
+)
+
+// startTestGateway points the gateway at a fresh miniredis and its fake clock, for the length
+// of the test, and returns the app with every route. Replies go through the response keys
+// (REPLY_MODE=key), so no dispatcher has to run.
+func startTestGateway(t *testing.T) (*fiber.App, *redistest.Server) {
+	t.Helper()
+	srv := redistest.Start(t)
+	prevRdb, prevClock, prevReplyMode := rdb, clock, replyMode
+	rdb, clock, replyMode = srv.Client, srv.Clock, replyModeKey
+	t.Cleanup(func() { rdb, clock, replyMode = prevRdb, prevClock, prevReplyMode })
+	return newApp(), srv
+}
+
+// upperCase is the demo worker's processing.
+func upperCase(job redistest.Job) {
+	job.SetResult(strings.ToUpper(job.Content()), true)
+}
+
+// get sends a GET to app and returns the status and body.
+func get(t *testing.T, app *fiber.App, target string, headers ...string) (int, []byte) {
+	t.Helper()
+	req := httptest.NewRequest(fiber.MethodGet, target, nil)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("GET %s: %v", target, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("GET %s: %v", target, err)
+	}
+	return resp.StatusCode, body
+}
@@ -0,0 +1,202 @@
+package gateway
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Job Index ---
+
+// Every submitted job gets a small info hash (status, queue, tenant, received_ms and the
+// updated_ms of its last status change) plus membership in sorted sets scored by the
+// receive time (unix ms): one overall, one per status, per queue and per tenant. The queue is
+// the list the job was pushed to (canary, region, affinity partition or tenant queue
+// included), and validate:jobs:queues lists every queue with a set. GET /jobs picks the
+// narrowest set for the filters and checks the rest against the hash.
+
+const (
+	jobStatusPending   = "pending"
+	jobStatusCompleted = "completed"
+	jobStatusTimeout   = "timeout"
+	jobStatusFailed    = "failed"
+	jobStatusLate      = "late"
+
+	jobsIndexKey  = "validate:jobs:index"
+	jobsQueuesKey = "validate:jobs:queues"
+
+	maxJobsPageSize = 500
+)
+
+func jobInfoKey(requestId string) string {
+	return fmt.Sprintf("validate:jobinfo:%s", requestId)
+}
+
+func jobsByStatusKey(status string) string {
+	return fmt.Sprintf("validate:jobs:status:%s", status)
+}
+
+func jobsByQueueKey(queue string) string {
+	return fmt.Sprintf("validate:jobs:queue:%s", queue)
+}
+
+func jobsByTenantKey(tenant string) string {
+	return fmt.Sprintf("validate:jobs:tenant:%s", tenant)
+}
+
+type JobInfo struct {
+	RequestID  string `json:"request_id"`
+	Status     string `json:"status"`
+	Queue      string `json:"queue"`
+	Tenant     string `json:"tenant,omitempty"`
+	ReceivedMs int64  `json:"received_ms"`
+}
+
+// indexJob adds a freshly submitted job, pushed to queue, to the index within the given
+// transaction, and wakes whoever awaits it. Synthetic probes are left out.
+func indexJob(pipe redis.Pipeliner, msg *Message, queue string) {
+	if msg.Synthetic {
+		return
+	}
+	receivedMs := msg.Meta.At(stageRestRequestReceived) / int64(time.Millisecond)
+	entry := redis.Z{Score: float64(receivedMs), Member: msg.RequestID}
+
+	pipe.HSet(ctx, jobInfoKey(msg.RequestID),
+		"status", jobStatusPending,
+		"queue", queue,
+		"tenant", msg.Tenant,
+		"received_ms", receivedMs,
+		"updated_ms", clock.Now().UnixMilli(),
+	)
+	pipe.Expire(ctx, jobInfoKey(msg.RequestID), jobResultTTL.Get())
+	pipe.ZAdd(ctx, jobsIndexKey, entry)
+	pipe.ZAdd(ctx, jobsByStatusKey(jobStatusPending), entry)
+	pipe.ZAdd(ctx, jobsByQueueKey(queue), entry)
+	pipe.SAdd(ctx, jobsQueuesKey, queue)
+	if msg.Tenant != "" {
+		pipe.ZAdd(ctx, jobsByTenantKey(msg.Tenant), entry)
+	}
+	pipe.Publish(ctx, jobChangesChannel, msg.RequestID)
+}
+
+// setJobStatus moves a job into the given status set, keeping its receive time as score, and
+// wakes the long polls on it.
+func setJobStatus(requestId, status string) {
+	fields, err := rdb.HMGet(ctx, jobInfoKey(requestId), "status", "received_ms").Result()
+	if err != nil || fields[0] == nil || fields[1] == nil {
+		return
+	}
+	current, _ := fields[0].(string)
+	receivedMs, err := strconv.ParseFloat(fmt.Sprint(fields[1]), 64)
+	if err != nil || current == status {
+		return
+	}
+
+	pipe := rdb.TxPipeline()
+	pipe.HSet(ctx, jobInfoKey(requestId), "status", status, "updated_ms", clock.Now().UnixMilli())
+	pipe.ZRem(ctx, jobsByStatusKey(current), requestId)
+	pipe.ZAdd(ctx, jobsByStatusKey(status), redis.Z{Score: receivedMs, Member: requestId})
+	pipe.Publish(ctx, jobChangesChannel, requestId)
+	_, _ = pipe.Exec(ctx)
+}
+
+// trimJobIndex drops index entries older than the job retention. Tenant sets are trimmed
+// lazily when listed, since there is no cheap way to enumerate them.
+func trimJobIndex() {
+	retention := jobResultTTL.Get()
+	if shedding.Load() {
+		retention = min(retention, shedResultTTL.Get())
+	}
+	cutoff := strconv.FormatInt(clock.Now().Add(-retention).UnixMilli(), 10)
+	keys := []string{jobsIndexKey}
+	queues, _ := rdb.SMembers(ctx, jobsQueuesKey).Result()
+	for _, queue := range queues {
+		keys = append(keys, jobsByQueueKey(queue))
+	}
+	for _, status := range []string{jobStatusWaiting, jobStatusPending, jobStatusCompleted, jobStatusTimeout, jobStatusFailed, jobStatusLate} {
+		keys = append(keys, jobsByStatusKey(status))
+	}
+
+	pipe := rdb.Pipeline()
+	for _, key := range keys {
+		pipe.ZRemRangeByScore(ctx, key, "-inf", "("+cutoff)
+	}
+	_, _ = pipe.Exec(ctx)
+}
+
+// --- Jobs Listing Handler ---
+
+// jobsHandler serves GET /jobs?status=&queue=&tenant=&since=&cursor=&limit=,
+// oldest first. since is unix ms; cursor is the opaque next_cursor of the previous page.
+func jobsHandler(c *fiber.Ctx) error {
+	status, queue, tenant := c.Query("status"), c.Query("queue"), c.Query("tenant")
+
+	limit := c.QueryInt("limit", 50)
+	if limit <= 0 || limit > maxJobsPageSize {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("'limit' must be within 1..%d", maxJobsPageSize))
+	}
+	offset := c.QueryInt("cursor", 0)
+	if offset < 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid 'cursor' query param")
+	}
+	since := "-inf"
+	if raw := c.Query("since"); raw != "" {
+		if _, err := strconv.ParseInt(raw, 10, 64); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "'since' must be unix milliseconds")
+		}
+		since = raw
+	}
+
+	// Narrowest index first
+	index := jobsIndexKey
+	switch {
+	case tenant != "":
+		index = jobsByTenantKey(tenant)
+	case status != "":
+		index = jobsByStatusKey(status)
+	case queue != "":
+		index = jobsByQueueKey(queue)
+	}
+
+	ids, err := rdb.ZRangeByScore(ctx, index, &redis.ZRangeBy{
+		Min: since, Max: "+inf", Offset: int64(offset), Count: int64(limit),
+	}).Result()
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to list jobs")
+	}
+
+	pipe := rdb.Pipeline()
+	infos := make([]*redis.MapStringStringCmd, len(ids))
+	for i, id := range ids {
+		infos[i] = pipe.HGetAll(ctx, jobInfoKey(id))
+	}
+	_, _ = pipe.Exec(ctx)
+
+	jobs := make([]JobInfo, 0, len(ids))
+	var expired []any
+	for i, id := range ids {
+		fields := infos[i].Val()
+		if len(fields) == 0 {
+			expired = append(expired, id)
+			continue
+		}
+		job := JobInfo{RequestID: id, Status: fields["status"], Queue: fields["queue"], Tenant: fields["tenant"]}
+		job.ReceivedMs, _ = strconv.ParseInt(fields["received_ms"], 10, 64)
+		if (status != "" && job.Status != status) || (queue != "" && job.Queue != queue) || (tenant != "" && job.Tenant != tenant) {
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	if len(expired) > 0 {
+		_ = rdb.ZRem(ctx, index, expired...).Err()
+	}
+
+	response := fiber.Map{"jobs": jobs}
+	if len(ids) == limit {
+		response["next_cursor"] = strconv.Itoa(offset + limit - len(expired))
+	}
+	return c.JSON(response)
+}
@@ -0,0 +1,41 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestJobIndexRecordsTheQueueTheJobWasPushedTo(t *testing.T) {
+	app, srv := startTestGateway(t)
+	if _, err := tenantQueues.apply("on"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tenantQueues.apply("") })
+	queue := tenantQueueKey(queueKey, "acme")
+	srv.Work(t, queue, upperCase)
+
+	if status, body := get(t, app, "/validate?content=hello", "X-Tenant", "acme"); status != fiber.StatusOK {
+		t.Fatalf("status = %d, body %s", status, body)
+	}
+
+	for filter, want := range map[string]int{queue: 1, queueKey: 0} {
+		status, body := get(t, app, "/jobs?queue="+url.QueryEscape(filter))
+		var listing struct {
+			Jobs []JobInfo `json:"jobs"`
+		}
+		if err := json.Unmarshal(body, &listing); err != nil || status != fiber.StatusOK {
+			t.Fatalf("queue %s: status %d, body %s", filter, status, body)
+		}
+		if len(listing.Jobs) != want {
+			t.Fatalf("queue %s lists %+v, want %d jobs", filter, listing.Jobs, want)
+		}
+		for _, job := range listing.Jobs {
+			if job.Queue != queue {
+				t.Fatalf("job indexed under queue %q, want %q", job.Queue, queue)
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package gateway
+
+import (
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go-async-proxy/metrics"
+)
+
+// --- Redis Scripts ---
+
+// takeResponseScript pops the response and deletes whatever is left of the key in one step,
+// so a duplicate or late push can never outlive the consumer.
+var takeResponseScript = redis.NewScript(`
+local value = redis.call('LPOP', KEYS[1])
+local left = redis.call('LLEN', KEYS[1])
+redis.call('DEL', KEYS[1])
+return {value, left}
+`)
+
+// collectResponseScript removes an abandoned response key and returns its entries.
+var collectResponseScript = redis.NewScript(`
+local items = redis.call('LRANGE', KEYS[1], 0, -1)
+redis.call('DEL', KEYS[1])
+return items
+`)
+
+// --- Response Janitor ---
+
+const janitorLockKey = "validate:janitor:lock"
+
+// startResponseJanitor periodically releases dead journal entries and collects responses
+// nobody is waiting for anymore. Only one replica sweeps per interval, guarded by a short-lived
+// lock key. The first sweep runs right away so a restarted gateway recovers immediately.
+func startResponseJanitor() {
+	sweep := func() {
+		acquired, err := rdb.SetNX(ctx, janitorLockKey, instanceID, janitorInterval.Get()).Result()
+		if err != nil || !acquired {
+			return
+		}
+		sweepJournal()
+		sweepDeadReplyQueues()
+		sweepOrphanedResponses()
+		trimJobIndex()
+		if shedding.Load() {
+			expireStoredResults()
+		}
+	}
+
+	go func() {
+		sweep()
+
+		ticker := time.NewTicker(janitorInterval.Get())
+		defer ticker.Stop()
+
+		for range ticker.C {
+			sweep()
+			ticker.Reset(janitorInterval.Get())
+		}
+	}()
+}
+
+// sweepOrphanedResponses walks all response keys and collects the ones whose waiter is gone.
+// The waiter is registered before the job is pushed, so a response without one is always late.
+func sweepOrphanedResponses() {
+	prefix := responseKey("")
+	iter := rdb.Scan(ctx, 0, prefix+"*", 500).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		requestId := strings.TrimPrefix(key, prefix)
+		waiting, err := rdb.Exists(ctx, waiterKey(requestId)).Result()
+		if err != nil || waiting > 0 {
+			continue
+		}
+
+		items, err := collectResponseScript.Run(ctx, rdb, []string{key}).StringSlice()
+		if err != nil || len(items) == 0 {
+			continue
+		}
+		metrics.CounterOrphanedResponses.Inc()
+		handleLateResult(requestId, []byte(items[0]))
+	}
+}
@@ -0,0 +1,306 @@
+package gateway
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+	"go-async-proxy/metrics"
+)
+
+// --- Late Result Policy ---
+
+const (
+	latePolicyDiscard = "discard"
+	latePolicyStore   = "store"
+	latePolicyWebhook = "webhook"
+)
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// jobKey holds a result stored for later polling via GET /jobs/:id.
+func jobKey(requestId string) string {
+	return fmt.Sprintf("validate:job:%s", requestId)
+}
+
+// callbackKey holds the webhook URL a caller asked late results to be delivered to.
+func callbackKey(requestId string) string {
+	return fmt.Sprintf("validate:callback:%s", requestId)
+}
+
+// handleLateResult applies the configured policy to a result whose caller already gave up.
+func handleLateResult(requestId string, payload []byte) {
+	var chunks *ChunkRef
+	var msg Message
+	logger := slog.With("request_id", requestId)
+	if codec.Unmarshal(payload, &msg) == nil {
+		chunks = msg.Data.Chunks
+		logger = jobLogger(&msg)
+	}
+
+	policy, disconnected := latePolicyFor(requestId)
+	delivered := false
+	switch policy {
+	case latePolicyStore:
+		if err := rdb.Set(ctx, jobKey(requestId), payload, jobResultTTL.Get()).Err(); err != nil {
+			logger.Error("Cannot store late result", "error", err)
+		}
+		if chunks != nil {
+			// Chunks must outlive the stored result that references them
+			for n := range chunks.Count {
+				_ = rdb.Expire(ctx, chunks.Key+":"+strconv.Itoa(n), jobResultTTL.Get())
+			}
+		}
+	case latePolicyWebhook:
+		if chunks != nil {
+			// Webhook receivers can't reach Redis, so they get the result inline
+			err := assembleChunks(&msg)
+			if err == nil {
+				payload, err = codec.Marshal(&msg)
+			}
+			releaseChunks(chunks)
+			if err != nil {
+				logger.Error("Cannot assemble late result", "error", err)
+				break
+			}
+		}
+		if err := deliverWebhook(requestId, payload); err != nil {
+			metrics.CounterLateWebhookFailures.Inc()
+			logger.Error("Cannot deliver late result", "error", err)
+		} else {
+			delivered = true
+		}
+	}
+	metrics.CounterLateCompletions.WithLabelValues(policy).Inc()
+	if disconnected != "" {
+		countDisconnectedJob(disconnected, &msg, delivered)
+	}
+	recordJobError(requestId, msg.Meta.lastError())
+	setJobStatus(requestId, jobStatusLate)
+	if msg.RequestID != "" {
+		storeJob(&msg, jobStatusLate)
+	}
+}
+
+func deliverWebhook(requestId string, payload []byte) error {
+	url, err := rdb.Get(ctx, callbackKey(requestId)).Result()
+	if err == redis.Nil {
+		url = lateResultWebhook.Get()
+	} else if err != nil {
+		return err
+	}
+	if url == "" {
+		return fmt.Errorf("no callback url")
+	}
+	defer rdb.Del(ctx, callbackKey(requestId))
+
+	resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("callback responded %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// --- Jobs Controller Handler ---
+
+// jobHandler serves GET /jobs/:id: the stored result when there is one,
+// 202 while the job is still in flight and 404 once nothing is known about it.
+// Polling clients revalidating with If-None-Match or If-Modified-Since get 304 until the job
+// changes, without the result or the queue estimate being read. With ?wait= the request is
+// held until then instead, see awaitJobChange.
+func jobHandler(c *fiber.Ctx) error {
+	requestId := c.Params("id")
+	wait, err := longPollWait(c)
+	if err != nil {
+		return err
+	}
+
+	state, known := readJobState(requestId)
+	if known && wait > 0 && (state.inFlight() || notModified(c, state.etag(), state.updated)) {
+		state, known = awaitJobChange(requestId, state, wait)
+	}
+	if known {
+		c.Set(fiber.HeaderETag, state.etag())
+		c.Set(fiber.HeaderLastModified, state.updated.UTC().Format(http.TimeFormat))
+		c.Vary(fiber.HeaderAccept)
+		if notModified(c, state.etag(), state.updated) {
+			return c.SendStatus(fiber.StatusNotModified)
+		}
+	}
+
+	if known && state.status == jobStatusWaiting {
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"request_id": requestId, "status": jobStatusWaiting})
+	}
+
+	payload, err := rdb.Get(ctx, jobKey(requestId)).Bytes()
+	if err == redis.Nil {
+		// The janitor may not have swept a late result yet
+		payload, err = rdb.LIndex(ctx, responseKey(requestId), 0).Bytes()
+	}
+	if err == redis.Nil {
+		// A job whose caller timed out is still pending while it sits in the queue
+		waiting, _ := rdb.Exists(ctx, waiterKey(requestId)).Result()
+		estimate, queued := jobQueueEstimate(requestId)
+		if waiting > 0 || (queued && estimate.Position > 0) {
+			body := fiber.Map{"request_id": requestId, "status": "pending"}
+			if queued {
+				setQueueHeaders(c, estimate)
+				addQueueFields(body, estimate)
+			}
+			return c.Status(fiber.StatusAccepted).JSON(body)
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Unknown request_id")
+	}
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to read job")
+	}
+
+	var msg Message
+	if err := codec.Unmarshal(payload, &msg); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to decode job")
+	}
+	if msg.Data.Chunks != nil {
+		return respondChunked(c, &msg, false)
+	}
+	return respondMessage(c, &msg)
+}
+
+// jobState is what a job's ETag and Last-Modified derive from: its status, the time of its
+// last status change and whether a result is stored, which together change whenever the
+// response to GET /jobs/:id would.
+type jobState struct {
+	status  string
+	updated time.Time
+	stored  bool
+}
+
+func (s jobState) etag() string {
+	stored := 0
+	if s.stored {
+		stored = 1
+	}
+	return fmt.Sprintf(`"%s-%d-%d"`, s.status, s.updated.UnixMilli(), stored)
+}
+
+// inFlight reports whether the job has no result yet but may still get one.
+func (s jobState) inFlight() bool {
+	return !s.stored && (s.status == jobStatusWaiting || s.status == jobStatusPending || s.status == jobStatusTimeout)
+}
+
+// readJobState reads the state of a job, known is false for jobs missing from the index.
+func readJobState(requestId string) (state jobState, known bool) {
+	pipe := rdb.Pipeline()
+	info := pipe.HMGet(ctx, jobInfoKey(requestId), "status", "updated_ms")
+	stored := pipe.Exists(ctx, jobKey(requestId), responseKey(requestId))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return jobState{}, false
+	}
+	status, _ := info.Val()[0].(string)
+	updated, _ := info.Val()[1].(string)
+	updatedMs, err := strconv.ParseInt(updated, 10, 64)
+	if status == "" || err != nil {
+		return jobState{}, false
+	}
+	return jobState{status: status, updated: time.UnixMilli(updatedMs), stored: stored.Val() > 0}, true
+}
+
+// notModified evaluates the request's preconditions: If-None-Match when given, otherwise
+// If-Modified-Since, which only has second resolution.
+func notModified(c *fiber.Ctx, etag string, modified time.Time) bool {
+	if strings.Contains(c.Get(fiber.HeaderCacheControl), "no-cache") {
+		return false
+	}
+	if match := c.Get(fiber.HeaderIfNoneMatch); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(c.Get(fiber.HeaderIfModifiedSince))
+	return err == nil && !modified.Truncate(time.Second).After(since)
+}
+
+// --- Bulk Status Handler ---
+
+// bulkStatusMax caps the request_ids of one POST /jobs/status.
+var bulkStatusMax = intTunable("BULK_STATUS_MAX", 500)
+
+// bulkStatusEntry is one job of a POST /jobs/status answer. Result is the stored result,
+// after the result middlewares and projected by ?fields= and ?meta= like GET /jobs/:id's;
+// chunked results are only flagged, their content is served by GET /jobs/:id.
+type bulkStatusEntry struct {
+	RequestID string       `json:"request_id"`
+	Status    string       `json:"status"`
+	Chunked   bool         `json:"chunked,omitempty"`
+	Result    *MessageView `json:"result,omitempty"`
+}
+
+// bulkStatusHandler serves POST /jobs/status {"request_ids": [...]}: the status and stored
+// result of every job, in request order, read in one pipelined round trip. Jobs nothing is
+// known about are "unknown".
+func bulkStatusHandler(c *fiber.Ctx) error {
+	var request struct {
+		RequestIDs []string `json:"request_ids"`
+	}
+	if err := codec.Unmarshal(c.Body(), &request); err != nil || len(request.RequestIDs) == 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "Expected {\"request_ids\": [...]}")
+	}
+	if limit := bulkStatusMax.Get(); len(request.RequestIDs) > limit {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("At most %d request_ids per call", limit))
+	}
+	projection, err := projectionOf(c)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, len(request.RequestIDs))
+	for i, id := range request.RequestIDs {
+		keys[i] = jobKey(id)
+	}
+	pipe := rdb.Pipeline()
+	results := pipe.MGet(ctx, keys...)
+	statuses := make([]*redis.SliceCmd, len(request.RequestIDs))
+	for i, id := range request.RequestIDs {
+		statuses[i] = pipe.HMGet(ctx, jobInfoKey(id), "status")
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to read jobs")
+	}
+
+	jobs := make([]bulkStatusEntry, len(request.RequestIDs))
+	for i, id := range request.RequestIDs {
+		entry := bulkStatusEntry{RequestID: id, Status: "unknown"}
+		if status, ok := statuses[i].Val()[0].(string); ok {
+			entry.Status = status
+		}
+		if payload, ok := results.Val()[i].(string); ok {
+			var msg Message
+			if err := codec.Unmarshal([]byte(payload), &msg); err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "Failed to decode job")
+			}
+			if msg.Data.Chunks != nil {
+				entry.Chunked = true
+			} else {
+				if err := applyResultMiddlewares(c, &msg); err != nil {
+					return err
+				}
+				entry.Result = projection.view(&msg)
+			}
+		}
+		jobs[i] = entry
+	}
+	return c.JSON(fiber.Map{"jobs": jobs})
+}
@@ -0,0 +1,118 @@
+package gateway
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"go-async-proxy/metrics"
+)
+
+// --- Request Journal ---
+
+// The journal is a sorted set of "<instance>|<request_id>" members scored by the caller's
+// deadline (unix ms). Every gateway instance keeps a heartbeat key alive, so entries owned by
+// a crashed instance can be released right away instead of waiting for the waiter TTL.
+
+const (
+	journalKey        = "validate:journal"
+	heartbeatInterval = 5 * time.Second
+)
+
+var instanceID = newInstanceID()
+
+func newInstanceID() string {
+	if host, err := os.Hostname(); err == nil && host != "" {
+		return fmt.Sprintf("%s-%s", host, uuid.NewString()[:8])
+	}
+	return uuid.NewString()
+}
+
+func heartbeatKey(instance string) string {
+	return fmt.Sprintf("validate:gateway:%s", instance)
+}
+
+func journalMember(requestId string) string {
+	return instanceID + "|" + requestId
+}
+
+// heartbeat is the value of a heartbeat key; liveness only depends on the key existing.
+type heartbeat struct {
+	TsNs  int64     `json:"ts_ns"`
+	Build BuildInfo `json:"build"`
+}
+
+// startHeartbeat keeps this instance marked alive for journal recovery.
+func startHeartbeat() {
+	beat := func() {
+		payload, _ := codec.Marshal(heartbeat{TsNs: nowNs(), Build: buildInfo})
+		_ = rdb.Set(ctx, heartbeatKey(instanceID), payload, 3*heartbeatInterval).Err()
+	}
+	beat()
+
+	go func() {
+		ticker := time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			beat()
+		}
+	}()
+}
+
+// sweepJournal releases journaled requests that can no longer be answered by their owner:
+// the caller's deadline passed, or the owning instance stopped heartbeating. Releasing drops
+// the waiter, so the janitor hands any (later) result to the late result policy.
+func sweepJournal() {
+	entries, err := rdb.ZRangeWithScores(ctx, journalKey, 0, -1).Result()
+	if err != nil {
+		return
+	}
+
+	now := clock.Now().UnixMilli()
+	alive := map[string]bool{}
+	for _, entry := range entries {
+		member, _ := entry.Member.(string)
+		instance, requestId, found := strings.Cut(member, "|")
+		if !found {
+			_ = rdb.ZRem(ctx, journalKey, member).Err()
+			continue
+		}
+
+		if int64(entry.Score) < now {
+			releaseJournalEntry(member, requestId)
+			metrics.CounterJournalExpired.Inc()
+			continue
+		}
+
+		isAlive, known := alive[instance]
+		if !known {
+			exists, err := rdb.Exists(ctx, heartbeatKey(instance)).Result()
+			if err != nil {
+				continue
+			}
+			isAlive = exists > 0
+			alive[instance] = isAlive
+		}
+		if !isAlive {
+			releaseJournalEntry(member, requestId)
+			metrics.CounterJournalRecovered.Inc()
+		}
+	}
+}
+
+func releaseJournalEntry(member, requestId string) {
+	pipe := rdb.TxPipeline()
+	pipe.Del(ctx, waiterKey(requestId))
+	pipe.ZRem(ctx, journalKey, member)
+	_, _ = pipe.Exec(ctx)
+}
+
+// journalAdd records a waiting request in the given transaction.
+func journalAdd(pipe redis.Pipeliner, requestId string) {
+	deadline := clock.Now().Add(waitTimeout.Get()).UnixMilli()
+	pipe.ZAdd(ctx, journalKey, redis.Z{Score: float64(deadline), Member: journalMember(requestId)})
+}
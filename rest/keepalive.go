@@ -0,0 +1,68 @@
+package gateway
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go-async-proxy/metrics"
+)
+
+// --- Keep-Alive Frames ---
+
+// Proxies with aggressive idle timeouts reset connections on which nothing moved for a while,
+// and a caller waiting minutes for its result looks idle. With ?keepalive=true on an HTTP/1.1
+// request, the gateway sends an interim response every KEEPALIVE_INTERVAL while the caller
+// waits for its dependencies and its result: "102 Processing", or "103 Early Hints" with
+// KEEPALIVE_STATUS=103 for clients and proxies that drop 102. Interim responses go out before
+// the final one, so the final status and headers are unaffected. Some clients give up after a
+// handful of interim responses (Go's net/http after 5 by default), so callers opt in.
+
+const (
+	keepaliveProcessing = "102"
+	keepaliveEarlyHints = "103"
+)
+
+var (
+	keepaliveInterval = durationTunable("KEEPALIVE_INTERVAL", 15*time.Second)
+	keepaliveStatus   = stringTunable("KEEPALIVE_STATUS", keepaliveProcessing, keepaliveProcessing, keepaliveEarlyHints)
+)
+
+// keepaliveFrames are the interim responses, written straight to the connection.
+var keepaliveFrames = map[string][]byte{
+	keepaliveProcessing: []byte("HTTP/1.1 102 Processing\r\n\r\n"),
+	keepaliveEarlyHints: []byte("HTTP/1.1 103 Early Hints\r\n\r\n"),
+}
+
+// startKeepalive sends interim responses to the caller of c until the returned func is
+// called, which must happen before the handler writes its response.
+func startKeepalive(c *fiber.Ctx) func() {
+	conn := c.Context().Conn()
+	if c.Query("keepalive") != "true" || !c.Request().Header.IsHTTP11() || conn == nil {
+		return func() {}
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(keepaliveInterval.Get())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			_ = conn.SetWriteDeadline(time.Now().Add(time.Second))
+			_, err := conn.Write(keepaliveFrames[keepaliveStatus.Get()])
+			_ = conn.SetWriteDeadline(time.Time{})
+			if err != nil {
+				return
+			}
+			metrics.CounterKeepaliveFrames.Inc()
+		}
+	}()
+	return func() {
+		close(stop)
+		// The response is written on the same connection, never concurrently with a frame
+		<-done
+	}
+}
@@ -0,0 +1,80 @@
+package gateway
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// --- Logging ---
+
+// Log lines go through slog, as text or JSON (LOG_FORMAT). Everything logged about a job
+// carries its request_id, trace_id and queue, the same fields the worker logs, so one grep
+// follows a request through both services.
+
+// logLeveler follows the LOG_LEVEL tunable, so a config reload changes the level right away.
+type logLeveler struct{}
+
+func (logLeveler) Level() slog.Level {
+	switch logLevel.Get() {
+	case "debug":
+		return slog.LevelDebug
+	case "warn":
+		return slog.LevelWarn
+	}
+	return slog.LevelInfo
+}
+
+// initLogging installs the default logger. The standard log package goes through it as well.
+func initLogging() {
+	options := &slog.HandlerOptions{Level: logLeveler{}}
+	var handler slog.Handler = slog.NewTextHandler(os.Stdout, options)
+	if envString("LOG_FORMAT", "text") == "json" {
+		handler = slog.NewJSONHandler(os.Stdout, options)
+	}
+	slog.SetDefault(slog.New(handler).With("service", "rest"))
+}
+
+// jobLogger returns a logger carrying the correlation fields of msg.
+func jobLogger(msg *Message) *slog.Logger {
+	return slog.With("request_id", msg.RequestID, "trace_id", msg.TraceID, "queue", jobQueueFor(msg))
+}
+
+type loggerKey struct{}
+
+// withLogger carries logger in ctx, for code that only gets a context.
+func withLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// loggerFrom returns the logger carried in ctx, or the default one.
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// traceIDFrom continues the W3C trace of the caller (traceparent header), or starts a new one.
+func traceIDFrom(c *fiber.Ctx) string {
+	// traceparent: version-traceid-parentid-flags, the trace id being 32 lowercase hex digits
+	parts := strings.Split(c.Get("traceparent"), "-")
+	if len(parts) == 4 && len(parts[1]) == 32 && strings.Trim(parts[1], "0") != "" {
+		if _, err := hex.DecodeString(parts[1]); err == nil && strings.ToLower(parts[1]) == parts[1] {
+			return parts[1]
+		}
+	}
+	return newTraceID()
+}
+
+// newTraceID returns a random W3C trace id.
+func newTraceID() string {
+	var id [16]byte
+	_, _ = rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
@@ -0,0 +1,109 @@
+package gateway
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// --- Long Polling ---
+
+// GET /jobs/:id?wait=30s holds the request until the job changes, at most the given duration
+// (capped by LONG_POLL_MAX), then answers with its state as usual. A job changes when its
+// status does, or when a result is stored for it: every gateway publishes the request_id on
+// jobChangesChannel, and the reply dispatcher of every gateway wakes its local long polls.
+
+const jobChangesChannel = "validate:jobs:changed"
+
+var longPollMax = durationTunable("LONG_POLL_MAX", time.Minute)
+
+// longPollWait parses ?wait=, 0 when absent.
+func longPollWait(c *fiber.Ctx) (time.Duration, error) {
+	raw := c.Query("wait")
+	if raw == "" {
+		return 0, nil
+	}
+	wait, err := time.ParseDuration(raw)
+	if err != nil || wait < 0 {
+		return 0, fiber.NewError(fiber.StatusBadRequest, "'wait' must be a duration, e.g. 30s")
+	}
+	return min(wait, longPollMax.Get()), nil
+}
+
+// awaitJobChange blocks until the job is no longer in state or wait passed, and returns its
+// state by then.
+func awaitJobChange(requestId string, state jobState, wait time.Duration) (jobState, bool) {
+	changed := dispatcher.watch(requestId)
+	defer dispatcher.unwatch(requestId, changed)
+	// It may have changed before the watch was in place; a job missing from the index is
+	// awaited until it is indexed
+	if current, known := readJobState(requestId); current.etag() != state.etag() {
+		return current, known
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-changed:
+	case <-timer.C:
+	}
+	return readJobState(requestId)
+}
+
+// publishJobChange tells every gateway's long polls on requestId to look again.
+func publishJobChange(requestId string) {
+	_ = rdb.Publish(ctx, jobChangesChannel, requestId).Err()
+}
+
+// watch returns a channel closed at the next change of the job.
+func (d *replyDispatcher) watch(requestId string) chan struct{} {
+	changed := make(chan struct{})
+	d.mu.Lock()
+	d.watchers[requestId] = append(d.watchers[requestId], changed)
+	d.mu.Unlock()
+	return changed
+}
+
+func (d *replyDispatcher) unwatch(requestId string, changed chan struct{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	watchers := d.watchers[requestId]
+	for i, watcher := range watchers {
+		if watcher == changed {
+			watchers = append(watchers[:i], watchers[i+1:]...)
+			break
+		}
+	}
+	if len(watchers) == 0 {
+		delete(d.watchers, requestId)
+	} else {
+		d.watchers[requestId] = watchers
+	}
+}
+
+// notifyChanged wakes every local long poll on the job.
+func (d *replyDispatcher) notifyChanged(requestId string) {
+	d.mu.Lock()
+	watchers := d.watchers[requestId]
+	delete(d.watchers, requestId)
+	d.mu.Unlock()
+	for _, changed := range watchers {
+		close(changed)
+	}
+}
+
+// startJobChangeListener subscribes the dispatcher to job changes published by any gateway.
+func startJobChangeListener() error {
+	sub := rdb.Subscribe(ctx, jobChangesChannel)
+	if _, err := sub.Receive(ctx); err != nil {
+		return err
+	}
+	go func() {
+		for m := range sub.Channel() {
+			dispatcher.notifyChanged(m.Payload)
+		}
+		slog.Error("Job change subscription closed")
+	}()
+	return nil
+}
@@ -1,138 +1,187 @@
-package main
+package gateway
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/adaptor"
 	"github.com/google/uuid"
-	jsoniter "github.com/json-iterator/go"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/redis/go-redis/v9"
+	"go-async-proxy/metrics"
 	"log"
+	"log/slog"
 	"time"
 )
 
 var (
-	ctx  = context.Background()
-	rdb  *redis.Client
-	json = jsoniter.ConfigFastest
-
-	// --- Metrics ---
-
-	buckets = []float64{
-		0.1, 0.2, 0.5, 1, 2, 3, 4, 5,
-		10, 20, 50, 100, 200, 500,
-		1000, 2000,
-	}
-
-	counterSuccess = prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "rest_success_total",
-		Help: "Total number of successful requests",
-	})
-
-	counterFailure = prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "rest_failure_total",
-		Help: "Total number of failed requests",
-	})
-
-	gaugeQueued = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "rest_queued_count",
-		Help: "Queue size gauge, updated every 30s. Similar across replicas.",
-	})
-
-	durationRestRequestToRestPushMs = prometheus.NewHistogram(prometheus.HistogramOpts{
-		Name:    "duration_rest_request_to_queue_push_ms",
-		Help:    "Duration from REST request to Redis push (REST) (ms)",
-		Buckets: buckets,
-	})
-
-	durationRestPushToWorkerPullMs = prometheus.NewHistogram(prometheus.HistogramOpts{
-		Name:    "duration_rest_push_to_worker_pull_ms",
-		Help:    "Duration from Redis push (REST) to Redis pull (Worker) (ms)",
-		Buckets: buckets,
-	})
-
-	durationWorkerPullToWorkerPushMs = prometheus.NewHistogram(prometheus.HistogramOpts{
-		Name:    "duration_worker_pull_to_worker_push_ms",
-		Help:    "Duration from Redis pull (Worker) to Redis push (Worker) (ms)",
-		Buckets: buckets,
-	})
-
-	durationWorkerPushToRestPullMs = prometheus.NewHistogram(prometheus.HistogramOpts{
-		Name:    "duration_worker_push_to_rest_pull_ms",
-		Help:    "Duration from Redis push (Worker) to Redis pull (REST) (ms)",
-		Buckets: buckets,
-	})
-
-	durationRestPullToRestResponseMs = prometheus.NewHistogram(prometheus.HistogramOpts{
-		Name:    "duration_rest_pull_to_rest_response_ms",
-		Help:    "Duration from Redis pull (REST) to HTTP response (REST) (ms)",
-		Buckets: buckets,
-	})
-
-	durationFullCycleMs = prometheus.NewHistogram(prometheus.HistogramOpts{
-		Name:    "duration_total_roundtrip_ms",
-		Help:    "Total roundtrip time from REST request to REST response (ms)",
-		Buckets: buckets,
-	})
+	ctx = context.Background()
+	rdb *redis.Client
 )
 
 // --- Data Structures ---
 
+// Meta records the pipeline stages the message went through, in order. Its JSON carries the
+// older per-stage timestamps as well, see legacyStageTimes.
 type Meta struct {
-	RestRequestReceived  int64 `json:"rest_request_received_ns"`
-	RestRequestPushed    int64 `json:"rest_request_pushed_ns"`
-	WorkerRequestPulled  int64 `json:"worker_request_pulled_ns"`
-	WorkerResponsePushed int64 `json:"worker_response_pushed_ns"`
-	RestResponsePulled   int64 `json:"rest_response_pulled_ns"`
-	RoundtripDurationNs  int64 `json:"rest_roundtrip_duration_ns"`
+	Stages   []StageEvent `json:"stages,omitempty" xml:"stage"`
+	Attempts []Attempt    `json:"attempts,omitempty" xml:"attempts>attempt,omitempty"`
+	// EnqueueDepth is how many jobs were ahead of this one in its queue when it was pushed. It
+	// is taken after the payload went out, so only the gateway's copy has it, see pushToQueue.
+	EnqueueDepth        int64 `json:"enqueue_depth" xml:"enqueue_depth"`
+	RoundtripDurationNs int64 `json:"rest_roundtrip_duration_ns" xml:"rest_roundtrip_duration_ns"`
 }
 
 type Data struct {
-	Content string `json:"content"`
-	Result  bool   `json:"result"`
+	Content string `json:"content" xml:"content"`
+	// Binary payloads carry their content base64 encoded.
+	Binary bool `json:"binary,omitempty" xml:"binary,omitempty"`
+	// File references an uploaded file the worker streams instead of Content.
+	File *FileRef `json:"file,omitempty" xml:"file,omitempty"`
+	// Chunks references a result the worker published in chunks, see respondChunked.
+	Chunks *ChunkRef    `json:"chunks,omitempty" xml:"chunks,omitempty"`
+	Result bool         `json:"result" xml:"result"`
+	Rules  []RuleResult `json:"rules,omitempty" xml:"rules>rule,omitempty"`
+}
+
+// RuleResult is the outcome of one validation rule evaluated by the worker.
+type RuleResult struct {
+	ID      string `json:"id" xml:"id,attr"`
+	Passed  bool   `json:"passed" xml:"passed,attr"`
+	Message string `json:"message,omitempty" xml:",chardata"`
+}
+
+// WorkerInfo identifies the worker that produced a result.
+type WorkerInfo struct {
+	Hostname   string `json:"hostname" xml:"hostname,attr"`
+	InstanceID string `json:"instance_id" xml:"instance_id,attr"`
+	Version    string `json:"version" xml:"version,attr"`
 }
 
 type Message struct {
 	RequestID string `json:"request_id"`
-	Meta      Meta   `json:"meta"`
-	Data      Data   `json:"data"`
+	// TraceID correlates the request's log lines across services, see traceIDFrom.
+	TraceID  string `json:"trace_id,omitempty"`
+	ReplyTo  string `json:"reply_to,omitempty"`
+	ReplyVia string `json:"reply_via,omitempty"`
+	Tenant   string `json:"tenant,omitempty"`
+	JobType  string `json:"job_type,omitempty"`
+	Geo      string `json:"geo,omitempty"`
+	// Region is the data region the job must be processed in, see applyResidency.
+	Region string `json:"region,omitempty"`
+	// Shadow copies are processed for comparison only, their results are dropped.
+	Shadow bool `json:"shadow,omitempty"`
+	// Synthetic jobs are the gateway's own probes, kept out of the job index.
+	Synthetic bool `json:"synthetic,omitempty"`
+	// Flags are the feature flags the gateway turned on for this request.
+	Flags []string `json:"flags,omitempty"`
+	// Cost attributes the job to a team and cost center, see costLabelsFor.
+	Cost *CostLabels `json:"cost,omitempty"`
+	// Affinity keeps the jobs of a key on one worker, see affinityPartition.
+	Affinity string `json:"affinity,omitempty"`
+	// DependsOn are the jobs that had to complete before this one was queued, see
+	// awaitDependencies.
+	DependsOn []string `json:"depends_on,omitempty"`
+	// QueueDeadlineNs is when a worker gives up on the job instead of processing it, see
+	// setQueueDeadline.
+	QueueDeadlineNs int64 `json:"queue_deadline_ns,omitempty"`
+	// Worker is stamped by the worker that produced the result.
+	Worker *WorkerInfo `json:"worker,omitempty"`
+	// Annotations are added by the annotate result middleware and stage budget checks, they
+	// never reach the worker.
+	Annotations []Annotation `json:"annotations,omitempty"`
+	Meta        Meta         `json:"meta"`
+	Data        Data         `json:"data"`
 }
 
 // --- Utility Functions ---
 
 func nowNs() int64 {
-	return time.Now().UnixNano()
+	return clock.Now().UnixNano()
+}
+
+// --- Redis Keys ---
+
+const queueKey = "validate:queue"
+
+func responseKey(requestId string) string {
+	return fmt.Sprintf("validate:response:%s", requestId)
+}
+
+// waiterKey marks a request that still has a caller blocked on its response.
+func waiterKey(requestId string) string {
+	return fmt.Sprintf("validate:waiter:%s", requestId)
 }
 
 // --- Redis Setup ---
 
 func initRedis() {
 	rdb = redis.NewClient(&redis.Options{
-		Addr:     "redis:6379",
+		Addr:     envString("REDIS_ADDR", "redis:6379"),
 		PoolSize: 80,
+		// Asked on every new connection, so a rotated password is picked up
+		CredentialsProvider: func() (string, string) {
+			return envString("REDIS_USERNAME", ""), secret("REDIS_PASSWORD", "")
+		},
 	})
 }
 
 // --- Fiber App Entry Point ---
 
-func main() {
+// Main runs the gateway until the process exits: the gateway binary (cmd/rest) calls it, and the
+// all-in-one binary runs it next to a worker.
+func Main() {
+	initLogging()
+	if err := initCodec(); err != nil {
+		log.Fatalf("Cannot init codec error: %v", err)
+	}
+	initBuildInfo()
+	if err := initSecrets(); err != nil {
+		log.Fatalf("Cannot init secrets error: %v", err)
+	}
 	initRedis()
+	if err := initReplica(); err != nil {
+		log.Fatalf("Cannot init replica error: %v", err)
+	}
+	if err := preflight(); err != nil {
+		log.Fatalf("Preflight failed:\n%v", err)
+	}
+	startConfigReloader()
+	if err := initJobStore(); err != nil {
+		log.Fatalf("Cannot init job store error: %v", err)
+	}
+	if err := initEventExport(); err != nil {
+		log.Fatalf("Cannot init event export error: %v", err)
+	}
+	if err := initCostLabels(); err != nil {
+		log.Fatalf("Cannot init cost labels error: %v", err)
+	}
+	if err := initFixtures(); err != nil {
+		log.Fatalf("Cannot init fixtures error: %v", err)
+	}
+	if err := initAccessLog(); err != nil {
+		log.Fatalf("Cannot init access log error: %v", err)
+	}
+	if err := initFirewall(); err != nil {
+		log.Fatalf("Cannot init firewall error: %v", err)
+	}
+	if err := initRedaction(); err != nil {
+		log.Fatalf("Cannot init redaction error: %v", err)
+	}
+	if err := initResidency(); err != nil {
+		log.Fatalf("Cannot init data residency error: %v", err)
+	}
+	if err := initRequestMiddlewares(); err != nil {
+		log.Fatalf("Cannot init request middlewares error: %v", err)
+	}
+	if err := initResultMiddlewares(); err != nil {
+		log.Fatalf("Cannot init result middlewares error: %v", err)
+	}
 
 	// Register Prometheus metrics
-	prometheus.MustRegister(
-		counterSuccess,                   // Operation success counters
-		counterFailure,                   // Operation failed counters
-		gaugeQueued,                      // Queue size gauge, updated every 30s. Similar across replicas.
-		durationRestRequestToRestPushMs,  // From REST request receive → Redis push (by REST)
-		durationRestPushToWorkerPullMs,   // From Redis push (REST) → Redis pull (Worker)
-		durationWorkerPullToWorkerPushMs, // From Redis pull (Worker) → Redis push (Worker)
-		durationWorkerPushToRestPullMs,   // From Redis push (Worker) → Redis pull (REST)
-		durationRestPullToRestResponseMs, // From Redis pull (REST) → HTTP response (REST)
-		durationFullCycleMs,              // Full roundtrip: REST request → HTTP response
-	)
+	prometheus.MustRegister(metrics.All()...)
 
 	go func() {
 		ticker := time.NewTicker(30 * time.Second)
@@ -140,53 +189,483 @@ func main() {
 
 		for range ticker.C {
 			ctxTimeout, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
-			length, err := rdb.LLen(ctxTimeout, "validate:queue").Result()
+			length, err := rdb.LLen(ctxTimeout, queueKey).Result()
 			cancel()
 			if err == nil {
-				gaugeQueued.Set(float64(length))
+				metrics.GaugeQueued.Set(float64(length))
 			}
 		}
 	}()
 
-	app := fiber.New()
+	startHeartbeat()
+	if err := startReplyDispatcher(); err != nil {
+		log.Fatalf("Cannot start reply dispatcher error: %v", err)
+	}
+	if err := startJobChangeListener(); err != nil {
+		log.Fatalf("Cannot subscribe to job changes error: %v", err)
+	}
+	if err := startControlListener(); err != nil {
+		log.Fatalf("Cannot subscribe to the control channel error: %v", err)
+	}
+	startResponseJanitor()
+	startCanaryGuard()
+	startMemoryMonitor()
+	startProber()
+	startDrainSampler()
+	startAffinitySampler()
+	startResidencySampler()
 
-	app.Get("/metrics", adaptor.HTTPHandler(promhttp.Handler()))
-	app.Get("/validate", validateHandler)
+	app := newApp()
 
-	fmt.Println("Listening on :3000")
+	slog.Info("Listening", "addr", ":3000")
 	if err := app.Listen(":3000"); err != nil {
 		log.Fatalf("Cannot bind to port 3000 error: %v", err)
 	}
 }
 
+// newApp returns the gateway's Fiber app with its middlewares and every route.
+func newApp() *fiber.App {
+	config := fiber.Config{
+		BodyLimit:   max(maxModuleSize, maxFileSize) + 64<<10,
+		JSONEncoder: codec.Marshal,
+		JSONDecoder: codec.Unmarshal,
+	}
+	app := fiber.New(config)
+	app.Use(accessLogger)
+	app.Use(firewallGuard)
+	app.Use(auditLogger)
+	app.Use(adminGuard)
+	if handler := newCORSHandler(); handler != nil {
+		app.Use(handler)
+	}
+	app.Use(newCompressionHandler())
+
+	route(app, fiber.MethodGet, "/metrics", adaptor.HTTPHandler(promhttp.Handler()), apiOperation{
+		Summary:   "Prometheus metrics",
+		Responses: map[int]string{200: "Prometheus text exposition format"},
+	})
+	route(app, fiber.MethodGet, "/readyz", readyzHandler, apiOperation{
+		Summary:   "Readiness: Redis reachable, enough live workers, recent success rate and memory budget",
+		Responses: map[int]string{200: "Ready, with every check", 503: "Not ready, with the failing checks"},
+	})
+	route(app, fiber.MethodGet, "/version", versionHandler, apiOperation{
+		Summary:   "Build version, git SHA, build time, Go version and enabled features",
+		Responses: map[int]string{200: "Build info"},
+	})
+	route(app, fiber.MethodGet, "/flags", flagsHandler, apiOperation{
+		Summary:   "Feature flags and the percentage of requests each one is rolled out to",
+		Responses: map[int]string{200: "Flag name to rollout percentage"},
+	})
+	route(app, fiber.MethodGet, "/validate", validateHandler, apiOperation{
+		Summary: "Submit content and wait synchronously for the worker's result",
+		Params: []apiParam{
+			{Name: "content", In: "query", Description: "Content to validate", Required: true},
+			{Name: "encoding", In: "query", Description: "text (default, must be valid UTF-8) or base64 for binary content"},
+			{Name: "callback", In: "query", Description: "Webhook for a late result (LATE_RESULT_POLICY=webhook)"},
+			{Name: "dry_run", In: "query", Description: "true to run every check and answer with what would be enqueued, without enqueuing"},
+			{Name: "keepalive", In: "query", Description: "true to receive an interim 102 (or 103, KEEPALIVE_STATUS) response every KEEPALIVE_INTERVAL while waiting, HTTP/1.1 only"},
+			{Name: "X-Tenant", In: "header", Description: "Tenant the job is indexed under"},
+			{Name: "X-API-Key", In: "header", Description: "API key the job is accounted to (GET /usage)"},
+			{Name: "X-Affinity-Key", In: "header", Description: "Key whose jobs all go to the same worker (AFFINITY_PARTITIONS), e.g. a customer ID"},
+			{Name: "X-Nonce", In: "header", Description: "Client generated nonce: a retry with the same one within NONCE_WINDOW gets the original job's request_id and result instead of queueing it again"},
+			{Name: "X-Depends-On", In: "header", Description: "Comma separated request_ids that must complete without error before this job is queued; the wait counts against WAIT_TIMEOUT"},
+			{Name: "X-Data-Region", In: "header", Description: "Region the job's data must stay in: only workers with that WORKER_REGION process it (TENANT_REGIONS may pin the tenant)"},
+			{Name: "X-Failure-Cache", In: "header", Description: "bypass to run the job even when its payload failed recently (FAILURE_CACHE=on)"},
+			{Name: "X-Team", In: "header", Description: "Team the job's cost is attributed to, unless COST_LABELS has one for the API key"},
+			{Name: "X-Cost-Center", In: "header", Description: "Cost center the job's cost is attributed to, unless COST_LABELS has one for the API key"},
+			{Name: "type", In: "query", Description: "Job type, selects the WASM module (HANDLER=wasm) or downstream (HANDLER=callout) on the worker"},
+			{Name: "fields", In: "query", Description: "Comma separated sections to return: request_id, tenant, worker, annotations, meta, data"},
+			{Name: "meta", In: "query", Description: "Meta verbosity: full (default), summary (roundtrip only) or none"},
+			{Name: "Accept", In: "header", Description: "application/json (default), application/msgpack or application/xml"},
+		},
+		Responses: map[int]string{200: "Processed message, or what would be enqueued with dry_run, or a recent failure of the same payload (X-Failure-Cache: hit)", 400: "Missing content, or content not matching its encoding", 406: "Unsupported Accept, rejected before the job is queued", 424: "A job in X-Depends-On failed, is unknown or did not complete in time", 500: "Queue push failed", 503: "Maintenance mode, shedding load because Redis is over its memory budget, not expected to complete within WAIT_TIMEOUT (ADMISSION_CONTROL=on, with the estimate), or no live worker in the job's data region", 504: "Timed out waiting for the result, with the stage the job reached (queued, claimed, processing or unknown) in X-Timeout-Stage and the body"},
+	})
+	route(app, fiber.MethodPost, "/validate/file", validateFileHandler, apiOperation{
+		Summary: "Upload a file as multipart/form-data (field \"file\") and wait for the worker's result",
+		Params: []apiParam{
+			{Name: "type", In: "query", Description: "Job type"},
+			{Name: "callback", In: "query", Description: "Webhook for a late result (LATE_RESULT_POLICY=webhook)"},
+			{Name: "dry_run", In: "query", Description: "true to run every check and answer with what would be enqueued, without enqueuing"},
+			{Name: "keepalive", In: "query", Description: "true to receive an interim 102 (or 103, KEEPALIVE_STATUS) response every KEEPALIVE_INTERVAL while waiting, HTTP/1.1 only"},
+			{Name: "X-Tenant", In: "header", Description: "Tenant the job is indexed under"},
+			{Name: "X-API-Key", In: "header", Description: "API key the job is accounted to (GET /usage)"},
+			{Name: "X-Affinity-Key", In: "header", Description: "Key whose jobs all go to the same worker (AFFINITY_PARTITIONS), e.g. a customer ID"},
+			{Name: "X-Nonce", In: "header", Description: "Client generated nonce: a retry with the same one within NONCE_WINDOW gets the original job's request_id and result instead of queueing it again"},
+			{Name: "X-Depends-On", In: "header", Description: "Comma separated request_ids that must complete without error before this job is queued; the wait counts against WAIT_TIMEOUT"},
+			{Name: "X-Data-Region", In: "header", Description: "Region the job's data must stay in: only workers with that WORKER_REGION process it (TENANT_REGIONS may pin the tenant)"},
+			{Name: "X-Team", In: "header", Description: "Team the job's cost is attributed to, unless COST_LABELS has one for the API key"},
+			{Name: "X-Cost-Center", In: "header", Description: "Cost center the job's cost is attributed to, unless COST_LABELS has one for the API key"},
+			{Name: "fields", In: "query", Description: "Comma separated sections to return: request_id, tenant, worker, annotations, meta, data"},
+			{Name: "meta", In: "query", Description: "Meta verbosity: full (default), summary (roundtrip only) or none"},
+			{Name: "Accept", In: "header", Description: "application/json (default), application/msgpack or application/xml"},
+		},
+		Responses: map[int]string{200: "Processed message, or what would be enqueued with dry_run", 400: "Missing file", 406: "Unsupported Accept, rejected before the job is queued", 413: "File too large", 424: "A job in X-Depends-On failed, is unknown or did not complete in time", 500: "Queue push failed", 503: "Maintenance mode, shedding load because Redis is over its memory budget, not expected to complete within WAIT_TIMEOUT (ADMISSION_CONTROL=on, with the estimate), or no live worker in the job's data region", 504: "Timed out waiting for the result, with the stage the job reached (queued, claimed, processing or unknown) in X-Timeout-Stage and the body"},
+	})
+	route(app, fiber.MethodGet, "/jobs", jobsHandler, apiOperation{
+		Summary: "List jobs, oldest first",
+		Params: []apiParam{
+			{Name: "status", In: "query", Description: "waiting, pending, completed, timeout, failed or late"},
+			{Name: "queue", In: "query", Description: "Queue the job was pushed to, e.g. validate:queue"},
+			{Name: "tenant", In: "query", Description: "Tenant"},
+			{Name: "since", In: "query", Description: "Only jobs received at or after this unix ms"},
+			{Name: "cursor", In: "query", Description: "next_cursor of the previous page"},
+			{Name: "limit", In: "query", Description: "Page size, 1..500 (default 50)"},
+		},
+		Responses: map[int]string{200: "Page of jobs", 400: "Invalid filters"},
+	})
+	route(app, fiber.MethodPost, "/jobs/status", bulkStatusHandler, apiOperation{
+		Summary: "Status and stored result of many jobs in one call: {\"request_ids\": [...]}, at most BULK_STATUS_MAX",
+		Params: []apiParam{
+			{Name: "fields", In: "query", Description: "Comma separated sections of every result to return: request_id, tenant, worker, annotations, meta, data"},
+			{Name: "meta", In: "query", Description: "Meta verbosity of every result: full (default), summary (roundtrip only) or none"},
+		},
+		Responses: map[int]string{200: "jobs, in request order, each with request_id, status (unknown when nothing is known) and result (shaped like GET /jobs/:id's) or chunked", 400: "Invalid or too many request_ids, or invalid fields or meta"},
+	})
+	route(app, fiber.MethodGet, "/jobs/:id", jobHandler, apiOperation{
+		Summary: "Fetch a job's result",
+		Params: []apiParam{
+			{Name: "id", In: "path", Description: "request_id"},
+			{Name: "wait", In: "query", Description: "Long poll: hold the request until the job changes, up to this duration (e.g. 30s, capped by LONG_POLL_MAX)"},
+			{Name: "If-None-Match", In: "header", Description: "ETag of the previous poll, answered 304 while the job is unchanged"},
+			{Name: "If-Modified-Since", In: "header", Description: "Last-Modified of the previous poll, answered 304 while the job is unchanged"},
+			{Name: "fields", In: "query", Description: "Comma separated sections to return: request_id, tenant, worker, annotations, meta, data"},
+			{Name: "meta", In: "query", Description: "Meta verbosity: full (default), summary (roundtrip only) or none"},
+			{Name: "Accept", In: "header", Description: "application/json (default), application/msgpack or application/xml"},
+		},
+		Responses: map[int]string{200: "Stored result", 202: "Still waiting for its X-Depends-On jobs, or pending with queue_position and estimated_wait_ms (also as X-Queue-Position and X-Estimated-Wait-Ms)", 304: "Unchanged since If-None-Match (ETag) or If-Modified-Since", 404: "Unknown request_id", 406: "Unsupported Accept"},
+	})
+	route(app, fiber.MethodGet, "/jobs/:id/events", jobEventsHandler, apiOperation{
+		Summary: "Server-sent events of a job's progress: progress events with its status, queue_position and estimated_wait_ms as they change, then a done event once GET /jobs/:id has its outcome",
+		Params: []apiParam{
+			{Name: "id", In: "path", Description: "request_id"},
+		},
+		Responses: map[int]string{200: "text/event-stream of progress events and a final done event", 404: "Unknown request_id"},
+	})
+	route(app, fiber.MethodPost, "/jobs/:id/replay", replayJobHandler, apiOperation{
+		Summary: "Run a job stored in the job store (JOB_STORE) again as a new job and wait for its result",
+		Params: []apiParam{
+			{Name: "id", In: "path", Description: "request_id of the stored job"},
+			{Name: "dry_run", In: "query", Description: "true to run every check and answer with what would be enqueued, without enqueuing"},
+			{Name: "keepalive", In: "query", Description: "true to receive an interim 102 (or 103, KEEPALIVE_STATUS) response every KEEPALIVE_INTERVAL while waiting, HTTP/1.1 only"},
+			{Name: "X-API-Key", In: "header", Description: "API key the new job is accounted to (GET /usage)"},
+			{Name: "X-Affinity-Key", In: "header", Description: "Key whose jobs all go to the same worker (AFFINITY_PARTITIONS), the stored job's key by default"},
+			{Name: "X-Nonce", In: "header", Description: "Client generated nonce: a retry with the same one within NONCE_WINDOW gets the original job's request_id and result instead of queueing it again"},
+			{Name: "X-Depends-On", In: "header", Description: "Comma separated request_ids that must complete without error before this job is queued; the wait counts against WAIT_TIMEOUT"},
+			{Name: "X-Data-Region", In: "header", Description: "Region the job's data must stay in: only workers with that WORKER_REGION process it (TENANT_REGIONS may pin the tenant)"},
+			{Name: "X-Failure-Cache", In: "header", Description: "bypass to run the job even when its payload failed recently (FAILURE_CACHE=on)"},
+			{Name: "fields", In: "query", Description: "Comma separated sections to return: request_id, tenant, worker, annotations, meta, data"},
+			{Name: "meta", In: "query", Description: "Meta verbosity: full (default), summary (roundtrip only) or none"},
+			{Name: "Accept", In: "header", Description: "application/json (default), application/msgpack or application/xml"},
+		},
+		Responses: map[int]string{200: "Processed message, or what would be enqueued with dry_run, or a recent failure of the same payload (X-Failure-Cache: hit)", 404: "Unknown request_id", 406: "Unsupported Accept, rejected before the job is queued", 409: "Job was a file upload", 424: "A job in X-Depends-On failed, is unknown or did not complete in time", 501: "No job store configured", 503: "Maintenance mode, shedding load because Redis is over its memory budget, not expected to complete within WAIT_TIMEOUT (ADMISSION_CONTROL=on, with the estimate), or no live worker in the job's data region", 504: "Timed out waiting for the result, with the stage the job reached (queued, claimed, processing or unknown) in X-Timeout-Stage and the body"},
+	})
+	route(app, fiber.MethodGet, "/admin/slow-requests", slowRequestsHandler, apiOperation{
+		Summary: "Traces of slow, timed out and sampled requests, newest first",
+		Params: []apiParam{
+			{Name: "reason", In: "query", Description: "slow, timeout or sampled"},
+			{Name: "cursor", In: "query", Description: "next_cursor of the previous page"},
+			{Name: "limit", In: "query", Description: "Page size (default 50, max 500)"},
+		},
+		Responses: map[int]string{200: "Page of traces", 400: "Invalid limit"},
+	})
+	route(app, fiber.MethodDelete, "/admin/tenants/:tenant/data", eraseTenantHandler, apiOperation{
+		Summary: "Erase a tenant's jobs, results, fixtures, WASM modules, workflow runs, audit entries, traces and job store rows, answering what was deleted",
+		Params: []apiParam{
+			{Name: "tenant", In: "path", Description: "Tenant (X-Tenant of its jobs)"},
+		},
+		Responses: map[int]string{200: "Deletion report: jobs, redis_keys, audit_entries, traces, fixtures, modules, workflow_runs and stored_jobs", 500: "Erasure failed part way, run it again"},
+	})
+	route(app, fiber.MethodGet, "/admin/audit", auditHandler, apiOperation{
+		Summary: "Administrative changes (PUT, POST and DELETE under /admin and /modules), newest first",
+		Params: []apiParam{
+			{Name: "actor", In: "query", Description: "Only changes made by this operator (ADMIN_TOKENS)"},
+			{Name: "path", In: "query", Description: "Only changes to paths starting with this, e.g. /admin/control"},
+			{Name: "cursor", In: "query", Description: "next_cursor of the previous page"},
+			{Name: "limit", In: "query", Description: "Page size (default 50, max 500)"},
+		},
+		Responses: map[int]string{200: "Page of entries: actor, key_id, client, method, path, query, params, status", 400: "Invalid limit"},
+	})
+	route(app, fiber.MethodGet, "/admin/firewall", firewallHandler, apiOperation{
+		Summary: "Firewall rules in force and the client IPs with the most refusals and 4xx answers on this gateway",
+		Params: []apiParam{
+			{Name: "limit", In: "query", Description: "Number of client IPs (default 50, max 500)"},
+		},
+		Responses: map[int]string{200: "allow, deny, patterns, tracked_ips and offenders", 400: "Invalid limit"},
+	})
+	route(app, fiber.MethodGet, "/usage", usageHandler, apiOperation{
+		Summary: "The caller's usage per UTC day and in total: jobs, processing time and data volume",
+		Params: []apiParam{
+			{Name: "X-API-Key", In: "header", Description: "API key the jobs were submitted with", Required: true},
+			{Name: "from", In: "query", Description: "First day, YYYY-MM-DD (default 29 days before to)"},
+			{Name: "to", In: "query", Description: "Last day, YYYY-MM-DD (default today)"},
+		},
+		Responses: map[int]string{200: "Usage per day and total", 400: "Invalid range", 401: "Missing X-API-Key"},
+	})
+	route(app, fiber.MethodGet, "/admin/usage", usageReportHandler, apiOperation{
+		Summary: "Usage of every API key over the range, heaviest consumers first, for chargeback",
+		Params: []apiParam{
+			{Name: "from", In: "query", Description: "First day, YYYY-MM-DD (default 29 days before to)"},
+			{Name: "to", In: "query", Description: "Last day, YYYY-MM-DD (default today)"},
+		},
+		Responses: map[int]string{200: "Usage per API key", 400: "Invalid range"},
+	})
+	route(app, fiber.MethodGet, "/admin/maintenance", maintenanceHandler, apiOperation{
+		Summary:   "Maintenance mode as this gateway applies it",
+		Responses: map[int]string{200: "enabled, message, retry_after and since_ms"},
+	})
+	route(app, fiber.MethodPut, "/admin/maintenance", setMaintenanceHandler, apiOperation{
+		Summary:   "Turn maintenance mode on or off for every gateway: {\"enabled\": true, \"message\": \"...\", \"retry_after\": \"10m\"}",
+		Responses: map[int]string{200: "Applied, with the control_id of the broadcast", 400: "Invalid state", 500: "Failed to store"},
+	})
+	route(app, fiber.MethodPost, "/admin/control", controlHandler, apiOperation{
+		Summary:   "Broadcast a command to every worker and gateway: {\"action\": drain, resume, reload_config, flush_caches, log_level (with \"value\") or invalidate_session (with \"key\")}",
+		Responses: map[int]string{202: "Sent, with its id and how many receivers it reached", 400: "Invalid command", 500: "Publish failed"},
+	})
+	route(app, fiber.MethodGet, "/admin/control/:id", controlStatusHandler, apiOperation{
+		Summary: "A sent command and the acknowledgment of every receiver (ok, ignored or error)",
+		Params: []apiParam{
+			{Name: "id", In: "path", Description: "id returned by POST /admin/control"},
+		},
+		Responses: map[int]string{200: "Command, receivers, acks and pending count", 404: "Unknown or expired command"},
+	})
+	route(app, fiber.MethodDelete, "/admin/sessions", invalidateSessionsHandler, apiOperation{
+		Summary:   "Drop every worker session, on every worker",
+		Responses: map[int]string{202: "Sent, follow it with GET /admin/control/:id", 500: "Publish failed"},
+	})
+	route(app, fiber.MethodDelete, "/admin/sessions/:key", invalidateSessionsHandler, apiOperation{
+		Summary: "Drop the worker session of an affinity key, on every worker",
+		Params: []apiParam{
+			{Name: "key", In: "path", Description: "Affinity key (X-Affinity-Key)"},
+		},
+		Responses: map[int]string{202: "Sent, follow it with GET /admin/control/:id", 500: "Publish failed"},
+	})
+	route(app, fiber.MethodPut, "/modules/:type", uploadModuleHandler, apiOperation{
+		Summary: "Upload the WASI module processing the given job type for the tenant",
+		Params: []apiParam{
+			{Name: "type", In: "path", Description: "Job type"},
+			{Name: "X-Tenant", In: "header", Description: "Tenant owning the module"},
+		},
+		Responses: map[int]string{200: "Stored, with its sha256", 400: "Not a WebAssembly module", 413: "Module too large"},
+	})
+	route(app, fiber.MethodDelete, "/modules/:type", deleteModuleHandler, apiOperation{
+		Summary: "Delete the tenant's module for the given job type",
+		Params: []apiParam{
+			{Name: "type", In: "path", Description: "Job type"},
+			{Name: "X-Tenant", In: "header", Description: "Tenant owning the module"},
+		},
+		Responses: map[int]string{204: "Deleted", 404: "Unknown module"},
+	})
+	route(app, fiber.MethodPut, "/admin/workflows/:name", putWorkflowHandler, apiOperation{
+		Summary: "Define a workflow, a DAG of job types: {\"nodes\": [{\"id\": \"lint\", \"type\": \"lint\", \"depends_on\": [\"parse\"]}, ...]}",
+		Params: []apiParam{
+			{Name: "name", In: "path", Description: "Workflow name"},
+		},
+		Responses: map[int]string{200: "Stored, with the order its nodes run in", 400: "Invalid workflow: duplicate or unknown nodes, or a cycle", 500: "Failed to store"},
+	})
+	route(app, fiber.MethodGet, "/admin/workflows/:name", getWorkflowHandler, apiOperation{
+		Summary: "A workflow's definition",
+		Params: []apiParam{
+			{Name: "name", In: "path", Description: "Workflow name"},
+		},
+		Responses: map[int]string{200: "Workflow", 404: "Unknown workflow"},
+	})
+	route(app, fiber.MethodDelete, "/admin/workflows/:name", deleteWorkflowHandler, apiOperation{
+		Summary: "Delete a workflow, runs already started go on",
+		Params: []apiParam{
+			{Name: "name", In: "path", Description: "Workflow name"},
+		},
+		Responses: map[int]string{204: "Deleted", 404: "Unknown workflow"},
+	})
+	route(app, fiber.MethodPost, "/workflows/:name/runs", startWorkflowHandler, apiOperation{
+		Summary: "Run a workflow on the content: every node is queued as a job once the nodes it depends on completed",
+		Params: []apiParam{
+			{Name: "name", In: "path", Description: "Workflow name"},
+			{Name: "content", In: "query", Description: "Content every node's job processes", Required: true},
+			{Name: "X-Tenant", In: "header", Description: "Tenant the jobs are indexed under"},
+		},
+		Responses: map[int]string{202: "Started, with run_id and status_url", 400: "Missing content", 404: "Unknown workflow", 409: "The stored workflow is invalid", 503: "Maintenance mode, or shedding load because Redis is over its memory budget"},
+	})
+	route(app, fiber.MethodGet, "/workflows/runs/:id", workflowRunHandler, apiOperation{
+		Summary: "A workflow run: its status (running, completed, failed or abandoned) and every node's request_id, status and timing",
+		Params: []apiParam{
+			{Name: "id", In: "path", Description: "run_id returned when the run started"},
+		},
+		Responses: map[int]string{200: "Run", 404: "Unknown or expired run"},
+	})
+	registerDashboard(app)
+	registerDemo(app)
+	registerOpenAPI(app)
+
+	return app
+}
+
 // --- Main Controller Handler ---
 
 func validateHandler(c *fiber.Ctx) error {
+	if inMaintenance() {
+		return respondMaintenance(c)
+	}
+	if err := rejectWhenShedding(c); err != nil {
+		return err
+	}
 	requestReceived := nowNs()
 	input, err := extractContent(c)
 	if err != nil {
 		return err
 	}
+	binary, err := checkEncoding(input, c.Query("encoding"))
+	if err != nil {
+		return err
+	}
 
 	msg := prepareMessage(input, requestReceived)
+	msg.Data.Binary = binary
+	msg.Tenant = c.Get("X-Tenant")
+	msg.JobType = c.Query("type")
+	return submitAndWait(c, msg)
+}
+
+// submitAndWait enqueues msg for the caller and answers with the worker's result. It is shared
+// by every endpoint creating jobs.
+func submitAndWait(c *fiber.Ctx, msg *Message) error {
+	if err := checkResponseFormat(c); err != nil {
+		return err
+	}
+	keyID := usageKeyID(c)
+	msg.Cost = costLabelsFor(c)
+	if key := c.Get("X-Affinity-Key"); key != "" {
+		msg.Affinity = key
+	}
+	msg.TraceID = traceIDFrom(c)
+	c.Set("X-Trace-ID", msg.TraceID)
+	c.SetUserContext(withLogger(c.UserContext(), jobLogger(msg)))
+	c.Locals(localsMessage, msg)
+	decideFlags(msg)
+	if err := enrichMessage(c, msg); err != nil {
+		return err
+	}
+	deps, err := parseDependencies(c)
+	if err != nil {
+		return err
+	}
+	msg.DependsOn = deps
+	if err := applyResidency(c, msg); err != nil {
+		return err
+	}
+	if failure, ok := cachedFailure(c, msg); ok {
+		return respondCachedFailure(c, keyID, msg, failure)
+	}
+	if err := checkGatewayBudget(msg); err != nil {
+		if !isDryRun(c) {
+			recordOutcome(msg, outcomeFailed)
+			recordUsage(keyID, msg, nil, outcomeFailed)
+		}
+		return err
+	}
+	if estimate, rejected := admissionRejected(msg); rejected {
+		return respondNotAdmitted(c, msg, estimate)
+	}
+	if isDryRun(c) {
+		return respondDryRun(c, keyID, msg)
+	}
+	nonce := c.Get("X-Nonce")
+	if nonce != "" {
+		// Without Redis the push fails as well, so claim errors are left to it
+		if original, claimed, err := claimNonce(keyID, nonce, msg.RequestID); err == nil && !claimed {
+			return attachToOriginal(c, original)
+		}
+	}
+	stopKeepalive := startKeepalive(c)
+	defer stopKeepalive()
+	var waited time.Duration
+	if len(msg.DependsOn) > 0 {
+		if waited, err = holdForDependencies(keyID, msg); err != nil {
+			if nonce != "" {
+				releaseNonce(keyID, nonce)
+			}
+			return err
+		}
+	}
+	msg.Meta.Mark(stageRestRequestPushed)
+	setQueueDeadline(msg)
 	logHandling(msg)
 
-	if err := pushToQueue(msg); err != nil {
-		counterFailure.Inc()
+	reply := dispatcher.register(msg.RequestID)
+	defer dispatcher.cancel(msg.RequestID)
+
+	callback := c.Query("callback")
+	accepted, err := pushToQueue(msg, callback)
+	if err != nil && failoverJob(msg) {
+		err = nil
+	}
+	if err != nil {
+		if nonce != "" {
+			releaseNonce(keyID, nonce)
+		}
+		metrics.CounterFailure.Inc()
+		recordOutcome(msg, outcomeFailed)
+		recordUsage(keyID, msg, nil, outcomeFailed)
+		setJobStatus(msg.RequestID, jobStatusFailed)
+		storeJob(msg, jobStatusFailed)
 		return fiber.NewError(fiber.StatusInternalServerError, "Failed to push to job queue")
 	}
-
-	result, err := waitForResult(msg.RequestID)
+	mirrorJob(msg)
+	storeJob(msg, jobStatusPending)
+	setQueueHeaders(c, accepted)
+
+	gone, stopWatching := watchClient(c, msg, reply)
+	result, err := awaitResult(msg.RequestID, reply, gone, waitTimeout.Get()-waited)
+	stopWatching()
+	if err == errClientGone {
+		return respondClientGone(c, msg)
+	}
 	if err != nil {
-		counterFailure.Inc()
-		return fiber.NewError(fiber.StatusGatewayTimeout, "Timeout waiting for result")
+		metrics.CounterFailure.Inc()
+		recordOutcome(msg, outcomeTimeout)
+		recordUsage(keyID, msg, nil, outcomeTimeout)
+		setJobStatus(msg.RequestID, jobStatusTimeout)
+		storeJob(msg, jobStatusTimeout)
+		forensics := respondTimeout(c, msg)
+		if lateResultPolicy.Get() == latePolicyStore {
+			body := fiber.Map{
+				"request_id": msg.RequestID,
+				"status":     "pending",
+				"status_url": "/jobs/" + msg.RequestID,
+			}
+			if forensics.Queue != nil {
+				setQueueHeaders(c, *forensics.Queue)
+			}
+			return c.Status(fiber.StatusGatewayTimeout).JSON(addTimeoutFields(body, forensics))
+		}
+		return fiber.NewError(fiber.StatusGatewayTimeout, "Timeout waiting for result, job "+forensics.String())
+	}
+	if callback != "" {
+		_ = rdb.Del(ctx, callbackKey(msg.RequestID))
 	}
+	if msg.Data.File != nil {
+		_ = rdb.Del(ctx, msg.Data.File.Key)
+	}
+	recordJobError(msg.RequestID, result.Meta.lastError())
+	setJobStatus(msg.RequestID, jobStatusCompleted)
 
+	result.Meta.EnqueueDepth = msg.Meta.EnqueueDepth
 	finalMsg := finalizeResult(result)
+	checkStageBudgets(c, finalMsg)
 	logHandling(finalMsg)
+	storeJob(finalMsg, jobStatusCompleted)
+	recordUsage(keyID, msg, finalMsg, outcomeCompleted)
+	recordFixture(msg, finalMsg)
+	rememberFailure(msg, finalMsg)
+	c.Locals(localsMessage, finalMsg)
+	if nonce != "" {
+		keepNonceResult(finalMsg)
+	}
 
-	c.Set("Content-Type", "application/json")
-	return c.JSON(finalMsg)
+	if finalMsg.Data.Chunks != nil {
+		// Retries with the nonce may still need the chunks, they expire on their own
+		return respondChunked(c, finalMsg, nonce == "")
+	}
+	return respondMessage(c, finalMsg)
 }
 
 // --- Sub-functions used by the controller ---
@@ -200,11 +679,19 @@ func extractContent(c *fiber.Ctx) (string, error) {
 }
 
 func prepareMessage(content string, requestReceived int64) *Message {
+	var replyTo, replyVia string
+	switch replyMode {
+	case replyModeInstance:
+		replyTo = replyKey(instanceID)
+	case replyModePubSub:
+		replyTo, replyVia = replyKey(instanceID), "pubsub"
+	}
 	return &Message{
 		RequestID: uuid.NewString(),
+		ReplyTo:   replyTo,
+		ReplyVia:  replyVia,
 		Meta: Meta{
-			RestRequestReceived: requestReceived,
-			RestRequestPushed:   nowNs(),
+			Stages: []StageEvent{{Name: stageRestRequestReceived, TsNs: requestReceived}},
 		},
 		Data: Data{
 			Content: content,
@@ -213,56 +700,204 @@ func prepareMessage(content string, requestReceived int64) *Message {
 	}
 }
 
-func pushToQueue(msg *Message) error {
-	payload, err := json.Marshal(msg)
+// pushToQueue registers the waiter (and the optional late result callback) and enqueues the job
+// in one round trip. The waiter must exist before the job does, otherwise the janitor could
+// collect a fast response. It returns the job's place in the queue as it was pushed, and keeps
+// the jobs ahead of it in msg.Meta.EnqueueDepth.
+func pushToQueue(msg *Message, callback string) (queueEstimate, error) {
+	payload, err := codec.Marshal(msg)
 	if err != nil {
-		return err
+		return queueEstimate{}, err
+	}
+
+	pipe := rdb.TxPipeline()
+	pipe.Set(ctx, waiterKey(msg.RequestID), 1, waitTimeout.Get())
+	base := baseQueueFor(msg)
+	queue := jobQueueFor(msg)
+	journalAdd(pipe, msg.RequestID)
+	indexJob(pipe, msg, queue)
+	if callback != "" {
+		pipe.Set(ctx, callbackKey(msg.RequestID), callback, waitTimeout.Get()+jobResultTTL.Get())
+	}
+	if msg.Tenant != "" && queue == tenantQueueKey(base, msg.Tenant) {
+		// Fair scheduling workers find the tenant queues through this set
+		pipe.SAdd(ctx, tenantsKey(base), msg.Tenant)
+	}
+	seq := pipe.Incr(ctx, queueSeqKey(queue))
+	length := pipe.RPush(ctx, queue, payload)
+	if msg.hasFlag(shadowFlag) {
+		if err := shadowJob(pipe, msg); err != nil {
+			return queueEstimate{}, err
+		}
+	}
+	if _, err = pipe.Exec(ctx); err != nil {
+		return queueEstimate{}, err
+	}
+	if partition, ok := affinityPartition(msg.Affinity); ok {
+		countAffinityPush(partition)
 	}
-	return rdb.RPush(ctx, "validate:queue", payload).Err()
+	msg.Meta.EnqueueDepth = length.Val() - 1
+	recordQueueSeq(msg, queue, seq.Val())
+	return newQueueEstimate(queue, length.Val()), nil
 }
 
-func waitForResult(requestId string) (*Message, error) {
-	resultKey := fmt.Sprintf("validate:response:%s", requestId)
-	result, err := rdb.BLPop(ctx, 5*time.Minute, resultKey).Result()
-	if err != nil || len(result) < 2 {
+var errResponseTimeout = errors.New("timeout waiting for response")
+
+// waitForResult waits for the dispatcher to hand over the reply, or in "key" reply mode
+// (reply == nil) blocks on the request's own response key.
+func waitForResult(requestId string, reply <-chan *Message, timeout time.Duration) (*Message, error) {
+	return awaitResult(requestId, reply, nil, timeout)
+}
+
+// awaitResult is waitForResult giving up with errClientGone once gone is closed.
+func awaitResult(requestId string, reply <-chan *Message, gone <-chan struct{}, timeout time.Duration) (*Message, error) {
+	defer func() {
+		pipe := rdb.Pipeline()
+		pipe.Del(ctx, waiterKey(requestId))
+		pipe.ZRem(ctx, journalKey, journalMember(requestId))
+		_, _ = pipe.Exec(ctx)
+	}()
+
+	if reply != nil {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case msg := <-reply:
+			return msg, nil
+		case <-gone:
+			return nil, errClientGone
+		case <-timer.C:
+			// A Pub/Sub reply that found no subscriber was parked on the response key
+			if payload, err := sweepResponse(responseKey(requestId)); err == nil {
+				return decodeResult(payload)
+			}
+			return nil, errResponseTimeout
+		}
+	}
+
+	payload, err := takeResponse(responseKey(requestId), timeout)
+	if err != nil {
 		return nil, err
 	}
+	return decodeResult(payload)
+}
 
+func decodeResult(payload []byte) (*Message, error) {
 	var msg Message
-	if err := json.Unmarshal([]byte(result[1]), &msg); err != nil {
+	if err := codec.Unmarshal(payload, &msg); err != nil {
 		return nil, err
 	}
-
-	_ = rdb.Del(ctx, resultKey)
 	return &msg, nil
 }
 
+// takeResponse blocks until the worker's response arrives, then atomically sweeps the key:
+// duplicates are dropped, and on timeout a response pushed right at the deadline is still
+// delivered instead of being leaked.
+func takeResponse(resultKey string, timeout time.Duration) ([]byte, error) {
+	result, err := rdb.BLPop(ctx, timeout, resultKey).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	if len(result) == 2 {
+		// Drop duplicates, if any
+		_, _ = sweepResponse(resultKey)
+		return []byte(result[1]), nil
+	}
+	return sweepResponse(resultKey)
+}
+
+// sweepResponse atomically takes the first entry of a response key and removes the rest.
+func sweepResponse(resultKey string) ([]byte, error) {
+	swept, err := takeResponseScript.Run(ctx, rdb, []string{resultKey}).Slice()
+	if err != nil || len(swept) != 2 {
+		return nil, errResponseTimeout
+	}
+	left, _ := swept[1].(int64)
+	value, ok := swept[0].(string)
+	if !ok {
+		return nil, errResponseTimeout
+	}
+	metrics.CounterResponseLeftovers.Add(float64(left))
+	return []byte(value), nil
+}
+
+// recordOutcome feeds the outcome of a finished request to everything tracking outcomes: the
+// canary SLO, the analytics export, slow request traces and readiness.
+func recordOutcome(msg *Message, outcome string) {
+	recordCohort(msg, outcome, float64(msg.Meta.RoundtripDurationNs)/1_000_000)
+	exportStages(msg, outcome)
+	recordCost(msg, outcome)
+	traceRequest(msg, outcome)
+	recordReadiness(outcome)
+}
+
 func logHandling(msg *Message) {
-	fmt.Printf("[REST] Handling request_id=%s | content=%q | received_ns=%d\n",
-		msg.RequestID,
-		msg.Data.Content,
-		msg.Meta.RestRequestReceived,
+	worker := "-"
+	if msg.Worker != nil {
+		worker = msg.Worker.InstanceID + "@" + msg.Worker.Version
+	}
+	jobLogger(msg).Info("Handling",
+		"content", redact(redactLog, msg.Data.Content),
+		"received_ns", msg.Meta.At(stageRestRequestReceived),
+		"attempts", len(msg.Meta.Attempts),
+		"worker", worker,
 	)
 }
 
 func finalizeResult(msg *Message) *Message {
-	now := time.Now().UnixNano()
-	msg.Meta.RestResponsePulled = now
-
-	// Compute and store total roundtrip duration
-	duration := now - msg.Meta.RestRequestReceived
-	msg.Meta.RoundtripDurationNs = duration
-
-	// Observe Prometheus histograms (in ms)
-	durationRestRequestToRestPushMs.Observe(float64(msg.Meta.RestRequestPushed-msg.Meta.RestRequestReceived) / 1_000_000)
-	durationRestPushToWorkerPullMs.Observe(float64(msg.Meta.WorkerRequestPulled-msg.Meta.RestRequestPushed) / 1_000_000)
-	durationWorkerPullToWorkerPushMs.Observe(float64(msg.Meta.WorkerResponsePushed-msg.Meta.WorkerRequestPulled) / 1_000_000)
-	durationWorkerPushToRestPullMs.Observe(float64(msg.Meta.RestResponsePulled-msg.Meta.WorkerResponsePushed) / 1_000_000)
-	durationRestPullToRestResponseMs.Observe(float64(now-msg.Meta.RestResponsePulled) / 1_000_000)
-	durationFullCycleMs.Observe(float64(duration) / 1_000_000)
-
-	// Mark success
-	counterSuccess.Inc()
+	now := clock.Now().UnixNano()
+	msg.Meta.MarkAt(stageRestResponsePulled, now)
+
+	// Compute and store total roundtrip duration, left at 0 when the received stamp is missing
+	// or ahead of this replica's clock
+	received := msg.Meta.At(stageRestRequestReceived)
+	pushed := msg.Meta.At(stageRestRequestPushed)
+	pulled := msg.Meta.At(stageWorkerRequestPulled)
+	responded := msg.Meta.At(stageWorkerResponsePushed)
+	if received > 0 && now >= received {
+		msg.Meta.RoundtripDurationNs = now - received
+	}
+
+	outcome := outcomeCompleted
+	if attempts := msg.Meta.Attempts; len(attempts) > 0 && attempts[len(attempts)-1].Error != "" {
+		outcome = outcomeFailed
+	}
+	if outcome == outcomeCompleted {
+		metrics.CounterSuccess.Inc()
+	}
+	recordOutcome(msg, outcome)
+
+	if msg.Meta.retried() {
+		metrics.CounterRetried.Inc()
+		if retriedObservations.Get() == retriedExclude {
+			return msg
+		}
+	}
+
+	// Observe Prometheus histograms (in ms), leaving out durations that can't be trusted
+	observeStages(msg)
+	durations := []struct {
+		histogram prometheus.Observer
+		from, to  int64
+	}{
+		{metrics.DurationRestRequestToRestPushMs, received, pushed},
+		{metrics.DurationRestPushToWorkerPullMs.WithLabelValues(depthBucket(msg.Meta.EnqueueDepth)), pushed, pulled},
+		{metrics.DurationWorkerPullToWorkerPushMs, pulled, responded},
+		{metrics.DurationWorkerPushToRestPullMs, responded, now},
+		{metrics.DurationRestPullToRestResponseMs, now, clock.Now().UnixNano()},
+		{metrics.DurationFullCycleMs, received, now},
+	}
+	samples := make([]float64, 0, len(durations))
+	for _, d := range durations {
+		if ms, ok := stageDuration(d.from, d.to); ok {
+			d.histogram.Observe(ms)
+			samples = append(samples, ms)
+		}
+	}
+	// The dashboard samples are positional, so only complete sets are kept
+	if len(samples) == len(durations) {
+		recordStageSamples(samples...)
+	}
 
 	return msg
 }
@@ -0,0 +1,128 @@
+package gateway
+
+import (
+	"log/slog"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go-async-proxy/metrics"
+)
+
+// --- Maintenance Mode ---
+
+// PUT /admin/maintenance turns maintenance mode on or off for the whole fleet: new
+// submissions are answered 503 with the operator's message and a Retry-After, while requests
+// already waiting get their results. The state lives in the validate:maintenance hash, which
+// every gateway re-reads with its config and right away on the reload_config broadcast the
+// switch sends.
+
+const maintenanceKey = "validate:maintenance"
+
+// maintenanceState is the mode as set by the operator.
+type maintenanceState struct {
+	Enabled    bool   `json:"enabled"`
+	Message    string `json:"message,omitempty"`
+	RetryAfter string `json:"retry_after,omitempty"`
+	SinceMs    int64  `json:"since_ms,omitempty"`
+}
+
+const defaultMaintenanceMessage = "The service is down for maintenance, please try again later."
+
+var maintenance atomic.Pointer[maintenanceState]
+
+func init() {
+	maintenance.Store(&maintenanceState{})
+}
+
+// loadMaintenance refreshes the mode from Redis, keeping the current one when Redis can't be read.
+func loadMaintenance() {
+	fields, err := rdb.HGetAll(ctx, maintenanceKey).Result()
+	if err != nil {
+		slog.Error("Cannot read maintenance mode", "error", err)
+		return
+	}
+	sinceMs, _ := strconv.ParseInt(fields["since_ms"], 10, 64)
+	state := &maintenanceState{
+		Enabled:    fields["enabled"] == "1",
+		Message:    fields["message"],
+		RetryAfter: fields["retry_after"],
+		SinceMs:    sinceMs,
+	}
+	if previous := maintenance.Swap(state); previous.Enabled != state.Enabled {
+		slog.Warn("Maintenance mode changed", "enabled", state.Enabled)
+	}
+	if state.Enabled {
+		metrics.GaugeMaintenance.Set(1)
+	} else {
+		metrics.GaugeMaintenance.Set(0)
+	}
+}
+
+// retryAfter is how long clients are told to wait, 5 minutes unless the operator said otherwise.
+func (s *maintenanceState) retryAfter() time.Duration {
+	if d, err := time.ParseDuration(s.RetryAfter); err == nil && d > 0 {
+		return d
+	}
+	return 5 * time.Minute
+}
+
+// inMaintenance reports whether new work is refused.
+func inMaintenance() bool {
+	return maintenance.Load().Enabled
+}
+
+// respondMaintenance answers 503 to new work with the maintenance message.
+func respondMaintenance(c *fiber.Ctx) error {
+	state := maintenance.Load()
+	metrics.CounterMaintenanceRejections.Inc()
+	message := state.Message
+	if message == "" {
+		message = defaultMaintenanceMessage
+	}
+	retry := state.retryAfter()
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(retry.Seconds())))
+	return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+		"status":              "maintenance",
+		"message":             message,
+		"retry_after_seconds": int(retry.Seconds()),
+	})
+}
+
+func maintenanceHandler(c *fiber.Ctx) error {
+	return c.JSON(maintenance.Load())
+}
+
+// setMaintenanceHandler stores the mode in the body, {"enabled", "message", "retry_after"},
+// and has every gateway apply it.
+func setMaintenanceHandler(c *fiber.Ctx) error {
+	var state maintenanceState
+	if err := codec.Unmarshal(c.Body(), &state); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid maintenance state")
+	}
+	if state.RetryAfter != "" {
+		if d, err := time.ParseDuration(state.RetryAfter); err != nil || d <= 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "retry_after must be a positive duration, e.g. 10m")
+		}
+	}
+
+	var err error
+	if state.Enabled {
+		state.SinceMs = clock.Now().UnixMilli()
+		err = rdb.HSet(ctx, maintenanceKey, "enabled", "1", "message", state.Message,
+			"retry_after", state.RetryAfter, "since_ms", state.SinceMs).Err()
+	} else {
+		err = rdb.Del(ctx, maintenanceKey).Err()
+	}
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to store maintenance mode")
+	}
+	loadMaintenance()
+	record, err := sendControl(ControlMessage{Action: controlReloadConfig})
+	if err != nil {
+		// Stored: the other gateways pick it up with their next config reload
+		slog.Warn("Cannot broadcast maintenance mode", "error", err)
+	}
+	return c.JSON(fiber.Map{"maintenance": maintenance.Load(), "control_id": record.Command.ID})
+}
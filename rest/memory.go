@@ -0,0 +1,110 @@
+package gateway
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go-async-proxy/metrics"
+)
+
+// --- Redis Memory Budget ---
+
+var (
+	// memoryBudget is the Redis used_memory, in bytes, above which the gateway sheds new work.
+	// 0 disables the monitor.
+	memoryBudget = intTunable("REDIS_MEMORY_BUDGET", 0)
+
+	// memoryCheckInterval is how often INFO memory is checked against the budget.
+	memoryCheckInterval = durationTunable("MEMORY_CHECK_INTERVAL", 10*time.Second)
+
+	// shedResultTTL is how long stored results are kept while shedding, instead of JOB_RESULT_TTL.
+	shedResultTTL = durationTunable("SHED_RESULT_TTL", 5*time.Minute)
+)
+
+// shedResumeRatio is the share of the budget memory has to drop below before shedding stops,
+// so the gateway doesn't flap around the budget.
+const shedResumeRatio = 0.9
+
+// shedding is set while Redis is over its memory budget.
+var shedding atomic.Bool
+
+// startMemoryMonitor periodically compares Redis memory to the budget and switches shed mode.
+// Every replica checks on its own, the same INFO makes them agree.
+func startMemoryMonitor() {
+	go func() {
+		for {
+			checkMemory()
+			time.Sleep(memoryCheckInterval.Get())
+		}
+	}()
+}
+
+func checkMemory() {
+	info, err := rdb.Info(ctx, "memory").Result()
+	if err != nil {
+		slog.Error("Cannot read Redis memory info", "error", err)
+		return
+	}
+	used, err := infoField(info, "used_memory")
+	if err != nil {
+		slog.Error("Cannot read Redis used_memory", "error", err)
+		return
+	}
+	metrics.GaugeRedisUsedMemory.Set(float64(used))
+
+	budget := int64(memoryBudget.Get())
+	switch {
+	case budget == 0 || float64(used) < float64(budget)*shedResumeRatio:
+		if shedding.Swap(false) {
+			slog.Info("Redis memory below budget, accepting new work again", "used", used, "budget", budget)
+		}
+	case used >= budget:
+		if !shedding.Swap(true) {
+			slog.Warn("Redis memory over budget, shedding new work", "used", used, "budget", budget)
+			expireStoredResults()
+		}
+	}
+	if shedding.Load() {
+		metrics.GaugeShedding.Set(1)
+	} else {
+		metrics.GaugeShedding.Set(0)
+	}
+}
+
+// infoField reads an integer field of an INFO reply.
+func infoField(info, field string) (int64, error) {
+	for _, line := range strings.Split(info, "\n") {
+		if value, found := strings.CutPrefix(strings.TrimSpace(line), field+":"); found {
+			return strconv.ParseInt(value, 10, 64)
+		}
+	}
+	return 0, fmt.Errorf("no %s in INFO", field)
+}
+
+// rejectWhenShedding answers 503 to new work while Redis is over budget. Jobs already queued
+// and results being polled are still served.
+func rejectWhenShedding(c *fiber.Ctx) error {
+	if !shedding.Load() {
+		return nil
+	}
+	metrics.CounterShedRequests.Inc()
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(max(int(memoryCheckInterval.Get().Seconds()), 1)))
+	return fiber.NewError(fiber.StatusServiceUnavailable, "Redis memory budget exceeded, try again later")
+}
+
+// expireStoredResults shortens the TTL of stored late results and result chunks to
+// SHED_RESULT_TTL. The janitor repeats it every sweep while shedding.
+func expireStoredResults() {
+	ttl := shedResultTTL.Get()
+	for _, pattern := range []string{jobKey("*"), chunkKey("*")} {
+		iter := rdb.Scan(ctx, 0, pattern, 500).Iterator()
+		for iter.Next(ctx) {
+			_ = rdb.ExpireLT(ctx, iter.Val(), ttl).Err()
+		}
+	}
+}
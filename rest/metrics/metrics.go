@@ -0,0 +1,402 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Metrics ---
+
+var (
+	Buckets = []float64{
+		0.1, 0.2, 0.5, 1, 2, 3, 4, 5,
+		10, 20, 50, 100, 200, 500,
+		1000, 2000,
+	}
+
+	// Operation success counters
+	CounterSuccess = counter(prometheus.CounterOpts{
+		Name: "rest_success_total",
+		Help: "Total number of successful requests",
+	})
+
+	// Operation failed counters
+	CounterFailure = counter(prometheus.CounterOpts{
+		Name: "rest_failure_total",
+		Help: "Total number of failed requests",
+	})
+
+	// Results the worker needed more than one attempt for
+	CounterRetried = counter(prometheus.CounterOpts{
+		Name: "rest_retried_total",
+		Help: "Total number of successful requests the worker had to retry",
+	})
+
+	// Checksum of the effective tunables, equal on replicas running the same config
+	GaugeConfigVersion = gauge(prometheus.GaugeOpts{
+		Name: "rest_config_version",
+		Help: "Checksum of the effective tunable config, equal across replicas running the same config",
+	})
+
+	// Feature flag evaluations, by flag and decision
+	CounterFlagDecisions = counterVec(prometheus.CounterOpts{
+		Name: "rest_flag_decisions_total",
+		Help: "Total number of feature flag evaluations, by flag and whether it was enabled",
+	}, []string{"flag", "enabled"})
+
+	// Pipeline stages that overran their STAGE_BUDGETS budget
+	CounterStageBudgetExceeded = counterVec(prometheus.CounterOpts{
+		Name: "rest_stage_budget_exceeded_total",
+		Help: "Total number of jobs that overran a stage budget, by stage (gateway, queue_wait, processing, reply, total)",
+	}, []string{"stage"})
+
+	// Requests copied to the shadow queue
+	CounterShadowed = counter(prometheus.CounterOpts{
+		Name: "rest_shadowed_total",
+		Help: "Total number of requests copied to the shadow queue",
+	})
+
+	// Request outcomes per cohort, canary or stable
+	CounterCohortOutcomes = counterVec(prometheus.CounterOpts{
+		Name: "rest_cohort_outcomes_total",
+		Help: "Total number of requests by cohort (canary or stable) and outcome (completed, failed, timeout)",
+	}, []string{"cohort", "outcome"})
+
+	// Canary routes turned off after an SLO breach
+	CounterCanaryDisabled = counter(prometheus.CounterOpts{
+		Name: "rest_canary_disabled_total",
+		Help: "Total number of times the canary route was disabled after breaching its SLO",
+	})
+
+	// Queue size gauge, updated every 30s. Similar across replicas.
+	GaugeQueued = gauge(prometheus.GaugeOpts{
+		Name: "rest_queued_count",
+		Help: "Queue size gauge, updated every 30s. Similar across replicas.",
+	})
+
+	// Jobs taken off the main queue per second, the basis of queue wait estimates
+	GaugeQueueDrainRate = gauge(prometheus.GaugeOpts{
+		Name: "rest_queue_drain_per_second",
+		Help: "Jobs taken off the main queue per second over QUEUE_DRAIN_WINDOW",
+	})
+
+	// Redis used_memory, compared against REDIS_MEMORY_BUDGET
+	GaugeRedisUsedMemory = gauge(prometheus.GaugeOpts{
+		Name: "rest_redis_used_memory_bytes",
+		Help: "Redis used_memory as last checked by the memory budget monitor",
+	})
+
+	// Shed mode: 1 while Redis is over its memory budget
+	GaugeShedding = gauge(prometheus.GaugeOpts{
+		Name: "rest_shedding",
+		Help: "1 while the gateway sheds new work because Redis is over its memory budget",
+	})
+
+	// Requests refused while shedding
+	CounterShedRequests = counter(prometheus.CounterOpts{
+		Name: "rest_shed_requests_total",
+		Help: "Total number of requests answered 503 while shedding",
+	})
+
+	// Job store writes dropped because the write buffer was full
+	CounterJobStoreDropped = counter(prometheus.CounterOpts{
+		Name: "rest_job_store_dropped_total",
+		Help: "Total number of job store writes dropped because the write buffer was full",
+	})
+
+	// Job store batches that failed to write
+	CounterJobStoreErrors = counter(prometheus.CounterOpts{
+		Name: "rest_job_store_errors_total",
+		Help: "Total number of job store batches that failed to write",
+	})
+
+	// Analytics events dropped because the export buffer was full
+	CounterEventsDropped = counter(prometheus.CounterOpts{
+		Name: "rest_events_dropped_total",
+		Help: "Total number of analytics events dropped because the export buffer was full",
+	})
+
+	// Analytics event batches that failed to export
+	CounterEventExportErrors = counter(prometheus.CounterOpts{
+		Name: "rest_event_export_errors_total",
+		Help: "Total number of analytics event batches that failed to export",
+	})
+
+	// Requests whose full trace was kept, by reason
+	CounterTracedRequests = counterVec(prometheus.CounterOpts{
+		Name: "rest_traced_requests_total",
+		Help: "Total number of requests traced to the slow request stream, by reason (slow, timeout, sampled)",
+	}, []string{"reason"})
+
+	// Stage durations left out of the histograms because their timestamps can't be trusted
+	CounterMetaAnomalies = counterVec(prometheus.CounterOpts{
+		Name: "meta_anomalies_total",
+		Help: "Total number of stage durations excluded from histograms, by reason (negative, implausible, missing_stage)",
+	}, []string{"reason"})
+
+	// Jobs by cost attribution labels and outcome
+	CounterCostJobs = counterVec(prometheus.CounterOpts{
+		Name: "rest_cost_jobs_total",
+		Help: "Total number of jobs by cost attribution (team, cost_center) and outcome",
+	}, []string{"team", "cost_center", "outcome"})
+
+	// Worker processing time by cost attribution labels
+	CounterCostProcessingMs = counterVec(prometheus.CounterOpts{
+		Name: "rest_cost_processing_ms_total",
+		Help: "Total worker processing time in milliseconds by cost attribution (team, cost_center)",
+	}, []string{"team", "cost_center"})
+
+	// Synthetic probes, by outcome (completed, failed, timeout, error)
+	CounterProbes = counterVec(prometheus.CounterOpts{
+		Name: "rest_probes_total",
+		Help: "Total number of synthetic probes sent through the pipeline, by outcome",
+	}, []string{"outcome"})
+
+	// 1 when the last synthetic probe completed, 0 otherwise
+	GaugeProbeUp = gauge(prometheus.GaugeOpts{
+		Name: "rest_probe_up",
+		Help: "1 when the last synthetic probe completed end to end, 0 otherwise",
+	})
+
+	// Unix time of the last completed probe, for staleness alerts
+	GaugeProbeLastSuccess = gauge(prometheus.GaugeOpts{
+		Name: "rest_probe_last_success_timestamp_seconds",
+		Help: "Unix time of the last synthetic probe that completed end to end",
+	})
+
+	// Response keys removed by the janitor
+	CounterOrphanedResponses = counter(prometheus.CounterOpts{
+		Name: "rest_orphaned_responses_deleted_total",
+		Help: "Total number of response keys collected by the janitor after their waiter was gone",
+	})
+
+	// Submissions predicted to miss WAIT_TIMEOUT, by ADMISSION_CONTROL mode
+	CounterAdmissionRejections = counterVec(prometheus.CounterOpts{
+		Name: "rest_admission_rejections_total",
+		Help: "Total number of submissions predicted to complete after WAIT_TIMEOUT, by mode (on answers them 503, observe only counts)",
+	}, []string{"mode"})
+
+	// Submissions that attached to an earlier job with the same nonce
+	CounterNonceDuplicates = counter(prometheus.CounterOpts{
+		Name: "rest_nonce_duplicates_total",
+		Help: "Total number of submissions answered with the job of an earlier submission with the same X-Nonce",
+	})
+
+	// Jobs failed because a job they depend on did not complete successfully
+	CounterDependencyFailures = counter(prometheus.CounterOpts{
+		Name: "rest_dependency_failures_total",
+		Help: "Total number of jobs answered 424 because a job in X-Depends-On failed, was unknown or did not complete in time",
+	})
+
+	// Requests answered 504, by the stage their job reached
+	CounterTimeouts = counterVec(prometheus.CounterOpts{
+		Name: "rest_timeouts_total",
+		Help: "Total number of requests timed out waiting for their result, by the stage the job reached (queued, claimed, processing, unknown)",
+	}, []string{"stage"})
+
+	// Callers that hung up while waiting for their result, by CLIENT_DISCONNECT_POLICY
+	CounterClientDisconnects = counterVec(prometheus.CounterOpts{
+		Name: "rest_client_disconnects_total",
+		Help: "Total number of callers that disconnected while waiting for their result, by disconnect policy",
+	}, []string{"policy"})
+
+	// What became of the jobs of callers that hung up
+	CounterDisconnectedJobs = counterVec(prometheus.CounterOpts{
+		Name: "rest_disconnected_jobs_total",
+		Help: "Total number of results of jobs whose caller disconnected, by disconnect policy and outcome (cancelled, discarded, stored, delivered, webhook_failed)",
+	}, []string{"policy", "outcome"})
+
+	// Interim responses sent to callers waiting with ?keepalive=true
+	CounterKeepaliveFrames = counter(prometheus.CounterOpts{
+		Name: "rest_keepalive_frames_total",
+		Help: "Total number of interim responses (102 or 103) sent to keep waiting connections alive",
+	})
+
+	// Requests refused by the firewall, by reason
+	CounterFirewallBlocks = counterVec(prometheus.CounterOpts{
+		Name: "rest_firewall_blocks_total",
+		Help: "Total number of requests refused by the firewall, by reason (denied, not_allowed, pattern)",
+	}, []string{"reason"})
+
+	// Failed re-reads of SECRETS_PROVIDER, the previous secrets stay in use
+	CounterSecretRefreshFailures = counter(prometheus.CounterOpts{
+		Name: "rest_secret_refresh_failures_total",
+		Help: "Total number of failed secret refreshes from the secrets provider",
+	})
+
+	// Values masked by redaction, by where the payload was going
+	CounterRedactions = counterVec(prometheus.CounterOpts{
+		Name: "rest_redactions_total",
+		Help: "Total number of field values and pattern matches redacted, by sink (log, audit, store, fixture, response)",
+	}, []string{"sink"})
+
+	// Submissions refused because their data region had no live worker
+	CounterResidencyRejections = counterVec(prometheus.CounterOpts{
+		Name: "rest_residency_rejections_total",
+		Help: "Total number of submissions refused because no live worker serves their data region, by region",
+	}, []string{"region"})
+
+	// Workflow runs finished, by outcome
+	CounterWorkflowRuns = counterVec(prometheus.CounterOpts{
+		Name: "rest_workflow_runs_total",
+		Help: "Total number of workflow runs finished, by status (completed, failed)",
+	}, []string{"status"})
+
+	// Jobs copied to the secondary region's queue
+	CounterReplicatedJobs = counterVec(prometheus.CounterOpts{
+		Name: "rest_replicated_jobs_total",
+		Help: "Total number of jobs queued in the replica region, by replica mode (active mirrors, standby fails over)",
+	}, []string{"mode"})
+
+	// Jobs the replica region's Redis refused
+	CounterReplicationFailures = counter(prometheus.CounterOpts{
+		Name: "rest_replication_failures_total",
+		Help: "Total number of jobs that could not be queued in the replica region",
+	})
+
+	// First results of replicated jobs, by the region that produced them
+	CounterReplicaResults = counterVec(prometheus.CounterOpts{
+		Name: "rest_replica_results_total",
+		Help: "Total number of results that won the race between regions, by region (primary, replica)",
+	}, []string{"region"})
+
+	// Second results of replicated jobs, dropped
+	CounterReplicaDuplicates = counterVec(prometheus.CounterOpts{
+		Name: "rest_replica_duplicate_results_total",
+		Help: "Total number of results dropped because the other region answered first, by region",
+	}, []string{"region"})
+
+	// Requests whose payload failed recently, by FAILURE_CACHE mode
+	CounterFailureCacheHits = counterVec(prometheus.CounterOpts{
+		Name: "rest_failure_cache_hits_total",
+		Help: "Total number of requests whose payload failed within FAILURE_CACHE_TTL, by mode (on answers them from the cache, observe only counts)",
+	}, []string{"mode"})
+
+	// Failures remembered by the failure cache
+	CounterFailureCacheStores = counter(prometheus.CounterOpts{
+		Name: "rest_failure_cache_stores_total",
+		Help: "Total number of failed payloads remembered by the failure cache",
+	})
+
+	// Jobs pushed to every affinity partition
+	CounterAffinityJobs = counterVec(prometheus.CounterOpts{
+		Name: "rest_affinity_jobs_total",
+		Help: "Total number of jobs pushed to an affinity partition, by partition",
+	}, []string{"partition"})
+
+	// Spread of the affinity keys over the partitions
+	GaugeAffinitySkew = gauge(prometheus.GaugeOpts{
+		Name: "rest_affinity_skew",
+		Help: "Pushes to the busiest affinity partition over the mean per partition, during the last sample interval: 1 is even",
+	})
+
+	// 1 while maintenance mode is on
+	GaugeMaintenance = gauge(prometheus.GaugeOpts{
+		Name: "rest_maintenance",
+		Help: "1 while maintenance mode is on and new submissions are answered 503, 0 otherwise",
+	})
+
+	// Submissions refused in maintenance mode
+	CounterMaintenanceRejections = counter(prometheus.CounterOpts{
+		Name: "rest_maintenance_rejections_total",
+		Help: "Total number of submissions answered 503 because of maintenance mode",
+	})
+
+	// Results that arrived after their caller gave up
+	CounterLateCompletions = counterVec(prometheus.CounterOpts{
+		Name: "rest_late_completions_total",
+		Help: "Total number of results completed after their caller gave up, by late result policy",
+	}, []string{"policy"})
+
+	// Late results the webhook policy failed to deliver
+	CounterLateWebhookFailures = counter(prometheus.CounterOpts{
+		Name: "rest_late_webhook_failures_total",
+		Help: "Total number of late results that could not be delivered via webhook",
+	})
+
+	// Chunked results failing their checksums or missing chunks
+	CounterChunkFailures = counter(prometheus.CounterOpts{
+		Name: "rest_chunk_failures_total",
+		Help: "Total number of chunked results that failed their integrity checks",
+	})
+
+	// Duplicate response entries dropped on consume
+	CounterResponseLeftovers = counter(prometheus.CounterOpts{
+		Name: "rest_response_leftovers_total",
+		Help: "Total number of extra response entries dropped while consuming a response",
+	})
+
+	// Waiters released after their gateway instance died
+	CounterJournalRecovered = counter(prometheus.CounterOpts{
+		Name: "rest_journal_recovered_total",
+		Help: "Total number of waiting requests released because their gateway instance died",
+	})
+
+	// Waiters released after their deadline passed
+	CounterJournalExpired = counter(prometheus.CounterOpts{
+		Name: "rest_journal_expired_total",
+		Help: "Total number of journaled requests released after their caller deadline passed",
+	})
+
+	// From REST request receive → Redis push (by REST)
+	DurationRestRequestToRestPushMs = histogram(prometheus.HistogramOpts{
+		Name:    "duration_rest_request_to_queue_push_ms",
+		Help:    "Duration from REST request to Redis push (REST) (ms)",
+		Buckets: Buckets,
+	})
+
+	// Request middlewares, by middleware
+	DurationEnrichmentMs = histogramVec(prometheus.HistogramOpts{
+		Name:    "duration_rest_enrichment_ms",
+		Help:    "Duration of each request middleware before the Redis push (REST) (ms)",
+		Buckets: Buckets,
+	}, []string{"middleware"})
+
+	// Between any two consecutive stage events, covering stages without a dedicated histogram
+	DurationStageMs = histogramVec(prometheus.HistogramOpts{
+		Name:    "duration_pipeline_stage_ms",
+		Help:    "Duration between consecutive pipeline stage events, by stage pair and cohort (ms)",
+		Buckets: Buckets,
+	}, []string{"from", "to", "cohort"})
+
+	// From Redis push (REST) → Redis pull (Worker), by the queue depth the job was pushed behind
+	DurationRestPushToWorkerPullMs = histogramVec(prometheus.HistogramOpts{
+		Name:    "duration_rest_push_to_worker_pull_ms",
+		Help:    "Duration from Redis push (REST) to Redis pull (Worker), by jobs ahead at push (0, 1-9, 10-99, 100-999, 1000+) (ms)",
+		Buckets: Buckets,
+	}, []string{"depth"})
+
+	// From Redis pull (Worker) → Redis push (Worker)
+	DurationWorkerPullToWorkerPushMs = histogram(prometheus.HistogramOpts{
+		Name:    "duration_worker_pull_to_worker_push_ms",
+		Help:    "Duration from Redis pull (Worker) to Redis push (Worker) (ms)",
+		Buckets: Buckets,
+	})
+
+	// From Redis push (Worker) → Redis pull (REST)
+	DurationWorkerPushToRestPullMs = histogram(prometheus.HistogramOpts{
+		Name:    "duration_worker_push_to_rest_pull_ms",
+		Help:    "Duration from Redis push (Worker) to Redis pull (REST) (ms)",
+		Buckets: Buckets,
+	})
+
+	// From Redis pull (REST) → HTTP response (REST)
+	DurationRestPullToRestResponseMs = histogram(prometheus.HistogramOpts{
+		Name:    "duration_rest_pull_to_rest_response_ms",
+		Help:    "Duration from Redis pull (REST) to HTTP response (REST) (ms)",
+		Buckets: Buckets,
+	})
+
+	// Synthetic probe roundtrip
+	DurationProbeMs = histogram(prometheus.HistogramOpts{
+		Name:    "rest_probe_duration_ms",
+		Help:    "Roundtrip of completed synthetic probes through the whole pipeline (ms)",
+		Buckets: Buckets,
+	})
+
+	// Full roundtrip: REST request → HTTP response
+	DurationFullCycleMs = histogram(prometheus.HistogramOpts{
+		Name:    "duration_total_roundtrip_ms",
+		Help:    "Total roundtrip time from REST request to REST response (ms)",
+		Buckets: Buckets,
+	})
+)
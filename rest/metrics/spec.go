@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Metric Catalog ---
+
+// Spec describes one metric as defined in this package. cmd/gen-dashboards derives the
+// Grafana dashboard and alert rules from these, so they never drift from the instrumentation.
+type Spec struct {
+	Name    string
+	Help    string
+	Kind    string // "counter", "gauge" or "histogram"
+	Labels  []string
+	Buckets []float64
+}
+
+var (
+	specs      []Spec
+	collectors []prometheus.Collector
+)
+
+// All returns every collector of the REST service, in declaration order.
+func All() []prometheus.Collector {
+	return collectors
+}
+
+// Specs returns the catalog of every metric of the REST service, in declaration order.
+func Specs() []Spec {
+	return specs
+}
+
+func add(collector prometheus.Collector, spec Spec) {
+	collectors = append(collectors, collector)
+	specs = append(specs, spec)
+}
+
+func counter(opts prometheus.CounterOpts) prometheus.Counter {
+	c := prometheus.NewCounter(opts)
+	add(c, Spec{Name: opts.Name, Help: opts.Help, Kind: "counter"})
+	return c
+}
+
+func counterVec(opts prometheus.CounterOpts, labels []string) *prometheus.CounterVec {
+	c := prometheus.NewCounterVec(opts, labels)
+	add(c, Spec{Name: opts.Name, Help: opts.Help, Kind: "counter", Labels: labels})
+	return c
+}
+
+func gauge(opts prometheus.GaugeOpts) prometheus.Gauge {
+	g := prometheus.NewGauge(opts)
+	add(g, Spec{Name: opts.Name, Help: opts.Help, Kind: "gauge"})
+	return g
+}
+
+func histogram(opts prometheus.HistogramOpts) prometheus.Histogram {
+	h := prometheus.NewHistogram(opts)
+	add(h, Spec{Name: opts.Name, Help: opts.Help, Kind: "histogram", Buckets: opts.Buckets})
+	return h
+}
+
+func histogramVec(opts prometheus.HistogramOpts, labels []string) *prometheus.HistogramVec {
+	h := prometheus.NewHistogramVec(opts, labels)
+	add(h, Spec{Name: opts.Name, Help: opts.Help, Kind: "histogram", Labels: labels, Buckets: opts.Buckets})
+	return h
+}
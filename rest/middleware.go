@@ -0,0 +1,98 @@
+package gateway
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// --- Result Middlewares ---
+
+// ResultMiddleware rewrites a worker result after it was pulled and before it is written to
+// the HTTP response, for both /validate and GET /jobs/:id.
+type ResultMiddleware func(c *fiber.Ctx, msg *Message) error
+
+// Annotation is a static key/value attached to every response by the annotate middleware.
+type Annotation struct {
+	Key   string `json:"key" xml:"key,attr"`
+	Value string `json:"value" xml:"value,attr"`
+}
+
+var resultMiddlewareFactories = map[string]func() (ResultMiddleware, error){
+	"redact":   newRedactMiddleware,
+	"annotate": newAnnotateMiddleware,
+}
+
+// resultMiddlewares is the chain picked by RESULT_MIDDLEWARES, applied in order.
+var resultMiddlewares []ResultMiddleware
+
+// initResultMiddlewares builds the chain from RESULT_MIDDLEWARES, a comma separated list of
+// middleware names (e.g. "redact,annotate").
+func initResultMiddlewares() error {
+	for _, name := range strings.Split(envString("RESULT_MIDDLEWARES", ""), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		factory, ok := resultMiddlewareFactories[name]
+		if !ok {
+			return fmt.Errorf("unknown result middleware %q", name)
+		}
+		middleware, err := factory()
+		if err != nil {
+			return fmt.Errorf("result middleware %q: %w", name, err)
+		}
+		resultMiddlewares = append(resultMiddlewares, middleware)
+	}
+	return nil
+}
+
+func applyResultMiddlewares(c *fiber.Ctx, msg *Message) error {
+	for _, middleware := range resultMiddlewares {
+		if err := middleware(c, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newRedactMiddleware masks the redaction config's fields and patterns (see redact.go) in text
+// content and the rule messages, turning the response sink on.
+func newRedactMiddleware() (ResultMiddleware, error) {
+	if len(redaction.fields) == 0 && len(redaction.patterns) == 0 {
+		return nil, fmt.Errorf("REDACT_FIELDS or REDACT_PATTERNS is required")
+	}
+	redaction.sinks[redactResponse] = true
+
+	return func(_ *fiber.Ctx, msg *Message) error {
+		if !msg.Data.Binary {
+			msg.Data.Content = redact(redactResponse, msg.Data.Content)
+		}
+		for i := range msg.Data.Rules {
+			msg.Data.Rules[i].Message = redact(redactResponse, msg.Data.Rules[i].Message)
+		}
+		return nil
+	}, nil
+}
+
+// newAnnotateMiddleware attaches the static RESULT_ANNOTATIONS ("key=value,key=value", e.g. the
+// deployment's region or environment) to every response.
+func newAnnotateMiddleware() (ResultMiddleware, error) {
+	var annotations []Annotation
+	for _, pair := range strings.Split(envString("RESULT_ANNOTATIONS", ""), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid RESULT_ANNOTATIONS entry %q", pair)
+		}
+		annotations = append(annotations, Annotation{Key: strings.TrimSpace(key), Value: strings.TrimSpace(value)})
+	}
+
+	return func(_ *fiber.Ctx, msg *Message) error {
+		msg.Annotations = append(msg.Annotations, annotations...)
+		return nil
+	}, nil
+}
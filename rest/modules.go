@@ -0,0 +1,61 @@
+package gateway
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// --- WASM Modules ---
+
+// Modules are stored per "<tenant>/<job_type>" for workers running HANDLER=wasm.
+// The digest lets workers notice an update without refetching the module on every job.
+
+const (
+	wasmModulesKey = "validate:wasm:modules"
+	wasmDigestsKey = "validate:wasm:digests"
+)
+
+var wasmMagic = []byte("\x00asm")
+
+func moduleField(c *fiber.Ctx) string {
+	return c.Get("X-Tenant") + "/" + c.Params("type")
+}
+
+// uploadModuleHandler serves PUT /modules/:type with the raw WASI module as body.
+func uploadModuleHandler(c *fiber.Ctx) error {
+	body := c.Body()
+	if len(body) > maxModuleSize {
+		return fiber.NewError(fiber.StatusRequestEntityTooLarge, "Module too large")
+	}
+	if !bytes.HasPrefix(body, wasmMagic) {
+		return fiber.NewError(fiber.StatusBadRequest, "Body is not a WebAssembly module")
+	}
+
+	sum := sha256.Sum256(body)
+	digest := hex.EncodeToString(sum[:])
+
+	pipe := rdb.TxPipeline()
+	pipe.HSet(ctx, wasmModulesKey, moduleField(c), body)
+	pipe.HSet(ctx, wasmDigestsKey, moduleField(c), digest)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to store module")
+	}
+	return c.JSON(fiber.Map{"job_type": c.Params("type"), "sha256": digest})
+}
+
+// deleteModuleHandler serves DELETE /modules/:type.
+func deleteModuleHandler(c *fiber.Ctx) error {
+	pipe := rdb.TxPipeline()
+	deleted := pipe.HDel(ctx, wasmModulesKey, moduleField(c))
+	pipe.HDel(ctx, wasmDigestsKey, moduleField(c))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to delete module")
+	}
+	if deleted.Val() == 0 {
+		return fiber.NewError(fiber.StatusNotFound, "Unknown module")
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
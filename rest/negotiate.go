@@ -0,0 +1,156 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// --- Content Negotiation ---
+
+const mimeMsgpack = "application/msgpack"
+
+// MessageView is the public shape of a Message in HTTP responses. Sections left out by
+// ?fields= or ?meta=none are nil and omitted in every format.
+type MessageView struct {
+	XMLName     xml.Name     `json:"-" xml:"message"`
+	RequestID   string       `json:"request_id,omitempty" xml:"request_id,omitempty"`
+	Tenant      string       `json:"tenant,omitempty" xml:"tenant,omitempty"`
+	Worker      *WorkerInfo  `json:"worker,omitempty" xml:"worker,omitempty"`
+	Annotations []Annotation `json:"annotations,omitempty" xml:"annotation,omitempty"`
+	Meta        any          `json:"meta,omitempty" xml:"meta,omitempty"`
+	Data        *Data        `json:"data,omitempty" xml:"data,omitempty"`
+}
+
+// MetaSummary is what ?meta=summary returns instead of the full stage timestamps.
+type MetaSummary struct {
+	RoundtripDurationNs int64 `json:"rest_roundtrip_duration_ns" xml:"rest_roundtrip_duration_ns"`
+}
+
+// projection is what the ?fields= and ?meta= query params keep of a message.
+type projection struct {
+	// fields are the wanted top-level fields, nil for all of them.
+	fields    map[string]bool
+	metaLevel string
+}
+
+// projectionOf parses the comma separated top-level fields (request_id, tenant, worker,
+// annotations, meta, data) and the meta verbosity (full, summary or none) of c's query. Empty
+// values keep everything.
+func projectionOf(c *fiber.Ctx) (projection, error) {
+	p := projection{metaLevel: c.Query("meta")}
+	switch p.metaLevel {
+	case "", "full", "summary", "none":
+	default:
+		return p, fiber.NewError(fiber.StatusBadRequest, "'meta' must be full, summary or none")
+	}
+	fields := c.Query("fields")
+	if fields == "" {
+		return p, nil
+	}
+
+	p.fields = map[string]bool{}
+	for _, field := range strings.Split(fields, ",") {
+		field = strings.TrimSpace(field)
+		switch field {
+		case "request_id", "tenant", "worker", "annotations", "meta", "data":
+			p.fields[field] = true
+		default:
+			return p, fiber.NewError(fiber.StatusBadRequest, "Unknown field in 'fields': "+field)
+		}
+	}
+	return p, nil
+}
+
+// view projects msg.
+func (p projection) view(msg *Message) *MessageView {
+	view := &MessageView{RequestID: msg.RequestID, Tenant: msg.Tenant, Worker: msg.Worker, Annotations: msg.Annotations, Meta: &msg.Meta, Data: &msg.Data}
+	switch p.metaLevel {
+	case "summary":
+		view.Meta = &MetaSummary{RoundtripDurationNs: msg.Meta.RoundtripDurationNs}
+	case "none":
+		view.Meta = nil
+	}
+	if p.fields == nil {
+		return view
+	}
+
+	if !p.fields["request_id"] {
+		view.RequestID = ""
+	}
+	if !p.fields["tenant"] {
+		view.Tenant = ""
+	}
+	if !p.fields["worker"] {
+		view.Worker = nil
+	}
+	if !p.fields["annotations"] {
+		view.Annotations = nil
+	}
+	if !p.fields["meta"] {
+		view.Meta = nil
+	}
+	if !p.fields["data"] {
+		view.Data = nil
+	}
+	return view
+}
+
+// responseFormat picks the format of the response from the Accept header: JSON, msgpack or XML.
+func responseFormat(c *fiber.Ctx) (string, error) {
+	format := c.Accepts(fiber.MIMEApplicationJSON, mimeMsgpack, fiber.MIMEApplicationXML)
+	if format == "" {
+		return "", fiber.NewError(fiber.StatusNotAcceptable, "Supported formats: application/json, application/msgpack, application/xml")
+	}
+	return format, nil
+}
+
+// checkResponseFormat rejects a request whose result could not be sent back, before a job is
+// queued for it: a worker must not process a job whose caller only gets a 406, or a 400 for
+// its ?fields= or ?meta=.
+func checkResponseFormat(c *fiber.Ctx) error {
+	if _, err := projectionOf(c); err != nil {
+		return err
+	}
+	_, err := responseFormat(c)
+	return err
+}
+
+// respondMessage writes msg in the format picked from the Accept header (JSON, msgpack or XML),
+// projected by the ?fields= and ?meta= query params, after the result middlewares ran on it.
+// The stage durations go to Server-Timing whatever the projection.
+func respondMessage(c *fiber.Ctx, msg *Message) error {
+	setServerTiming(c, msg)
+	if err := applyResultMiddlewares(c, msg); err != nil {
+		return err
+	}
+	projection, err := projectionOf(c)
+	if err != nil {
+		return err
+	}
+	view := projection.view(msg)
+	format, err := responseFormat(c)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case mimeMsgpack:
+		var buf bytes.Buffer
+		enc := msgpack.NewEncoder(&buf)
+		enc.SetCustomStructTag("json")
+		enc.SetOmitEmpty(true)
+		if err := enc.Encode(view); err != nil {
+			return err
+		}
+		c.Set(fiber.HeaderContentType, mimeMsgpack)
+		return c.Send(buf.Bytes())
+	case fiber.MIMEApplicationXML:
+		return c.XML(view)
+	default:
+		return c.JSON(view)
+	}
+}
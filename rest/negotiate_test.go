@@ -0,0 +1,81 @@
+package gateway
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestUnacceptableFormatIsRejectedBeforeEnqueue(t *testing.T) {
+	app, srv := startTestGateway(t)
+
+	status, body := get(t, app, "/validate?content=hello", fiber.HeaderAccept, "text/csv")
+	if status != fiber.StatusNotAcceptable {
+		t.Fatalf("status = %d, body %s, want 406", status, body)
+	}
+	if queued, _ := srv.Client.LLen(ctx, queueKey).Result(); queued != 0 {
+		t.Fatalf("%d jobs queued for a caller that can't read the result", queued)
+	}
+}
+
+func TestInvalidProjectionIsRejectedBeforeEnqueue(t *testing.T) {
+	app, srv := startTestGateway(t)
+
+	for _, query := range []string{"fields=request_id,nope", "meta=verbose"} {
+		status, body := get(t, app, "/validate?content=hello&"+query)
+		if status != fiber.StatusBadRequest {
+			t.Errorf("%s: status = %d, body %s, want 400", query, status, body)
+		}
+	}
+	if queued, _ := srv.Client.LLen(ctx, queueKey).Result(); queued != 0 {
+		t.Fatalf("%d jobs queued for a caller that can't read the result", queued)
+	}
+}
+
+func TestBulkStatusProjectsResults(t *testing.T) {
+	app, srv := startTestGateway(t)
+	result := Message{RequestID: "req-1", Tenant: "acme", Data: Data{Content: "HELLO", Result: true}}
+	payload, _ := codec.Marshal(&result)
+	srv.Client.Set(ctx, jobKey(result.RequestID), payload, 0)
+
+	req := httptest.NewRequest(fiber.MethodPost, "/jobs/status?fields=request_id,data&meta=none", strings.NewReader(`{"request_ids": ["`+result.RequestID+`"]}`))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	var answer struct {
+		Jobs []struct {
+			Result map[string]json.RawMessage `json:"result"`
+		} `json:"jobs"`
+	}
+	if err := json.Unmarshal(body, &answer); err != nil || len(answer.Jobs) != 1 {
+		t.Fatalf("status %d, body %s", resp.StatusCode, body)
+	}
+	got := answer.Jobs[0].Result
+	if _, ok := got["data"]; !ok || len(got) != 2 || got["request_id"] == nil {
+		t.Fatalf("result = %s, want only request_id and data", body)
+	}
+}
+
+func TestResultIsSentInTheAcceptedFormat(t *testing.T) {
+	app, srv := startTestGateway(t)
+	srv.Work(t, queueKey, upperCase)
+
+	for accept, prefix := range map[string]string{
+		fiber.MIMEApplicationJSON:       "{",
+		fiber.MIMEApplicationXML:        "<message>",
+		"text/csv, application/*;q=0.5": "{",
+	} {
+		status, body := get(t, app, "/validate?content=hello", fiber.HeaderAccept, accept)
+		if status != fiber.StatusOK || !strings.HasPrefix(string(body), prefix) {
+			t.Errorf("Accept %q: status %d, body %s, want a body starting with %s", accept, status, body, prefix)
+		}
+	}
+}
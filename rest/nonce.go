@@ -0,0 +1,93 @@
+package gateway
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+	"go-async-proxy/metrics"
+)
+
+// --- Double-Submit Protection ---
+
+// A client retrying a submission it got no answer for (a dropped connection, a proxy timeout)
+// would queue the same work twice. Clients that send an X-Nonce of their own make the retry
+// safe: the first submission claims the nonce with SETNX for NONCE_WINDOW, and any later one
+// with the same nonce attaches to the original job instead of queueing a new one, answering
+// with its request_id and result. Nonces are scoped by API key. The original's result is kept
+// under its job key for the window, so retries arriving after it completed still get it.
+
+var nonceWindow = durationTunable("NONCE_WINDOW", 10*time.Minute)
+
+func nonceKey(keyID, nonce string) string {
+	return "validate:nonce:" + keyID + ":" + nonce
+}
+
+// claimNonce records requestId as the job of nonce. When the nonce is already taken, it
+// returns the request_id of the job that took it and claimed is false.
+func claimNonce(keyID, nonce, requestId string) (original string, claimed bool, err error) {
+	key := nonceKey(keyID, nonce)
+	claimed, err = rdb.SetNX(ctx, key, requestId, nonceWindow.Get()).Result()
+	if err != nil || claimed {
+		return requestId, claimed, err
+	}
+	original, err = rdb.Get(ctx, key).Result()
+	if err == redis.Nil {
+		// Expired in between, the next retry claims it
+		return requestId, true, nil
+	}
+	return original, false, err
+}
+
+// releaseNonce frees a nonce whose job never made it to the queue, so a retry submits anew.
+func releaseNonce(keyID, nonce string) {
+	_ = rdb.Del(ctx, nonceKey(keyID, nonce)).Err()
+}
+
+// keepNonceResult stores the result of a job submitted with a nonce for retries to pick up.
+func keepNonceResult(msg *Message) {
+	payload, err := codec.Marshal(msg)
+	if err != nil {
+		return
+	}
+	if err := rdb.Set(ctx, jobKey(msg.RequestID), payload, nonceWindow.Get()).Err(); err != nil {
+		jobLogger(msg).Warn("Cannot keep result for nonce retries", "error", err)
+		return
+	}
+	publishJobChange(msg.RequestID)
+}
+
+// attachToOriginal answers a retried submission with the result of the original job, waiting
+// for it up to WAIT_TIMEOUT on the job change notifications. An original that failed to be
+// queued is answered the way its caller got it.
+func attachToOriginal(c *fiber.Ctx, requestId string) error {
+	metrics.CounterNonceDuplicates.Inc()
+	c.Set("X-Nonce-Replayed", "true")
+	deadline := clock.Now().Add(waitTimeout.Get())
+	state, _ := readJobState(requestId)
+	for {
+		payload, err := rdb.Get(ctx, jobKey(requestId)).Bytes()
+		if err == nil {
+			msg, err := decodeResult(payload)
+			if err != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, "Failed to decode job")
+			}
+			c.Locals(localsMessage, msg)
+			if msg.Data.Chunks != nil {
+				return respondChunked(c, msg, false)
+			}
+			return respondMessage(c, msg)
+		}
+		if state.status == jobStatusFailed {
+			return fiber.NewError(fiber.StatusInternalServerError, "Failed to push to job queue")
+		}
+		// An original whose caller timed out or hung up may still complete as a late result,
+		// so only the retry's own deadline ends the wait
+		remaining := deadline.Sub(clock.Now())
+		if remaining <= 0 {
+			return fiber.NewError(fiber.StatusGatewayTimeout, "Timeout waiting for result")
+		}
+		// The original may not be indexed yet: indexing it is a change as well
+		state, _ = awaitJobChange(requestId, state, remaining)
+	}
+}
@@ -0,0 +1,73 @@
+package gateway
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestRetryIsWokenByTheOriginalsResult(t *testing.T) {
+	app, srv := startTestGateway(t)
+	if err := startJobChangeListener(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := waitTimeout.apply("10s"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _, _ = waitTimeout.apply("") })
+	if _, claimed, err := claimNonce(usageAnonymous, "nonce-1", "req-original"); err != nil || !claimed {
+		t.Fatalf("claimed = %v, err %v", claimed, err)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		keepNonceResult(&Message{RequestID: "req-original", Data: Data{Content: "HELLO", Result: true}})
+	}()
+	status, body := get(t, app, "/validate?content=hello", "X-Nonce", "nonce-1")
+	if status != fiber.StatusOK || !strings.Contains(string(body), "req-original") {
+		t.Fatalf("status = %d, body %s, want the original's result", status, body)
+	}
+	if queued, _ := srv.Client.LLen(ctx, queueKey).Result(); queued != 0 {
+		t.Fatalf("%d jobs queued for a retry", queued)
+	}
+}
+
+func TestRetryOfATimedOutOriginalGetsItsLateResult(t *testing.T) {
+	app, _ := startTestGateway(t)
+	if err := startJobChangeListener(); err != nil {
+		t.Fatal(err)
+	}
+	for tun, raw := range map[*tunable[string]]string{lateResultPolicy: latePolicyStore} {
+		if _, err := tun.apply(raw); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _, _ = tun.apply("") })
+	}
+	if _, err := waitTimeout.apply("10s"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _, _ = waitTimeout.apply("") })
+
+	original := &Message{RequestID: "req-original", Data: Data{Content: "HELLO", Result: true}}
+	pipe := rdb.TxPipeline()
+	indexJob(pipe, original, queueKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+	setJobStatus(original.RequestID, jobStatusTimeout)
+	if _, claimed, err := claimNonce(usageAnonymous, "nonce-1", original.RequestID); err != nil || !claimed {
+		t.Fatalf("claimed = %v, err %v", claimed, err)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		payload, _ := codec.Marshal(original)
+		handleLateResult(original.RequestID, payload)
+	}()
+	status, body := get(t, app, "/validate?content=hello", "X-Nonce", "nonce-1")
+	if status != fiber.StatusOK || !strings.Contains(string(body), "HELLO") {
+		t.Fatalf("status = %d, body %s, want the original's late result", status, body)
+	}
+}
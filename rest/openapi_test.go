@@ -0,0 +1,99 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// routesOnStack returns "METHOD /spec/path" for every route on app's stack, leaving out HEAD,
+// which Fiber adds next to every GET, and the middlewares, which Use mounts with the same
+// handler under every method.
+func routesOnStack(app *fiber.App) []string {
+	stack := app.Stack()
+	mounted := func(r *fiber.Route) string {
+		return fmt.Sprintf("%s %x", r.Path, reflect.ValueOf(r.Handlers[0]).Pointer())
+	}
+	methods := map[string]map[string]bool{}
+	for _, routes := range stack {
+		for _, r := range routes {
+			if methods[mounted(r)] == nil {
+				methods[mounted(r)] = map[string]bool{}
+			}
+			methods[mounted(r)][r.Method] = true
+		}
+	}
+	var routes []string
+	for _, stackRoutes := range stack {
+		for _, r := range stackRoutes {
+			if r.Method == fiber.MethodHead || len(methods[mounted(r)]) == len(stack) {
+				continue
+			}
+			routes = append(routes, r.Method+" "+pathParam.ReplaceAllString(r.Path, "{$1}"))
+		}
+	}
+	slices.Sort(routes)
+	return slices.Compact(routes)
+}
+
+func TestSpecDocumentsEveryRoute(t *testing.T) {
+	app, _ := startTestGateway(t)
+	status, body := get(t, app, "/openapi.json")
+	if status != fiber.StatusOK {
+		t.Fatalf("GET /openapi.json = %d", status)
+	}
+	var spec struct {
+		Paths map[string]map[string]struct {
+			Parameters []struct{ Name, In string }
+		}
+	}
+	if err := json.Unmarshal(body, &spec); err != nil {
+		t.Fatal(err)
+	}
+
+	var documented []string
+	for path, methods := range spec.Paths {
+		for method, op := range methods {
+			documented = append(documented, strings.ToUpper(method)+" "+path)
+			for _, match := range pathParam.FindAllStringSubmatch(strings.NewReplacer("{", ":", "}", "").Replace(path), -1) {
+				if !slices.ContainsFunc(op.Parameters, func(p struct{ Name, In string }) bool { return p.Name == match[1] && p.In == "path" }) {
+					t.Errorf("%s %s does not document its path parameter %q", method, path, match[1])
+				}
+			}
+		}
+	}
+	slices.Sort(documented)
+
+	served := routesOnStack(app)
+	for _, route := range served {
+		if !slices.Contains(documented, route) {
+			t.Errorf("%s is served but missing from the spec", route)
+		}
+	}
+	for _, route := range documented {
+		if !slices.Contains(served, route) {
+			t.Errorf("%s is in the spec but not served", route)
+		}
+	}
+}
+
+func TestDocsServeVendoredSwaggerUI(t *testing.T) {
+	app, _ := startTestGateway(t)
+	status, page := get(t, app, "/docs")
+	if status != fiber.StatusOK || strings.Contains(string(page), "https://") {
+		t.Fatalf("GET /docs = %d, page must load its assets from the service:\n%s", status, page)
+	}
+	for _, asset := range []string{"/docs/assets/swagger-ui.css", "/docs/assets/swagger-ui-bundle.js"} {
+		if !strings.Contains(string(page), asset) {
+			t.Errorf("/docs does not load %s", asset)
+		}
+		if status, body := get(t, app, asset); status != fiber.StatusOK || len(body) == 0 {
+			t.Errorf("GET %s = %d with %d bytes", asset, status, len(body))
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package gateway
+
+import (
+	"encoding/base64"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// --- Payloads ---
+
+// checkEncoding validates content against the requested encoding and reports whether it is a
+// binary payload. Text must be valid UTF-8; binary content travels base64 encoded end to end,
+// so raw bytes never end up in the JSON envelope.
+func checkEncoding(content, encoding string) (bool, error) {
+	switch encoding {
+	case "", "text":
+		if !utf8.ValidString(content) {
+			return false, fiber.NewError(fiber.StatusBadRequest, "'content' is not valid UTF-8, send binary content with encoding=base64")
+		}
+		return false, nil
+	case "base64":
+		if _, err := base64.StdEncoding.DecodeString(content); err != nil {
+			return false, fiber.NewError(fiber.StatusBadRequest, "'content' is not valid base64")
+		}
+		return true, nil
+	default:
+		return false, fiber.NewError(fiber.StatusBadRequest, "'encoding' must be text or base64")
+	}
+}
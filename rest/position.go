@@ -0,0 +1,245 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"go-async-proxy/metrics"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Queue Position ---
+
+// Every push increments a per-queue sequence in the same transaction, so a job's sequence is
+// the number of jobs ever pushed up to and including it, and pushed minus queue length is the
+// number of jobs taken off and not put back. Workers put jobs back at the head of their queue
+// (on drain, and when reaping the bulkhead jobs of a dead worker) without a push, which takes
+// back their dequeue, and restamp the job's sequence with that of the head. Jobs are taken off
+// the head, so a job's position is its sequence minus the jobs taken off. The estimated wait
+// divides the position by the drain rate sampled over queueDrainWindow. Both are approximate:
+// workers pop concurrently. GET /jobs/:id/events streams them as they change, see
+// jobEventsHandler.
+
+var (
+	// queueSampleInterval is how often the drain rate sampler reads the queues.
+	queueSampleInterval = durationTunable("QUEUE_SAMPLE_INTERVAL", 5*time.Second)
+
+	// queueDrainWindow is how far back the drain rate looks.
+	queueDrainWindow = envDuration("QUEUE_DRAIN_WINDOW", time.Minute)
+)
+
+func queueSeqKey(queue string) string {
+	return queue + ":seq"
+}
+
+type drainSample struct {
+	at       time.Time
+	dequeued int64
+}
+
+// drainSamples holds the dequeued totals of each job queue over queueDrainWindow.
+var drainSamples = struct {
+	sync.Mutex
+	byQueue map[string][]drainSample
+}{byQueue: map[string][]drainSample{}}
+
+// startDrainSampler samples the main, canary and tenant queues for their drain rate.
+func startDrainSampler() {
+	go func() {
+		for {
+			time.Sleep(queueSampleInterval.Get())
+			queues := jobQueues()
+			for _, queue := range queues {
+				if err := sampleDrain(queue); err != nil {
+					slog.Debug("Cannot sample queue drain", "queue", queue, "error", err)
+				}
+			}
+			// Forget tenant queues the workers dropped
+			drainSamples.Lock()
+			for queue := range drainSamples.byQueue {
+				if !slices.Contains(queues, queue) {
+					delete(drainSamples.byQueue, queue)
+				}
+			}
+			drainSamples.Unlock()
+			metrics.GaugeQueueDrainRate.Set(drainRate(queueKey))
+		}
+	}()
+}
+
+func sampleDrain(queue string) error {
+	dequeued, err := dequeuedTotal(queue)
+	if err != nil {
+		return err
+	}
+	now := clock.Now()
+	drainSamples.Lock()
+	defer drainSamples.Unlock()
+	samples := append(drainSamples.byQueue[queue], drainSample{at: now, dequeued: dequeued})
+	for len(samples) > 2 && now.Sub(samples[1].at) >= queueDrainWindow {
+		samples = samples[1:]
+	}
+	drainSamples.byQueue[queue] = samples
+	return nil
+}
+
+// dequeuedTotal returns how many jobs were ever taken off the queue.
+func dequeuedTotal(queue string) (int64, error) {
+	ctxTimeout, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	pipe := rdb.Pipeline()
+	pushed := pipe.Get(ctxTimeout, queueSeqKey(queue))
+	length := pipe.LLen(ctxTimeout, queue)
+	if _, err := pipe.Exec(ctxTimeout); err != nil && err != redis.Nil {
+		return 0, err
+	}
+	total, _ := pushed.Int64()
+	return total - length.Val(), nil
+}
+
+// drainRate returns the jobs taken off the queue per second, 0 while unknown.
+func drainRate(queue string) float64 {
+	drainSamples.Lock()
+	defer drainSamples.Unlock()
+	samples := drainSamples.byQueue[queue]
+	if len(samples) < 2 {
+		return 0
+	}
+	first, last := samples[0], samples[len(samples)-1]
+	elapsed := last.at.Sub(first.at).Seconds()
+	if elapsed <= 0 || last.dequeued < first.dequeued {
+		return 0
+	}
+	return float64(last.dequeued-first.dequeued) / elapsed
+}
+
+// queueEstimate is a job's place in its queue: 1 is next, 0 means a worker already took it.
+// EstimatedWait is 0 when the drain rate is unknown.
+type queueEstimate struct {
+	Position      int64
+	EstimatedWait time.Duration
+}
+
+// estimateQueuePosition places the job pushed with sequence seq on queue.
+func estimateQueuePosition(queue string, seq int64) (queueEstimate, error) {
+	dequeued, err := dequeuedTotal(queue)
+	if err != nil {
+		return queueEstimate{}, err
+	}
+	return newQueueEstimate(queue, max(seq-dequeued, 0)), nil
+}
+
+func newQueueEstimate(queue string, position int64) queueEstimate {
+	estimate := queueEstimate{Position: position}
+	if rate := drainRate(queue); rate > 0 {
+		estimate.EstimatedWait = time.Duration(float64(position) / rate * float64(time.Second))
+	}
+	return estimate
+}
+
+// recordQueueSeq keeps the job's queue and sequence in its info hash, for GET /jobs/:id on
+// any replica.
+func recordQueueSeq(msg *Message, queue string, seq int64) {
+	if msg.Synthetic {
+		return
+	}
+	_ = rdb.HSet(ctx, jobInfoKey(msg.RequestID), "queue_key", queue, "queue_seq", seq).Err()
+}
+
+// jobQueueEstimate estimates the position of a pending job from its info hash.
+func jobQueueEstimate(requestId string) (queueEstimate, bool) {
+	fields, err := rdb.HMGet(ctx, jobInfoKey(requestId), "queue_key", "queue_seq").Result()
+	if err != nil || fields[0] == nil || fields[1] == nil {
+		return queueEstimate{}, false
+	}
+	queue, _ := fields[0].(string)
+	seq, err := strconv.ParseInt(fields[1].(string), 10, 64)
+	if err != nil {
+		return queueEstimate{}, false
+	}
+	estimate, err := estimateQueuePosition(queue, seq)
+	return estimate, err == nil
+}
+
+// setQueueHeaders exposes the estimate as X-Queue-Position and X-Estimated-Wait-Ms.
+func setQueueHeaders(c *fiber.Ctx, estimate queueEstimate) {
+	c.Set("X-Queue-Position", strconv.FormatInt(estimate.Position, 10))
+	if estimate.EstimatedWait > 0 {
+		c.Set("X-Estimated-Wait-Ms", strconv.FormatInt(estimate.EstimatedWait.Milliseconds(), 10))
+	}
+}
+
+// addQueueFields adds the estimate to a pending job's JSON body.
+func addQueueFields(body fiber.Map, estimate queueEstimate) fiber.Map {
+	body["queue_position"] = estimate.Position
+	if estimate.EstimatedWait > 0 {
+		body["estimated_wait_ms"] = estimate.EstimatedWait.Milliseconds()
+	}
+	return body
+}
+
+// jobEventsHandler serves GET /jobs/:id/events, a server-sent events stream of the job's
+// progress: a progress event with its status, and its queue_position and estimated_wait_ms
+// while queued, each time they change, then a done event once the job has a result or may no
+// longer get one, for GET /jobs/:id to fetch. The estimate is refreshed every
+// QUEUE_SAMPLE_INTERVAL between job changes.
+func jobEventsHandler(c *fiber.Ctx) error {
+	requestId := c.Params("id")
+	state, known := readJobState(requestId)
+	if !known {
+		return fiber.NewError(fiber.StatusNotFound, "Unknown request_id")
+	}
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		var sent []byte
+		for known {
+			event := fiber.Map{"request_id": requestId, "status": state.status}
+			done := !state.inFlight()
+			if estimate, queued := jobQueueEstimate(requestId); !done && queued && estimate.Position > 0 {
+				addQueueFields(event, estimate)
+			}
+			data, _ := codec.Marshal(event)
+			switch {
+			case done:
+				fmt.Fprintf(w, "event: done\ndata: %s\n\n", data)
+			case !bytes.Equal(data, sent):
+				fmt.Fprintf(w, "event: progress\ndata: %s\n\n", data)
+				sent = data
+			default:
+				// A comment, so a client that hung up is noticed
+				w.WriteString(": unchanged\n\n")
+			}
+			if err := w.Flush(); err != nil || done {
+				return
+			}
+			state, known = awaitJobChange(requestId, state, queueSampleInterval.Get())
+		}
+	})
+	return nil
+}
+
+// depthBucket labels the queue wait histogram with the order of magnitude of the jobs ahead at
+// push, so wait time can be plotted against backlog without a label per depth.
+func depthBucket(depth int64) string {
+	switch {
+	case depth <= 0:
+		return "0"
+	case depth < 10:
+		return "1-9"
+	case depth < 100:
+		return "10-99"
+	case depth < 1000:
+		return "100-999"
+	}
+	return "1000+"
+}
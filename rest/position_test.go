@@ -0,0 +1,65 @@
+package gateway
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestJobEventsFollowTheQueuePositionUntilDone(t *testing.T) {
+	app, srv := startTestGateway(t)
+	if err := startJobChangeListener(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := queueSampleInterval.apply("20ms"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _, _ = queueSampleInterval.apply("") })
+
+	for seq, id := range []string{"req-ahead", "req-followed"} {
+		msg := &Message{RequestID: id}
+		pipe := rdb.TxPipeline()
+		indexJob(pipe, msg, queueKey)
+		pipe.Incr(ctx, queueSeqKey(queueKey))
+		pipe.RPush(ctx, queueKey, id)
+		if _, err := pipe.Exec(ctx); err != nil {
+			t.Fatal(err)
+		}
+		recordQueueSeq(msg, queueKey, int64(seq+1))
+	}
+	go func() {
+		// A worker takes the job ahead, then the followed one and completes it
+		time.Sleep(100 * time.Millisecond)
+		srv.Client.LPop(ctx, queueKey)
+		time.Sleep(100 * time.Millisecond)
+		srv.Client.LPop(ctx, queueKey)
+		payload, _ := codec.Marshal(&Message{RequestID: "req-followed"})
+		srv.Client.Set(ctx, jobKey("req-followed"), payload, time.Minute)
+		setJobStatus("req-followed", jobStatusCompleted)
+	}()
+
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/jobs/req-followed/events", nil), 5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.Header.Get(fiber.HeaderContentType) != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", resp.Header.Get(fiber.HeaderContentType))
+	}
+	events := string(body)
+	second := strings.Index(events, `"queue_position":2`)
+	first := strings.Index(events, `"queue_position":1`)
+	done := strings.Index(events, "event: done\ndata: {\"request_id\":\"req-followed\",\"status\":\"completed\"}")
+	if second < 0 || first < second || done < first || strings.Count(events, "event: done") != 1 {
+		t.Fatalf("events:\n%s\nwant position 2, then 1, then done", events)
+	}
+
+	if status, _ := get(t, app, "/jobs/req-unknown/events"); status != fiber.StatusNotFound {
+		t.Fatalf("unknown job: status %d, want 404", status)
+	}
+}
@@ -0,0 +1,120 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"go-async-proxy/metrics"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Startup Preflight ---
+
+// preflight checks everything the service needs before it takes traffic and reports every
+// problem at once, so a broken deployment fails at boot instead of answering with 500s.
+func preflight() error {
+	var problems []error
+	problems = append(problems, checkConfig()...)
+	if err := waitForRedis(envDuration("PREFLIGHT_REDIS_TIMEOUT", 30*time.Second)); err != nil {
+		return errors.Join(append(problems, err)...)
+	}
+	if err := checkClockSkew(envDuration("PREFLIGHT_MAX_CLOCK_SKEW", time.Second)); err != nil {
+		problems = append(problems, err)
+	}
+	if err := checkKeyTypes(); err != nil {
+		problems = append(problems, err)
+	}
+	return errors.Join(problems...)
+}
+
+func checkConfig() []error {
+	var problems []error
+	if !sort.Float64sAreSorted(metrics.Buckets) {
+		problems = append(problems, errors.New("metrics.Buckets must be in ascending order"))
+	}
+	for _, t := range tunables {
+		if err := t.check(); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	switch replyMode {
+	case replyModeInstance, replyModePubSub, replyModeKey:
+	default:
+		problems = append(problems, fmt.Errorf("REPLY_MODE must be instance, pubsub or key, got %q", replyMode))
+	}
+	if lateResultPolicy.Get() == latePolicyWebhook && lateResultWebhook.Get() == "" {
+		slog.Warn("LATE_RESULT_POLICY=webhook without LATE_RESULT_WEBHOOK: only requests with ?callback= get late results")
+	}
+	return problems
+}
+
+// waitForRedis pings Redis until it answers, giving a Redis started alongside the service
+// time to come up.
+func waitForRedis(timeout time.Duration) error {
+	deadline := clock.Now().Add(timeout)
+	for {
+		ctxTimeout, cancel := context.WithTimeout(ctx, time.Second)
+		err := rdb.Ping(ctxTimeout).Err()
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if clock.Now().After(deadline) {
+			return fmt.Errorf("redis at %s unreachable for %s: %w", rdb.Options().Addr, timeout, err)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// checkClockSkew compares the local clock with Redis' one. Stage durations subtract timestamps
+// taken on different hosts, so skewed clocks make them meaningless.
+func checkClockSkew(maxSkew time.Duration) error {
+	before := clock.Now()
+	redisNow, err := rdb.Time(ctx).Result()
+	if err != nil {
+		return fmt.Errorf("reading redis TIME: %w", err)
+	}
+	local := before.Add(since(before) / 2)
+	skew := local.Sub(redisNow)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > maxSkew {
+		return fmt.Errorf("clock differs from redis by %s (max %s): check NTP on this host", skew.Round(time.Millisecond), maxSkew)
+	}
+	return nil
+}
+
+// checkKeyTypes makes sure the shared keys, when they exist, have the type this service uses,
+// e.g. a queue left behind as a stream by another deployment.
+func checkKeyTypes() error {
+	expected := map[string]string{
+		queueKey:             "list",
+		dlqKey:               "list",
+		journalKey:           "zset",
+		jobsIndexKey:         "zset",
+		slowRequestsKey:      "stream",
+		tenantsKey(queueKey): "set",
+	}
+	pipe := rdb.Pipeline()
+	types := map[string]*redis.StatusCmd{}
+	for key := range expected {
+		types[key] = pipe.Type(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("checking key types: %w", err)
+	}
+
+	var problems []error
+	for key, want := range expected {
+		if got := types[key].Val(); got != "none" && got != want {
+			problems = append(problems, fmt.Errorf("redis key %s is a %s, expected a %s: delete or rename it", key, got, want))
+		}
+	}
+	return errors.Join(problems...)
+}
@@ -0,0 +1,85 @@
+package gateway
+
+import (
+	"errors"
+	"time"
+
+	"go-async-proxy/metrics"
+)
+
+// --- Synthetic Probe ---
+
+// Every PROBE_INTERVAL each replica submits a synthetic job through the whole pipeline (queue,
+// worker, reply path) and exports the outcome and latency, an end-to-end health signal that
+// doesn't depend on user traffic. Probes are left out of the job index and the user metrics.
+
+const (
+	probeOutcomeCompleted = "completed"
+	probeOutcomeFailed    = "failed"
+	probeOutcomeTimeout   = "timeout"
+	probeOutcomeError     = "error"
+)
+
+var (
+	// probeInterval is the time between probes; 0 disables the prober.
+	probeInterval = envDuration("PROBE_INTERVAL", 30*time.Second)
+
+	// probeTimeout is how long a probe waits for its result.
+	probeTimeout = envDuration("PROBE_TIMEOUT", 10*time.Second)
+
+	// probeContent and probeJobType shape the synthetic job, for handlers that need real input.
+	probeContent = envString("PROBE_CONTENT", "probe")
+	probeJobType = envString("PROBE_JOB_TYPE", "")
+)
+
+func startProber() {
+	if probeInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(probeInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			runProbe()
+		}
+	}()
+}
+
+// runProbe submits one synthetic job and records how it went.
+func runProbe() {
+	start := clock.Now()
+	msg := prepareMessage(probeContent, start.UnixNano())
+	msg.Synthetic = true
+	msg.TraceID = newTraceID()
+	msg.Tenant, msg.JobType = "probe", probeJobType
+	msg.Meta.Mark(stageRestRequestPushed)
+
+	outcome := probeOutcomeCompleted
+	reply := dispatcher.register(msg.RequestID)
+	defer dispatcher.cancel(msg.RequestID)
+	result, err := func() (*Message, error) {
+		if _, err := pushToQueue(msg, ""); err != nil {
+			return nil, err
+		}
+		return waitForResult(msg.RequestID, reply, probeTimeout)
+	}()
+	switch {
+	case errors.Is(err, errResponseTimeout):
+		outcome = probeOutcomeTimeout
+	case err != nil:
+		outcome = probeOutcomeError
+	case len(result.Meta.Attempts) > 0 && result.Meta.Attempts[len(result.Meta.Attempts)-1].Error != "":
+		outcome = probeOutcomeFailed
+	}
+
+	metrics.CounterProbes.WithLabelValues(outcome).Inc()
+	if outcome != probeOutcomeCompleted {
+		metrics.GaugeProbeUp.Set(0)
+		jobLogger(msg).Warn("Probe did not complete", "outcome", outcome, "error", err)
+		return
+	}
+	metrics.GaugeProbeUp.Set(1)
+	metrics.GaugeProbeLastSuccess.SetToCurrentTime()
+	metrics.DurationProbeMs.Observe(float64(since(start).Nanoseconds()) / 1_000_000)
+	jobLogger(msg).Debug("Probe completed", "duration", since(start))
+}
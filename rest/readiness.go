@@ -0,0 +1,124 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// --- Readiness ---
+
+// /readyz answers 503 when the pipeline behind this gateway is effectively down, not only when
+// Redis is: too few live workers, a collapsing success rate or Redis over its memory budget
+// take the replica out of the load balancer as well.
+
+var (
+	// readyMinWorkers is the smallest live worker fleet the gateway is ready with.
+	readyMinWorkers = envInt("READY_MIN_WORKERS", 1)
+
+	// readyMinSuccessRate is the lowest share of completed requests over readyWindow.
+	readyMinSuccessRate = envFloat("READY_MIN_SUCCESS_RATE", 0.5)
+
+	// readyMinSamples is how many requests readyWindow needs before its success rate counts.
+	readyMinSamples = envInt("READY_MIN_SAMPLES", 20)
+
+	// readyWindow is how far back the success rate looks.
+	readyWindow = envDuration("READY_WINDOW", time.Minute)
+)
+
+const readyBuckets = 12
+
+// recentOutcomes counts this replica's outcomes in readyBuckets buckets spanning readyWindow.
+var recentOutcomes struct {
+	sync.Mutex
+	buckets [readyBuckets]struct {
+		slot              int64
+		completed, failed int
+	}
+}
+
+func readyBucketSlot(now time.Time) int64 {
+	return now.UnixNano() / max(int64(readyWindow/readyBuckets), 1)
+}
+
+// recordReadiness counts a finished request towards the recent success rate.
+func recordReadiness(outcome string) {
+	slot := readyBucketSlot(clock.Now())
+	recentOutcomes.Lock()
+	defer recentOutcomes.Unlock()
+	bucket := &recentOutcomes.buckets[slot%readyBuckets]
+	if bucket.slot != slot {
+		bucket.slot, bucket.completed, bucket.failed = slot, 0, 0
+	}
+	if outcome == outcomeCompleted {
+		bucket.completed++
+	} else {
+		bucket.failed++
+	}
+}
+
+// recentSuccessRate returns the share of completed requests over readyWindow.
+func recentSuccessRate() (float64, int) {
+	oldest := readyBucketSlot(clock.Now()) - readyBuckets + 1
+	recentOutcomes.Lock()
+	defer recentOutcomes.Unlock()
+	completed, total := 0, 0
+	for _, bucket := range recentOutcomes.buckets {
+		if bucket.slot >= oldest {
+			completed += bucket.completed
+			total += bucket.completed + bucket.failed
+		}
+	}
+	if total == 0 {
+		return 1, 0
+	}
+	return float64(completed) / float64(total), total
+}
+
+type readinessCheck struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+	Value  any    `json:"value,omitempty"`
+}
+
+// readyzHandler serves GET /readyz.
+func readyzHandler(c *fiber.Ctx) error {
+	checks := map[string]readinessCheck{}
+
+	ctxTimeout, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if err := rdb.Ping(ctxTimeout).Err(); err != nil {
+		checks["broker"] = readinessCheck{Detail: err.Error()}
+		// Everything else lives in Redis too
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"status": "not_ready", "checks": checks})
+	}
+	checks["broker"] = readinessCheck{OK: true}
+
+	workers := len(liveInstances("validate:worker:"))
+	checks["workers"] = readinessCheck{OK: workers >= readyMinWorkers, Value: workers,
+		Detail: fmt.Sprintf("live workers, at least %d needed", readyMinWorkers)}
+
+	rate, samples := recentSuccessRate()
+	checks["success_rate"] = readinessCheck{OK: samples < readyMinSamples || rate >= readyMinSuccessRate, Value: rate,
+		Detail: fmt.Sprintf("%d requests over %s, at least %g needed", samples, readyWindow, readyMinSuccessRate)}
+
+	checks["memory"] = readinessCheck{OK: !shedding.Load()}
+	checks["draining"] = readinessCheck{OK: !draining.Load()}
+	// Visible but ready: the gateway keeps answering, with the maintenance message
+	mode := "off"
+	if inMaintenance() {
+		mode = "on"
+	}
+	checks["maintenance"] = readinessCheck{OK: true, Value: mode}
+
+	status, code := "ready", fiber.StatusOK
+	for _, check := range checks {
+		if !check.OK {
+			status, code = "not_ready", fiber.StatusServiceUnavailable
+		}
+	}
+	return c.Status(code).JSON(fiber.Map{"status": status, "checks": checks})
+}
@@ -0,0 +1,163 @@
+package gateway
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"go-async-proxy/metrics"
+)
+
+// --- Redaction ---
+
+// Payloads that carry user data must not end up in plain text where the job doesn't need
+// them. Redaction rewrites the copies the gateway writes elsewhere, never the job itself (the
+// scrub_pii request middleware is the one changing what workers get):
+//
+//	log       the content in the "Handling" log lines
+//	audit     the parameters and query of audit log entries
+//	store     the request and result in the job store (JOB_STORE); replays run on what is stored
+//	fixture   the request and result contents of recorded fixtures (FIXTURE_DIR)
+//	response  results sent to callers, only with the redact result middleware
+//	dlq       dead letters, written by the worker, which reads the same config
+//
+// REDACT_SINKS picks them (default all of them; the redact middleware turns response on).
+// REDACT_FIELDS lists dot separated field paths replaced in JSON payloads, "*" matching any
+// key or array element (e.g. user.email, items.*.card), REDACT_PATTERNS is a JSON array of
+// regular expressions replaced in any payload. Both are replaced with REDACT_MASK and counted
+// in rest_redactions_total{sink}. Without fields or patterns nothing is redacted. Stage
+// exports carry no payload.
+
+const (
+	redactLog      = "log"
+	redactAudit    = "audit"
+	redactStore    = "store"
+	redactFixture  = "fixture"
+	redactResponse = "response"
+	redactDLQ      = "dlq"
+)
+
+// redactSinks are every sink of REDACT_SINKS, the worker's included.
+var redactSinks = []string{redactLog, redactAudit, redactStore, redactFixture, redactResponse, redactDLQ}
+
+var redaction struct {
+	fields   [][]string
+	patterns []*regexp.Regexp
+	sinks    map[string]bool
+	mask     string
+}
+
+func initRedaction() error {
+	for _, path := range strings.Split(envString("REDACT_FIELDS", ""), ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			redaction.fields = append(redaction.fields, strings.Split(path, "."))
+		}
+	}
+	var sources []string
+	if raw := envString("REDACT_PATTERNS", ""); raw != "" {
+		if err := codec.Unmarshal([]byte(raw), &sources); err != nil {
+			return fmt.Errorf("invalid REDACT_PATTERNS: %w", err)
+		}
+	}
+	for _, source := range sources {
+		pattern, err := regexp.Compile(source)
+		if err != nil {
+			return fmt.Errorf("invalid redaction pattern %q: %w", source, err)
+		}
+		redaction.patterns = append(redaction.patterns, pattern)
+	}
+	redaction.sinks = map[string]bool{}
+	for _, sink := range strings.Split(envString("REDACT_SINKS", strings.Join(redactSinks, ",")), ",") {
+		sink = strings.TrimSpace(sink)
+		if sink == "" {
+			continue
+		}
+		if !slices.Contains(redactSinks, sink) {
+			return fmt.Errorf("unknown REDACT_SINKS entry %q", sink)
+		}
+		redaction.sinks[sink] = true
+	}
+	redaction.mask = envString("REDACT_MASK", "[REDACTED]")
+	return nil
+}
+
+// redactionOn reports whether payloads written to sink are redacted.
+func redactionOn(sink string) bool {
+	return redaction.sinks[sink] && (len(redaction.fields) > 0 || len(redaction.patterns) > 0)
+}
+
+// redact returns text with the configured fields and patterns masked, for sink.
+func redact(sink, text string) string {
+	if !redactionOn(sink) || text == "" {
+		return text
+	}
+	count := 0
+	if len(redaction.fields) > 0 && (text[0] == '{' || text[0] == '[') {
+		var doc any
+		if codec.Unmarshal([]byte(text), &doc) == nil {
+			for _, path := range redaction.fields {
+				count += maskField(doc, path)
+			}
+			if count > 0 {
+				if masked, err := codec.Marshal(doc); err == nil {
+					text = string(masked)
+				}
+			}
+		}
+	}
+	for _, pattern := range redaction.patterns {
+		if matches := len(pattern.FindAllStringIndex(text, -1)); matches > 0 {
+			count += matches
+			text = pattern.ReplaceAllLiteralString(text, redaction.mask)
+		}
+	}
+	if count > 0 {
+		metrics.CounterRedactions.WithLabelValues(sink).Add(float64(count))
+	}
+	return text
+}
+
+// maskField replaces the values at path in doc and returns how many it replaced.
+func maskField(doc any, path []string) int {
+	if len(path) == 0 {
+		return 0
+	}
+	count := 0
+	visit := func(key string, value any, set func(any)) {
+		if path[0] != "*" && path[0] != key {
+			return
+		}
+		if len(path) == 1 {
+			set(redaction.mask)
+			count++
+			return
+		}
+		count += maskField(value, path[1:])
+	}
+	switch node := doc.(type) {
+	case map[string]any:
+		for key, value := range node {
+			visit(key, value, func(masked any) { node[key] = masked })
+		}
+	case []any:
+		for i, value := range node {
+			visit(fmt.Sprint(i), value, func(masked any) { node[i] = masked })
+		}
+	}
+	return count
+}
+
+// redactedMessage returns msg, or a copy with its content redacted when sink redacts.
+func redactedMessage(sink string, msg *Message) *Message {
+	if !redactionOn(sink) || msg.Data.Binary {
+		return msg
+	}
+	content := redact(sink, msg.Data.Content)
+	if content == msg.Data.Content {
+		return msg
+	}
+	redacted := *msg
+	redacted.Data.Content = content
+	return &redacted
+}
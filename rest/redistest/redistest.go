@@ -0,0 +1,160 @@
+// Package redistest runs code under test against miniredis, an in-memory Redis, with a fake
+// clock that drives both the timestamps the code takes and the expiry of Redis keys, so
+// pipeline tests are fast and deterministic without a real Redis. Work plays the worker's side
+// of the queue protocol, so gateway tests can run the full flow; the worker has a copy without it.
+package redistest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// Epoch is where every fake clock starts.
+var Epoch = time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// Server is a miniredis instance, a client connected to it and the clock both follow.
+type Server struct {
+	*miniredis.Miniredis
+	Client *redis.Client
+	Clock  *Clock
+}
+
+// Start runs a fresh miniredis for the test and stops it when the test ends.
+func Start(tb testing.TB) *Server {
+	tb.Helper()
+	mr := miniredis.RunT(tb)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	tb.Cleanup(func() { _ = client.Close() })
+
+	clock := &Clock{now: Epoch}
+	mr.SetTime(Epoch)
+	clock.advanced = func(d time.Duration) {
+		// TTLs count down with the clock, TIME and the stream IDs follow it
+		mr.FastForward(d)
+		mr.SetTime(clock.Now())
+	}
+	return &Server{Miniredis: mr, Client: client, Clock: clock}
+}
+
+// Clock is a fake clock that only moves when told to, see Advance.
+type Clock struct {
+	mu       sync.Mutex
+	now      time.Time
+	advanced func(time.Duration)
+}
+
+// Now returns the fake time.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d, expiring the Redis keys whose TTL ran out.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+	if c.advanced != nil {
+		c.advanced(d)
+	}
+}
+
+// Job is a job as Work hands it to its process function: the decoded envelope, whose fields
+// may be changed in place.
+type Job map[string]any
+
+// Content returns data.content.
+func (j Job) Content() string {
+	data, _ := j["data"].(map[string]any)
+	content, _ := data["content"].(string)
+	return content
+}
+
+// SetResult sets data.content and data.result.
+func (j Job) SetResult(content string, result bool) {
+	data, _ := j["data"].(map[string]any)
+	if data == nil {
+		data = map[string]any{}
+		j["data"] = data
+	}
+	data["content"], data["result"] = content, result
+}
+
+// mark appends a stage the way the worker does.
+func (j Job) mark(stage string, at time.Time) {
+	meta, _ := j["meta"].(map[string]any)
+	if meta == nil {
+		meta = map[string]any{}
+		j["meta"] = meta
+	}
+	stages, _ := meta["stages"].([]any)
+	meta["stages"] = append(stages, map[string]any{"name": stage, "ts_ns": at.UnixNano()})
+}
+
+// Work plays a worker until the test ends: it pops the JSON jobs pushed to queue, lets process
+// change them and answers them the way the worker does, on reply_to (a list, or a channel with
+// reply_via pubsub) or on the job's own response key.
+func (s *Server) Work(tb testing.TB, queue string, process func(Job)) {
+	tb.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	// Its own client, closed to cut the blocking pop short when the test ends
+	client := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	done := make(chan struct{})
+	tb.Cleanup(func() {
+		cancel()
+		_ = client.Close()
+		<-done
+	})
+	go func() {
+		defer close(done)
+		for ctx.Err() == nil {
+			popped, err := client.BLPop(ctx, time.Second, queue).Result()
+			if err != nil {
+				if !errors.Is(err, redis.Nil) && ctx.Err() == nil {
+					tb.Errorf("redistest: pop %s: %v", queue, err)
+					return
+				}
+				continue
+			}
+			var job Job
+			if err := json.Unmarshal([]byte(popped[1]), &job); err != nil {
+				tb.Errorf("redistest: invalid job on %s: %v", queue, err)
+				return
+			}
+			job.mark("worker_request_pulled", s.Clock.Now())
+			if process != nil {
+				process(job)
+			}
+			job.mark("worker_response_pushed", s.Clock.Now())
+			if err := s.answer(ctx, job); err != nil && ctx.Err() == nil {
+				tb.Errorf("redistest: answer: %v", err)
+				return
+			}
+		}
+	}()
+}
+
+// answer pushes job where the gateway waits for it.
+func (s *Server) answer(ctx context.Context, job Job) error {
+	payload, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	replyTo, _ := job["reply_to"].(string)
+	if via, _ := job["reply_via"].(string); via == "pubsub" {
+		return s.Client.Publish(ctx, replyTo, payload).Err()
+	}
+	if replyTo == "" {
+		requestID, _ := job["request_id"].(string)
+		replyTo = "validate:response:" + requestID
+	}
+	return s.Client.RPush(ctx, replyTo, payload).Err()
+}
@@ -0,0 +1,159 @@
+package gateway
+
+import (
+	"fmt"
+	"hash/crc32"
+	"log/slog"
+	"maps"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go-async-proxy/metrics"
+)
+
+// --- Config Reload ---
+
+// configKey is the Redis hash of tunable overrides (field = environment key) shared by the fleet.
+const configKey = "validate:config"
+
+// loadOverrides reads the tunable overrides from CONFIG_SOURCE: "redis" (the validate:config
+// hash, the default) or "file" (CONFIG_FILE, a JSON object of environment keys to values).
+func loadOverrides() (map[string]string, error) {
+	switch source := envString("CONFIG_SOURCE", "redis"); source {
+	case "redis":
+		return rdb.HGetAll(ctx, configKey).Result()
+	case "file":
+		raw, err := os.ReadFile(envString("CONFIG_FILE", "config.json"))
+		if err != nil {
+			return nil, err
+		}
+		overrides := map[string]string{}
+		if err := codec.Unmarshal(raw, &overrides); err != nil {
+			return nil, err
+		}
+		return overrides, nil
+	default:
+		return nil, fmt.Errorf("unknown CONFIG_SOURCE %q", source)
+	}
+}
+
+// lastOverrides is what the previous reload applied; unchanged overrides are not re-applied.
+var lastOverrides map[string]string
+
+// reloadConfig applies the overrides to the tunables. A broken override is reported and leaves
+// that tunable unchanged. Keys this service doesn't know are reported too, as they may be typos
+// (or tunables of the other service, the hash is shared).
+func reloadConfig() {
+	overrides, err := loadOverrides()
+	if err != nil {
+		slog.Error("Config reload failed, keeping current config", "error", err)
+		return
+	}
+	if lastOverrides != nil && maps.Equal(overrides, lastOverrides) {
+		return
+	}
+	if lastOverrides != nil {
+		auditConfigChange(lastOverrides, overrides)
+	}
+	lastOverrides = overrides
+
+	for key := range overrides {
+		if _, ok := tunables[key]; !ok {
+			slog.Warn("Ignoring config override: not a tunable", "key", key)
+		}
+	}
+
+	keys := make([]string, 0, len(tunables))
+	for key := range tunables {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var applied strings.Builder
+	for _, key := range keys {
+		t := tunables[key]
+		before := t.String()
+		changed, err := t.apply(overrides[key])
+		if err != nil {
+			slog.Warn("Invalid config override", "error", err)
+		} else if changed {
+			slog.Info("Config changed", "key", key, "from", before, "to", t.String())
+		}
+		fmt.Fprintf(&applied, "%s=%s\n", key, t.String())
+	}
+
+	// Replicas with the same effective config report the same version
+	metrics.GaugeConfigVersion.Set(float64(crc32.ChecksumIEEE([]byte(applied.String()))))
+}
+
+// configRefresh is how often the overrides are read again, 0 for only at startup.
+var configRefresh = envDuration("CONFIG_REFRESH", 30*time.Second)
+
+// auditConfigChange records the overrides that changed from previous, "" for removed ones, in
+// the audit log. Every gateway sees the change within configRefresh; the first one to claim it
+// records it.
+func auditConfigChange(previous, overrides map[string]string) {
+	changes := map[string]string{}
+	for key, value := range overrides {
+		if before, ok := previous[key]; !ok || before != value {
+			changes[key] = value
+		}
+	}
+	for key := range previous {
+		if _, ok := overrides[key]; !ok {
+			changes[key] = ""
+		}
+	}
+	claim := fmt.Sprintf("validate:audit:config:%08x:%08x", overridesDigest(previous), overridesDigest(overrides))
+	if claimed, err := rdb.SetNX(ctx, claim, instanceID, 2*max(configRefresh, time.Minute)).Result(); err != nil || !claimed {
+		return
+	}
+	params, _ := codec.Marshal(changes)
+	source := envString("CONFIG_SOURCE", "redis")
+	path := configKey
+	if source == "file" {
+		path = envString("CONFIG_FILE", "config.json")
+	}
+	appendAudit(map[string]any{
+		"actor":   "config:" + source,
+		"method":  "RELOAD",
+		"path":    path,
+		"params":  redact(redactAudit, string(params)),
+		"status":  fiber.StatusOK,
+		"gateway": instanceID,
+	})
+}
+
+// overridesDigest identifies a set of overrides.
+func overridesDigest(overrides map[string]string) uint32 {
+	keys := make([]string, 0, len(overrides))
+	for key := range overrides {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	h := crc32.NewIEEE()
+	for _, key := range keys {
+		fmt.Fprintf(h, "%s=%s\n", key, overrides[key])
+	}
+	return h.Sum32()
+}
+
+// startConfigReloader applies the overrides and flag rollouts now and then every CONFIG_REFRESH (0 disables).
+func startConfigReloader() {
+	reloadConfig()
+	reloadFlags()
+	loadMaintenance()
+	if configRefresh <= 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(configRefresh) {
+			reloadConfig()
+			reloadFlags()
+			loadMaintenance()
+		}
+	}()
+}
@@ -0,0 +1,174 @@
+package gateway
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/redis/go-redis/v9"
+	"go-async-proxy/metrics"
+)
+
+// --- Cross-Region Replication ---
+
+// With REPLICA_REDIS_ADDR set, the gateway also talks to a secondary region's Redis, where that
+// region's workers consume the same queues. REPLICA_MODE "active" mirrors every job there
+// (active-active), "standby" only pushes there when the primary push fails (warm standby).
+// Workers answer on the Redis they pulled from, so the gateway listens for replies on both and
+// the first result of a job wins; the other one is dropped.
+//
+// The secondary Redis is authenticated like the primary, as REPLICA_REDIS_USERNAME with the
+// REPLICA_REDIS_PASSWORD secret, each falling back to the primary's.
+//
+// Replication needs the instance or pubsub reply mode: in key mode every request blocks on a
+// single response key, which lives in one region only.
+
+const (
+	replicaModeActive  = "active"
+	replicaModeStandby = "standby"
+
+	regionPrimary = "primary"
+	regionReplica = "replica"
+)
+
+var (
+	// replicaRdb is the secondary region's Redis, nil when replication is off.
+	replicaRdb *redis.Client
+
+	replicaMode = stringTunable("REPLICA_MODE", replicaModeActive, replicaModeActive, replicaModeStandby)
+)
+
+// resultClaimKey is set by the first result of a replicated job, so the other region's result
+// is recognised as a duplicate, even when both arrive after the caller gave up.
+func resultClaimKey(requestId string) string {
+	return "validate:result:claim:" + requestId
+}
+
+func initReplica() error {
+	addr := envString("REPLICA_REDIS_ADDR", "")
+	if addr == "" {
+		return nil
+	}
+	if replyMode == replyModeKey {
+		return fmt.Errorf("REPLICA_REDIS_ADDR needs REPLY_MODE %s or %s", replyModeInstance, replyModePubSub)
+	}
+	replicaRdb = redis.NewClient(&redis.Options{
+		Addr:     addr,
+		PoolSize: 80,
+		// Asked on every new connection, so a rotated password is picked up
+		CredentialsProvider: func() (string, string) {
+			return envString("REPLICA_REDIS_USERNAME", envString("REDIS_USERNAME", "")), secret("REPLICA_REDIS_PASSWORD", secret("REDIS_PASSWORD", ""))
+		},
+	})
+	if err := replicaRdb.Ping(ctx).Err(); err != nil {
+		// The secondary region may come up later, mirroring retries with every job
+		slog.Warn("Replica redis unreachable", "addr", addr, "error", err)
+	}
+	slog.Info("Replicating jobs", "addr", addr, "mode", replicaMode.Get())
+	return nil
+}
+
+// replicateJob queues a copy of msg on the secondary region's queue, along with its uploaded
+// file, which the secondary workers can only read from their own Redis.
+func replicateJob(msg *Message) error {
+	payload, err := codec.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	pipe := replicaRdb.TxPipeline()
+	if msg.Data.File != nil {
+		content, err := rdb.Get(ctx, msg.Data.File.Key).Bytes()
+		if err != nil {
+			return fmt.Errorf("cannot read file: %w", err)
+		}
+		pipe.Set(ctx, msg.Data.File.Key, content, waitTimeout.Get()+jobResultTTL.Get())
+	}
+	base := baseQueueFor(msg)
+	queue := jobQueueFor(msg)
+	if msg.Tenant != "" && queue == tenantQueueKey(base, msg.Tenant) {
+		pipe.SAdd(ctx, tenantsKey(base), msg.Tenant)
+	}
+	pipe.RPush(ctx, queue, payload)
+	if _, err := pipe.Exec(ctx); err != nil {
+		metrics.CounterReplicationFailures.Inc()
+		return err
+	}
+	metrics.CounterReplicatedJobs.WithLabelValues(replicaMode.Get()).Inc()
+	return nil
+}
+
+// mirrorJob replicates a job the primary accepted, in active mode. The primary already has the
+// job, so a failure only costs the redundancy.
+func mirrorJob(msg *Message) {
+	// Jobs pinned to a data region never leave it
+	if replicaRdb == nil || replicaMode.Get() != replicaModeActive || msg.Region != "" {
+		return
+	}
+	if err := replicateJob(msg); err != nil {
+		jobLogger(msg).Warn("Cannot replicate job", "error", err)
+	}
+}
+
+// failoverJob replicates a job the primary could not take, in standby mode. It returns false
+// when the job reached no region.
+func failoverJob(msg *Message) bool {
+	if replicaRdb == nil || replicaMode.Get() != replicaModeStandby || msg.Region != "" {
+		return false
+	}
+	if err := replicateJob(msg); err != nil {
+		jobLogger(msg).Error("Cannot fail over job", "error", err)
+		return false
+	}
+	jobLogger(msg).Warn("Job failed over to the replica region")
+	return true
+}
+
+// claimResult reports whether msg is the first result of its job. Without replication every
+// result is the only one.
+func claimResult(source *redis.Client, msg *Message) bool {
+	if replicaRdb == nil {
+		return true
+	}
+	region := regionOf(source)
+	claimed, err := rdb.SetNX(ctx, resultClaimKey(msg.RequestID), region, waitTimeout.Get()+jobResultTTL.Get()).Result()
+	if err != nil {
+		// Without the primary nobody can tell; a duplicate beats a lost result
+		return true
+	}
+	if !claimed {
+		metrics.CounterReplicaDuplicates.WithLabelValues(region).Inc()
+		if msg.Data.Chunks != nil {
+			_ = source.Del(ctx, chunkKeys(msg.Data.Chunks)...)
+		}
+		return false
+	}
+	metrics.CounterReplicaResults.WithLabelValues(region).Inc()
+	return true
+}
+
+func regionOf(client *redis.Client) string {
+	if client == replicaRdb {
+		return regionReplica
+	}
+	return regionPrimary
+}
+
+// adoptChunks copies the chunks of a result the secondary region produced into the primary,
+// where the rest of the gateway reads them.
+func adoptChunks(source *redis.Client, ref *ChunkRef) error {
+	if source == rdb || ref == nil {
+		return nil
+	}
+	pipe := rdb.Pipeline()
+	for n, key := range chunkKeys(ref) {
+		chunk, err := source.Get(ctx, key).Bytes()
+		if err != nil {
+			return fmt.Errorf("chunk %d: %w", n, err)
+		}
+		pipe.Set(ctx, key, chunk, jobResultTTL.Get())
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	_ = source.Del(ctx, chunkKeys(ref)...)
+	return nil
+}
@@ -0,0 +1,67 @@
+package gateway
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestReplicaAuthenticatesLikeThePrimary(t *testing.T) {
+	prevRdb, prevReplyMode := replicaRdb, replyMode
+	replyMode = replyModeInstance
+	t.Cleanup(func() { replicaRdb, replyMode = prevRdb, prevReplyMode })
+
+	for _, tc := range []struct {
+		name, replicaPassword, want string
+	}{
+		{"primary's password", "", "primary-secret"},
+		{"own password", "replica-secret", "replica-secret"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			replica := miniredis.RunT(t)
+			replica.RequireUserAuth("gateway", tc.want)
+			t.Setenv("REPLICA_REDIS_ADDR", replica.Addr())
+			t.Setenv("REDIS_USERNAME", "gateway")
+			t.Setenv("REDIS_PASSWORD", "primary-secret")
+			t.Setenv("REPLICA_REDIS_PASSWORD", tc.replicaPassword)
+
+			if err := initReplica(); err != nil {
+				t.Fatal(err)
+			}
+			defer replicaRdb.Close()
+			if err := replicaRdb.Ping(ctx).Err(); err != nil {
+				t.Fatalf("replica refused the gateway: %v", err)
+			}
+		})
+	}
+}
+
+func TestReplicaPasswordComesFromTheSecretsProvider(t *testing.T) {
+	prevRdb, prevReplyMode, prevProvider := replicaRdb, replyMode, secrets.provider
+	replyMode = replyModeInstance
+	dir := t.TempDir()
+	secrets.provider = fileSecrets{dir: dir}
+	t.Cleanup(func() {
+		replicaRdb, replyMode, secrets.provider = prevRdb, prevReplyMode, prevProvider
+		secrets.values = map[string]string{}
+	})
+	if err := os.WriteFile(filepath.Join(dir, "REPLICA_REDIS_PASSWORD"), []byte("mounted-secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := refreshSecrets(); err != nil {
+		t.Fatal(err)
+	}
+
+	replica := miniredis.RunT(t)
+	replica.RequireAuth("mounted-secret")
+	t.Setenv("REPLICA_REDIS_ADDR", replica.Addr())
+	if err := initReplica(); err != nil {
+		t.Fatal(err)
+	}
+	defer replicaRdb.Close()
+	if err := replicaRdb.Ping(ctx).Err(); err != nil {
+		t.Fatalf("replica refused the mounted password: %v", err)
+	}
+}
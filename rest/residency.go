@@ -0,0 +1,135 @@
+package gateway
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go-async-proxy/metrics"
+)
+
+// --- Data Residency ---
+
+// A job whose data must stay in a region names it with X-Data-Region, or inherits it from
+// its tenant through TENANT_REGIONS (a JSON object of tenant to region). It is pushed to
+// <queue>:region:<region>, the queue of the workers started with WORKER_REGION=<region>,
+// which consume nothing else, and it is never replicated to the secondary region's Redis.
+// DATA_REGIONS, when set, lists the regions requests may name (comma separated). Submissions
+// are refused with 503 while no live, undrained worker of the region sends heartbeats: the region's
+// workers are read from the heartbeats every RESIDENCY_REFRESH_INTERVAL.
+
+var (
+	// dataRegions are the regions jobs may be pinned to, empty for any.
+	dataRegions []string
+
+	// tenantRegions pins every job of a tenant to a region.
+	tenantRegions = map[string]string{}
+
+	residencyRefreshInterval = envDuration("RESIDENCY_REFRESH_INTERVAL", 5*time.Second)
+)
+
+func regionQueueKey(queue, region string) string {
+	return queue + ":region:" + region
+}
+
+// liveRegions are the regions with at least one live worker, from their heartbeats.
+var liveRegions = struct {
+	sync.RWMutex
+	regions map[string]int
+}{regions: map[string]int{}}
+
+func initResidency() error {
+	for _, region := range strings.Split(envString("DATA_REGIONS", ""), ",") {
+		if region = strings.TrimSpace(region); region != "" {
+			dataRegions = append(dataRegions, region)
+		}
+	}
+	if raw := envString("TENANT_REGIONS", ""); raw != "" {
+		if err := codec.Unmarshal([]byte(raw), &tenantRegions); err != nil {
+			return fmt.Errorf("invalid TENANT_REGIONS: %w", err)
+		}
+	}
+	for tenant, region := range tenantRegions {
+		if len(dataRegions) > 0 && !slices.Contains(dataRegions, region) {
+			return fmt.Errorf("TENANT_REGIONS pins %q to %q, which is not in DATA_REGIONS", tenant, region)
+		}
+	}
+	return nil
+}
+
+// startResidencySampler keeps liveRegions current.
+func startResidencySampler() {
+	go func() {
+		for {
+			sampleLiveRegions()
+			time.Sleep(residencyRefreshInterval)
+		}
+	}()
+}
+
+func sampleLiveRegions() {
+	ids := liveInstances("validate:worker:")
+	if len(ids) == 0 {
+		liveRegions.Lock()
+		liveRegions.regions = map[string]int{}
+		liveRegions.Unlock()
+		return
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = "validate:worker:" + id
+	}
+	values, err := rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		slog.Debug("Cannot read worker heartbeats", "error", err)
+		return
+	}
+	regions := map[string]int{}
+	for _, value := range values {
+		raw, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var beat struct {
+			Region   string `json:"region"`
+			Draining bool   `json:"draining"`
+		}
+		if codec.Unmarshal([]byte(raw), &beat) == nil && beat.Region != "" && !beat.Draining {
+			regions[beat.Region]++
+		}
+	}
+	liveRegions.Lock()
+	liveRegions.regions = regions
+	liveRegions.Unlock()
+}
+
+// applyResidency pins msg to the region its request or tenant requires, refusing it when the
+// region is not allowed or has no live worker.
+func applyResidency(c *fiber.Ctx, msg *Message) error {
+	region := c.Get("X-Data-Region")
+	if pinned, ok := tenantRegions[msg.Tenant]; ok {
+		if region != "" && region != pinned {
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Tenant data must stay in %s", pinned))
+		}
+		region = pinned
+	}
+	if region == "" {
+		return nil
+	}
+	if len(dataRegions) > 0 && !slices.Contains(dataRegions, region) {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Unknown data region %q", region))
+	}
+	liveRegions.RLock()
+	workers := liveRegions.regions[region]
+	liveRegions.RUnlock()
+	if workers == 0 {
+		metrics.CounterResidencyRejections.WithLabelValues(region).Inc()
+		return fiber.NewError(fiber.StatusServiceUnavailable, fmt.Sprintf("No worker in data region %s", region))
+	}
+	msg.Region = region
+	return nil
+}
@@ -0,0 +1,37 @@
+package gateway
+
+import (
+	"go-async-proxy/metrics"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Traffic Shadowing ---
+
+// shadowFlag is the feature flag whose rollout is the share of requests copied to the shadow
+// queue, e.g. FLAGS=shadow=5 or HSET validate:flags shadow 5.
+const shadowFlag = "shadow"
+
+var (
+	// shadowQueueKey is consumed by the workers under test (QUEUE_KEY on the worker).
+	shadowQueueKey = envString("SHADOW_QUEUE", "validate:queue:shadow")
+
+	// shadowMaxLength bounds the shadow queue, so shadowing without shadow workers can't fill Redis.
+	shadowMaxLength = envInt("SHADOW_MAX_LENGTH", 10000)
+)
+
+// shadowJob queues a copy of msg on the shadow queue as part of pipe. The copy carries no reply
+// address and is marked shadow, so the worker drops its result.
+func shadowJob(pipe redis.Pipeliner, msg *Message) error {
+	shadow := *msg
+	shadow.ReplyTo, shadow.ReplyVia = "", ""
+	shadow.Shadow = true
+	payload, err := codec.Marshal(&shadow)
+	if err != nil {
+		return err
+	}
+	pipe.RPush(ctx, shadowQueueKey, payload)
+	pipe.LTrim(ctx, shadowQueueKey, int64(-shadowMaxLength), -1)
+	metrics.CounterShadowed.Inc()
+	return nil
+}
@@ -0,0 +1,181 @@
+package gateway
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go-async-proxy/metrics"
+)
+
+// --- Pipeline Stages ---
+
+// StageEvent is one timestamped step of a message's trip through the pipeline. Events are kept
+// in the order they happened, so a new stage only has to be marked where it happens.
+type StageEvent struct {
+	Name string `json:"name" xml:"name,attr"`
+	TsNs int64  `json:"ts_ns" xml:"ts_ns,attr"`
+}
+
+const (
+	stageRestRequestReceived  = "rest_request_received"
+	stageRestRequestEnriched  = "rest_request_enriched"
+	stageRestRequestPushed    = "rest_request_pushed"
+	stageWorkerRequestPulled  = "worker_request_pulled"
+	stageWorkerResponsePushed = "worker_response_pushed"
+	stageRestResponsePulled   = "rest_response_pulled"
+)
+
+// MarkAt records the stage name at tsNs.
+func (m *Meta) MarkAt(name string, tsNs int64) {
+	m.Stages = append(m.Stages, StageEvent{Name: name, TsNs: tsNs})
+}
+
+// Mark records the stage name now.
+func (m *Meta) Mark(name string) {
+	m.MarkAt(name, nowNs())
+}
+
+// At returns the timestamp of the last event of the stage name, or 0 when it never happened.
+func (m *Meta) At(name string) int64 {
+	for i := len(m.Stages) - 1; i >= 0; i-- {
+		if m.Stages[i].Name == name {
+			return m.Stages[i].TsNs
+		}
+	}
+	return 0
+}
+
+// Reasons a stage duration is left out of the histograms.
+const (
+	anomalyNegative     = "negative"
+	anomalyImplausible  = "implausible"
+	anomalyMissingStage = "missing_stage"
+)
+
+// maxPlausibleDuration is the longest a single stage may take before its timestamps are
+// assumed broken (e.g. a worker clock far off) rather than the stage slow.
+var maxPlausibleDuration = durationTunable("MAX_PLAUSIBLE_DURATION", 10*time.Minute)
+
+// legacyStageTimes are the per-stage timestamps Meta carried before Stages. They are still
+// written next to Stages, and read when Stages is missing, so a gateway and a worker on either
+// side of that change understand each other during a rolling deploy.
+type legacyStageTimes struct {
+	RestRequestReceived  int64 `json:"rest_request_received_ns,omitempty"`
+	RestRequestEnriched  int64 `json:"rest_request_enriched_ns,omitempty"`
+	RestRequestPushed    int64 `json:"rest_request_pushed_ns,omitempty"`
+	WorkerRequestPulled  int64 `json:"worker_request_pulled_ns,omitempty"`
+	WorkerResponsePushed int64 `json:"worker_response_pushed_ns,omitempty"`
+	RestResponsePulled   int64 `json:"rest_response_pulled_ns,omitempty"`
+}
+
+// legacyStages are the stages legacyStageTimes has a field for, in pipeline order.
+var legacyStages = []string{
+	stageRestRequestReceived,
+	stageRestRequestEnriched,
+	stageRestRequestPushed,
+	stageWorkerRequestPulled,
+	stageWorkerResponsePushed,
+	stageRestResponsePulled,
+}
+
+// fields returns a pointer to the field of every stage in legacyStages, in the same order.
+func (l *legacyStageTimes) fields() []*int64 {
+	return []*int64{
+		&l.RestRequestReceived,
+		&l.RestRequestEnriched,
+		&l.RestRequestPushed,
+		&l.WorkerRequestPulled,
+		&l.WorkerResponsePushed,
+		&l.RestResponsePulled,
+	}
+}
+
+// metaFields is Meta without its methods, so (un)marshaling it does not recurse.
+type metaFields Meta
+
+// MarshalJSON writes Meta with the legacy per-stage timestamps next to Stages.
+func (m Meta) MarshalJSON() ([]byte, error) {
+	var legacy legacyStageTimes
+	for i, ts := range legacy.fields() {
+		*ts = m.At(legacyStages[i])
+	}
+	return codec.Marshal(struct {
+		metaFields
+		legacyStageTimes
+	}{metaFields(m), legacy})
+}
+
+// UnmarshalJSON reads Meta, taking the stages from the legacy per-stage timestamps when the
+// sender did not write Stages.
+func (m *Meta) UnmarshalJSON(data []byte) error {
+	var v struct {
+		metaFields
+		legacyStageTimes
+	}
+	if err := codec.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*m = Meta(v.metaFields)
+	if len(m.Stages) == 0 {
+		for i, ts := range v.legacyStageTimes.fields() {
+			if *ts != 0 {
+				m.Stages = append(m.Stages, StageEvent{Name: legacyStages[i], TsNs: *ts})
+			}
+		}
+	}
+	return nil
+}
+
+// stageDuration returns the milliseconds from fromNs to toNs. Durations that can't be trusted
+// (a missing or garbled stage, clock skew between hosts putting a stage before the previous one, or an
+// implausibly long gap) are counted in meta_anomalies_total and reported as not ok, so they
+// stay out of the histograms.
+func stageDuration(fromNs, toNs int64) (float64, bool) {
+	reason := ""
+	switch {
+	case fromNs <= 0 || toNs <= 0:
+		reason = anomalyMissingStage
+	case toNs < fromNs:
+		reason = anomalyNegative
+	case time.Duration(toNs-fromNs) > maxPlausibleDuration.Get():
+		reason = anomalyImplausible
+	}
+	if reason != "" {
+		metrics.CounterMetaAnomalies.WithLabelValues(reason).Inc()
+		return 0, false
+	}
+	return float64(toNs-fromNs) / 1_000_000, true
+}
+
+// observeStages feeds the duration between every pair of consecutive events into the generic
+// stage histogram, labelled with the message's cohort, so stages added later show up without
+// new metrics and canary workers can be compared with stable ones.
+func observeStages(msg *Message) {
+	cohort := cohortOf(msg)
+	stages := msg.Meta.Stages
+	for i := 1; i < len(stages); i++ {
+		from, to := stages[i-1], stages[i]
+		if ms, ok := stageDuration(from.TsNs, to.TsNs); ok {
+			metrics.DurationStageMs.WithLabelValues(from.Name, to.Name, cohort).Observe(ms)
+		}
+	}
+}
+
+// setServerTiming reports the budget stages msg went through (gateway, queue_wait, processing,
+// reply and total) in the Server-Timing header, which browser devtools and APM agents display
+// on their own. Stages missing a timestamp or running backwards are left out.
+func setServerTiming(c *fiber.Ctx, msg *Message) {
+	var timings []string
+	for _, stage := range budgetStages {
+		from, to := msg.Meta.At(stage.from), msg.Meta.At(stage.to)
+		if from == 0 || to < from {
+			continue
+		}
+		timings = append(timings, fmt.Sprintf("%s;dur=%.3f", stage.name, float64(to-from)/1_000_000))
+	}
+	if len(timings) > 0 {
+		c.Set("Server-Timing", strings.Join(timings, ", "))
+	}
+}
@@ -0,0 +1,124 @@
+package gateway
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go-async-proxy/metrics"
+)
+
+func TestStageDurationIsNeverNegative(t *testing.T) {
+	property := func(from int64, deltaUs int32, missing uint8) bool {
+		to := from + int64(deltaUs)*1000
+		if missing&1 != 0 {
+			from = 0
+		}
+		if missing&2 != 0 {
+			to = 0
+		}
+		ms, ok := stageDuration(from, to)
+		if !ok {
+			return ms == 0
+		}
+		return ms >= 0 && ms <= float64(maxPlausibleDuration.Get())/1e6
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 10000}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// timeline is the stamps of the stages a gateway reads back from a worker: received, pushed,
+// pulled and responded, as offsets from the gateway's now. Each stage lands a little after
+// the previous one, sometimes before it (clock skew between hosts), sometimes implausibly
+// late, and sometimes never happened.
+type timeline struct {
+	offsets [4]time.Duration
+	missing [4]bool
+}
+
+func (timeline) Generate(r *rand.Rand, _ int) reflect.Value {
+	var tl timeline
+	at := -time.Duration(r.Int63n(int64(time.Minute)))
+	for i := range tl.offsets {
+		if i > 0 {
+			switch r.Intn(10) {
+			case 0:
+				at -= time.Duration(r.Int63n(int64(2 * time.Second)))
+			case 1:
+				at += 20 * time.Minute
+			default:
+				at += time.Duration(r.Int63n(int64(5 * time.Second)))
+			}
+		}
+		tl.offsets[i] = at
+		tl.missing[i] = r.Intn(8) == 0
+	}
+	return reflect.ValueOf(tl)
+}
+
+// message returns a reply carrying tl's stamps, now being the gateway's clock.
+func (tl timeline) message(now int64) *Message {
+	msg := &Message{RequestID: "req-1"}
+	for i, stage := range []string{stageRestRequestReceived, stageRestRequestPushed, stageWorkerRequestPulled, stageWorkerResponsePushed} {
+		if !tl.missing[i] {
+			msg.Meta.MarkAt(stage, now+int64(tl.offsets[i]))
+		}
+	}
+	return msg
+}
+
+func TestFinalizeResultDurationsAddUp(t *testing.T) {
+	startTestGateway(t)
+	property := func(tl timeline) bool {
+		stageSamples.Lock()
+		stageSamples.values = map[string][]float64{}
+		stageSamples.Unlock()
+
+		msg := finalizeResult(tl.message(clock.Now().UnixNano()))
+		if msg.Meta.RoundtripDurationNs < 0 {
+			return false
+		}
+
+		stageSamples.Lock()
+		defer stageSamples.Unlock()
+		roundtrip := stageSamples.values["roundtrip"]
+		if len(roundtrip) == 0 {
+			// Some duration couldn't be trusted, so no stage was sampled
+			return len(stageSamples.values) == 0
+		}
+		sum := 0.0
+		for _, name := range stageNames[:len(stageNames)-1] {
+			ms := stageSamples.values[name][0]
+			if ms < 0 {
+				return false
+			}
+			sum += ms
+		}
+		return roundtrip[0] >= 0 && math.Abs(sum-roundtrip[0]) <= 1e-6*math.Max(1, roundtrip[0])
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 500}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFailedResultIsNotCountedAsSuccess(t *testing.T) {
+	startTestGateway(t)
+	before := testutil.ToFloat64(metrics.CounterSuccess)
+
+	failed := &Message{RequestID: "req-1"}
+	failed.Meta.Attempts = []Attempt{{Error: "boom"}}
+	finalizeResult(failed)
+	if after := testutil.ToFloat64(metrics.CounterSuccess); after != before {
+		t.Fatalf("rest_success_total went from %v to %v for a failed result", before, after)
+	}
+
+	finalizeResult(&Message{RequestID: "req-2"})
+	if after := testutil.ToFloat64(metrics.CounterSuccess); after != before+1 {
+		t.Fatalf("rest_success_total went from %v to %v for a completed result", before, after)
+	}
+}
@@ -0,0 +1,221 @@
+package gateway
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go-async-proxy/metrics"
+)
+
+// --- Durable Job Store ---
+
+// Jobs and results can additionally be written to Postgres for long-term querying, reporting
+// and replay. Writes are queued and flushed in batches off the request path; Redis stays the
+// hot path, and a full buffer drops store writes rather than slowing down requests.
+
+var (
+	// jobStoreKind is "" (disabled) or "postgres".
+	jobStoreKind = envString("JOB_STORE", "")
+
+	jobStoreBatch  = envInt("JOB_STORE_BATCH", 100)
+	jobStoreFlush  = envDuration("JOB_STORE_FLUSH", time.Second)
+	jobStoreBuffer = envInt("JOB_STORE_BUFFER", 10000)
+)
+
+const createJobsTable = `
+CREATE TABLE IF NOT EXISTS jobs (
+	request_id  TEXT PRIMARY KEY,
+	tenant      TEXT NOT NULL DEFAULT '',
+	job_type    TEXT NOT NULL DEFAULT '',
+	status      TEXT NOT NULL,
+	request     JSONB,
+	result      JSONB,
+	received_at TIMESTAMPTZ NOT NULL,
+	updated_at  TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS jobs_received_at ON jobs (received_at);
+CREATE INDEX IF NOT EXISTS jobs_tenant_received_at ON jobs (tenant, received_at);
+`
+
+// upsertJob keeps the first request and the latest result seen for a job.
+const upsertJob = `
+INSERT INTO jobs (request_id, tenant, job_type, status, request, result, received_at, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (request_id) DO UPDATE SET
+	status     = EXCLUDED.status,
+	request    = COALESCE(jobs.request, EXCLUDED.request),
+	result     = COALESCE(EXCLUDED.result, jobs.result),
+	updated_at = EXCLUDED.updated_at
+`
+
+type storedJob struct {
+	requestId, tenant, jobType, status string
+	request, result                    []byte
+	receivedAt, updatedAt              time.Time
+}
+
+var jobStore struct {
+	db      *sql.DB
+	records chan storedJob
+}
+
+// initJobStore connects to the store picked by JOB_STORE and creates its schema.
+func initJobStore() error {
+	switch jobStoreKind {
+	case "":
+		return nil
+	case "postgres":
+	default:
+		return fmt.Errorf("JOB_STORE must be empty or postgres, got %q", jobStoreKind)
+	}
+	if postgresDriver == "" {
+		return errors.New("the postgres job store needs a gateway built with -tags postgres")
+	}
+
+	db, err := sql.Open(postgresDriver, secret("JOB_STORE_DSN", "postgres://postgres@postgres:5432/validate?sslmode=disable"))
+	if err != nil {
+		return err
+	}
+	ctxTimeout, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if _, err := db.ExecContext(ctxTimeout, createJobsTable); err != nil {
+		db.Close()
+		return err
+	}
+
+	jobStore.db = db
+	jobStore.records = make(chan storedJob, jobStoreBuffer)
+	go runJobStore()
+	return nil
+}
+
+// storeJob queues a job for the store. msg is the request while the job is pending, failed
+// or timed out, and the result once it completed or arrived late.
+func storeJob(msg *Message, status string) {
+	if jobStore.db == nil {
+		return
+	}
+	payload, err := codec.Marshal(redactedMessage(redactStore, msg))
+	if err != nil {
+		return
+	}
+	record := storedJob{
+		requestId:  msg.RequestID,
+		tenant:     msg.Tenant,
+		jobType:    msg.JobType,
+		status:     status,
+		receivedAt: time.Unix(0, msg.Meta.At(stageRestRequestReceived)),
+		updatedAt:  clock.Now(),
+	}
+	if status == jobStatusCompleted || status == jobStatusLate {
+		record.result = payload
+	} else {
+		record.request = payload
+	}
+
+	select {
+	case jobStore.records <- record:
+	default:
+		metrics.CounterJobStoreDropped.Inc()
+	}
+}
+
+func runJobStore() {
+	ticker := time.NewTicker(jobStoreFlush)
+	defer ticker.Stop()
+
+	batch := make([]storedJob, 0, jobStoreBatch)
+	for {
+		select {
+		case record := <-jobStore.records:
+			batch = append(batch, record)
+			if len(batch) < jobStoreBatch {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := flushJobs(batch); err != nil {
+			metrics.CounterJobStoreErrors.Inc()
+			slog.Error("Cannot write jobs to the store", "jobs", len(batch), "error", err)
+		}
+		batch = batch[:0]
+	}
+}
+
+// flushJobs writes a batch in one transaction.
+func flushJobs(batch []storedJob) error {
+	ctxTimeout, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	tx, err := jobStore.db.BeginTx(ctxTimeout, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctxTimeout, upsertJob)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, record := range batch {
+		if _, err := stmt.ExecContext(ctxTimeout, record.requestId, record.tenant, record.jobType, record.status,
+			jsonColumn(record.request), jsonColumn(record.result), record.receivedAt, record.updatedAt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// jsonColumn passes a payload as JSON text, or NULL when there is none.
+func jsonColumn(payload []byte) any {
+	if payload == nil {
+		return nil
+	}
+	return string(payload)
+}
+
+// --- Replay Handler ---
+
+// replayJobHandler serves POST /jobs/:id/replay: the stored request runs again as a new job,
+// with the same tenant, type and content, and the result is returned like /validate's.
+func replayJobHandler(c *fiber.Ctx) error {
+	if jobStore.db == nil {
+		return fiber.NewError(fiber.StatusNotImplemented, "No job store configured")
+	}
+	if inMaintenance() {
+		return respondMaintenance(c)
+	}
+	if err := rejectWhenShedding(c); err != nil {
+		return err
+	}
+
+	var request []byte
+	err := jobStore.db.QueryRowContext(c.Context(), `SELECT request FROM jobs WHERE request_id = $1`, c.Params("id")).Scan(&request)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && request == nil) {
+		return fiber.NewError(fiber.StatusNotFound, "Unknown request_id")
+	}
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to read job")
+	}
+	var original Message
+	if err := codec.Unmarshal(request, &original); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to decode job")
+	}
+	if original.Data.File != nil {
+		return fiber.NewError(fiber.StatusConflict, "Uploaded files are not kept for replay")
+	}
+
+	msg := prepareMessage(original.Data.Content, nowNs())
+	msg.Data.Binary = original.Data.Binary
+	msg.Tenant, msg.JobType, msg.Affinity = original.Tenant, original.JobType, original.Affinity
+	return submitAndWait(c, msg)
+}
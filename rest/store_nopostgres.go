@@ -0,0 +1,7 @@
+//go:build !postgres
+
+package gateway
+
+// postgresDriver is empty unless the gateway is built with the postgres tag, which keeps pgx
+// and its dependencies out of the default binary.
+const postgresDriver = ""
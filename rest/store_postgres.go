@@ -0,0 +1,9 @@
+//go:build postgres
+
+package gateway
+
+// Needs the postgres build tag: go get github.com/jackc/pgx/v5 && go build -tags postgres
+import _ "github.com/jackc/pgx/v5/stdlib"
+
+// postgresDriver is the database/sql driver backing JOB_STORE=postgres.
+const postgresDriver = "pgx"
@@ -0,0 +1,52 @@
+package gateway
+
+import (
+	"context"
+	"time"
+)
+
+// --- Tenant Queues ---
+
+// With TENANT_QUEUES=on the jobs of every tenant go to their own queue next to the main (or
+// canary) one, and the tenant is added to the queue's tenant set. Workers running with
+// FAIR_SCHEDULING=on take turns between these queues, so one tenant flooding the gateway can't
+// starve the others. Jobs without a tenant stay on the shared queue, which workers treat as
+// one more tenant.
+
+// tenantQueues is "on" or "off"; turn it on once the workers run with FAIR_SCHEDULING=on,
+// or tenant jobs are never consumed.
+var tenantQueues = stringTunable("TENANT_QUEUES", "off", "on", "off")
+
+func tenantQueueKey(queue, tenant string) string {
+	return queue + ":tenant:" + tenant
+}
+
+// tenantsKey holds the tenants that have a queue next to the given one.
+func tenantsKey(queue string) string {
+	return queue + ":tenants"
+}
+
+// tenantQueueFor returns the tenant's queue next to queue, or queue itself when tenant queues
+// are off or the job has no tenant.
+func tenantQueueFor(queue, tenant string) string {
+	if tenantQueues.Get() != "on" || tenant == "" {
+		return queue
+	}
+	return tenantQueueKey(queue, tenant)
+}
+
+// jobQueues lists the main and canary queues with their tenant queues.
+func jobQueues() []string {
+	ctxTimeout, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	var queues []string
+	for _, queue := range []string{queueKey, canaryQueueKey} {
+		queues = append(queues, queue)
+		queues = append(queues, affinityQueues(queue)...)
+		tenants, _ := rdb.SMembers(ctxTimeout, tenantsKey(queue)).Result()
+		for _, tenant := range tenants {
+			queues = append(queues, tenantQueueKey(queue, tenant))
+		}
+	}
+	return queues
+}
@@ -0,0 +1,161 @@
+package gateway
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+	"go-async-proxy/metrics"
+)
+
+// --- Slow Request Traces ---
+
+// Full stage and attempt detail is kept only for requests slower than SLOW_REQUEST_THRESHOLD,
+// timed out ones, and a TRACE_SAMPLE_PERCENT sample of the rest (tail-based: the decision is
+// taken once the request finished). Traces go to a capped stream read by the admin API.
+
+const slowRequestsKey = "validate:slow_requests"
+
+const (
+	traceReasonSlow    = "slow"
+	traceReasonTimeout = "timeout"
+	traceReasonSampled = "sampled"
+)
+
+var (
+	// slowRequestThreshold is the roundtrip above which a request is always traced.
+	slowRequestThreshold = durationTunable("SLOW_REQUEST_THRESHOLD", 2*time.Second)
+
+	// traceSamplePercent is the share of the remaining requests traced as a baseline.
+	traceSamplePercent = intTunable("TRACE_SAMPLE_PERCENT", 0)
+
+	// slowRequestsMax caps the stream, approximately.
+	slowRequestsMax = int64(envInt("SLOW_REQUESTS_MAX", 10000))
+)
+
+// requestTrace is what is kept of a traced request: everything but the content.
+type requestTrace struct {
+	RequestID string      `json:"request_id"`
+	Tenant    string      `json:"tenant,omitempty"`
+	JobType   string      `json:"job_type,omitempty"`
+	Flags     []string    `json:"flags,omitempty"`
+	Worker    *WorkerInfo `json:"worker,omitempty"`
+	Meta      Meta        `json:"meta"`
+}
+
+// traceRequest keeps the trace of a finished request when it is slow, timed out or sampled.
+func traceRequest(msg *Message, outcome string) {
+	var reason string
+	switch {
+	case outcome == outcomeTimeout:
+		reason = traceReasonTimeout
+	case msg.Meta.RoundtripDurationNs > slowRequestThreshold.Get().Nanoseconds():
+		reason = traceReasonSlow
+	case traceSampled(msg.RequestID):
+		reason = traceReasonSampled
+	default:
+		return
+	}
+
+	trace, err := codec.Marshal(requestTrace{
+		RequestID: msg.RequestID,
+		Tenant:    msg.Tenant,
+		JobType:   msg.JobType,
+		Flags:     msg.Flags,
+		Worker:    msg.Worker,
+		Meta:      msg.Meta,
+	})
+	if err != nil {
+		return
+	}
+	err = rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: slowRequestsKey,
+		MaxLen: slowRequestsMax,
+		Approx: true,
+		Values: map[string]any{
+			"request_id":   msg.RequestID,
+			"reason":       reason,
+			"outcome":      outcome,
+			"roundtrip_ms": float64(msg.Meta.RoundtripDurationNs) / 1_000_000,
+			"trace":        trace,
+		},
+	}).Err()
+	if err != nil {
+		jobLogger(msg).Error("Cannot record trace", "error", err)
+		return
+	}
+	metrics.CounterTracedRequests.WithLabelValues(reason).Inc()
+}
+
+// traceSampled buckets requests the way flags do, so a request's sampling doesn't depend on
+// the replica that handled it.
+func traceSampled(requestId string) bool {
+	percent := traceSamplePercent.Get()
+	if percent <= 0 {
+		return false
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte("trace:" + requestId))
+	return int(h.Sum32()%100) < percent
+}
+
+// --- Admin Handler ---
+
+// slowRequestsHandler serves GET /admin/slow-requests?reason=&cursor=&limit=, newest first.
+// cursor is the next_cursor of the previous page.
+func slowRequestsHandler(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 50)
+	if limit <= 0 || limit > maxJobsPageSize {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("'limit' must be within 1..%d", maxJobsPageSize))
+	}
+	start := "+"
+	if cursor := c.Query("cursor"); cursor != "" {
+		start = "(" + cursor
+	}
+	reason := c.Query("reason")
+
+	type traceEntry struct {
+		ID          string        `json:"id"`
+		Reason      string        `json:"reason"`
+		Outcome     string        `json:"outcome"`
+		RoundtripMs float64       `json:"roundtrip_ms"`
+		Trace       *requestTrace `json:"trace"`
+	}
+	traces := []traceEntry{}
+	nextCursor := ""
+	// Filtering by reason happens client side of the stream, so keep reading pages until full
+	for len(traces) < limit {
+		entries, err := rdb.XRevRangeN(ctx, slowRequestsKey, start, "-", int64(limit)).Result()
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Failed to read traces")
+		}
+		for _, entry := range entries {
+			start, nextCursor = "("+entry.ID, entry.ID
+			if reason != "" && entry.Values["reason"] != reason {
+				continue
+			}
+			item := traceEntry{ID: entry.ID, Trace: &requestTrace{}}
+			item.Reason, _ = entry.Values["reason"].(string)
+			item.Outcome, _ = entry.Values["outcome"].(string)
+			roundtrip, _ := entry.Values["roundtrip_ms"].(string)
+			item.RoundtripMs, _ = strconv.ParseFloat(roundtrip, 64)
+			trace, _ := entry.Values["trace"].(string)
+			if err := codec.Unmarshal([]byte(trace), item.Trace); err != nil {
+				item.Trace = nil
+			}
+			traces = append(traces, item)
+			if len(traces) == limit {
+				break
+			}
+		}
+		if len(entries) < limit {
+			nextCursor = ""
+			break
+		}
+	}
+
+	return c.JSON(fiber.Map{"traces": traces, "next_cursor": nextCursor})
+}
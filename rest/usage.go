@@ -0,0 +1,230 @@
+package gateway
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Usage Accounting ---
+
+// Every job is accounted to the X-API-Key it was submitted with, in one hash per key and UTC
+// day: that hash is the daily rollup GET /usage and GET /admin/usage sum over a range. Redis
+// only ever sees a digest of the key. Jobs without a key are accounted as anonymous, so the
+// admin report adds up to the whole traffic.
+
+const (
+	usageAnonymous = "anonymous"
+	usageDayLayout = "2006-01-02"
+
+	// maxUsageDays bounds the range a report may span.
+	maxUsageDays = 366
+)
+
+// usageRetention is how long daily rollups are kept.
+var usageRetention = envDuration("USAGE_RETENTION", 90*24*time.Hour)
+
+// usageKey holds the rollup of one API key for one day.
+func usageKey(day, keyID string) string {
+	return fmt.Sprintf("validate:usage:%s:%s", day, keyID)
+}
+
+// usageKeysKey holds the key ids with usage on the day.
+func usageKeysKey(day string) string {
+	return fmt.Sprintf("validate:usage:%s", day)
+}
+
+// usageKeyID identifies the caller's API key without storing it.
+func usageKeyID(c *fiber.Ctx) string {
+	apiKey := c.Get("X-API-Key")
+	if apiKey == "" {
+		return usageAnonymous
+	}
+	digest := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(digest[:8])
+}
+
+// recordUsage accounts one job; result is nil unless the job completed.
+func recordUsage(keyID string, msg, result *Message, outcome string) {
+	day := clock.Now().UTC().Format(usageDayLayout)
+	key := usageKey(day, keyID)
+
+	pipe := rdb.Pipeline()
+	pipe.HIncrBy(ctx, key, "jobs", 1)
+	pipe.HIncrBy(ctx, key, "bytes_in", msg.Data.size())
+	if outcome == outcomeCompleted {
+		pipe.HIncrBy(ctx, key, "completed", 1)
+	} else {
+		pipe.HIncrBy(ctx, key, "failed", 1)
+	}
+	if result != nil {
+		pipe.HIncrBy(ctx, key, "bytes_out", result.Data.size())
+		pulled, pushed := result.Meta.At(stageWorkerRequestPulled), result.Meta.At(stageWorkerResponsePushed)
+		if pulled > 0 && pushed > pulled {
+			pipe.HIncrByFloat(ctx, key, "processing_ms", float64(pushed-pulled)/1e6)
+		}
+	}
+	pipe.Expire(ctx, key, usageRetention)
+	pipe.SAdd(ctx, usageKeysKey(day), keyID)
+	pipe.Expire(ctx, usageKeysKey(day), usageRetention)
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.Warn("Cannot record usage", "key_id", keyID, "error", err)
+	}
+}
+
+// size is the payload volume of the data: the uploaded file, the chunked result or the inline
+// content.
+func (d *Data) size() int64 {
+	switch {
+	case d.File != nil:
+		return d.File.Size
+	case d.Chunks != nil:
+		return d.Chunks.Size
+	}
+	return int64(len(d.Content))
+}
+
+// Usage is the consumption of an API key over a period.
+type Usage struct {
+	Jobs         int64   `json:"jobs"`
+	Completed    int64   `json:"completed"`
+	Failed       int64   `json:"failed"`
+	ProcessingMs float64 `json:"processing_ms"`
+	BytesIn      int64   `json:"bytes_in"`
+	BytesOut     int64   `json:"bytes_out"`
+}
+
+func (u *Usage) add(fields map[string]string) {
+	count := func(field string) int64 {
+		value, _ := strconv.ParseInt(fields[field], 10, 64)
+		return value
+	}
+	processingMs, _ := strconv.ParseFloat(fields["processing_ms"], 64)
+	u.Jobs += count("jobs")
+	u.Completed += count("completed")
+	u.Failed += count("failed")
+	u.BytesIn += count("bytes_in")
+	u.BytesOut += count("bytes_out")
+	u.ProcessingMs += processingMs
+}
+
+// usageDays parses ?from= and ?to= (YYYY-MM-DD, UTC) into the days of the range, by default the
+// last 30 days.
+func usageDays(c *fiber.Ctx) ([]string, error) {
+	to := clock.Now().UTC().Truncate(24 * time.Hour)
+	if raw := c.Query("to"); raw != "" {
+		parsed, err := time.Parse(usageDayLayout, raw)
+		if err != nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "'to' must be a YYYY-MM-DD date")
+		}
+		to = parsed
+	}
+	from := to.AddDate(0, 0, -29)
+	if raw := c.Query("from"); raw != "" {
+		parsed, err := time.Parse(usageDayLayout, raw)
+		if err != nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "'from' must be a YYYY-MM-DD date")
+		}
+		from = parsed
+	}
+	if from.After(to) || to.Sub(from) >= maxUsageDays*24*time.Hour {
+		return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("'from' must be before 'to' and the range at most %d days", maxUsageDays))
+	}
+	var days []string
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		days = append(days, day.Format(usageDayLayout))
+	}
+	return days, nil
+}
+
+// usageHandler serves GET /usage: the caller's own usage per day and in total.
+func usageHandler(c *fiber.Ctx) error {
+	if c.Get("X-API-Key") == "" {
+		return fiber.NewError(fiber.StatusUnauthorized, "Missing X-API-Key header")
+	}
+	days, err := usageDays(c)
+	if err != nil {
+		return err
+	}
+	keyID := usageKeyID(c)
+
+	pipe := rdb.Pipeline()
+	rollups := make([]*redis.MapStringStringCmd, len(days))
+	for i, day := range days {
+		rollups[i] = pipe.HGetAll(ctx, usageKey(day, keyID))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to read usage")
+	}
+
+	type dayUsage struct {
+		Day string `json:"day"`
+		Usage
+	}
+	perDay := []dayUsage{}
+	var total Usage
+	for i, day := range days {
+		if len(rollups[i].Val()) == 0 {
+			continue
+		}
+		entry := dayUsage{Day: day}
+		entry.add(rollups[i].Val())
+		total.add(rollups[i].Val())
+		perDay = append(perDay, entry)
+	}
+	return c.JSON(fiber.Map{"key_id": keyID, "days": perDay, "total": total})
+}
+
+// usageReportHandler serves GET /admin/usage: every API key's usage over the range, heaviest
+// consumers first.
+func usageReportHandler(c *fiber.Ctx) error {
+	days, err := usageDays(c)
+	if err != nil {
+		return err
+	}
+
+	pipe := rdb.Pipeline()
+	keyIDs := make([]*redis.StringSliceCmd, len(days))
+	for i, day := range days {
+		keyIDs[i] = pipe.SMembers(ctx, usageKeysKey(day))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to read usage")
+	}
+
+	pipe = rdb.Pipeline()
+	rollups := map[string][]*redis.MapStringStringCmd{}
+	for i, day := range days {
+		for _, keyID := range keyIDs[i].Val() {
+			rollups[keyID] = append(rollups[keyID], pipe.HGetAll(ctx, usageKey(day, keyID)))
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to read usage")
+	}
+
+	type keyUsage struct {
+		KeyID string `json:"key_id"`
+		Usage
+	}
+	keys := []keyUsage{}
+	for keyID, cmds := range rollups {
+		entry := keyUsage{KeyID: keyID}
+		for _, cmd := range cmds {
+			entry.add(cmd.Val())
+		}
+		keys = append(keys, entry)
+	}
+	slices.SortFunc(keys, func(a, b keyUsage) int {
+		return cmp.Or(cmp.Compare(b.ProcessingMs, a.ProcessingMs), cmp.Compare(b.Jobs, a.Jobs), cmp.Compare(a.KeyID, b.KeyID))
+	})
+	return c.JSON(fiber.Map{"from": days[0], "to": days[len(days)-1], "keys": keys})
+}
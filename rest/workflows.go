@@ -0,0 +1,389 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"go-async-proxy/metrics"
+)
+
+// --- Workflows ---
+
+// A workflow is a DAG of job types, defined as JSON with PUT /admin/workflows/:name:
+//
+//	{"nodes": [
+//		{"id": "parse", "type": "parse"},
+//		{"id": "lint", "type": "lint", "depends_on": ["parse"]},
+//		{"id": "score", "type": "score", "depends_on": ["parse"]},
+//		{"id": "report", "type": "report", "depends_on": ["lint", "score"]}
+//	]}
+//
+// POST /workflows/:name/runs?content= starts a run and answers 202 right away. The gateway
+// that took it coordinates the run: every node is queued as a job of its type on the run's
+// content once the nodes it depends on completed without error, with their request_ids as its
+// depends_on, and skipped when one of them failed. GET /workflows/runs/:id reports the run
+// and every node's job, status and timing. Runs live in the gateway coordinating them, a run
+// whose gateway died is reported abandoned.
+
+const (
+	workflowsKey = "validate:workflows"
+
+	workflowRunning   = "running"
+	workflowCompleted = "completed"
+	workflowFailed    = "failed"
+	workflowAbandoned = "abandoned"
+
+	nodeWaiting   = "waiting"
+	nodePending   = "pending"
+	nodeCompleted = "completed"
+	nodeFailed    = "failed"
+	nodeSkipped   = "skipped"
+
+	// maxWorkflowNodes caps the size of a workflow.
+	maxWorkflowNodes = 64
+)
+
+func workflowRunKey(id string) string {
+	return "validate:workflow:run:" + id
+}
+
+// WorkflowNode is one step of a workflow, run as a job of JobType.
+type WorkflowNode struct {
+	ID        string   `json:"id"`
+	JobType   string   `json:"type"`
+	DependsOn []string `json:"depends_on,omitempty"`
+}
+
+type Workflow struct {
+	Name  string         `json:"name"`
+	Nodes []WorkflowNode `json:"nodes"`
+}
+
+// order checks the workflow and returns its nodes so that every node comes after the nodes it
+// depends on (Kahn's algorithm); nodes left over sit on a cycle.
+func (w *Workflow) order() ([]WorkflowNode, error) {
+	if len(w.Nodes) == 0 || len(w.Nodes) > maxWorkflowNodes {
+		return nil, fmt.Errorf("a workflow has 1..%d nodes", maxWorkflowNodes)
+	}
+	nodes := make(map[string]WorkflowNode, len(w.Nodes))
+	for _, node := range w.Nodes {
+		if node.ID == "" {
+			return nil, errors.New("every node needs an id")
+		}
+		if _, ok := nodes[node.ID]; ok {
+			return nil, fmt.Errorf("node %q is defined twice", node.ID)
+		}
+		nodes[node.ID] = node
+	}
+	blocking := make(map[string]int, len(w.Nodes))
+	dependents := map[string][]string{}
+	for _, node := range w.Nodes {
+		for _, dep := range node.DependsOn {
+			if _, ok := nodes[dep]; !ok {
+				return nil, fmt.Errorf("node %q depends on unknown node %q", node.ID, dep)
+			}
+			blocking[node.ID]++
+			dependents[dep] = append(dependents[dep], node.ID)
+		}
+	}
+
+	var ready, ordered []string
+	for _, node := range w.Nodes {
+		if blocking[node.ID] == 0 {
+			ready = append(ready, node.ID)
+		}
+	}
+	for len(ready) > 0 {
+		id := ready[0]
+		ready = ready[1:]
+		ordered = append(ordered, id)
+		for _, dependent := range dependents[id] {
+			if blocking[dependent]--; blocking[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+	if len(ordered) != len(w.Nodes) {
+		return nil, errors.New("the dependencies form a cycle")
+	}
+	result := make([]WorkflowNode, len(ordered))
+	for i, id := range ordered {
+		result[i] = nodes[id]
+	}
+	return result, nil
+}
+
+// WorkflowNodeRun is the state of one node in a run.
+type WorkflowNodeRun struct {
+	RequestID  string  `json:"request_id,omitempty"`
+	Status     string  `json:"status"`
+	Error      string  `json:"error,omitempty"`
+	StartedMs  int64   `json:"started_ms,omitempty"`
+	FinishedMs int64   `json:"finished_ms,omitempty"`
+	DurationMs float64 `json:"duration_ms,omitempty"`
+}
+
+// WorkflowRun is what GET /workflows/runs/:id reports.
+type WorkflowRun struct {
+	ID         string                      `json:"id"`
+	Workflow   string                      `json:"workflow"`
+	Tenant     string                      `json:"tenant,omitempty"`
+	Status     string                      `json:"status"`
+	Instance   string                      `json:"instance"`
+	StartedMs  int64                       `json:"started_ms"`
+	FinishedMs int64                       `json:"finished_ms,omitempty"`
+	DurationMs float64                     `json:"duration_ms,omitempty"`
+	Nodes      map[string]*WorkflowNodeRun `json:"nodes"`
+}
+
+// workflowCoordinator advances one run; node goroutines report to it under mu.
+type workflowCoordinator struct {
+	mu      sync.Mutex
+	run     *WorkflowRun
+	content string
+	traceID string
+}
+
+// save writes the run for GET /workflows/runs/:id; callers hold mu.
+func (w *workflowCoordinator) save() {
+	payload, err := codec.Marshal(w.run)
+	if err == nil {
+		err = rdb.Set(ctx, workflowRunKey(w.run.ID), payload, jobResultTTL.Get()).Err()
+	}
+	if err != nil {
+		loggerFrom(ctx).Warn("Cannot save workflow run", "run", w.run.ID, "error", err)
+	}
+}
+
+func (w *workflowCoordinator) update(id string, apply func(node *WorkflowNodeRun)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	apply(w.run.Nodes[id])
+	w.save()
+}
+
+// advance runs every node once its dependencies are done and finishes the run.
+func (w *workflowCoordinator) advance(nodes []WorkflowNode) {
+	done := make(map[string]chan struct{}, len(nodes))
+	for _, node := range nodes {
+		done[node.ID] = make(chan struct{})
+	}
+	var wg sync.WaitGroup
+	for _, node := range nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(done[node.ID])
+			var requestIds []string
+			for _, dep := range node.DependsOn {
+				<-done[dep]
+				w.mu.Lock()
+				state := *w.run.Nodes[dep]
+				w.mu.Unlock()
+				if state.Status != nodeCompleted {
+					w.update(node.ID, func(n *WorkflowNodeRun) { n.Status, n.Error = nodeSkipped, "dependency "+dep+" "+state.Status })
+					return
+				}
+				requestIds = append(requestIds, state.RequestID)
+			}
+			w.runNode(node, requestIds)
+		}()
+	}
+	wg.Wait()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.run.Status = workflowCompleted
+	for _, node := range w.run.Nodes {
+		if node.Status != nodeCompleted {
+			w.run.Status = workflowFailed
+		}
+	}
+	now := clock.Now()
+	w.run.FinishedMs = now.UnixMilli()
+	w.run.DurationMs = float64(now.UnixMilli() - w.run.StartedMs)
+	w.save()
+	metrics.CounterWorkflowRuns.WithLabelValues(w.run.Status).Inc()
+	loggerFrom(ctx).Info("Workflow run finished", "run", w.run.ID, "workflow", w.run.Workflow, "status", w.run.Status)
+}
+
+// runNode queues the node's job and waits for its result, like submitAndWait does for a caller.
+func (w *workflowCoordinator) runNode(node WorkflowNode, dependsOn []string) {
+	started := clock.Now()
+	msg := prepareMessage(w.content, started.UnixNano())
+	msg.Tenant, msg.JobType, msg.TraceID, msg.DependsOn = w.run.Tenant, node.JobType, w.traceID, dependsOn
+	msg.Meta.Mark(stageRestRequestPushed)
+	setQueueDeadline(msg)
+	w.update(node.ID, func(n *WorkflowNodeRun) {
+		n.RequestID, n.Status, n.StartedMs = msg.RequestID, nodePending, started.UnixMilli()
+	})
+
+	reply := dispatcher.register(msg.RequestID)
+	defer dispatcher.cancel(msg.RequestID)
+	status, failure := nodeCompleted, ""
+	if _, err := pushToQueue(msg, ""); err != nil {
+		setJobStatus(msg.RequestID, jobStatusFailed)
+		status, failure = nodeFailed, "queue push failed"
+	} else if result, err := waitForResult(msg.RequestID, reply, waitTimeout.Get()); err != nil {
+		recordOutcome(msg, outcomeTimeout)
+		setJobStatus(msg.RequestID, jobStatusTimeout)
+		status, failure = nodeFailed, "timeout waiting for result"
+	} else {
+		recordJobError(msg.RequestID, result.Meta.lastError())
+		setJobStatus(msg.RequestID, jobStatusCompleted)
+		result.Meta.EnqueueDepth = msg.Meta.EnqueueDepth
+		finalMsg := finalizeResult(result)
+		storeJob(finalMsg, jobStatusCompleted)
+		if failure = finalMsg.Meta.lastError(); failure != "" {
+			status = nodeFailed
+		}
+	}
+
+	w.update(node.ID, func(n *WorkflowNodeRun) {
+		now := clock.Now()
+		n.Status, n.Error = status, failure
+		n.FinishedMs = now.UnixMilli()
+		n.DurationMs = float64(now.Sub(started).Microseconds()) / 1000
+	})
+}
+
+// --- Workflow Handlers ---
+
+// putWorkflowHandler stores the workflow in the body under :name, once it checked out.
+func putWorkflowHandler(c *fiber.Ctx) error {
+	var workflow Workflow
+	if err := codec.Unmarshal(c.Body(), &workflow); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid workflow JSON")
+	}
+	workflow.Name = c.Params("name")
+	order, err := workflow.order()
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid workflow: "+err.Error())
+	}
+	payload, err := codec.Marshal(workflow)
+	if err != nil {
+		return err
+	}
+	if err := rdb.HSet(ctx, workflowsKey, workflow.Name, payload).Err(); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to store workflow")
+	}
+	ids := make([]string, len(order))
+	for i, node := range order {
+		ids[i] = node.ID
+	}
+	return c.JSON(fiber.Map{"workflow": workflow, "order": ids})
+}
+
+// loadWorkflow reads the workflow name, nil when there is none.
+func loadWorkflow(name string) (*Workflow, error) {
+	payload, err := rdb.HGet(ctx, workflowsKey, name).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var workflow Workflow
+	if err := codec.Unmarshal(payload, &workflow); err != nil {
+		return nil, err
+	}
+	return &workflow, nil
+}
+
+func getWorkflowHandler(c *fiber.Ctx) error {
+	workflow, err := loadWorkflow(c.Params("name"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to read workflow")
+	}
+	if workflow == nil {
+		return fiber.NewError(fiber.StatusNotFound, "Unknown workflow")
+	}
+	return c.JSON(workflow)
+}
+
+func deleteWorkflowHandler(c *fiber.Ctx) error {
+	deleted, err := rdb.HDel(ctx, workflowsKey, c.Params("name")).Result()
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to delete workflow")
+	}
+	if deleted == 0 {
+		return fiber.NewError(fiber.StatusNotFound, "Unknown workflow")
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// startWorkflowHandler serves POST /workflows/:name/runs?content=: the run is coordinated in
+// the background and followed with GET /workflows/runs/:id.
+func startWorkflowHandler(c *fiber.Ctx) error {
+	if inMaintenance() {
+		return respondMaintenance(c)
+	}
+	if err := rejectWhenShedding(c); err != nil {
+		return err
+	}
+	content, err := extractContent(c)
+	if err != nil {
+		return err
+	}
+	workflow, err := loadWorkflow(c.Params("name"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to read workflow")
+	}
+	if workflow == nil {
+		return fiber.NewError(fiber.StatusNotFound, "Unknown workflow")
+	}
+	nodes, err := workflow.order()
+	if err != nil {
+		return fiber.NewError(fiber.StatusConflict, "Invalid workflow: "+err.Error())
+	}
+
+	run := &WorkflowRun{
+		ID:        uuid.NewString(),
+		Workflow:  workflow.Name,
+		Tenant:    c.Get("X-Tenant"),
+		Status:    workflowRunning,
+		Instance:  instanceID,
+		StartedMs: clock.Now().UnixMilli(),
+		Nodes:     make(map[string]*WorkflowNodeRun, len(nodes)),
+	}
+	for _, node := range nodes {
+		run.Nodes[node.ID] = &WorkflowNodeRun{Status: nodeWaiting}
+	}
+	coordinator := &workflowCoordinator{run: run, content: content, traceID: traceIDFrom(c)}
+	coordinator.mu.Lock()
+	coordinator.save()
+	coordinator.mu.Unlock()
+	go coordinator.advance(nodes)
+
+	c.Set("X-Trace-ID", coordinator.traceID)
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"run_id":     run.ID,
+		"status":     run.Status,
+		"status_url": "/workflows/runs/" + run.ID,
+	})
+}
+
+// workflowRunHandler serves GET /workflows/runs/:id.
+func workflowRunHandler(c *fiber.Ctx) error {
+	payload, err := rdb.Get(ctx, workflowRunKey(c.Params("id"))).Bytes()
+	if err == redis.Nil {
+		return fiber.NewError(fiber.StatusNotFound, "Unknown workflow run")
+	}
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to read workflow run")
+	}
+	var run WorkflowRun
+	if err := codec.Unmarshal(payload, &run); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to decode workflow run")
+	}
+	if run.Status == workflowRunning {
+		if alive, err := rdb.Exists(ctx, heartbeatKey(run.Instance)).Result(); err == nil && alive == 0 {
+			run.Status = workflowAbandoned
+		}
+	}
+	return c.JSON(run)
+}
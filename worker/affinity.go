@@ -0,0 +1,122 @@
+package worker
+
+import (
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Worker Affinity ---
+
+// With AFFINITY_PARTITIONS=n (the gateway's value), jobs sent with an affinity key wait on
+// <queue>:affinity:<partition>. The live workers of a queue, known from their heartbeats, split
+// the partitions by rank: the worker ranked i of m serves the partitions p with p mod m == i,
+// next to the queue itself. Every worker derives the same split, and it follows the fleet as
+// workers come and go, one heartbeat interval late.
+
+var affinityPartitions = envInt("AFFINITY_PARTITIONS", 0)
+
+func affinityQueueKey(queue string, partition int) string {
+	return queue + ":affinity:" + strconv.Itoa(partition)
+}
+
+// affinityPartitionOf returns the partition a queue popped by this worker belongs to.
+func affinityPartitionOf(queue string) (string, bool) {
+	return strings.CutPrefix(queue, queueKey+":affinity:")
+}
+
+// ownedPartitions is this worker's share of the partitions, re-derived every heartbeat interval.
+type ownedPartitions struct {
+	mu        sync.Mutex
+	queues    []string
+	refreshed time.Time
+}
+
+var owned = &ownedPartitions{}
+
+// get returns the partition queues this worker serves.
+func (o *ownedPartitions) get(rdb *redis.Client) []string {
+	if affinityPartitions == 0 {
+		return nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if since(o.refreshed) < heartbeatInterval {
+		return o.queues
+	}
+	workers, err := liveWorkers(rdb)
+	if err != nil {
+		// Keep serving the last known share until the fleet can be read again
+		slog.Warn("Cannot list workers for affinity", "error", err)
+		return o.queues
+	}
+	if !slices.Contains(workers, workerID) {
+		workers = append(workers, workerID)
+		slices.Sort(workers)
+	}
+	rank := slices.Index(workers, workerID)
+
+	// A new slice, callers may still be blocked on the previous one
+	var queues []string
+	for p := rank; p < affinityPartitions; p += len(workers) {
+		queues = append(queues, affinityQueueKey(queueKey, p))
+	}
+	o.queues, o.refreshed = queues, clock.Now()
+	GaugeAffinityPartitions.Set(float64(len(o.queues)))
+	return o.queues
+}
+
+// liveWorkers returns the sorted IDs of the workers pulling from queueKey, draining ones aside.
+func liveWorkers(rdb *redis.Client) ([]string, error) {
+	prefix := heartbeatKey("")
+	var workers []string
+	iter := rdb.Scan(ctx, 0, prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		payload, err := rdb.Get(ctx, iter.Val()).Bytes()
+		if err != nil {
+			continue
+		}
+		var beat heartbeat
+		if codec.Unmarshal(payload, &beat) != nil || beat.Queue != queueKey || beat.Draining {
+			continue
+		}
+		workers = append(workers, strings.TrimPrefix(iter.Val(), prefix))
+	}
+	slices.Sort(workers)
+	return workers, iter.Err()
+}
+
+// observeAffinity counts a job popped from one of this worker's partitions.
+func observeAffinity(queue string) {
+	if partition, ok := affinityPartitionOf(queue); ok {
+		CounterAffinityJobs.WithLabelValues(partition).Inc()
+	}
+}
+
+// popRotation spreads the consumers' first pick over the queues, so a busy partition can't
+// starve the queue itself.
+var popRotation atomic.Uint64
+
+// popWithPartitions waits for a job on the queue or on one of this worker's partitions. The
+// wait is bounded so a change of share is picked up.
+func popWithPartitions(rdb *redis.Client) (string, string, error) {
+	for {
+		queues := append([]string{queueKey}, owned.get(rdb)...)
+		start := int(popRotation.Add(1) % uint64(len(queues)))
+		queues = slices.Concat(queues[start:], queues[:start])
+		result, err := rdb.BLPop(ctx, time.Second, queues...).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return queueKey, "", err
+		}
+		return result[0], result[1], nil
+	}
+}
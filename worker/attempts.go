@@ -0,0 +1,69 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// --- Attempts ---
+
+// Attempt is one run of the handler on a job, kept in the envelope so the gateway and its
+// logs see the whole history.
+type Attempt struct {
+	WorkerID string `json:"worker_id"`
+	StartNs  int64  `json:"start_ns"`
+	EndNs    int64  `json:"end_ns"`
+	Error    string `json:"error,omitempty"`
+}
+
+var (
+	// maxAttempts is how many times a failing handler runs before the job is answered as failed.
+	maxAttempts = intTunable("MAX_ATTEMPTS", 1)
+
+	// retryBackoff is the pause before the second attempt, doubled for every following one.
+	retryBackoff = durationTunable("RETRY_BACKOFF", 100*time.Millisecond)
+)
+
+// runAttempts runs handler on msg until it succeeds or maxAttempts is reached, starting every
+// attempt from the original data and recording each one in msg.Meta.Attempts. A job aborted by
+// its guardrails or by a panic is not retried, it would most likely fail the same way again.
+func runAttempts(ctx context.Context, handler Handler, msg *Message) error {
+	original := msg.Data
+	var err error
+	attempts := maxAttempts.Get()
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(retryBackoff.Get() << (attempt - 1))
+			msg.Data = original
+		}
+
+		record := Attempt{WorkerID: workerID, StartNs: nowNs()}
+		err = runGuarded(ctx, handler, msg)
+		record.EndNs = nowNs()
+		if err != nil {
+			record.Error = err.Error()
+			loggerFrom(ctx).Warn("Attempt failed", "attempt", attempt+1, "error", err)
+		}
+		msg.Meta.Attempts = append(msg.Meta.Attempts, record)
+		var panicked *panicError
+		if err == nil || errors.Is(err, errResourceExceeded) || errors.As(err, &panicked) {
+			return err
+		}
+	}
+	return err
+}
+
+// errQueueBudgetExceeded answers a job that waited in the queue past the deadline the gateway
+// derived from its queue_wait stage budget.
+var errQueueBudgetExceeded = errors.New("stage budget exceeded: queue_wait")
+
+// checkQueueDeadline fails a job pulled after its queue deadline; the gateway no longer wants it
+// processed.
+func checkQueueDeadline(msg *Message) error {
+	if msg.QueueDeadlineNs == 0 || msg.Meta.At(stageWorkerRequestPulled) <= msg.QueueDeadlineNs {
+		return nil
+	}
+	CounterQueueBudgetExceeded.Inc()
+	return errQueueBudgetExceeded
+}
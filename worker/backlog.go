@@ -0,0 +1,126 @@
+package worker
+
+import (
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Startup Backlog ---
+
+// When a worker starts it logs how far behind the fleet is: the jobs waiting in its queue, its
+// tenant queues and its affinity partitions, and when the fleet would catch up on them. The
+// estimate divides the backlog by the live workers' slots (WORKER_CONCURRENCY, announced in
+// the heartbeats) and multiplies it by their recent mean job time, which every worker keeps as
+// a moving average of its pull-to-push times and announces in its heartbeat too. A fleet that
+// processed nothing yet has no job time and no estimate. The figures are exported as
+// worker_startup_backlog_jobs and worker_startup_catch_up_seconds.
+
+// jobTimeWeight is the weight of the newest job in the moving average.
+const jobTimeWeight = 0.1
+
+// recentJobTime is this worker's moving average of its pull-to-push times, in ms.
+var recentJobTime = struct {
+	sync.Mutex
+	ms float64
+}{}
+
+// observeJobTime folds one pull-to-push time into recentJobTime.
+func observeJobTime(ms float64) {
+	recentJobTime.Lock()
+	defer recentJobTime.Unlock()
+	if recentJobTime.ms == 0 {
+		recentJobTime.ms = ms
+		return
+	}
+	recentJobTime.ms += jobTimeWeight * (ms - recentJobTime.ms)
+}
+
+func meanJobTime() float64 {
+	recentJobTime.Lock()
+	defer recentJobTime.Unlock()
+	return recentJobTime.ms
+}
+
+// backlogSize counts the jobs waiting in every queue this worker's fleet serves.
+func backlogSize(rdb *redis.Client) (int64, error) {
+	queues := []string{queueKey}
+	tenants, err := rdb.SMembers(ctx, tenantsKey(queueKey)).Result()
+	if err != nil {
+		return 0, err
+	}
+	for _, tenant := range tenants {
+		queues = append(queues, tenantQueueKey(queueKey, tenant))
+	}
+	for p := 0; p < affinityPartitions; p++ {
+		queues = append(queues, affinityQueueKey(queueKey, p))
+	}
+
+	pipe := rdb.Pipeline()
+	lengths := make([]*redis.IntCmd, len(queues))
+	for i, queue := range queues {
+		lengths[i] = pipe.LLen(ctx, queue)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, length := range lengths {
+		total += length.Val()
+	}
+	return total, nil
+}
+
+// logStartupBacklog logs and exports the backlog and the fleet's catch-up estimate.
+func logStartupBacklog(rdb *redis.Client) {
+	backlog, err := backlogSize(rdb)
+	if err != nil {
+		slog.Warn("Cannot read backlog", "queue", queueKey, "error", err)
+		return
+	}
+	GaugeStartupBacklog.Set(float64(backlog))
+
+	// This worker counts among the slots, but has no job time yet
+	workers, slots := 1, concurrency
+	var weightedMs float64
+	var timedSlots int
+	iter := rdb.Scan(ctx, 0, heartbeatKey("*"), 100).Iterator()
+	for iter.Next(ctx) {
+		if iter.Val() == heartbeatKey(workerID) {
+			continue
+		}
+		payload, err := rdb.Get(ctx, iter.Val()).Bytes()
+		if err != nil {
+			continue
+		}
+		var beat heartbeat
+		if codec.Unmarshal(payload, &beat) != nil || beat.Queue != queueKey || beat.Draining {
+			continue
+		}
+		workers++
+		slots += max(beat.Concurrency, 1)
+		if beat.JobMs > 0 {
+			weightedMs += beat.JobMs * float64(max(beat.Concurrency, 1))
+			timedSlots += max(beat.Concurrency, 1)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		// The fleet is unknown, and so is when it catches up
+		slog.Warn("Cannot read the fleet's heartbeats", "queue", queueKey, "jobs", backlog, "error", err)
+		GaugeStartupCatchUp.Set(math.NaN())
+		return
+	}
+	if timedSlots == 0 {
+		slog.Info("Backlog at startup", "queue", queueKey, "jobs", backlog, "workers", workers, "slots", slots, "catch_up", "unknown")
+		GaugeStartupCatchUp.Set(math.NaN())
+		return
+	}
+	jobMs := weightedMs / float64(timedSlots)
+	eta := time.Duration(float64(backlog) * jobMs / float64(slots) * float64(time.Millisecond))
+	GaugeStartupCatchUp.Set(eta.Seconds())
+	slog.Info("Backlog at startup", "queue", queueKey, "jobs", backlog, "workers", workers, "slots", slots,
+		"job_ms", math.Round(jobMs*10)/10, "catch_up", eta.Round(time.Second).String())
+}
@@ -0,0 +1,59 @@
+package worker
+
+import (
+	"log/slog"
+	"runtime"
+	"runtime/debug"
+)
+
+// --- Build Info ---
+
+// Set with -ldflags "-X go-async-worker.version=... -X go-async-worker.gitSHA=...
+// -X go-async-worker.buildTime=...". Local builds from a git checkout fall back to the VCS stamp
+// of the Go toolchain.
+var (
+	version   = "dev"
+	gitSHA    = ""
+	buildTime = ""
+)
+
+// BuildInfo describes the running binary, served on /version and carried in heartbeats.
+type BuildInfo struct {
+	Version   string            `json:"version"`
+	GitSHA    string            `json:"git_sha"`
+	BuildTime string            `json:"build_time"`
+	GoVersion string            `json:"go_version"`
+	Features  map[string]string `json:"features"`
+}
+
+// buildInfo is filled in by initBuildInfo, once the codec is picked.
+var buildInfo BuildInfo
+
+func initBuildInfo() {
+	buildInfo = BuildInfo{
+		Version:   version,
+		GitSHA:    gitSHA,
+		BuildTime: buildTime,
+		GoVersion: runtime.Version(),
+		Features: map[string]string{
+			"broker":  "redis",
+			"codec":   codec.Name(),
+			"handler": envString("HANDLER", "uppercase"),
+			"tracing": "off",
+		},
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && buildInfo.GitSHA == "":
+				buildInfo.GitSHA = setting.Value
+			case setting.Key == "vcs.time" && buildInfo.BuildTime == "":
+				buildInfo.BuildTime = setting.Value
+			case setting.Key == "-tags":
+				buildInfo.Features["tags"] = setting.Value
+			}
+		}
+	}
+	slog.Info("Build", "version", buildInfo.Version, "git_sha", buildInfo.GitSHA, "build_time", buildInfo.BuildTime,
+		"go", buildInfo.GoVersion, "features", buildInfo.Features)
+}
@@ -0,0 +1,294 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Bulkheads ---
+
+// JOB_LIMITS delays jobs of a capped type on the consumer that pulled them, so a job type that
+// turns slow still ties up every consumer. BULKHEADS isolates job types instead: a JSON object
+// of job type to Bulkhead ("*" gives every other job type a bulkhead of its own with those
+// settings). A job whose type has a bulkhead is handed to that bulkhead's own pool of
+// Concurrency goroutines and its consumer goes back to the queue right away. Up to QueueSize
+// jobs wait for the pool; past that, the job is answered unprocessed with a "rejected:" error
+// so the slow job type sheds load instead of the whole worker. Job types without a bulkhead are
+// processed by their consumer as before.
+//
+// Every job handed to a bulkhead is recorded in the worker's in-flight list
+// (validate:bulkhead:<worker id>) until it is processed. Draining the worker puts the jobs still
+// waiting for a pool back at the head of their queue, and a worker whose heartbeat has been
+// missing for bulkheadReapGrace past its expiry has its in-flight list requeued by the others,
+// so a crash doesn't lose the jobs it buffered while a worker that only missed a few heartbeats
+// doesn't get its running jobs run twice.
+
+const (
+	// bulkheadReapInterval is how often in-flight lists of dead workers are looked for.
+	bulkheadReapInterval = 30 * time.Second
+
+	// bulkheadReapGrace is how long a reaper must have found a worker's heartbeat missing
+	// before it requeues its in-flight list: one more heartbeat TTL.
+	bulkheadReapGrace = 3 * heartbeatInterval
+)
+
+// reapMarkKey notes since when (unix ms) reapers find the heartbeat of worker id missing. It
+// is outside validate:bulkhead: so the reapers' scan doesn't take it for an in-flight list.
+func reapMarkKey(id string) string {
+	return "validate:reaper:missing:" + id
+}
+
+// reapScript pops the next job of the in-flight list KEYS[1] only when the worker's heartbeat
+// KEYS[2] has been missing for ARGV[2] ms by ARGV[1], the time now, per the mark KEYS[3]. The
+// mark is set when the heartbeat is first found missing and cleared when it is back; checking
+// on every pop stops the reaping as soon as the worker beats again.
+var reapScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[2]) == 1 then
+	redis.call('DEL', KEYS[3])
+	return false
+end
+local since = redis.call('GET', KEYS[3])
+if not since then
+	redis.call('SET', KEYS[3], ARGV[1], 'PX', 10 * tonumber(ARGV[2]))
+	return false
+end
+if tonumber(ARGV[1]) - tonumber(since) < tonumber(ARGV[2]) then
+	return false
+end
+return redis.call('RPOP', KEYS[1])
+`)
+
+// Bulkhead sizes the pool of one job type.
+type Bulkhead struct {
+	Concurrency int `json:"concurrency"`
+	QueueSize   int `json:"queue_size"`
+}
+
+// bulkheadEntry is a job of the in-flight list: its payload as pulled and the queue to put it
+// back on.
+type bulkheadEntry struct {
+	Queue   string `json:"queue"`
+	Payload []byte `json:"payload"`
+}
+
+// bulkheadJob is a job waiting for its bulkhead's pool.
+type bulkheadJob struct {
+	ctx   context.Context
+	msg   *Message
+	entry bulkheadEntry
+	// recorded is the job's value in the in-flight list, empty when it could not be recorded.
+	recorded string
+}
+
+// bulkhead runs the jobs of one job type on its own goroutines.
+type bulkhead struct {
+	jobType string
+	jobs    chan bulkheadJob
+}
+
+var bulkheads = struct {
+	sync.Mutex
+	byType   map[string]*bulkhead
+	settings map[string]Bulkhead
+	rdb      *redis.Client
+	handler  Handler
+}{byType: map[string]*bulkhead{}}
+
+func bulkheadInflightKey(id string) string {
+	return "validate:bulkhead:" + id
+}
+
+func initBulkheads(rdb *redis.Client, handler Handler) error {
+	raw := envString("BULKHEADS", "")
+	if raw == "" {
+		return nil
+	}
+	var settings map[string]Bulkhead
+	if err := codec.Unmarshal([]byte(raw), &settings); err != nil {
+		return fmt.Errorf("invalid BULKHEADS: %w", err)
+	}
+	for jobType, b := range settings {
+		if b.Concurrency < 1 || b.QueueSize < 0 {
+			return fmt.Errorf("invalid BULKHEADS for %q: concurrency must be positive and queue_size not negative", jobType)
+		}
+	}
+	bulkheads.settings, bulkheads.rdb, bulkheads.handler = settings, rdb, handler
+	startBulkheadReaper(rdb)
+	return nil
+}
+
+// bulkheadFor returns the bulkhead of jobType, starting it on first use, or nil when the job
+// type has none.
+func bulkheadFor(jobType string) *bulkhead {
+	if bulkheads.settings == nil {
+		return nil
+	}
+	bulkheads.Lock()
+	defer bulkheads.Unlock()
+	if b, ok := bulkheads.byType[jobType]; ok {
+		return b
+	}
+	settings, ok := bulkheads.settings[jobType]
+	if !ok {
+		if settings, ok = bulkheads.settings["*"]; !ok {
+			return nil
+		}
+	}
+	b := &bulkhead{jobType: jobType, jobs: make(chan bulkheadJob, settings.QueueSize)}
+	for i := 0; i < settings.Concurrency; i++ {
+		go b.run()
+	}
+	bulkheads.byType[jobType] = b
+	return b
+}
+
+func (b *bulkhead) run() {
+	for job := range b.jobs {
+		GaugeBulkheadQueued.WithLabelValues(b.jobType).Dec()
+		if draining.Load() {
+			// Drained while the job waited for the pool
+			requeueBulkheadJob(bulkheads.rdb, job)
+			continue
+		}
+		GaugeBulkheadActive.WithLabelValues(b.jobType).Inc()
+		runJob(job.ctx, bulkheads.rdb, bulkheads.handler, job.msg)
+		GaugeBulkheadActive.WithLabelValues(b.jobType).Dec()
+		forgetBulkheadJob(bulkheads.rdb, job)
+	}
+}
+
+// submit hands msg, pulled from queue as payload, to the bulkhead's pool, or rejects it when
+// the pool and its queue are full.
+func (b *bulkhead) submit(ctx context.Context, msg *Message, queue, payload string) {
+	job := bulkheadJob{ctx: ctx, msg: msg, entry: bulkheadEntry{Queue: queue, Payload: []byte(payload)}}
+	// Recorded before the pool can see it, so the record never outlives the job
+	if recorded, err := codec.Marshal(job.entry); err == nil {
+		if err := bulkheads.rdb.RPush(ctx, bulkheadInflightKey(workerID), recorded).Err(); err != nil {
+			loggerFrom(ctx).Warn("Cannot record bulkhead job, a crash loses it", "error", err)
+		} else {
+			job.recorded = string(recorded)
+		}
+	}
+
+	GaugeBulkheadQueued.WithLabelValues(b.jobType).Inc()
+	select {
+	case b.jobs <- job:
+		return
+	default:
+	}
+	GaugeBulkheadQueued.WithLabelValues(b.jobType).Dec()
+	CounterBulkheadRejections.WithLabelValues(b.jobType).Inc()
+	skipJob(ctx, msg, fmt.Errorf("rejected: bulkhead of job type %q is full", b.jobType))
+	respond(ctx, bulkheads.rdb, msg)
+	forgetBulkheadJob(bulkheads.rdb, job)
+}
+
+// forgetBulkheadJob drops a job that was answered from the in-flight list.
+func forgetBulkheadJob(rdb *redis.Client, job bulkheadJob) {
+	if job.recorded == "" {
+		return
+	}
+	if err := rdb.LRem(ctx, bulkheadInflightKey(workerID), 1, job.recorded).Err(); err != nil {
+		loggerFrom(job.ctx).Warn("Cannot forget bulkhead job", "error", err)
+	}
+}
+
+// requeueBulkheadJob puts a job that was not processed back at the head of its queue.
+func requeueBulkheadJob(rdb *redis.Client, job bulkheadJob) {
+	pipe := rdb.TxPipeline()
+	requeueJob(pipe, job.entry.Queue, job.entry.Payload)
+	if job.recorded != "" {
+		pipe.LRem(ctx, bulkheadInflightKey(workerID), 1, job.recorded)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		loggerFrom(job.ctx).Error("Cannot requeue bulkhead job on drain", "queue", job.entry.Queue, "error", err)
+		return
+	}
+	CounterBulkheadRequeued.WithLabelValues("drain").Inc()
+}
+
+// requeueBulkheadJobs puts every job waiting for a pool back on its queue, in their order; the
+// jobs running finish normally.
+func requeueBulkheadJobs(rdb *redis.Client) {
+	bulkheads.Lock()
+	defer bulkheads.Unlock()
+	for _, b := range bulkheads.byType {
+		var waiting []bulkheadJob
+		for len(waiting) < cap(b.jobs) {
+			select {
+			case job := <-b.jobs:
+				GaugeBulkheadQueued.WithLabelValues(b.jobType).Dec()
+				waiting = append(waiting, job)
+				continue
+			default:
+			}
+			break
+		}
+		// Each one goes to the head of its queue, the last first
+		for i := len(waiting) - 1; i >= 0; i-- {
+			requeueBulkheadJob(rdb, waiting[i])
+		}
+	}
+}
+
+// startBulkheadReaper requeues the in-flight lists of dead workers every bulkheadReapInterval.
+func startBulkheadReaper(rdb *redis.Client) {
+	go func() {
+		ticker := time.NewTicker(bulkheadReapInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			reapBulkheads(rdb)
+		}
+	}()
+}
+
+// reapBulkheads puts the jobs of every in-flight list whose worker has had no heartbeat for
+// bulkheadReapGrace back at the head of their queues, in their order. Jobs are popped one at a
+// time, so workers reaping the same list side by side requeue each job once.
+func reapBulkheads(rdb *redis.Client) {
+	iter := rdb.Scan(ctx, 0, bulkheadInflightKey("*"), 100).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		id := strings.TrimPrefix(key, bulkheadInflightKey(""))
+		if id == workerID {
+			continue
+		}
+		keys := []string{key, heartbeatKey(id), reapMarkKey(id)}
+		requeued := 0
+		for {
+			nowMs := nowNs() / int64(time.Millisecond)
+			raw, err := reapScript.Run(ctx, rdb, keys, nowMs, bulkheadReapGrace.Milliseconds()).Text()
+			if err != nil {
+				if err != redis.Nil {
+					slog.Warn("Cannot reap bulkhead jobs", "worker", id, "error", err)
+				}
+				break
+			}
+			var entry bulkheadEntry
+			if err := codec.Unmarshal([]byte(raw), &entry); err != nil {
+				slog.Warn("Invalid bulkhead job dropped", "worker", id, "error", err)
+				continue
+			}
+			if err := requeueJob(rdb, entry.Queue, entry.Payload).Err(); err != nil {
+				slog.Warn("Cannot requeue bulkhead job", "worker", id, "queue", entry.Queue, "error", err)
+				_ = rdb.RPush(ctx, key, raw).Err()
+				break
+			}
+			requeued++
+		}
+		if requeued > 0 {
+			CounterBulkheadRequeued.WithLabelValues("dead_worker").Add(float64(requeued))
+			slog.Info("Requeued the bulkhead jobs of a dead worker", "worker", id, "jobs", requeued)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		slog.Warn("Cannot scan bulkhead in-flight lists", "error", err)
+	}
+}
@@ -0,0 +1,140 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// useBulkheads configures settings for the length of the test, with handler running their jobs.
+func useBulkheads(t *testing.T, rdb *redis.Client, settings map[string]Bulkhead, handler Handler) {
+	t.Helper()
+	bulkheads.settings, bulkheads.rdb, bulkheads.handler = settings, rdb, handler
+	t.Cleanup(func() {
+		bulkheads.Lock()
+		defer bulkheads.Unlock()
+		for _, b := range bulkheads.byType {
+			close(b.jobs)
+		}
+		bulkheads.byType, bulkheads.settings, bulkheads.rdb, bulkheads.handler = map[string]*bulkhead{}, nil, nil, nil
+	})
+}
+
+func TestDrainRequeuesJobsWaitingForTheBulkhead(t *testing.T) {
+	srv := startTestRedis(t)
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	useBulkheads(t, srv.Client, map[string]Bulkhead{"slow": {Concurrency: 1, QueueSize: 2}}, func(ctx context.Context, msg *Message) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	})
+
+	b := bulkheadFor("slow")
+	for i, id := range []string{"req-1", "req-2", "req-3"} {
+		msg := &Message{RequestID: id, JobType: "slow"}
+		b.submit(ctx, msg, queueKey, id)
+		if i == 0 {
+			<-started
+		}
+	}
+	t.Cleanup(func() { draining.Store(false) })
+	if err := applyControl(srv.Client, ControlMessage{Action: controlDrain}); err != nil {
+		t.Fatal(err)
+	}
+
+	queued, _ := srv.Client.LRange(ctx, queueKey, 0, -1).Result()
+	if len(queued) != 2 || queued[0] != "req-2" || queued[1] != "req-3" {
+		t.Fatalf("queue = %v, want the waiting jobs back in order", queued)
+	}
+	if inflight, _ := srv.Client.LLen(ctx, bulkheadInflightKey(workerID)).Result(); inflight != 1 {
+		t.Fatalf("%d jobs in flight, want the running one only", inflight)
+	}
+
+	close(release)
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if inflight, _ := srv.Client.LLen(ctx, bulkheadInflightKey(workerID)).Result(); inflight == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the processed job stayed in flight")
+		}
+	}
+}
+
+func TestDeadWorkersBulkheadJobsAreRequeued(t *testing.T) {
+	srv := startTestRedis(t)
+	for _, id := range []string{"req-1", "req-2"} {
+		entry, _ := codec.Marshal(bulkheadEntry{Queue: queueKey, Payload: []byte(id)})
+		srv.Client.RPush(ctx, bulkheadInflightKey("dead-worker"), entry)
+	}
+	entry, _ := codec.Marshal(bulkheadEntry{Queue: queueKey, Payload: []byte("req-3")})
+	srv.Client.RPush(ctx, bulkheadInflightKey("live-worker"), entry)
+	srv.Client.Set(ctx, heartbeatKey("live-worker"), "{}", time.Minute)
+
+	// The dead worker's heartbeat is only found missing on the first pass
+	reapBulkheads(srv.Client)
+	if queued, _ := srv.Client.LLen(ctx, queueKey).Result(); queued != 0 {
+		t.Fatalf("%d jobs requeued as soon as the heartbeat was found missing", queued)
+	}
+	srv.Clock.Advance(bulkheadReapGrace)
+	reapBulkheads(srv.Client)
+
+	queued, _ := srv.Client.LRange(ctx, queueKey, 0, -1).Result()
+	if len(queued) != 2 || queued[0] != "req-1" || queued[1] != "req-2" {
+		t.Fatalf("queue = %v, want the dead worker's jobs in order", queued)
+	}
+	if inflight, _ := srv.Client.LLen(ctx, bulkheadInflightKey("live-worker")).Result(); inflight != 1 {
+		t.Fatalf("a live worker's job was requeued")
+	}
+}
+
+func TestRequeuedJobIsRestampedAtTheHeadOfItsQueue(t *testing.T) {
+	srv := startTestRedis(t)
+	// The gateway pushed three jobs: a worker that died took the first, another one the second
+	payloads := map[string][]byte{}
+	for seq, id := range []string{"req-1", "req-2", "req-3"} {
+		payloads[id], _ = codec.Marshal(&Message{RequestID: id})
+		srv.Client.HSet(ctx, jobInfoKey(id), "queue_key", queueKey, "queue_seq", seq+1)
+	}
+	srv.Client.Set(ctx, queueKey+":seq", 3, 0)
+	srv.Client.RPush(ctx, queueKey, payloads["req-3"])
+	entry, _ := codec.Marshal(bulkheadEntry{Queue: queueKey, Payload: payloads["req-1"]})
+	srv.Client.RPush(ctx, bulkheadInflightKey("dead-worker"), entry)
+
+	reapBulkheads(srv.Client)
+	srv.Clock.Advance(bulkheadReapGrace)
+	reapBulkheads(srv.Client)
+
+	// The gateway's position is the job's sequence minus the jobs taken off, pushed minus length
+	length, _ := srv.Client.LLen(ctx, queueKey).Result()
+	for id, want := range map[string]int64{"req-1": 1, "req-3": 2} {
+		seq, _ := srv.Client.HGet(ctx, jobInfoKey(id), "queue_seq").Int64()
+		if position := seq - (3 - length); position != want {
+			t.Errorf("%s: position %d, want %d", id, position, want)
+		}
+	}
+}
+
+func TestWorkerMissingAFewHeartbeatsKeepsItsBulkheadJobs(t *testing.T) {
+	srv := startTestRedis(t)
+	entry, _ := codec.Marshal(bulkheadEntry{Queue: queueKey, Payload: []byte("req-1")})
+	srv.Client.RPush(ctx, bulkheadInflightKey("flaky-worker"), entry)
+
+	// Its heartbeat is missing on every other pass, never for the whole grace
+	for pass := range 4 {
+		if pass%2 == 1 {
+			srv.Client.Set(ctx, heartbeatKey("flaky-worker"), "{}", 3*heartbeatInterval)
+		}
+		reapBulkheads(srv.Client)
+		srv.Clock.Advance(bulkheadReapGrace)
+	}
+
+	if queued, _ := srv.Client.LLen(ctx, queueKey).Result(); queued != 0 {
+		t.Fatalf("%d jobs of a live worker requeued", queued)
+	}
+	if inflight, _ := srv.Client.LLen(ctx, bulkheadInflightKey("flaky-worker")).Result(); inflight != 1 {
+		t.Fatalf("%d jobs in flight, want the worker's job kept", inflight)
+	}
+}
@@ -0,0 +1,298 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// --- HTTP Callout Handler ---
+
+// errCircuitOpen is returned without calling the downstream while its breaker is open.
+var errCircuitOpen = errors.New("circuit open")
+
+// calloutRequest is the body POSTed to the downstream service.
+type calloutRequest struct {
+	RequestID string `json:"request_id"`
+	Tenant    string `json:"tenant,omitempty"`
+	JobType   string `json:"job_type,omitempty"`
+	Content   string `json:"content"`
+	Binary    bool   `json:"binary,omitempty"`
+}
+
+// calloutResponse is the body expected back; a missing content leaves the original one.
+// Binary content is base64 encoded, in both directions.
+type calloutResponse struct {
+	Content *string `json:"content"`
+	Binary  bool    `json:"binary"`
+	Result  bool    `json:"result"`
+}
+
+// breaker is a consecutive-failure circuit breaker. After threshold failures it opens for
+// cooldown, then lets a single probe through (half-open) whose outcome closes or reopens it.
+type breaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+const (
+	circuitClosed = iota
+	circuitHalfOpen
+	circuitOpen
+)
+
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return true
+	}
+	if clock.Now().Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	GaugeDownstreamCircuit.WithLabelValues(b.name).Set(circuitHalfOpen)
+	return true
+}
+
+func (b *breaker) record(ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if ok {
+		b.failures = 0
+		GaugeDownstreamCircuit.WithLabelValues(b.name).Set(circuitClosed)
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = clock.Now().Add(b.cooldown)
+		GaugeDownstreamCircuit.WithLabelValues(b.name).Set(circuitOpen)
+	}
+}
+
+// downstream is one configured target URL with its own breaker and, when CALLOUT_LIMITS
+// limits it, its own limiter.
+type downstream struct {
+	name      string
+	transport transport
+	breaker   *breaker
+	limiter   *downstreamLimiter
+}
+
+// transport makes one call to a downstream: body is the encoded calloutRequest and answer the
+// encoded calloutResponse. outcome labels the call's duration, and faulted tells a failure of
+// the downstream (counted by its breaker, and retried) from a rejection of the job or a local
+// error.
+type transport interface {
+	call(ctx context.Context, body []byte) (answer []byte, outcome string, faulted bool, err error)
+}
+
+// httpTransport POSTs the request as JSON.
+type httpTransport struct {
+	client *http.Client
+	url    string
+}
+
+func (t *httpTransport) call(ctx context.Context, body []byte) ([]byte, string, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, "error", false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, "error", true, err
+	}
+	defer resp.Body.Close()
+
+	answer, err := io.ReadAll(io.LimitReader(resp.Body, int64(envInt("CALLOUT_MAX_RESPONSE", 1<<20))))
+	if err != nil {
+		return nil, "error", true, err
+	}
+	outcome := strconv.Itoa(resp.StatusCode)
+	switch {
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return nil, outcome, true, fmt.Errorf("downstream answered %d", resp.StatusCode)
+	case resp.StatusCode >= 300:
+		// The downstream is healthy, it just rejected this job
+		return nil, outcome, false, fmt.Errorf("downstream answered %d", resp.StatusCode)
+	}
+	return answer, outcome, false, nil
+}
+
+// newCalloutHandler forwards each job to a downstream service instead of processing it
+// locally. CALLOUT_URL is the default target and CALLOUT_ROUTES (a JSON object) maps job types
+// to their own URLs: http(s):// URLs are POSTed the request as JSON, grpc:// (grpcs:// for TLS)
+// ones are called over gRPC, see grpcTransport. Connection errors, 5xx/429 answers and gRPC's
+// transient codes are retried up to CALLOUT_RETRIES times with exponential backoff from
+// CALLOUT_BACKOFF, within the fleet's retry budget. Every downstream host, however many routes
+// lead to it, has one circuit breaker opening after CALLOUT_BREAKER_FAILURES consecutive
+// failures for CALLOUT_BREAKER_COOLDOWN, and CALLOUT_LIMITS may cap its rate and concurrency.
+func newCalloutHandler(rdb *redis.Client) (Handler, error) {
+	threshold := envInt("CALLOUT_BREAKER_FAILURES", 5)
+	cooldown := envDuration("CALLOUT_BREAKER_COOLDOWN", 30*time.Second)
+	limits, err := parseDownstreamLimits()
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: envDuration("CALLOUT_TIMEOUT", 10*time.Second)}
+	// Routes to the same host share its breaker and limiter, as they load the same service
+	breakers := map[string]*breaker{}
+	limiters := map[string]*downstreamLimiter{}
+	newDownstream := func(raw string) (*downstream, error) {
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid downstream URL %q", raw)
+		}
+		var t transport = &httpTransport{client: client, url: raw}
+		if u.Scheme == "grpc" || u.Scheme == "grpcs" {
+			if t, err = newGRPCTransport(u, client.Timeout); err != nil {
+				return nil, err
+			}
+		}
+		if _, ok := breakers[u.Host]; !ok {
+			breakers[u.Host] = &breaker{name: u.Host, threshold: threshold, cooldown: cooldown}
+			limiters[u.Host] = newDownstreamLimiter(u.Host, limits)
+		}
+		return &downstream{
+			name:      u.Host,
+			transport: t,
+			breaker:   breakers[u.Host],
+			limiter:   limiters[u.Host],
+		}, nil
+	}
+
+	var fallback *downstream
+	if raw := envString("CALLOUT_URL", ""); raw != "" {
+		d, err := newDownstream(raw)
+		if err != nil {
+			return nil, err
+		}
+		fallback = d
+	}
+	routes := map[string]*downstream{}
+	if raw := envString("CALLOUT_ROUTES", ""); raw != "" {
+		var urls map[string]string
+		if err := codec.Unmarshal([]byte(raw), &urls); err != nil {
+			return nil, fmt.Errorf("invalid CALLOUT_ROUTES: %w", err)
+		}
+		for jobType, u := range urls {
+			d, err := newDownstream(u)
+			if err != nil {
+				return nil, err
+			}
+			routes[jobType] = d
+		}
+	}
+	if fallback == nil && len(routes) == 0 {
+		return nil, errors.New("CALLOUT_URL or CALLOUT_ROUTES is required for the callout handler")
+	}
+
+	retries := envInt("CALLOUT_RETRIES", 2)
+	backoff := envDuration("CALLOUT_BACKOFF", 200*time.Millisecond)
+
+	return func(ctx context.Context, msg *Message) error {
+		data := &msg.Data
+		target, ok := routes[msg.JobType]
+		if !ok {
+			target = fallback
+		}
+		if target == nil {
+			return fmt.Errorf("no downstream for job type %q", msg.JobType)
+		}
+
+		request := calloutRequest{
+			RequestID: msg.RequestID,
+			Tenant:    msg.Tenant,
+			JobType:   msg.JobType,
+			Content:   data.Content,
+			Binary:    data.Binary,
+		}
+		if data.File != nil {
+			// Downstreams take files inline, as binary content
+			input, err := data.open(ctx, rdb)
+			if err != nil {
+				return err
+			}
+			raw, err := io.ReadAll(input)
+			if err != nil {
+				return err
+			}
+			request.Content, request.Binary = base64.StdEncoding.EncodeToString(raw), true
+		}
+		body, err := codec.Marshal(request)
+		if err != nil {
+			return err
+		}
+
+		var answer []byte
+		recordCall(ctx, rdb, target.name)
+		for attempt := 0; ; attempt++ {
+			var retryable bool
+			answer, retryable, err = callDownstream(ctx, target, body)
+			if err == nil || !retryable || attempt >= retries || !spendRetry(ctx, rdb, target.name) {
+				break
+			}
+			CounterDownstreamRetries.WithLabelValues(target.name).Inc()
+			select {
+			case <-time.After(backoff << attempt):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		var resp calloutResponse
+		if err := codec.Unmarshal(answer, &resp); err != nil {
+			return fmt.Errorf("invalid downstream response: %w", err)
+		}
+		if resp.Content != nil {
+			data.Content, data.Binary, data.File = *resp.Content, resp.Binary, nil
+		}
+		data.Result = resp.Result
+		return nil
+	}, nil
+}
+
+// callDownstream makes a single call through the target's limiter and breaker and reports
+// whether a failure is worth retrying. Every call the breaker allowed is recorded, so a failed
+// half-open probe can't leave it open for good.
+func callDownstream(ctx context.Context, target *downstream, body []byte) ([]byte, bool, error) {
+	// The limiter goes first: a shed call must not leave the breaker half-open
+	if target.limiter != nil {
+		release, err := target.limiter.acquire(ctx)
+		if err != nil {
+			return nil, false, err
+		}
+		defer release()
+	}
+	if !target.breaker.allow() {
+		return nil, false, errCircuitOpen
+	}
+
+	started := clock.Now()
+	answer, outcome, faulted, err := target.transport.call(ctx, body)
+	HistogramDownstreamDuration.WithLabelValues(target.name, outcome).
+		Observe(float64(since(started).Microseconds()) / 1000)
+	target.breaker.record(!faulted)
+	return answer, faulted, err
+}
@@ -0,0 +1,69 @@
+package worker
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// --- gRPC Callout Transport ---
+
+// A grpc://host:port/package.Service/Method downstream is called with a unary RPC to that
+// method, its messages being the same calloutRequest and calloutResponse as over HTTP, encoded
+// as JSON (content-type application/grpc+json) so downstreams need no generated stubs;
+// grpcs:// dials with TLS. Unavailable, deadline exceeded, resource exhausted, aborted,
+// internal and unknown errors count as the downstream's faults, the other codes as rejections.
+
+// jsonCodec encodes gRPC messages with the worker's codec.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return codec.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return codec.Unmarshal(data, v) }
+func (jsonCodec) Name() string                       { return "json" }
+
+// grpcTransport calls one method of a downstream over a shared connection.
+type grpcTransport struct {
+	conn    *grpc.ClientConn
+	method  string
+	timeout time.Duration
+}
+
+func newGRPCTransport(u *url.URL, timeout time.Duration) (*grpcTransport, error) {
+	if u.Path == "" || u.Path == "/" {
+		return nil, fmt.Errorf("invalid downstream URL %q: the path must name the method, /package.Service/Method", u.String())
+	}
+	creds := insecure.NewCredentials()
+	if u.Scheme == "grpcs" {
+		creds = credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	}
+	// Connects lazily, on the first call
+	conn, err := grpc.NewClient(u.Host, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("invalid downstream URL %q: %w", u.String(), err)
+	}
+	return &grpcTransport{conn: conn, method: u.Path, timeout: timeout}, nil
+}
+
+func (t *grpcTransport) call(ctx context.Context, body []byte) ([]byte, string, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	var answer json.RawMessage
+	err := t.conn.Invoke(ctx, t.method, json.RawMessage(body), &answer, grpc.ForceCodec(jsonCodec{}))
+	code := status.Code(err)
+	switch code {
+	case codes.OK:
+		return answer, code.String(), false, nil
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted, codes.Internal, codes.Unknown:
+		return nil, code.String(), true, err
+	}
+	return nil, code.String(), false, err
+}
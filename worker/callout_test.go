@@ -0,0 +1,149 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeTransport answers every call with err, faulted as given.
+type fakeTransport struct {
+	faulted bool
+	err     error
+}
+
+func (t fakeTransport) call(context.Context, []byte) ([]byte, string, bool, error) {
+	return nil, "error", t.faulted, t.err
+}
+
+func TestHalfOpenProbeFailingLocallyClosesTheBreaker(t *testing.T) {
+	srv := startTestRedis(t)
+	b := &breaker{name: "downstream", threshold: 1, cooldown: time.Second}
+	b.record(false)
+	srv.Clock.Advance(2 * time.Second)
+
+	target := &downstream{name: "downstream", breaker: b, transport: fakeTransport{err: errors.New("bad request")}}
+	if _, _, err := callDownstream(ctx, target, nil); err == nil || err == errCircuitOpen {
+		t.Fatalf("probe err = %v, want the transport's", err)
+	}
+	if !b.allow() {
+		t.Fatal("the breaker stayed open after a probe the downstream did not fail")
+	}
+}
+
+// startGRPCDownstream serves every method by uppercasing the request's content, answering
+// code when it is not OK.
+func startGRPCDownstream(t *testing.T, code codes.Code) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer(grpc.ForceServerCodec(jsonCodec{}), grpc.UnknownServiceHandler(func(_ any, stream grpc.ServerStream) error {
+		var request calloutRequest
+		if err := stream.RecvMsg(&request); err != nil {
+			return err
+		}
+		if code != codes.OK {
+			return status.Error(code, "refused")
+		}
+		content := strings.ToUpper(request.Content)
+		return stream.SendMsg(calloutResponse{Content: &content, Result: true})
+	}))
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+	return lis.Addr().String()
+}
+
+func TestCalloutOverGRPC(t *testing.T) {
+	srv := startTestRedis(t)
+	for name, c := range codecs {
+		t.Run(name, func(t *testing.T) {
+			prev := codec
+			codec = c
+			t.Cleanup(func() { codec = prev })
+			t.Setenv("CALLOUT_URL", "grpc://"+startGRPCDownstream(t, codes.OK)+"/validate.Callout/Process")
+			handler, err := newCalloutHandler(srv.Client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg := &Message{RequestID: "req-1", Data: Data{Content: "hello"}}
+			if err := handler(ctx, msg); err != nil {
+				t.Fatal(err)
+			}
+			if msg.Data.Content != "HELLO" || !msg.Data.Result {
+				t.Fatalf("data = %+v", msg.Data)
+			}
+		})
+	}
+}
+
+func TestGRPCRejectionsDoNotTripTheBreaker(t *testing.T) {
+	startTestRedis(t)
+	target := &downstream{name: "downstream", breaker: &breaker{name: "downstream", threshold: 1, cooldown: time.Minute}}
+	// A rejection first: it would find the breaker open after the fault
+	for _, code := range []codes.Code{codes.InvalidArgument, codes.Unavailable} {
+		u, _ := url.Parse("grpc://" + startGRPCDownstream(t, code) + "/validate.Callout/Process")
+		transport, err := newGRPCTransport(u, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		target.transport = transport
+		body, _ := json.Marshal(calloutRequest{RequestID: "req-1", Content: "hello"})
+		if _, retryable, err := callDownstream(ctx, target, body); status.Code(err) != code || retryable != (code == codes.Unavailable) {
+			t.Fatalf("%s: err = %v, retryable %v", code, err, retryable)
+		}
+	}
+	if target.breaker.allow() {
+		t.Fatal("the breaker is closed after an unavailable downstream")
+	}
+}
+
+func TestRoutesToOneHostShareItsBreakerAndLimiter(t *testing.T) {
+	srv := startTestRedis(t)
+	for _, tc := range []struct {
+		name, limits string
+		want         func(error) bool
+	}{
+		{"breaker", "", func(err error) bool { return err == errCircuitOpen }},
+		{"limiter", `{"*": {"rate": 0.001, "max_wait_ms": 1}}`, func(err error) bool {
+			var shed *downstreamShedError
+			return errors.As(err, &shed)
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+			t.Cleanup(downstream.Close)
+			t.Setenv("CALLOUT_ROUTES", `{"a": "`+downstream.URL+`/a", "b": "`+downstream.URL+`/b"}`)
+			t.Setenv("CALLOUT_LIMITS", tc.limits)
+			t.Setenv("CALLOUT_RETRIES", "0")
+			t.Setenv("CALLOUT_BREAKER_FAILURES", "1")
+			handler, err := newCalloutHandler(srv.Client)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := handler(ctx, &Message{RequestID: "req-1", JobType: "a"}); err == nil {
+				t.Fatal("the failing downstream succeeded")
+			}
+			if err := handler(ctx, &Message{RequestID: "req-2", JobType: "b"}); !tc.want(err) || calls != 1 {
+				t.Fatalf("route b: err = %v after %d calls, want it stopped by route a's %s", err, calls, tc.name)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package worker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Chunked Results ---
+
+// resultChunkSize is the largest content sent inline with the response; bigger results are
+// published as validate:result:<id>:<n> chunks for the gateway to reassemble. 0 disables it.
+var resultChunkSize = intTunable("RESULT_CHUNK_SIZE", 1<<20)
+
+// ChunkRef describes a result published in chunks: chunk n is stored at Key:n. Checksums holds
+// the CRC-32 of every chunk and SHA256 the digest of the whole content.
+type ChunkRef struct {
+	Key       string   `json:"key"`
+	Count     int      `json:"count"`
+	Size      int64    `json:"size"`
+	SHA256    string   `json:"sha256"`
+	Checksums []uint32 `json:"checksums"`
+}
+
+func chunkKey(requestId string) string {
+	return "validate:result:" + requestId
+}
+
+// publishChunks moves oversized content out of msg into chunk keys that expire with the
+// response. Chunks are whole base64 quanta, so binary content decodes chunk by chunk.
+func publishChunks(rdb *redis.Client, msg *Message) error {
+	size := resultChunkSize.Get() / 4 * 4
+	content := msg.Data.Content
+	if size <= 0 || len(content) <= size {
+		return nil
+	}
+
+	digest := sha256.Sum256([]byte(content))
+	ref := &ChunkRef{
+		Key:    chunkKey(msg.RequestID),
+		Size:   int64(len(content)),
+		SHA256: hex.EncodeToString(digest[:]),
+	}
+	pipe := rdb.TxPipeline()
+	for offset := 0; offset < len(content); offset += size {
+		chunk := content[offset:min(offset+size, len(content))]
+		key := fmt.Sprintf("%s:%d", ref.Key, ref.Count)
+		pipe.Set(ctx, key, chunk, responseTTL.Get())
+		ref.Checksums = append(ref.Checksums, crc32.ChecksumIEEE([]byte(chunk)))
+		ref.Count++
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	msg.Data.Content, msg.Data.Chunks = "", ref
+	return nil
+}
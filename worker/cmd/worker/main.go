@@ -0,0 +1,9 @@
+// Command worker pulls jobs from the queue, processes them and pushes the results back, see the
+// worker package for its configuration.
+package main
+
+import worker "go-async-worker"
+
+func main() {
+	worker.Main()
+}
@@ -0,0 +1,49 @@
+package worker
+
+import (
+	stdjson "encoding/json"
+	"fmt"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// --- Codec ---
+
+// Codec is the single injection point for (de)serializing envelopes.
+// Both the pulled requests and the pushed responses go through the active codec.
+type Codec interface {
+	Name() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+type jsoniterCodec struct{ api jsoniter.API }
+
+func (c jsoniterCodec) Name() string                       { return "jsoniter" }
+func (c jsoniterCodec) Marshal(v any) ([]byte, error)      { return c.api.Marshal(v) }
+func (c jsoniterCodec) Unmarshal(data []byte, v any) error { return c.api.Unmarshal(data, v) }
+
+type stdCodec struct{}
+
+func (stdCodec) Name() string                       { return "std" }
+func (stdCodec) Marshal(v any) ([]byte, error)      { return stdjson.Marshal(v) }
+func (stdCodec) Unmarshal(data []byte, v any) error { return stdjson.Unmarshal(data, v) }
+
+var codecs = map[string]Codec{
+	"jsoniter": jsoniterCodec{api: jsoniter.ConfigFastest},
+	"std":      stdCodec{},
+}
+
+// codec is the active codec. The default is picked by build tag (see codec_default*.go)
+// and can be overridden at runtime with the CODEC env variable.
+var codec Codec = codecs[defaultCodec]
+
+func initCodec() error {
+	name := envString("CODEC", defaultCodec)
+	selected, ok := codecs[name]
+	if !ok {
+		return fmt.Errorf("unknown codec %q", name)
+	}
+	codec = selected
+	return nil
+}
@@ -0,0 +1,5 @@
+//go:build !codec_std
+
+package worker
+
+const defaultCodec = "jsoniter"
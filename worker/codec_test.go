@@ -0,0 +1,39 @@
+package worker
+
+import "testing"
+
+// The codec benchmarks run on the golden envelopes, see envelope_test.go; compare them with
+// go test -bench Codec -benchmem, e.g. before changing the default codec.
+
+func BenchmarkCodecMarshal(b *testing.B) {
+	msg := goldenResponse()
+	for name, c := range codecs {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := c.Marshal(msg); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkCodecUnmarshal(b *testing.B) {
+	payload, err := stdCodec{}.Marshal(goldenResponse())
+	if err != nil {
+		b.Fatal(err)
+	}
+	for name, c := range codecs {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(payload)))
+			for i := 0; i < b.N; i++ {
+				var msg Message
+				if err := c.Unmarshal(payload, &msg); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// --- Configuration ---
+
+var (
+	// responseTTL bounds how long an unconsumed response may stay in Redis.
+	responseTTL = durationTunable("RESPONSE_TTL", time.Hour)
+
+	// concurrency is how many jobs this worker processes side by side, WORKER_CONCURRENCY read
+	// by Main.
+	concurrency = 1
+
+	// queueKey is the queue this worker consumes; shadow workers use validate:queue:shadow.
+	queueKey = regionQueueKey(envString("QUEUE_KEY", "validate:queue"), workerRegion)
+
+	// logLevel is "debug", "info" (logs every job, the default) or "warn" (errors only).
+	logLevel = stringTunable("LOG_LEVEL", "info", "debug", "info", "warn")
+)
+
+// tunable is a setting the config reloader may change while the worker runs. Its startup
+// value comes from the environment and is restored when the override is removed.
+type tunable[T comparable] struct {
+	key      string
+	fallback T
+	parse    func(string) (T, error)
+	value    atomic.Pointer[T]
+}
+
+// tunables holds every tunable by environment key, for the config reloader.
+var tunables = map[string]interface {
+	apply(raw string) (changed bool, err error)
+	check() error
+	String() string
+}{}
+
+func newTunable[T comparable](key string, fallback T, parse func(string) (T, error)) *tunable[T] {
+	t := &tunable[T]{key: key, fallback: fallback, parse: parse}
+	t.value.Store(&t.fallback)
+	tunables[key] = t
+	return t
+}
+
+// Get returns the current value.
+func (t *tunable[T]) Get() T {
+	return *t.value.Load()
+}
+
+func (t *tunable[T]) String() string {
+	return fmt.Sprint(t.Get())
+}
+
+// apply sets the value parsed from raw, or the startup value when raw is empty.
+func (t *tunable[T]) apply(raw string) (bool, error) {
+	next := t.fallback
+	if raw != "" {
+		parsed, err := t.parse(raw)
+		if err != nil {
+			return false, fmt.Errorf("invalid %s=%q: %w", t.key, raw, err)
+		}
+		next = parsed
+	}
+	if next == t.Get() {
+		return false, nil
+	}
+	t.value.Store(&next)
+	return true, nil
+}
+
+// check validates the startup value the same way an override is validated.
+func (t *tunable[T]) check() error {
+	if _, err := t.parse(fmt.Sprint(t.fallback)); err != nil {
+		return fmt.Errorf("invalid %s=%v: %w", t.key, t.fallback, err)
+	}
+	return nil
+}
+
+func intTunable(key string, fallback int) *tunable[int] {
+	return newTunable(key, envInt(key, fallback), func(raw string) (int, error) {
+		value, err := strconv.Atoi(raw)
+		if err == nil && value < 1 {
+			err = errors.New("must be at least 1")
+		}
+		return value, err
+	})
+}
+
+func durationTunable(key string, fallback time.Duration) *tunable[time.Duration] {
+	return newTunable(key, envDuration(key, fallback), func(raw string) (time.Duration, error) {
+		value, err := time.ParseDuration(raw)
+		if err == nil && value <= 0 {
+			err = errors.New("must be positive")
+		}
+		return value, err
+	})
+}
+
+// stringTunable accepts any value, or only one of allowed when given.
+func stringTunable(key, fallback string, allowed ...string) *tunable[string] {
+	return newTunable(key, envString(key, fallback), func(raw string) (string, error) {
+		if len(allowed) > 0 && !slices.Contains(allowed, raw) {
+			return "", fmt.Errorf("must be one of %s", strings.Join(allowed, ", "))
+		}
+		return raw, nil
+	})
+}
+
+// envString returns the value of the environment variable key, or fallback when unset.
+func envString(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+// envDuration parses the environment variable key as a time.Duration, or returns fallback when unset.
+// A malformed value is a deployment mistake, so it is reported and the fallback is used.
+func envDuration(key string, fallback time.Duration) time.Duration {
+	raw := envString(key, "")
+	if raw == "" {
+		return fallback
+	}
+	value, err := time.ParseDuration(raw)
+	if err != nil {
+		slog.Warn("Invalid config, using the default", "key", key, "value", raw, "default", fallback)
+		return fallback
+	}
+	return value
+}
+
+// envInt parses the environment variable key as an int, or returns fallback when unset.
+func envInt(key string, fallback int) int {
+	raw := envString(key, "")
+	if raw == "" {
+		return fallback
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		slog.Warn("Invalid config, using the default", "key", key, "value", raw, "default", fallback)
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,108 @@
+package worker
+
+import (
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Control Channel ---
+
+// The gateway's admin API broadcasts commands to every worker and gateway on a Pub/Sub
+// channel. Every receiver acknowledges in validate:control:ack:<id> with "ok", "ignored" (the
+// command doesn't concern it) or "error: ...", so the admin API can tell who applied it.
+
+// controlChannel carries ControlMessages to every worker and gateway.
+const controlChannel = "validate:control"
+
+// controlAckTTL bounds how long acknowledgments are kept.
+const controlAckTTL = time.Hour
+
+const (
+	controlDrain             = "drain"
+	controlResume            = "resume"
+	controlReloadConfig      = "reload_config"
+	controlFlushCaches       = "flush_caches"
+	controlLogLevel          = "log_level"
+	controlInvalidateSession = "invalidate_session"
+)
+
+// ControlMessage is an instruction to the whole fleet. Key selects an affinity key
+// (invalidate_session, "" means every key), Value carries the level of log_level.
+type ControlMessage struct {
+	ID     string `json:"id"`
+	Action string `json:"action"`
+	Key    string `json:"key,omitempty"`
+	Value  string `json:"value,omitempty"`
+}
+
+func controlAckKey(id string) string {
+	return "validate:control:ack:" + id
+}
+
+// draining stops the consumers from pulling new jobs; jobs in progress finish normally.
+var draining atomic.Bool
+
+// startControlListener applies the control messages and acknowledges them.
+func startControlListener(rdb *redis.Client) {
+	sub := rdb.Subscribe(ctx, controlChannel)
+	go func() {
+		for m := range sub.Channel() {
+			var control ControlMessage
+			if err := codec.Unmarshal([]byte(m.Payload), &control); err != nil {
+				slog.Warn("Invalid control message", "error", err)
+				continue
+			}
+			result := "ok"
+			if err := applyControl(rdb, control); err != nil {
+				result = "error: " + err.Error()
+			}
+			slog.Info("Control message", "id", control.ID, "action", control.Action, "result", result)
+			if control.ID == "" {
+				continue
+			}
+			pipe := rdb.Pipeline()
+			pipe.HSet(ctx, controlAckKey(control.ID), "worker:"+workerID, result)
+			pipe.Expire(ctx, controlAckKey(control.ID), controlAckTTL)
+			if _, err := pipe.Exec(ctx); err != nil {
+				slog.Warn("Cannot acknowledge control message", "id", control.ID, "error", err)
+			}
+		}
+	}()
+}
+
+func applyControl(rdb *redis.Client, control ControlMessage) error {
+	switch control.Action {
+	case controlDrain:
+		draining.Store(true)
+		GaugeDraining.Set(1)
+		requeueBulkheadJobs(rdb)
+	case controlResume:
+		draining.Store(false)
+		GaugeDraining.Set(0)
+	case controlReloadConfig:
+		reloadConfig(rdb)
+	case controlFlushCaches:
+		sessions.invalidate("")
+	case controlLogLevel:
+		// Holds until the config changes
+		if _, err := logLevel.apply(control.Value); err != nil {
+			return err
+		}
+	case controlInvalidateSession:
+		sessions.invalidate(control.Key)
+	default:
+		return fmt.Errorf("unknown action %q", control.Action)
+	}
+	return nil
+}
+
+// waitWhileDraining blocks a consumer for as long as the worker is drained.
+func waitWhileDraining() {
+	for draining.Load() {
+		time.Sleep(time.Second)
+	}
+}
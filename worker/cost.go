@@ -0,0 +1,26 @@
+package worker
+
+// --- Cost Attribution ---
+
+const costUnattributed = "unattributed"
+
+// CostLabels attribute a job's cost, set by the gateway from the caller's API key or headers.
+type CostLabels struct {
+	Team       string `json:"team,omitempty"`
+	CostCenter string `json:"cost_center,omitempty"`
+}
+
+// recordCost adds the job's processing time to its team and cost center.
+func recordCost(msg *Message) {
+	team, costCenter := costUnattributed, costUnattributed
+	if msg.Cost != nil && msg.Cost.Team != "" {
+		team = msg.Cost.Team
+	}
+	if msg.Cost != nil && msg.Cost.CostCenter != "" {
+		costCenter = msg.Cost.CostCenter
+	}
+	pulled, pushed := msg.Meta.At(stageWorkerRequestPulled), msg.Meta.At(stageWorkerResponsePushed)
+	if pulled > 0 && pushed > pulled {
+		CounterCostProcessingMs.WithLabelValues(team, costCenter).Add(float64(pushed-pulled) / 1e6)
+	}
+}
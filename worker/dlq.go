@@ -0,0 +1,78 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Panics and Dead Letters ---
+
+const dlqKey = "validate:dlq"
+
+// dlqMaxLength caps validate:dlq; past it the oldest dead letters are dropped, so a drained
+// stale backlog (STALE_JOB_POLICY=archive) can't fill Redis.
+var dlqMaxLength = intTunable("DLQ_MAX_LENGTH", 10000)
+
+// panicError is a handler panic turned into an error, with the stack where it happened.
+type panicError struct {
+	value any
+	stack string
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.value)
+}
+
+// recoverPanic turns a panic of the calling goroutine into *err; use as defer recoverPanic(&err).
+func recoverPanic(err *error) {
+	if value := recover(); value != nil {
+		CounterPanics.Inc()
+		*err = &panicError{value: value, stack: string(debug.Stack())}
+	}
+}
+
+// DeadLetter is what lands on validate:dlq for a job that can't be processed.
+type DeadLetter struct {
+	Message  *Message `json:"message"`
+	Error    string   `json:"error"`
+	Stack    string   `json:"stack,omitempty"`
+	WorkerID string   `json:"worker_id"`
+	FailedAt int64    `json:"failed_at_ms"`
+}
+
+// deadLetter parks msg on the DLQ when err is a panic; other failures are only answered.
+func deadLetter(ctx context.Context, rdb *redis.Client, msg *Message, err error) {
+	var panicked *panicError
+	if !errors.As(err, &panicked) {
+		return
+	}
+	pushDeadLetter(ctx, rdb, DeadLetter{
+		Message:  msg,
+		Error:    panicked.Error(),
+		Stack:    panicked.stack,
+		WorkerID: workerID,
+		FailedAt: clock.Now().UnixMilli(),
+	})
+}
+
+// pushDeadLetter parks entry on the DLQ, its content and error redacted, dropping the oldest
+// dead letters past dlqMaxLength.
+func pushDeadLetter(ctx context.Context, rdb *redis.Client, entry DeadLetter) {
+	entry.Message = redactedMessage(redactDLQ, entry.Message)
+	entry.Error = redact(redactDLQ, entry.Error)
+	payload, err := codec.Marshal(entry)
+	if err == nil {
+		// Redis transaction: RPush + LTrim, so the DLQ never outgrows its cap
+		pipe := rdb.TxPipeline()
+		pipe.RPush(ctx, dlqKey, payload)
+		pipe.LTrim(ctx, dlqKey, -int64(dlqMaxLength.Get()), -1)
+		_, err = pipe.Exec(ctx)
+	}
+	if err != nil {
+		loggerFrom(ctx).Error("DLQ push failed", "error", err)
+	}
+}
@@ -0,0 +1,48 @@
+package worker
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestDeadLettersAreRedacted(t *testing.T) {
+	srv := startTestRedis(t)
+	prev := redaction
+	t.Cleanup(func() { redaction = prev })
+	t.Setenv("REDACT_FIELDS", "card")
+	t.Setenv("REDACT_PATTERNS", `["\\d{3}-\\d{4}"]`)
+	redaction.fields, redaction.patterns = nil, nil
+	if err := initRedaction(); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := pulledJob(srv, `{"card":"4111111111111111","phone":"555-1234"}`, 0)
+	pushDeadLetter(ctx, srv.Client, DeadLetter{Message: msg, Error: "panic: bad phone 555-1234", WorkerID: workerID})
+
+	payload, err := srv.Client.LPop(ctx, dlqKey).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if regexp.MustCompile(`4111|555-1234`).MatchString(payload) || !strings.Contains(payload, redaction.mask) {
+		t.Fatalf("dead letter %s still holds redacted values", payload)
+	}
+	if !strings.Contains(msg.Data.Content, "555-1234") {
+		t.Fatalf("job content was changed to %s, only the dead letter must be redacted", msg.Data.Content)
+	}
+}
+
+func TestDeadLetterQueueIsCapped(t *testing.T) {
+	srv := startTestRedis(t)
+	setTunable(t, dlqMaxLength, "3")
+
+	for i := range 5 {
+		pushDeadLetter(ctx, srv.Client, DeadLetter{Message: pulledJob(srv, "hello", 0), Error: fmt.Sprint(i)})
+	}
+
+	entries, _ := srv.Client.LRange(ctx, dlqKey, 0, -1).Result()
+	if len(entries) != 3 || !strings.Contains(entries[0], `"error":"2"`) {
+		t.Fatalf("DLQ = %v, want the newest 3 dead letters", entries)
+	}
+}
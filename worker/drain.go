@@ -0,0 +1,100 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Stale Backlog Draining ---
+
+// After an outage the queue holds jobs whose callers gave up long ago. STALE_JOB_POLICY decides
+// what happens to a job pulled more than STALE_JOB_AGE after the gateway received it: "process"
+// runs it anyway, "fail" answers it as failed without running the handler, and "archive" parks
+// it on the DLQ unanswered, so the backlog drains at pull speed instead of handler speed. The
+// DLQ keeps the newest DLQ_MAX_LENGTH dead letters only.
+//
+// MAX_QUEUE_AGE bounds the wait between the gateway's push and the pull on its own: a job
+// queued longer is answered as expired whatever the policy, so its caller gets an expired
+// result right away rather than a success nobody can use anymore.
+
+const (
+	stalePolicyProcess = "process"
+	stalePolicyFail    = "fail"
+	stalePolicyArchive = "archive"
+)
+
+var (
+	staleJobPolicy = stringTunable("STALE_JOB_POLICY", stalePolicyProcess, stalePolicyProcess, stalePolicyFail, stalePolicyArchive)
+
+	// staleJobAge should match the gateway's WAIT_TIMEOUT, past which nobody waits for the job.
+	staleJobAge = durationTunable("STALE_JOB_AGE", 5*time.Minute)
+
+	// maxQueueAge is the longest a job may wait between the gateway's push and this worker's
+	// pull before it is answered as expired instead of processed, 0 for no limit.
+	maxQueueAge = newTunable("MAX_QUEUE_AGE", envDuration("MAX_QUEUE_AGE", 0), func(raw string) (time.Duration, error) {
+		value, err := time.ParseDuration(raw)
+		if err == nil && value < 0 {
+			err = errors.New("must not be negative")
+		}
+		return value, err
+	})
+)
+
+// staleJobError tells how long a stale job waited.
+type staleJobError struct {
+	age time.Duration
+	// expired is set when the job waited in the queue past MAX_QUEUE_AGE rather than STALE_JOB_AGE.
+	expired bool
+}
+
+// Error starts with "expired:" for an expired job, which the gateway relies on.
+func (e *staleJobError) Error() string {
+	if e.expired {
+		return fmt.Sprintf("expired: queued for %s, past MAX_QUEUE_AGE %s", e.age.Round(time.Millisecond), maxQueueAge.Get())
+	}
+	return fmt.Sprintf("stale job: received %s ago, past STALE_JOB_AGE %s", e.age.Round(time.Millisecond), staleJobAge.Get())
+}
+
+// archived reports whether the job is parked on the DLQ instead of answered.
+func (e *staleJobError) archived() bool {
+	return !e.expired && staleJobPolicy.Get() == stalePolicyArchive
+}
+
+// checkStaleJob returns a *staleJobError for a job queued longer than maxQueueAge, or older than
+// staleJobAge unless stale jobs are processed anyway.
+func checkStaleJob(msg *Message) error {
+	pulled := msg.Meta.At(stageWorkerRequestPulled)
+	if limit, pushed := maxQueueAge.Get(), msg.Meta.At(stageRestRequestPushed); limit > 0 && pushed > 0 {
+		if age := time.Duration(pulled - pushed); age > limit {
+			CounterExpiredJobs.Inc()
+			return &staleJobError{age: age, expired: true}
+		}
+	}
+
+	policy := staleJobPolicy.Get()
+	received := msg.Meta.At(stageRestRequestReceived)
+	if policy == stalePolicyProcess || received == 0 {
+		return nil
+	}
+	age := time.Duration(pulled - received)
+	if age <= staleJobAge.Get() {
+		return nil
+	}
+	CounterStaleJobs.WithLabelValues(policy).Inc()
+	return &staleJobError{age: age}
+}
+
+// archiveStaleJob parks a stale job on the DLQ instead of answering it.
+func archiveStaleJob(ctx context.Context, rdb *redis.Client, msg *Message, err *staleJobError) {
+	pushDeadLetter(ctx, rdb, DeadLetter{
+		Message:  msg,
+		Error:    err.Error(),
+		WorkerID: workerID,
+		FailedAt: clock.Now().UnixMilli(),
+	})
+	loggerFrom(ctx).Info("Archived stale job", "age", err.age)
+}
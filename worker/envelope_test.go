@@ -0,0 +1,123 @@
+package worker
+
+import (
+	"bytes"
+	stdjson "encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// The envelope golden files are shared with the gateway, whose tests read what the worker
+// writes and write what the worker reads. Run go test -update in either module after an
+// intended envelope change, and check the diff.
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+const envelopeDir = "../testdata/envelope"
+
+// goldenResponse is the answer the worker pushes for the gateway's request.json.
+func goldenResponse() *Message {
+	msg := &Message{
+		RequestID:       "7f9c2ba4-e88f-4b1a-9d2c-3a1f0e6b5d4c",
+		TraceID:         "4bf92f3577b34da6a3ce929d0e0e4736",
+		ReplyTo:         "validate:replies:gw-1",
+		ReplyVia:        "pubsub",
+		Tenant:          "acme",
+		JobType:         "uppercase",
+		Geo:             "DE",
+		Region:          "eu",
+		Flags:           []string{"new-rules"},
+		Cost:            &CostLabels{Team: "payments", CostCenter: "cc-42"},
+		Affinity:        "session-9",
+		DependsOn:       []string{"a1b2c3"},
+		QueueDeadlineNs: 1700000030000000000,
+		Worker:          &WorkerInfo{Hostname: "host-1", InstanceID: "worker-1", Version: "1.4.0"},
+		Data:            Data{Content: "HÉLLO WÖRLD", Result: true},
+	}
+	msg.Meta.Stages = []StageEvent{
+		{Name: stageRestRequestReceived, TsNs: 1700000000000000000},
+		{Name: stageRestRequestEnriched, TsNs: 1700000000000100000},
+		{Name: stageRestRequestPushed, TsNs: 1700000000000200000},
+		{Name: stageWorkerRequestPulled, TsNs: 1700000000005000000},
+		{Name: stageWorkerResponsePushed, TsNs: 1700000000007000000},
+	}
+	msg.Meta.Attempts = []Attempt{{WorkerID: "worker-1", StartNs: 1700000000005000000, EndNs: 1700000000007000000}}
+	return msg
+}
+
+// checkGolden compares the JSON got to the golden file name, ignoring key order and spacing,
+// or rewrites the file with -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join(envelopeDir, name)
+	if *update {
+		pretty, _ := stdjson.MarshalIndent(decodeJSON(t, got), "", "  ")
+		if err := os.WriteFile(path, append(pretty, '\n'), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decodeJSON(t, got), decodeJSON(t, want)) {
+		t.Fatalf("envelope differs from %s:\n got: %s\nwant: %s", path, got, want)
+	}
+}
+
+// decodeJSON decodes data generically, keeping numbers exact: timestamps do not fit a float64.
+func decodeJSON(t *testing.T, data []byte) any {
+	t.Helper()
+	dec := stdjson.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestResponseEnvelopeGolden(t *testing.T) {
+	for name, c := range codecs {
+		t.Run(name, func(t *testing.T) {
+			payload, err := c.Marshal(goldenResponse())
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, "response.json", payload)
+		})
+	}
+}
+
+func TestRequestEnvelopeDecodes(t *testing.T) {
+	want := goldenResponse()
+	want.Worker, want.Data, want.Meta.Attempts = nil, Data{Content: "héllo wörld"}, nil
+	want.Meta.Stages = want.Meta.Stages[:3]
+	// request_legacy.json is what a gateway wrote before Meta had stages.
+	for _, file := range []string{"request.json", "request_legacy.json"} {
+		payload, err := os.ReadFile(filepath.Join(envelopeDir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, c := range codecs {
+			t.Run(file+"/"+name, func(t *testing.T) {
+				var msg Message
+				if err := c.Unmarshal(payload, &msg); err != nil {
+					t.Fatal(err)
+				}
+				if file == "request_legacy.json" {
+					if !reflect.DeepEqual(msg.Meta.Stages, want.Meta.Stages) || !reflect.DeepEqual(msg.Data, want.Data) {
+						t.Fatalf("got stages %+v data %+v, want %+v %+v", msg.Meta.Stages, msg.Data, want.Meta.Stages, want.Data)
+					}
+					return
+				}
+				if !reflect.DeepEqual(&msg, want) {
+					t.Fatalf("decoded %+v, want %+v", msg, *want)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"plugin"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// --- External Handlers ---
+
+// limitedBuffer keeps at most max bytes and remembers whether anything was cut off.
+type limitedBuffer struct {
+	bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); len(p) > room {
+		b.truncated = true
+		if room > 0 {
+			b.Buffer.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// newExecHandler runs EXEC_COMMAND (a JSON array, or a space separated command line) per job.
+// The content is written to stdin and stdout becomes the new content; exit code 0 means the
+// content is valid. The command runs with an empty environment in a throwaway directory, its
+// output is capped at EXEC_MAX_OUTPUT bytes and the whole process group is killed after
+// EXEC_TIMEOUT.
+func newExecHandler(rdb *redis.Client) (Handler, error) {
+	raw := envString("EXEC_COMMAND", "")
+	if raw == "" {
+		return nil, errors.New("EXEC_COMMAND is required for the exec handler")
+	}
+	var argv []string
+	if strings.HasPrefix(raw, "[") {
+		if err := codec.Unmarshal([]byte(raw), &argv); err != nil {
+			return nil, fmt.Errorf("invalid EXEC_COMMAND: %w", err)
+		}
+	} else {
+		argv = strings.Fields(raw)
+	}
+	if len(argv) == 0 {
+		return nil, errors.New("EXEC_COMMAND is empty")
+	}
+	path, err := exec.LookPath(argv[0])
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := envDuration("EXEC_TIMEOUT", 10*time.Second)
+	maxOutput := envInt("EXEC_MAX_OUTPUT", 1<<20)
+
+	return func(ctx context.Context, msg *Message) error {
+		data := &msg.Data
+		dir, err := os.MkdirTemp("", "job-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+
+		ctxTimeout, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		stdout := &limitedBuffer{max: maxOutput}
+		stderr := &limitedBuffer{max: 4096}
+		cmd := exec.CommandContext(ctxTimeout, path, argv[1:]...)
+		cmd.Dir = dir
+		cmd.Env = []string{}
+		input, err := data.open(ctx, rdb)
+		if err != nil {
+			return fmt.Errorf("invalid content: %w", err)
+		}
+		cmd.Stdin = input
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+		isolateProcess(cmd)
+
+		err = cmd.Run()
+		if ctxTimeout.Err() != nil {
+			return fmt.Errorf("command timed out after %s", timeout)
+		}
+		if stdout.truncated {
+			return fmt.Errorf("command output exceeds %d bytes", maxOutput)
+		}
+
+		var exitErr *exec.ExitError
+		switch {
+		case err == nil:
+			data.setBytes(stdout.Bytes())
+			data.Result = true
+		case errors.As(err, &exitErr):
+			// A non-zero exit is a verdict on the content, not a handler failure
+			data.setBytes(stdout.Bytes())
+			data.Result = false
+		default:
+			return fmt.Errorf("command failed: %w: %s", err, stderr.String())
+		}
+		return nil
+	}, nil
+}
+
+// newPluginHandler loads the Go plugin at PLUGIN_PATH, which must export
+//
+//	func Handle(content string) (string, bool, error)
+//
+// Plugins share the worker's process, so unlike exec they get no isolation or timeout.
+func newPluginHandler(_ *redis.Client) (Handler, error) {
+	p, err := plugin.Open(envString("PLUGIN_PATH", "handler.so"))
+	if err != nil {
+		return nil, err
+	}
+	symbol, err := p.Lookup("Handle")
+	if err != nil {
+		return nil, err
+	}
+	handle, ok := symbol.(func(string) (string, bool, error))
+	if !ok {
+		return nil, fmt.Errorf("plugin Handle has type %T, want func(string) (string, bool, error)", symbol)
+	}
+
+	return func(_ context.Context, msg *Message) error {
+		data := &msg.Data
+		if !data.text() {
+			return errBinaryUnsupported
+		}
+		content, result, err := handle(data.Content)
+		if err != nil {
+			return err
+		}
+		data.Content, data.Result = content, result
+		return nil
+	}, nil
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package worker
+
+import (
+	"os/exec"
+)
+
+// isolateProcess relies on the default exec.CommandContext kill where process groups aren't available.
+func isolateProcess(*exec.Cmd) {}
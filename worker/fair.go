@@ -0,0 +1,216 @@
+package worker
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Fair Scheduling ---
+
+// With TENANT_QUEUES=on the gateway pushes every tenant's jobs to <queue>:tenant:<tenant> and
+// lists the tenant in <queue>:tenants; jobs without a tenant stay on the queue itself. With
+// FAIR_SCHEDULING=on the worker serves these queues by deficit round robin: each visit grants a
+// queue its tenant's weight in jobs, and a queue found empty forfeits what is left, so a
+// tenant flooding its queue gets its share and no more while the others have work.
+
+var (
+	// fairScheduling is "on" to serve the tenant queues next to queueKey, "off" to pop
+	// queueKey alone.
+	fairScheduling = envString("FAIR_SCHEDULING", "off")
+
+	// tenantRefreshInterval is how often the tenant set is re-read.
+	tenantRefreshInterval = envDuration("TENANT_REFRESH_INTERVAL", 5*time.Second)
+)
+
+// tenantWeights holds the jobs per turn of every tenant, from TENANT_WEIGHTS (a JSON object of
+// tenant to weight, "*" applies to tenants without an entry of their own, default 1).
+var tenantWeights = map[string]int{}
+
+// dropEmptyTenantScript removes a tenant from the set only while its queue is empty, so it
+// can't race the gateway pushing the tenant's next job.
+var dropEmptyTenantScript = redis.NewScript(`
+if redis.call('LLEN', KEYS[2]) == 0 then
+	return redis.call('SREM', KEYS[1], ARGV[1])
+end
+return 0
+`)
+
+func tenantQueueKey(queue, tenant string) string {
+	return queue + ":tenant:" + tenant
+}
+
+func tenantsKey(queue string) string {
+	return queue + ":tenants"
+}
+
+func initFairScheduling() error {
+	switch fairScheduling {
+	case "on", "off":
+	default:
+		return fmt.Errorf("FAIR_SCHEDULING must be on or off, got %q", fairScheduling)
+	}
+	raw := envString("TENANT_WEIGHTS", "")
+	if raw == "" {
+		return nil
+	}
+	if err := codec.Unmarshal([]byte(raw), &tenantWeights); err != nil {
+		return fmt.Errorf("invalid TENANT_WEIGHTS: %w", err)
+	}
+	for tenant, weight := range tenantWeights {
+		if weight < 1 {
+			return fmt.Errorf("invalid TENANT_WEIGHTS for %q: weight must be at least 1", tenant)
+		}
+	}
+	return nil
+}
+
+func tenantWeight(tenant string) int {
+	if weight, ok := tenantWeights[tenant]; ok {
+		return weight
+	}
+	if weight, ok := tenantWeights["*"]; ok {
+		return weight
+	}
+	return 1
+}
+
+// fairScheduler is shared by the consumers of this worker; every worker runs its own, which
+// keeps the fleet fair as long as each worker is.
+type fairScheduler struct {
+	mu        sync.Mutex
+	queues    []string
+	tenants   map[string]string
+	cursor    int
+	credit    int
+	refreshed time.Time
+}
+
+var scheduler = &fairScheduler{}
+
+// popJob blocks until a job is available and returns its queue and payload.
+func popJob(rdb *redis.Client) (string, string, error) {
+	if fairScheduling != "on" {
+		if affinityPartitions == 0 {
+			result, err := rdb.BLPop(ctx, 0, queueKey).Result()
+			if err != nil {
+				return queueKey, "", err
+			}
+			return result[0], result[1], nil
+		}
+		return popWithPartitions(rdb)
+	}
+	for {
+		queue, payload, err := scheduler.next(rdb)
+		if err != nil || payload != "" {
+			return queue, payload, err
+		}
+		// Every queue was empty: wait for any of them instead of polling
+		result, err := rdb.BLPop(ctx, time.Second, scheduler.snapshot()...).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return queueKey, "", err
+		}
+		return result[0], result[1], nil
+	}
+}
+
+// next pops the next job in deficit round robin order, or returns an empty payload when all
+// queues are empty.
+func (s *fairScheduler) next(rdb *redis.Client) (string, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if since(s.refreshed) >= tenantRefreshInterval {
+		if err := s.refresh(rdb); err != nil {
+			return queueKey, "", err
+		}
+	}
+
+	for visited := 0; visited <= len(s.queues); {
+		queue := s.queues[s.cursor]
+		if s.credit == 0 {
+			s.credit = tenantWeight(s.tenants[queue])
+		}
+		payload, err := rdb.LPop(ctx, queue).Result()
+		if err == redis.Nil {
+			// An empty queue forfeits the rest of its turn
+			s.advance()
+			visited++
+			continue
+		}
+		if err != nil {
+			return queue, "", err
+		}
+		s.credit--
+		if s.credit == 0 {
+			s.advance()
+		}
+		return queue, payload, nil
+	}
+	return queueKey, "", nil
+}
+
+func (s *fairScheduler) advance() {
+	s.cursor = (s.cursor + 1) % len(s.queues)
+	s.credit = 0
+}
+
+// refresh re-reads the tenant set, dropping the tenants whose queue is empty.
+func (s *fairScheduler) refresh(rdb *redis.Client) error {
+	tenants, err := rdb.SMembers(ctx, tenantsKey(queueKey)).Result()
+	if err != nil {
+		return err
+	}
+	slices.Sort(tenants)
+
+	queues := append([]string{queueKey}, owned.get(rdb)...)
+	byQueue := map[string]string{queueKey: ""}
+	for _, tenant := range tenants {
+		queue := tenantQueueKey(queueKey, tenant)
+		dropped, err := dropEmptyTenantScript.Run(ctx, rdb, []string{tenantsKey(queueKey), queue}, tenant).Int()
+		if err == nil && dropped > 0 {
+			continue
+		}
+		queues = append(queues, queue)
+		byQueue[queue] = tenant
+	}
+
+	current := ""
+	if len(s.queues) > 0 {
+		current = s.queues[s.cursor]
+	}
+	s.queues, s.tenants, s.refreshed = queues, byQueue, clock.Now()
+	// Keep the turn of the queue being served when it survived the refresh
+	if i := slices.Index(queues, current); i >= 0 {
+		s.cursor = i
+	} else {
+		s.cursor, s.credit = 0, 0
+	}
+	return nil
+}
+
+// snapshot returns the queues currently served.
+func (s *fairScheduler) snapshot() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.queues)
+}
+
+// observeQueueWait records how long msg was queued, overall and under its tenant.
+func observeQueueWait(msg *Message) {
+	wait, ok := queueWait(msg)
+	if !ok {
+		return
+	}
+	tenant := msg.Tenant
+	if tenant == "" {
+		tenant = "none"
+	}
+	HistogramStageQueueWait.Observe(wait)
+	HistogramTenantQueueWait.WithLabelValues(tenant).Observe(wait)
+}
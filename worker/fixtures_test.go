@@ -0,0 +1,56 @@
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// fixture is a request the gateway recorded with FIXTURE_DIR and the result it got, see the
+// gateway's Fixture.
+type fixture struct {
+	Redacted bool `json:"redacted"`
+	Request  Data `json:"request"`
+	Response Data `json:"response"`
+}
+
+// TestReplayFixtures runs the gateway's checked-in fixtures through the real handler and the
+// job processing, so recorded traffic guards the worker code too.
+func TestReplayFixtures(t *testing.T) {
+	paths, err := filepath.Glob("../testdata/fixtures/*.json")
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no fixtures: %v", err)
+	}
+	srv := startTestRedis(t)
+	handler, err := newUppercaseHandler(srv.Client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var f fixture
+			if err := codec.Unmarshal(raw, &f); err != nil {
+				t.Fatalf("invalid fixture: %v", err)
+			}
+
+			msg := pulledJob(srv, f.Request.Content, 0)
+			msg.Data = f.Request
+			processJob(ctx, srv.Client, handler, msg)
+
+			got := response(t, srv.Client, msg).Data
+			if f.Redacted {
+				// The handler saw the redacted content, so only the verdict is comparable
+				got.Content = f.Response.Content
+			}
+			if !reflect.DeepEqual(got, f.Response) {
+				t.Errorf("result differs\n  want %+v\n  got  %+v", f.Response, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package worker
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessJobAnswersTheGateway(t *testing.T) {
+	srv := startTestRedis(t)
+	handler, err := newUppercaseHandler(srv.Client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := pulledJob(srv, "hello", time.Second)
+	processJob(ctx, srv.Client, handler, msg)
+
+	resp := response(t, srv.Client, msg)
+	if resp.Data.Content != "HELLO" || !resp.Data.Result {
+		t.Fatalf("got %q result=%v, want HELLO result=true", resp.Data.Content, resp.Data.Result)
+	}
+	pulled, pushed := resp.Meta.At(stageWorkerRequestPulled), resp.Meta.At(stageWorkerResponsePushed)
+	if want := srv.Clock.Now().UnixNano(); pulled != want || pushed != want {
+		t.Fatalf("pulled at %d, pushed at %d, want both at the fake clock's %d", pulled, pushed, want)
+	}
+}
+
+func TestResponseKeyExpiresWithTheClock(t *testing.T) {
+	srv := startTestRedis(t)
+	handler, _ := newUppercaseHandler(srv.Client)
+
+	processJob(ctx, srv.Client, handler, pulledJob(srv, "hello", 0))
+	key := "validate:response:req-1"
+	if !srv.Exists(key) {
+		t.Fatal("no response pushed")
+	}
+	srv.Clock.Advance(responseTTL.Get() + time.Second)
+	if srv.Exists(key) {
+		t.Fatalf("response key outlived RESPONSE_TTL %s", responseTTL.Get())
+	}
+}
+
+func TestStaleJobFailsWithoutRunningTheHandler(t *testing.T) {
+	srv := startTestRedis(t)
+	setTunable(t, staleJobPolicy, stalePolicyFail)
+	ran := false
+	handler := func(_ context.Context, _ *Message) error {
+		ran = true
+		return nil
+	}
+
+	msg := pulledJob(srv, "hello", staleJobAge.Get()+time.Second)
+	processJob(ctx, srv.Client, handler, msg)
+
+	resp := response(t, srv.Client, msg)
+	if ran || resp.Data.Result {
+		t.Fatalf("stale job ran=%v result=%v, want neither", ran, resp.Data.Result)
+	}
+	if n := len(resp.Meta.Attempts); n != 1 || !strings.HasPrefix(resp.Meta.Attempts[0].Error, "stale job") {
+		t.Fatalf("attempts = %+v, want the single stale job attempt", resp.Meta.Attempts)
+	}
+}
+
+func TestQueueAgeExpiresWhateverTheStalePolicy(t *testing.T) {
+	srv := startTestRedis(t)
+	setTunable(t, staleJobPolicy, stalePolicyArchive)
+	setTunable(t, maxQueueAge, "1s")
+	handler := func(_ context.Context, _ *Message) error {
+		t.Fatal("handler ran for an expired job")
+		return nil
+	}
+
+	msg := pulledJob(srv, "hello", staleJobAge.Get()+time.Second)
+	processJob(ctx, srv.Client, handler, msg)
+
+	resp := response(t, srv.Client, msg)
+	if n := len(resp.Meta.Attempts); n != 1 || !strings.HasPrefix(resp.Meta.Attempts[0].Error, "expired:") {
+		t.Fatalf("attempts = %+v, want the single expired attempt", resp.Meta.Attempts)
+	}
+	if archived, _ := srv.Client.LLen(ctx, dlqKey).Result(); archived != 0 {
+		t.Fatalf("%d jobs archived, want the expired job answered", archived)
+	}
+}
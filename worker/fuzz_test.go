@@ -0,0 +1,39 @@
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// FuzzDecodeMessage feeds arbitrary queue payloads to the job decoder and the checks run on
+// every pulled job, under every codec: a producer's bytes must never crash the worker, and
+// what decodes must encode again for the response.
+// Fuzz with -fuzzminimizetime=5s: the golden seeds are large, and minimizing every new input
+// for the default minute stalls the run.
+func FuzzDecodeMessage(f *testing.F) {
+	files, _ := filepath.Glob(filepath.Join(envelopeDir, "*.json"))
+	for _, file := range files {
+		payload, err := os.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(payload)
+	}
+	f.Add([]byte(`{"meta":{"stages":null},"data":{"content":""}}`))
+	f.Add([]byte(`{"request_id":1}`))
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		for _, c := range codecs {
+			var msg Message
+			if c.Unmarshal(payload, &msg) != nil {
+				continue
+			}
+			msg.Meta.Mark(stageWorkerRequestPulled)
+			_ = checkStaleJob(&msg)
+			_ = checkQueueDeadline(&msg)
+			if _, err := c.Marshal(&msg); err != nil {
+				t.Fatalf("%s: decoded %q but cannot encode it again: %v", c.Name(), payload, err)
+			}
+		}
+	})
+}
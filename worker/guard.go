@@ -0,0 +1,122 @@
+package worker
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Job Guardrails ---
+
+// errResourceExceeded marks a job aborted by its guardrails; it is not retried.
+var errResourceExceeded = errors.New("resource_exceeded")
+
+// resourceError tells which guardrail aborted a job; it matches errResourceExceeded.
+type resourceError struct {
+	reason string // "wall_clock" or "memory"
+	detail string
+}
+
+func (e *resourceError) Error() string        { return "resource_exceeded: " + e.detail }
+func (e *resourceError) Is(target error) bool { return target == errResourceExceeded }
+
+var (
+	// jobTimeout is the wall-clock budget of one attempt (0 = none).
+	jobTimeout = envDuration("JOB_TIMEOUT", 0)
+
+	// jobMaxMemory aborts running jobs while the worker's memory use is above it, in bytes
+	// (0 = no sampling). Memory can't be attributed to a job, so with WORKER_CONCURRENCY > 1
+	// every job running at that moment is aborted.
+	jobMaxMemory = int64(envInt("JOB_MAX_MEMORY", 0))
+
+	// memorySampleInterval is how often memory use is sampled while a job runs.
+	memorySampleInterval = envDuration("JOB_MEMORY_SAMPLE", 100*time.Millisecond)
+)
+
+// runGuarded runs one attempt of handler under the guardrails. The handler works on a copy of
+// msg, so a runaway handler that ignores cancellation can't touch the message after it was
+// given up on; its data is only taken over when it finishes in time.
+func runGuarded(ctx context.Context, handler Handler, msg *Message) error {
+	jobCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	if jobTimeout > 0 {
+		var cancelTimeout context.CancelFunc
+		jobCtx, cancelTimeout = context.WithTimeoutCause(jobCtx, jobTimeout,
+			&resourceError{reason: "wall_clock", detail: fmt.Sprintf("wall-clock timeout %s", jobTimeout)})
+		defer cancelTimeout()
+	}
+
+	work := *msg
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		defer func() { done <- err }()
+		defer recoverPanic(&err)
+		err = handler(jobCtx, &work)
+	}()
+
+	var sample <-chan time.Time
+	if jobMaxMemory > 0 {
+		ticker := time.NewTicker(memorySampleInterval)
+		defer ticker.Stop()
+		sample = ticker.C
+	}
+
+	for {
+		select {
+		case err := <-done:
+			if cause := context.Cause(jobCtx); cause != nil && errors.Is(cause, errResourceExceeded) {
+				return cause
+			}
+			msg.Data = work.Data
+			return err
+		case <-jobCtx.Done():
+			return abandon(context.Cause(jobCtx))
+		case <-sample:
+			if used := memoryInUse(); used > jobMaxMemory {
+				cancel(&resourceError{reason: "memory", detail: fmt.Sprintf("memory %d bytes above %d", used, jobMaxMemory)})
+				return abandon(context.Cause(jobCtx))
+			}
+		}
+	}
+}
+
+// abandon reports a job given up on; its handler is left to notice the cancellation.
+func abandon(cause error) error {
+	reason := "cancelled"
+	var exceeded *resourceError
+	if errors.As(cause, &exceeded) {
+		reason = exceeded.reason
+	}
+	CounterResourceExceeded.WithLabelValues(reason).Inc()
+	return cause
+}
+
+// memoryInUse returns the container's memory use from cgroup v2 when available (it includes
+// child processes of the exec handler), otherwise the worker's own resident set size.
+func memoryInUse() int64 {
+	if raw, err := os.ReadFile("/sys/fs/cgroup/memory.current"); err == nil {
+		if used, err := strconv.ParseInt(strings.TrimSpace(string(raw)), 10, 64); err == nil {
+			return used
+		}
+	}
+
+	file, err := os.Open("/proc/self/status")
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) >= 2 && fields[0] == "VmRSS:" {
+			kb, _ := strconv.ParseInt(fields[1], 10, 64)
+			return kb * 1024
+		}
+	}
+	return 0
+}
@@ -0,0 +1,65 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+	"golang.org/x/text/unicode/norm"
+)
+
+// --- Handlers ---
+
+// Handler processes one job, updating msg.Data in place. ctx is cancelled when the job runs
+// out of time or resources; long running handlers must give up when it is.
+type Handler func(ctx context.Context, msg *Message) error
+
+var handlers = map[string]func(rdb *redis.Client) (Handler, error){
+	"uppercase": newUppercaseHandler,
+	"rules":     newRulesHandler,
+	"exec":      newExecHandler,
+	"plugin":    newPluginHandler,
+	"wasm":      newWasmHandler,
+	"callout":   newCalloutHandler,
+}
+
+// initHandler picks the job handler from the HANDLER env variable.
+func initHandler(rdb *redis.Client) (Handler, error) {
+	name := envString("HANDLER", "uppercase")
+	factory, ok := handlers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown handler %q", name)
+	}
+	return factory(rdb)
+}
+
+// newUppercaseHandler is the sample handler: it upper-cases the content with Unicode-aware
+// case mapping for the LOCALE language tag (e.g. "tr" maps i to İ). Invalid UTF-8 is replaced
+// with U+FFFD. The text is mapped decomposed (NFD), so locale rules that drop accents (Greek
+// drops the tonos) see them even on precomposed letters like ΐ, and comes out NFC, so
+// combining sequences and expansions like ß -> SS end in a stable form.
+func newUppercaseHandler(_ *redis.Client) (Handler, error) {
+	tag, err := language.Parse(envString("LOCALE", "und"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid LOCALE: %w", err)
+	}
+	caser := cases.Upper(tag)
+
+	return func(_ context.Context, msg *Message) error {
+		data := &msg.Data
+		if !data.text() {
+			return errBinaryUnsupported
+		}
+		content := data.Content
+		if !utf8.ValidString(content) {
+			content = strings.ToValidUTF8(content, string(utf8.RuneError))
+		}
+		data.Content = norm.NFC.String(caser.String(norm.NFD.String(content)))
+		data.Result = true
+		return nil
+	}, nil
+}
@@ -0,0 +1,83 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"testing/quick"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// upperCased runs the uppercase handler for LOCALE locale on content.
+func upperCased(t *testing.T, locale, content string) string {
+	t.Helper()
+	t.Setenv("LOCALE", locale)
+	handler, err := newUppercaseHandler(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &Message{Data: Data{Content: content}}
+	if err := handler(context.Background(), msg); err != nil || !msg.Data.Result {
+		t.Fatalf("handler failed on %q: %v", content, err)
+	}
+	return msg.Data.Content
+}
+
+func TestUppercaseProperties(t *testing.T) {
+	for _, locale := range []string{"und", "tr", "lt", "el"} {
+		t.Run(locale, func(t *testing.T) {
+			// Arbitrary bytes, so invalid UTF-8 is drawn as often as valid text
+			property := func(raw []byte, text string) bool {
+				for _, content := range []string{string(raw), text} {
+					upper := upperCased(t, locale, content)
+					if !utf8.ValidString(upper) || !norm.NFC.IsNormalString(upper) {
+						return false
+					}
+					if upperCased(t, locale, upper) != upper {
+						return false
+					}
+				}
+				return true
+			}
+			if err := quick.Check(property, &quick.Config{MaxCount: 2000}); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestUppercaseRoundTripsThroughEveryCodec(t *testing.T) {
+	property := func(raw []byte) bool {
+		upper := upperCased(t, "und", string(raw))
+		for _, c := range codecs {
+			payload, err := c.Marshal(&Message{Data: Data{Content: upper}})
+			if err != nil {
+				return false
+			}
+			var msg Message
+			if c.Unmarshal(payload, &msg) != nil || msg.Data.Content != upper {
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 2000}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUppercaseLocales(t *testing.T) {
+	for _, tc := range []struct{ locale, in, want string }{
+		{"und", "straße", "STRASSE"},
+		{"tr", "istanbul", "İSTANBUL"},
+		{"und", "istanbul", "ISTANBUL"},
+		{"und", "é", "É"},
+		{"el", "\u0390", "Ι"},
+		{"und", "bad\xffbyte", "BAD�BYTE"},
+	} {
+		if got := upperCased(t, tc.locale, tc.in); got != tc.want {
+			t.Errorf("LOCALE=%s: %q upper-cased to %q, want %q", tc.locale, tc.in, got, tc.want)
+		}
+	}
+}
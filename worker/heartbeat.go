@@ -0,0 +1,84 @@
+package worker
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Heartbeat ---
+
+const heartbeatInterval = 5 * time.Second
+
+// workerID identifies this worker process across the fleet.
+var workerID = newWorkerID()
+
+// WorkerInfo tells the gateway which worker produced a result.
+type WorkerInfo struct {
+	Hostname   string `json:"hostname"`
+	InstanceID string `json:"instance_id"`
+	Version    string `json:"version"`
+}
+
+var workerInfo = newWorkerInfo()
+
+func newWorkerInfo() *WorkerInfo {
+	host, _ := os.Hostname()
+	return &WorkerInfo{Hostname: host, InstanceID: workerID, Version: version}
+}
+
+func newWorkerID() string {
+	if host, err := os.Hostname(); err == nil && host != "" {
+		return fmt.Sprintf("%s-%s", host, uuid.NewString()[:8])
+	}
+	return uuid.NewString()
+}
+
+// heartbeat is the value of a heartbeat key; liveness only depends on the key existing.
+type heartbeat struct {
+	TsNs  int64     `json:"ts_ns"`
+	Build BuildInfo `json:"build"`
+	// Queue is the queue the worker consumes, which affinity partitions are split by.
+	Queue string `json:"queue"`
+	// Draining workers pull no jobs, see the drain control message.
+	Draining bool `json:"draining,omitempty"`
+	// Region is the data region the worker serves, see WORKER_REGION.
+	Region string `json:"region,omitempty"`
+	// Concurrency and JobMs, the recent mean job time, size the fleet for backlog estimates.
+	Concurrency int     `json:"concurrency"`
+	JobMs       float64 `json:"job_ms,omitempty"`
+}
+
+func heartbeatKey(id string) string {
+	return "validate:worker:" + id
+}
+
+// startHeartbeat keeps validate:worker:<id> alive so gateways can see the live fleet.
+func startHeartbeat(rdb *redis.Client) {
+	key := heartbeatKey(workerID)
+	beat := func() {
+		payload, _ := codec.Marshal(heartbeat{
+			TsNs:        nowNs(),
+			Build:       buildInfo,
+			Queue:       queueKey,
+			Draining:    draining.Load(),
+			Region:      workerRegion,
+			Concurrency: concurrency,
+			JobMs:       meanJobTime(),
+		})
+		_ = rdb.Set(ctx, key, payload, 3*heartbeatInterval).Err()
+	}
+	beat()
+
+	go func() {
+		ticker := time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			beat()
+		}
+	}()
+}
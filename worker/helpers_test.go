@@ -0,0 +1,54 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go-async-worker/redistest"
+)
+
+// startTestRedis points the worker's clock at a fresh miniredis's fake clock, for the length of
+// the test, and returns the server; jobs take its Client explicitly.
+func startTestRedis(t *testing.T) *redistest.Server {
+	t.Helper()
+	srv := redistest.Start(t)
+	prevClock := clock
+	clock = srv.Clock
+	t.Cleanup(func() { clock = prevClock })
+	return srv
+}
+
+// setTunable overrides tun with raw, the way the config reloader does, until the test ends.
+func setTunable[T comparable](t *testing.T, tun *tunable[T], raw string) {
+	t.Helper()
+	if _, err := tun.apply(raw); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tun.apply("") })
+}
+
+// pulledJob returns a job for content the gateway received and pushed now, and the worker
+// pulled after queued.
+func pulledJob(srv *redistest.Server, content string, queued time.Duration) *Message {
+	msg := &Message{RequestID: "req-1", Data: Data{Content: content}}
+	msg.Meta.Mark(stageRestRequestReceived)
+	msg.Meta.Mark(stageRestRequestPushed)
+	srv.Clock.Advance(queued)
+	msg.Meta.Mark(stageWorkerRequestPulled)
+	return msg
+}
+
+// response pops the response the worker pushed for msg.
+func response(t *testing.T, rdb *redis.Client, msg *Message) *Message {
+	t.Helper()
+	payload, err := rdb.LPop(ctx, "validate:response:"+msg.RequestID).Bytes()
+	if err != nil {
+		t.Fatalf("no response for %s: %v", msg.RequestID, err)
+	}
+	var resp Message
+	if err := codec.Unmarshal(payload, &resp); err != nil {
+		t.Fatal(err)
+	}
+	return &resp
+}
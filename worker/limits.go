@@ -0,0 +1,136 @@
+package worker
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// --- Job Type Limits ---
+
+// JobLimit caps one job type: at most Concurrency jobs at once (0 = no cap) and at most Rate
+// job starts per second (0 = no cap), with bursts of up to Burst (default 1).
+type JobLimit struct {
+	Concurrency int     `json:"concurrency"`
+	Rate        float64 `json:"rate"`
+	Burst       int     `json:"burst"`
+}
+
+// jobLimiter enforces the JobLimit of one job type.
+type jobLimiter struct {
+	jobType string
+	slots   chan struct{}
+	bucket  *tokenBucket
+}
+
+// tokenBucket refills rate tokens per second, holding at most burst of them.
+type tokenBucket struct {
+	mu       sync.Mutex
+	rate     float64
+	burst    float64
+	tokens   float64
+	lastFill time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	b := &tokenBucket{rate: rate, burst: float64(max(burst, 1)), lastFill: clock.Now()}
+	b.tokens = b.burst
+	return b
+}
+
+// jobLimiters holds the limiter of every limited job type, from JOB_LIMITS (a JSON object of
+// job type to JobLimit). "*" is the default limit of the job types without an entry of their
+// own, each of them getting a limiter of its own on first use.
+var jobLimiters = struct {
+	sync.Mutex
+	byType map[string]*jobLimiter
+	limits map[string]JobLimit
+}{byType: map[string]*jobLimiter{}}
+
+func initJobLimits() error {
+	raw := envString("JOB_LIMITS", "")
+	if raw == "" {
+		return nil
+	}
+	var limits map[string]JobLimit
+	if err := codec.Unmarshal([]byte(raw), &limits); err != nil {
+		return fmt.Errorf("invalid JOB_LIMITS: %w", err)
+	}
+	for jobType, limit := range limits {
+		if limit.Concurrency < 0 || limit.Rate < 0 || limit.Burst < 0 {
+			return fmt.Errorf("invalid JOB_LIMITS for %q: values must not be negative", jobType)
+		}
+	}
+	jobLimiters.limits = limits
+	return nil
+}
+
+// limiterFor returns the limiter for jobType, creating it on first use, or nil when the job
+// type is not limited.
+func limiterFor(jobType string) *jobLimiter {
+	if jobLimiters.limits == nil {
+		return nil
+	}
+	jobLimiters.Lock()
+	defer jobLimiters.Unlock()
+	if l, ok := jobLimiters.byType[jobType]; ok {
+		return l
+	}
+	limit, ok := jobLimiters.limits[jobType]
+	if !ok {
+		if limit, ok = jobLimiters.limits["*"]; !ok {
+			return nil
+		}
+	}
+	l := &jobLimiter{jobType: jobType, bucket: newTokenBucket(limit.Rate, limit.Burst)}
+	if limit.Concurrency > 0 {
+		l.slots = make(chan struct{}, limit.Concurrency)
+	}
+	jobLimiters.byType[jobType] = l
+	return l
+}
+
+// acquire blocks until the job may start and returns the function releasing its slot.
+func (l *jobLimiter) acquire() func() {
+	if l.bucket.rate > 0 {
+		if wait := l.bucket.take(); wait > 0 {
+			CounterJobLimitWaits.WithLabelValues(l.jobType, "rate").Inc()
+			time.Sleep(wait)
+		}
+	}
+	if l.slots == nil {
+		return func() {}
+	}
+	select {
+	case l.slots <- struct{}{}:
+	default:
+		CounterJobLimitWaits.WithLabelValues(l.jobType, "concurrency").Inc()
+		l.slots <- struct{}{}
+	}
+	GaugeJobsInFlight.WithLabelValues(l.jobType).Inc()
+	return func() {
+		GaugeJobsInFlight.WithLabelValues(l.jobType).Dec()
+		<-l.slots
+	}
+}
+
+// take reserves one token of the bucket and returns how long to wait until it is available.
+func (b *tokenBucket) take() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := clock.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.lastFill).Seconds()*b.rate)
+	b.lastFill = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// refund gives back a token taken for a call that was not made.
+func (b *tokenBucket) refund() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.burst, b.tokens+1)
+}
@@ -0,0 +1,28 @@
+package worker
+
+import "testing"
+
+func TestDefaultJobLimitIsPerJobType(t *testing.T) {
+	jobLimiters.limits = map[string]JobLimit{"*": {Concurrency: 1}}
+	t.Cleanup(func() {
+		jobLimiters.byType, jobLimiters.limits = map[string]*jobLimiter{}, nil
+	})
+
+	a := limiterFor("a")
+	if a == nil || limiterFor("a") != a {
+		t.Fatal("job type a has no limiter of its own")
+	}
+	releaseA := a.acquire()
+	defer releaseA()
+
+	b := limiterFor("b")
+	if b == a {
+		t.Fatal("job types a and b share the default limiter")
+	}
+	select {
+	case b.slots <- struct{}{}:
+		<-b.slots
+	default:
+		t.Fatal("a running job of type a holds the slot of type b")
+	}
+}
@@ -0,0 +1,56 @@
+package worker
+
+import (
+	"context"
+	"log/slog"
+	"os"
+)
+
+// --- Logging ---
+
+// Log lines go through slog, as text or JSON (LOG_FORMAT). Everything logged about a job
+// carries its request_id, trace_id and queue, the same fields the gateway logs, and handlers
+// get the job's logger through their context (loggerFrom).
+
+// logLeveler follows the LOG_LEVEL tunable, so a config reload changes the level right away.
+type logLeveler struct{}
+
+func (logLeveler) Level() slog.Level {
+	switch logLevel.Get() {
+	case "debug":
+		return slog.LevelDebug
+	case "warn":
+		return slog.LevelWarn
+	}
+	return slog.LevelInfo
+}
+
+// initLogging installs the default logger.
+func initLogging() {
+	options := &slog.HandlerOptions{Level: logLeveler{}}
+	var handler slog.Handler = slog.NewTextHandler(os.Stdout, options)
+	if envString("LOG_FORMAT", "text") == "json" {
+		handler = slog.NewJSONHandler(os.Stdout, options)
+	}
+	slog.SetDefault(slog.New(handler).With("service", "worker", "worker_id", workerID))
+}
+
+// jobLogger returns a logger carrying the correlation fields of msg.
+func jobLogger(msg *Message) *slog.Logger {
+	return slog.With("request_id", msg.RequestID, "trace_id", msg.TraceID, "queue", queueKey)
+}
+
+type loggerKey struct{}
+
+// withLogger carries logger in ctx, down to the handlers.
+func withLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// loggerFrom returns the logger carried in ctx, or the default one.
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,320 @@
+package worker
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// --- Metrics ---
+
+var (
+	// Checksum of the effective tunables, equal on workers running the same config
+	GaugeConfigVersion = gauge(prometheus.GaugeOpts{
+		Name: "worker_config_version",
+		Help: "Checksum of the effective tunable config, equal across workers running the same config",
+	})
+
+	// Shadow jobs processed, by result; their results are dropped
+	CounterShadowResults = counterVec(prometheus.CounterOpts{
+		Name: "worker_shadow_results_total",
+		Help: "Total number of shadow jobs processed, by result",
+	}, []string{"result"})
+
+	// Jobs that had to wait for their job type's limit, by job type and limit
+	CounterJobLimitWaits = counterVec(prometheus.CounterOpts{
+		Name: "worker_job_limit_waits_total",
+		Help: "Total number of jobs delayed by their job type's concurrency or rate limit",
+	}, []string{"job_type", "limit"})
+
+	// Jobs running per concurrency-limited job type
+	GaugeJobsInFlight = gaugeVec(prometheus.GaugeOpts{
+		Name: "worker_jobs_in_flight",
+		Help: "Jobs currently running per concurrency-limited job type",
+	}, []string{"job_type"})
+
+	// Jobs waiting for their bulkhead's pool, per job type
+	GaugeBulkheadQueued = gaugeVec(prometheus.GaugeOpts{
+		Name: "worker_bulkhead_queued",
+		Help: "Jobs waiting for a goroutine of their job type's bulkhead",
+	}, []string{"job_type"})
+
+	// Jobs running in their bulkhead's pool, per job type
+	GaugeBulkheadActive = gaugeVec(prometheus.GaugeOpts{
+		Name: "worker_bulkhead_active",
+		Help: "Jobs currently running in their job type's bulkhead",
+	}, []string{"job_type"})
+
+	// Jobs rejected because their bulkhead's pool and queue were full
+	CounterBulkheadRejections = counterVec(prometheus.CounterOpts{
+		Name: "worker_bulkhead_rejections_total",
+		Help: "Total number of jobs answered unprocessed because their job type's bulkhead was full, by job type",
+	}, []string{"job_type"})
+
+	// Bulkhead jobs put back on their queue instead of processed
+	CounterBulkheadRequeued = counterVec(prometheus.CounterOpts{
+		Name: "worker_bulkhead_requeued_total",
+		Help: "Total number of bulkhead jobs put back on their queue unprocessed, by reason (drain, dead_worker)",
+	}, []string{"reason"})
+
+	// Jobs waiting when the worker started
+	GaugeStartupBacklog = gauge(prometheus.GaugeOpts{
+		Name: "worker_startup_backlog_jobs",
+		Help: "Jobs waiting in the worker's queues when it started",
+	})
+
+	// Estimated time for the fleet to work off the startup backlog, NaN when unknown
+	GaugeStartupCatchUp = gauge(prometheus.GaugeOpts{
+		Name: "worker_startup_catch_up_seconds",
+		Help: "Estimated seconds for the live fleet to work off the backlog found at startup",
+	})
+
+	// Slow-start progress after startup, 1 once the worker pulls at full speed
+	GaugeWarmupProgress = gauge(prometheus.GaugeOpts{
+		Name: "worker_warmup_progress",
+		Help: "How far the worker is into its WARMUP_DURATION slow start, from 0 to 1",
+	})
+
+	// Panics recovered while processing jobs
+	CounterPanics = counter(prometheus.CounterOpts{
+		Name: "worker_panics_total",
+		Help: "Total number of panics recovered while processing jobs",
+	})
+
+	// Jobs aborted by their guardrails, by reason
+	CounterResourceExceeded = counterVec(prometheus.CounterOpts{
+		Name: "worker_resource_exceeded_total",
+		Help: "Total number of job attempts aborted by their guardrails, by reason (wall-clock, memory)",
+	}, []string{"reason"})
+
+	// Downstream call latency, by downstream host and outcome
+	HistogramDownstreamDuration = histogramVec(prometheus.HistogramOpts{
+		Name:    "worker_downstream_duration_ms",
+		Help:    "Downstream HTTP call duration in milliseconds, by downstream and outcome",
+		Buckets: []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000},
+	}, []string{"downstream", "outcome"})
+
+	// Downstream retries denied by the fleet's CALLOUT_RETRY_BUDGET_PERCENT
+	CounterDownstreamRetriesDenied = counterVec(prometheus.CounterOpts{
+		Name: "worker_downstream_retries_denied_total",
+		Help: "Total number of downstream retries denied because the fleet's retry budget was spent, by downstream",
+	}, []string{"downstream"})
+
+	// Downstream calls delayed by CALLOUT_LIMITS, i.e. how often a downstream is saturated
+	CounterDownstreamThrottled = counterVec(prometheus.CounterOpts{
+		Name: "worker_downstream_throttled_total",
+		Help: "Total number of downstream calls delayed by the downstream's rate or concurrency limit, by downstream and limit",
+	}, []string{"downstream", "limit"})
+
+	// Downstream calls shed because they would have waited past their limit's max_wait_ms
+	CounterDownstreamShed = counterVec(prometheus.CounterOpts{
+		Name: "worker_downstream_shed_total",
+		Help: "Total number of downstream calls shed without calling the downstream, by downstream and limit",
+	}, []string{"downstream", "limit"})
+
+	// Calls in flight per concurrency-limited downstream
+	GaugeDownstreamInFlight = gaugeVec(prometheus.GaugeOpts{
+		Name: "worker_downstream_in_flight",
+		Help: "Calls currently in flight per concurrency-limited downstream",
+	}, []string{"downstream"})
+
+	// Downstream retries
+	CounterDownstreamRetries = counterVec(prometheus.CounterOpts{
+		Name: "worker_downstream_retries_total",
+		Help: "Total number of retried downstream calls, by downstream",
+	}, []string{"downstream"})
+
+	// Time jobs spent queued, by tenant, to check that fair scheduling shares the workers
+	HistogramTenantQueueWait = histogramVec(prometheus.HistogramOpts{
+		Name:    "worker_tenant_queue_wait_ms",
+		Help:    "Time from the gateway's push to this worker's pull in milliseconds, by tenant (none for jobs without one)",
+		Buckets: []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000, 30000, 60000},
+	}, []string{"tenant"})
+
+	// Pipeline stages timed by the worker itself, so jobs whose callers gave up are measured too
+	HistogramStageQueueWait = histogram(prometheus.HistogramOpts{
+		Name:    "worker_stage_queue_wait_ms",
+		Help:    "Time from the gateway's push to this worker's pull in milliseconds, observed at pull whether or not the caller still waits",
+		Buckets: []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000, 30000, 60000},
+	})
+	HistogramStagePullToPush = histogramVec(prometheus.HistogramOpts{
+		Name:    "worker_stage_pull_to_push_ms",
+		Help:    "Time from this worker's pull to its push of the response in milliseconds, by result, observed whether or not the caller still waits",
+		Buckets: []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000, 30000, 60000},
+	}, []string{"result"})
+
+	// Processing time by cost attribution labels, shadow jobs included
+	CounterCostProcessingMs = counterVec(prometheus.CounterOpts{
+		Name: "worker_cost_processing_ms_total",
+		Help: "Total job processing time in milliseconds by cost attribution (team, cost_center)",
+	}, []string{"team", "cost_center"})
+
+	// Jobs answered as failed unprocessed because they waited past their queue deadline
+	CounterQueueBudgetExceeded = counter(prometheus.CounterOpts{
+		Name: "worker_queue_budget_exceeded_total",
+		Help: "Total number of jobs pulled after their queue_wait stage budget ran out, answered without processing",
+	})
+
+	// Jobs answered as expired unprocessed because they waited past MAX_QUEUE_AGE
+	CounterExpiredJobs = counter(prometheus.CounterOpts{
+		Name: "worker_expired_jobs_total",
+		Help: "Total number of jobs that waited in the queue longer than MAX_QUEUE_AGE, answered as expired without processing",
+	})
+
+	// Jobs answered as cancelled unprocessed because their caller disconnected
+	CounterCancelledJobs = counter(prometheus.CounterOpts{
+		Name: "worker_cancelled_jobs_total",
+		Help: "Total number of jobs cancelled by the gateway after their caller disconnected, answered without processing",
+	})
+
+	// Jobs refused because they are pinned to a data region this worker does not serve
+	CounterResidencyViolations = counter(prometheus.CounterOpts{
+		Name: "worker_residency_violations_total",
+		Help: "Total number of jobs answered unprocessed because they are pinned to another data region",
+	})
+
+	// Jobs pulled after their callers gave up, by what STALE_JOB_POLICY did with them
+	CounterStaleJobs = counterVec(prometheus.CounterOpts{
+		Name: "worker_stale_jobs_total",
+		Help: "Total number of jobs pulled more than STALE_JOB_AGE after the gateway received them, by policy (fail, archive)",
+	}, []string{"policy"})
+
+	// Affinity partitions this worker serves
+	GaugeAffinityPartitions = gauge(prometheus.GaugeOpts{
+		Name: "worker_affinity_partitions",
+		Help: "Number of affinity partitions this worker serves, out of AFFINITY_PARTITIONS",
+	})
+
+	// Jobs taken from every affinity partition, summed by instance this shows the load spread
+	CounterAffinityJobs = counterVec(prometheus.CounterOpts{
+		Name: "worker_affinity_jobs_total",
+		Help: "Total number of jobs popped from an affinity partition, by partition",
+	}, []string{"partition"})
+
+	// Session cache lookups by handlers, by result (hit, miss)
+	CounterSessionLookups = counterVec(prometheus.CounterOpts{
+		Name: "worker_session_lookups_total",
+		Help: "Total number of session cache lookups by handlers, by result (hit, miss)",
+	}, []string{"result"})
+
+	// Sessions dropped to stay within SESSION_CACHE_SIZE
+	CounterSessionEvictions = counter(prometheus.CounterOpts{
+		Name: "worker_session_evictions_total",
+		Help: "Total number of sessions evicted from the session cache",
+	})
+
+	// Sessions currently cached
+	GaugeSessions = gauge(prometheus.GaugeOpts{
+		Name: "worker_sessions",
+		Help: "Number of affinity key sessions in the session cache",
+	})
+
+	// 1 while the worker is drained through the control channel
+	GaugeDraining = gauge(prometheus.GaugeOpts{
+		Name: "worker_draining",
+		Help: "1 while the worker pulls no jobs after a drain control message, 0 otherwise",
+	})
+
+	// Failed re-reads of SECRETS_PROVIDER, the previous secrets stay in use
+	CounterSecretRefreshFailures = counter(prometheus.CounterOpts{
+		Name: "worker_secret_refresh_failures_total",
+		Help: "Total number of failed secret refreshes from the secrets provider",
+	})
+
+	// Values masked by redaction, by where the payload was going
+	CounterRedactions = counterVec(prometheus.CounterOpts{
+		Name: "worker_redactions_total",
+		Help: "Total number of field values and pattern matches redacted, by sink (dlq)",
+	}, []string{"sink"})
+
+	// Circuit breaker state, 0 closed, 1 half-open, 2 open
+	GaugeDownstreamCircuit = gaugeVec(prometheus.GaugeOpts{
+		Name: "worker_downstream_circuit_state",
+		Help: "Circuit breaker state per downstream: 0 closed, 1 half-open, 2 open",
+	}, []string{"downstream"})
+)
+
+// startMetricsServer exposes /metrics and /version on METRICS_ADDR; the worker has no other
+// HTTP surface.
+func startMetricsServer() {
+	prometheus.MustRegister(collectors...)
+
+	addr := envString("METRICS_ADDR", ":9100")
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/version", func(w http.ResponseWriter, _ *http.Request) {
+		payload, _ := codec.Marshal(buildInfo)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(payload)
+	})
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			slog.Error("Metrics server failed", "addr", addr, "error", err)
+		}
+	}()
+}
+
+// --- Metric Catalog ---
+
+// MetricSpec describes one metric of the worker; gen-dashboards derives the worker's Grafana
+// dashboard and alert rules from these, like the gateway's from go-async-proxy/metrics.
+type MetricSpec struct {
+	Name    string
+	Help    string
+	Kind    string // "counter", "gauge" or "histogram"
+	Labels  []string
+	Buckets []float64
+}
+
+var (
+	metricSpecs []MetricSpec
+	collectors  []prometheus.Collector
+)
+
+// MetricSpecs returns the catalog of every metric of the worker, in declaration order.
+func MetricSpecs() []MetricSpec {
+	return metricSpecs
+}
+
+func addMetric(collector prometheus.Collector, spec MetricSpec) {
+	collectors = append(collectors, collector)
+	metricSpecs = append(metricSpecs, spec)
+}
+
+func counter(opts prometheus.CounterOpts) prometheus.Counter {
+	c := prometheus.NewCounter(opts)
+	addMetric(c, MetricSpec{Name: opts.Name, Help: opts.Help, Kind: "counter"})
+	return c
+}
+
+func counterVec(opts prometheus.CounterOpts, labels []string) *prometheus.CounterVec {
+	c := prometheus.NewCounterVec(opts, labels)
+	addMetric(c, MetricSpec{Name: opts.Name, Help: opts.Help, Kind: "counter", Labels: labels})
+	return c
+}
+
+func gauge(opts prometheus.GaugeOpts) prometheus.Gauge {
+	g := prometheus.NewGauge(opts)
+	addMetric(g, MetricSpec{Name: opts.Name, Help: opts.Help, Kind: "gauge"})
+	return g
+}
+
+func gaugeVec(opts prometheus.GaugeOpts, labels []string) *prometheus.GaugeVec {
+	g := prometheus.NewGaugeVec(opts, labels)
+	addMetric(g, MetricSpec{Name: opts.Name, Help: opts.Help, Kind: "gauge", Labels: labels})
+	return g
+}
+
+func histogram(opts prometheus.HistogramOpts) prometheus.Histogram {
+	h := prometheus.NewHistogram(opts)
+	addMetric(h, MetricSpec{Name: opts.Name, Help: opts.Help, Kind: "histogram", Buckets: opts.Buckets})
+	return h
+}
+
+func histogramVec(opts prometheus.HistogramOpts, labels []string) *prometheus.HistogramVec {
+	h := prometheus.NewHistogramVec(opts, labels)
+	addMetric(h, MetricSpec{Name: opts.Name, Help: opts.Help, Kind: "histogram", Labels: labels, Buckets: opts.Buckets})
+	return h
+}
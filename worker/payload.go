@@ -0,0 +1,91 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"unicode/utf8"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Payloads ---
+
+// errBinaryUnsupported is returned by text-only handlers given a binary or file payload.
+var errBinaryUnsupported = errors.New("binary or file content is not supported by this handler")
+
+// fileChunkSize is how much of an uploaded file is fetched per GETRANGE.
+const fileChunkSize = 256 << 10
+
+// text reports whether the payload is plain text handlers can read from Content.
+func (d *Data) text() bool {
+	return !d.Binary && d.File == nil
+}
+
+// bytes returns the raw content, decoding it from base64 for binary payloads.
+func (d *Data) bytes() ([]byte, error) {
+	if d.Binary {
+		return base64.StdEncoding.DecodeString(d.Content)
+	}
+	return []byte(d.Content), nil
+}
+
+// open streams the input: uploaded files are read from Redis in chunks rather than loaded
+// whole, everything else comes from Content.
+func (d *Data) open(ctx context.Context, rdb *redis.Client) (io.Reader, error) {
+	if d.File == nil {
+		raw, err := d.bytes()
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(raw), nil
+	}
+	exists, err := rdb.Exists(ctx, d.File.Key).Result()
+	if err != nil {
+		return nil, err
+	}
+	if exists == 0 {
+		return nil, errors.New("uploaded file has expired")
+	}
+	return &redisFileReader{ctx: ctx, rdb: rdb, key: d.File.Key}, nil
+}
+
+// redisFileReader reads a Redis string sequentially with GETRANGE.
+type redisFileReader struct {
+	ctx    context.Context
+	rdb    *redis.Client
+	key    string
+	offset int64
+	chunk  []byte
+}
+
+func (r *redisFileReader) Read(p []byte) (int, error) {
+	if len(r.chunk) == 0 {
+		chunk, err := r.rdb.GetRange(r.ctx, r.key, r.offset, r.offset+fileChunkSize-1).Bytes()
+		if err != nil {
+			return 0, err
+		}
+		if len(chunk) == 0 {
+			return 0, io.EOF
+		}
+		r.chunk = chunk
+		r.offset += int64(len(chunk))
+	}
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+// setBytes stores raw handler output. Binary payloads stay binary, and text output that isn't
+// valid UTF-8 becomes binary too, so raw bytes never end up in the JSON envelope.
+// Output replaces an uploaded file as the payload.
+func (d *Data) setBytes(raw []byte) {
+	d.File = nil
+	if d.Binary || !utf8.Valid(raw) {
+		d.Content, d.Binary = base64.StdEncoding.EncodeToString(raw), true
+		return
+	}
+	d.Content = string(raw)
+}
@@ -0,0 +1,61 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Startup Preflight ---
+
+// preflight checks config sanity, Redis reachability, clock skew against Redis and the type of
+// the queue key, reporting every problem at once before the worker pulls its first job.
+func preflight(rdb *redis.Client) error {
+	var problems []error
+	for _, t := range tunables {
+		if err := t.check(); err != nil {
+			problems = append(problems, err)
+		}
+	}
+
+	timeout := envDuration("PREFLIGHT_REDIS_TIMEOUT", 30*time.Second)
+	deadline := clock.Now().Add(timeout)
+	for {
+		ctxTimeout, cancel := context.WithTimeout(ctx, time.Second)
+		err := rdb.Ping(ctxTimeout).Err()
+		cancel()
+		if err == nil {
+			break
+		}
+		if clock.Now().After(deadline) {
+			problems = append(problems, fmt.Errorf("redis at %s unreachable for %s: %w", rdb.Options().Addr, timeout, err))
+			return errors.Join(problems...)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	// Stage durations subtract timestamps taken on different hosts
+	maxSkew := envDuration("PREFLIGHT_MAX_CLOCK_SKEW", time.Second)
+	before := clock.Now()
+	if redisNow, err := rdb.Time(ctx).Result(); err != nil {
+		problems = append(problems, fmt.Errorf("reading redis TIME: %w", err))
+	} else {
+		skew := before.Add(since(before) / 2).Sub(redisNow)
+		if skew < 0 {
+			skew = -skew
+		}
+		if skew > maxSkew {
+			problems = append(problems, fmt.Errorf("clock differs from redis by %s (max %s): check NTP on this host", skew.Round(time.Millisecond), maxSkew))
+		}
+	}
+
+	if kind, err := rdb.Type(ctx, queueKey).Result(); err != nil {
+		problems = append(problems, fmt.Errorf("checking the queue key: %w", err))
+	} else if kind != "none" && kind != "list" {
+		problems = append(problems, fmt.Errorf("redis key %s is a %s, expected a list: delete or rename it", queueKey, kind))
+	}
+	return errors.Join(problems...)
+}
@@ -0,0 +1,91 @@
+package worker
+
+import (
+	"errors"
+	"log/slog"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Job Progress ---
+
+// The gateway only learns about a job again when its response arrives, so a 504 alone can't
+// tell a job still queued from one a worker is stuck on. The worker notes in the gateway's job
+// info hash (validate:jobinfo:<id>) when it claimed the job and when the handler started, with
+// its worker ID; the gateway reads them back when it times out. Jobs the gateway doesn't index
+// (probes, shadow copies) have no hash and are left alone. A gateway whose caller hung up
+// with CLIENT_DISCONNECT_POLICY=cancel marks the hash cancelled, and a job found cancelled
+// when claimed is answered without running the handler.
+//
+// The gateway also stamps the hash with the job's sequence in its queue (queue_seq), the
+// number of jobs ever pushed to it, to estimate the job's position. A job put back at the head
+// of its queue is restamped with the sequence of the head, see requeueJob.
+
+const (
+	progressClaimed    = "claimed"
+	progressProcessing = "processing"
+)
+
+// reportProgressScript writes the fields only into an existing info hash, so it neither
+// revives one the gateway expired nor creates one without a TTL, and returns whether the job
+// was cancelled.
+var reportProgressScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'worker_stage', ARGV[1], 'worker_stage_ms', ARGV[2], 'worker_id', ARGV[3])
+return redis.call('HEXISTS', KEYS[1], 'cancelled')
+`)
+
+// requeueScript pushes ARGV[1] back at the head of the queue KEYS[1] and, when the job's info
+// hash KEYS[3] has a queue sequence, restamps it so pushed (KEYS[2]) minus queue length plus
+// the job's sequence puts it first.
+var requeueScript = redis.NewScript(`
+redis.call('LPUSH', KEYS[1], ARGV[1])
+if redis.call('HEXISTS', KEYS[3], 'queue_seq') == 1 then
+	local pushed = tonumber(redis.call('GET', KEYS[2]) or 0)
+	redis.call('HSET', KEYS[3], 'queue_seq', pushed - redis.call('LLEN', KEYS[1]) + 1)
+end
+return 1
+`)
+
+var errJobCancelled = errors.New("cancelled: the caller disconnected")
+
+func jobInfoKey(requestId string) string {
+	return "validate:jobinfo:" + requestId
+}
+
+// requeueJob puts the job payload back at the head of queue, on c, a client or a pipeline.
+func requeueJob(c redis.Cmdable, queue string, payload []byte) *redis.Cmd {
+	var msg struct {
+		RequestID string `json:"request_id"`
+	}
+	_ = codec.Unmarshal(payload, &msg)
+	keys := []string{queue, queue + ":seq", jobInfoKey(msg.RequestID)}
+	return requeueScript.Eval(ctx, c, keys, payload)
+}
+
+// reportProgress records that msg reached stage on this worker and reports whether the job
+// was cancelled.
+func reportProgress(rdb *redis.Client, msg *Message, stage string) (cancelled bool) {
+	if msg.Shadow {
+		return false
+	}
+	nowMs := strconv.FormatInt(nowNs()/1e6, 10)
+	marked, err := reportProgressScript.Run(ctx, rdb, []string{jobInfoKey(msg.RequestID)}, stage, nowMs, workerID).Int()
+	if err != nil {
+		slog.Debug("Cannot report job progress", "request_id", msg.RequestID, "stage", stage, "error", err)
+		return false
+	}
+	return marked == 1
+}
+
+// checkCancelled reports the claim of msg, failing it when its caller is gone.
+func checkCancelled(rdb *redis.Client, msg *Message) error {
+	if reportProgress(rdb, msg, progressClaimed) {
+		CounterCancelledJobs.Inc()
+		return errJobCancelled
+	}
+	return nil
+}
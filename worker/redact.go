@@ -0,0 +1,145 @@
+package worker
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// --- Redaction ---
+
+// The worker reads the gateway's redaction config (REDACT_FIELDS, REDACT_PATTERNS, REDACT_MASK
+// and REDACT_SINKS, see the gateway's redact.go) and applies it to the one copy of a job it
+// writes elsewhere:
+//
+//	dlq  the content and error of dead letters on validate:dlq
+//
+// The other sinks are the gateway's, so they are accepted and ignored here. Matches are
+// counted in worker_redactions_total{sink}.
+
+const redactDLQ = "dlq"
+
+// redactSinks are every sink of REDACT_SINKS, the gateway's included.
+var redactSinks = []string{"log", "audit", "store", "fixture", "response", redactDLQ}
+
+var redaction struct {
+	fields   [][]string
+	patterns []*regexp.Regexp
+	sinks    map[string]bool
+	mask     string
+}
+
+func initRedaction() error {
+	for _, path := range strings.Split(envString("REDACT_FIELDS", ""), ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			redaction.fields = append(redaction.fields, strings.Split(path, "."))
+		}
+	}
+	var sources []string
+	if raw := envString("REDACT_PATTERNS", ""); raw != "" {
+		if err := codec.Unmarshal([]byte(raw), &sources); err != nil {
+			return fmt.Errorf("invalid REDACT_PATTERNS: %w", err)
+		}
+	}
+	for _, source := range sources {
+		pattern, err := regexp.Compile(source)
+		if err != nil {
+			return fmt.Errorf("invalid redaction pattern %q: %w", source, err)
+		}
+		redaction.patterns = append(redaction.patterns, pattern)
+	}
+	redaction.sinks = map[string]bool{}
+	for _, sink := range strings.Split(envString("REDACT_SINKS", strings.Join(redactSinks, ",")), ",") {
+		sink = strings.TrimSpace(sink)
+		if sink == "" {
+			continue
+		}
+		if !slices.Contains(redactSinks, sink) {
+			return fmt.Errorf("unknown REDACT_SINKS entry %q", sink)
+		}
+		redaction.sinks[sink] = true
+	}
+	redaction.mask = envString("REDACT_MASK", "[REDACTED]")
+	return nil
+}
+
+// redactionOn reports whether payloads written to sink are redacted.
+func redactionOn(sink string) bool {
+	return redaction.sinks[sink] && (len(redaction.fields) > 0 || len(redaction.patterns) > 0)
+}
+
+// redact returns text with the configured fields and patterns masked, for sink.
+func redact(sink, text string) string {
+	if !redactionOn(sink) || text == "" {
+		return text
+	}
+	count := 0
+	if len(redaction.fields) > 0 && (text[0] == '{' || text[0] == '[') {
+		var doc any
+		if codec.Unmarshal([]byte(text), &doc) == nil {
+			for _, path := range redaction.fields {
+				count += maskField(doc, path)
+			}
+			if count > 0 {
+				if masked, err := codec.Marshal(doc); err == nil {
+					text = string(masked)
+				}
+			}
+		}
+	}
+	for _, pattern := range redaction.patterns {
+		if matches := len(pattern.FindAllStringIndex(text, -1)); matches > 0 {
+			count += matches
+			text = pattern.ReplaceAllLiteralString(text, redaction.mask)
+		}
+	}
+	if count > 0 {
+		CounterRedactions.WithLabelValues(sink).Add(float64(count))
+	}
+	return text
+}
+
+// maskField replaces the values at path in doc and returns how many it replaced.
+func maskField(doc any, path []string) int {
+	if len(path) == 0 {
+		return 0
+	}
+	count := 0
+	visit := func(key string, value any, set func(any)) {
+		if path[0] != "*" && path[0] != key {
+			return
+		}
+		if len(path) == 1 {
+			set(redaction.mask)
+			count++
+			return
+		}
+		count += maskField(value, path[1:])
+	}
+	switch node := doc.(type) {
+	case map[string]any:
+		for key, value := range node {
+			visit(key, value, func(masked any) { node[key] = masked })
+		}
+	case []any:
+		for i, value := range node {
+			visit(fmt.Sprint(i), value, func(masked any) { node[i] = masked })
+		}
+	}
+	return count
+}
+
+// redactedMessage returns msg, or a copy with its content redacted when sink redacts.
+func redactedMessage(sink string, msg *Message) *Message {
+	if !redactionOn(sink) || msg.Data.Binary {
+		return msg
+	}
+	content := redact(sink, msg.Data.Content)
+	if content == msg.Data.Content {
+		return msg
+	}
+	redacted := *msg
+	redacted.Data.Content = content
+	return &redacted
+}
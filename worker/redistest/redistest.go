@@ -0,0 +1,65 @@
+// Package redistest runs code under test against miniredis, an in-memory Redis, with a fake
+// clock that drives both the timestamps the code takes and the expiry of Redis keys, so
+// pipeline tests are fast and deterministic without a real Redis. The gateway has a copy that
+// also plays the worker's side of the queue protocol.
+package redistest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// Epoch is where every fake clock starts.
+var Epoch = time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// Server is a miniredis instance, a client connected to it and the clock both follow.
+type Server struct {
+	*miniredis.Miniredis
+	Client *redis.Client
+	Clock  *Clock
+}
+
+// Start runs a fresh miniredis for the test and stops it when the test ends.
+func Start(tb testing.TB) *Server {
+	tb.Helper()
+	mr := miniredis.RunT(tb)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	tb.Cleanup(func() { _ = client.Close() })
+
+	clock := &Clock{now: Epoch}
+	mr.SetTime(Epoch)
+	clock.advanced = func(d time.Duration) {
+		// TTLs count down with the clock, TIME and the stream IDs follow it
+		mr.FastForward(d)
+		mr.SetTime(clock.Now())
+	}
+	return &Server{Miniredis: mr, Client: client, Clock: clock}
+}
+
+// Clock is a fake clock that only moves when told to, see Advance.
+type Clock struct {
+	mu       sync.Mutex
+	now      time.Time
+	advanced func(time.Duration)
+}
+
+// Now returns the fake time.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d, expiring the Redis keys whose TTL ran out.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+	if c.advanced != nil {
+		c.advanced(d)
+	}
+}
@@ -0,0 +1,100 @@
+package worker
+
+import (
+	"fmt"
+	"hash/crc32"
+	"log/slog"
+	"maps"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Config Reload ---
+
+// configKey is the Redis hash of tunable overrides (field = environment key) shared by the fleet.
+const configKey = "validate:config"
+
+// loadOverrides reads the tunable overrides from CONFIG_SOURCE: "redis" (the validate:config
+// hash, the default) or "file" (CONFIG_FILE, a JSON object of environment keys to values).
+func loadOverrides(rdb *redis.Client) (map[string]string, error) {
+	switch source := envString("CONFIG_SOURCE", "redis"); source {
+	case "redis":
+		return rdb.HGetAll(ctx, configKey).Result()
+	case "file":
+		raw, err := os.ReadFile(envString("CONFIG_FILE", "config.json"))
+		if err != nil {
+			return nil, err
+		}
+		overrides := map[string]string{}
+		if err := codec.Unmarshal(raw, &overrides); err != nil {
+			return nil, err
+		}
+		return overrides, nil
+	default:
+		return nil, fmt.Errorf("unknown CONFIG_SOURCE %q", source)
+	}
+}
+
+// lastOverrides is what the previous reload applied; unchanged overrides are not re-applied.
+var lastOverrides map[string]string
+
+// reloadConfig applies the overrides to the tunables. A broken override is reported and leaves
+// that tunable unchanged. Keys this service doesn't know are reported too, as they may be typos
+// (or tunables of the other service, the hash is shared).
+func reloadConfig(rdb *redis.Client) {
+	overrides, err := loadOverrides(rdb)
+	if err != nil {
+		slog.Error("Config reload failed, keeping current config", "error", err)
+		return
+	}
+	if lastOverrides != nil && maps.Equal(overrides, lastOverrides) {
+		return
+	}
+	lastOverrides = overrides
+
+	for key := range overrides {
+		if _, ok := tunables[key]; !ok {
+			slog.Warn("Ignoring config override: not a tunable", "key", key)
+		}
+	}
+
+	keys := make([]string, 0, len(tunables))
+	for key := range tunables {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var applied strings.Builder
+	for _, key := range keys {
+		t := tunables[key]
+		before := t.String()
+		changed, err := t.apply(overrides[key])
+		if err != nil {
+			slog.Warn("Invalid config override", "error", err)
+		} else if changed {
+			slog.Info("Config changed", "key", key, "from", before, "to", t.String())
+		}
+		fmt.Fprintf(&applied, "%s=%s\n", key, t.String())
+	}
+
+	// Replicas with the same effective config report the same version
+	GaugeConfigVersion.Set(float64(crc32.ChecksumIEEE([]byte(applied.String()))))
+}
+
+// startConfigReloader applies the overrides now and then every CONFIG_REFRESH (0 disables).
+func startConfigReloader(rdb *redis.Client) {
+	reloadConfig(rdb)
+	refresh := envDuration("CONFIG_REFRESH", 30*time.Second)
+	if refresh <= 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(refresh) {
+			reloadConfig(rdb)
+		}
+	}()
+}
@@ -0,0 +1,30 @@
+package worker
+
+import "fmt"
+
+// --- Data Residency ---
+
+// WORKER_REGION makes the worker serve one data region: it consumes <QUEUE_KEY>:region:<region>,
+// where the gateway pushes the jobs pinned to that region, announces the region in its
+// heartbeat so gateways know the region is served, and refuses any job pinned elsewhere. A
+// worker without WORKER_REGION only consumes the jobs free to go anywhere.
+
+// workerRegion is the data region this worker serves, "" for none.
+var workerRegion = envString("WORKER_REGION", "")
+
+func regionQueueKey(queue, region string) string {
+	if region == "" {
+		return queue
+	}
+	return queue + ":region:" + region
+}
+
+// checkResidency fails a job pinned to a region this worker does not serve, which only happens
+// when a job is pushed to the wrong queue by hand. The error starts with "residency:".
+func checkResidency(msg *Message) error {
+	if msg.Region == "" || msg.Region == workerRegion {
+		return nil
+	}
+	CounterResidencyViolations.Inc()
+	return fmt.Errorf("residency: job must stay in %s, worker serves %q", msg.Region, workerRegion)
+}
@@ -0,0 +1,89 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Fleet Retry Budget ---
+
+// During a partial outage every worker retrying its failed callouts multiplies the load on the
+// struggling downstream. CALLOUT_RETRY_BUDGET_PERCENT caps the retries of the whole fleet to that
+// percentage of the downstream's calls (0, the default, leaves retries to CALLOUT_RETRIES
+// alone), with CALLOUT_RETRY_BUDGET_MIN retries allowed in any case. The counts are shared in
+// Redis (validate:retrybudget:<downstream>:<window>) per CALLOUT_RETRY_BUDGET_WINDOW; a job
+// whose retry is denied fails with its last error. Retries are allowed when Redis can't be asked.
+
+var (
+	retryBudgetPercent = newTunable("CALLOUT_RETRY_BUDGET_PERCENT", envInt("CALLOUT_RETRY_BUDGET_PERCENT", 0), parseBudgetInt(100))
+	retryBudgetMin     = newTunable("CALLOUT_RETRY_BUDGET_MIN", envInt("CALLOUT_RETRY_BUDGET_MIN", 10), parseBudgetInt(math.MaxInt))
+	retryBudgetWindow  = envDuration("CALLOUT_RETRY_BUDGET_WINDOW", 10*time.Second)
+)
+
+// parseBudgetInt parses a budget setting from 0 (intTunable starts at 1) to limit.
+func parseBudgetInt(limit int) func(string) (int, error) {
+	return func(raw string) (int, error) {
+		value, err := strconv.Atoi(raw)
+		if err == nil && (value < 0 || value > limit) {
+			err = fmt.Errorf("must be between 0 and %d", limit)
+		}
+		return value, err
+	}
+}
+
+// spendRetryScript takes a retry from the window's budget if any is left.
+var spendRetryScript = redis.NewScript(`
+local calls = tonumber(redis.call('HGET', KEYS[1], 'calls') or '0')
+local retries = tonumber(redis.call('HGET', KEYS[1], 'retries') or '0')
+if retries >= math.max(tonumber(ARGV[2]), calls * tonumber(ARGV[1]) / 100) then
+	return 0
+end
+redis.call('HINCRBY', KEYS[1], 'retries', 1)
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+return 1
+`)
+
+// retryBudgetKey is the budget of downstream for the current window.
+func retryBudgetKey(downstream string) string {
+	window := clock.Now().UnixNano() / int64(retryBudgetWindow)
+	return "validate:retrybudget:" + downstream + ":" + strconv.FormatInt(window, 10)
+}
+
+// recordCall counts a first call to downstream, which the retries are a share of.
+func recordCall(ctx context.Context, rdb *redis.Client, downstream string) {
+	if retryBudgetPercent.Get() == 0 {
+		return
+	}
+	key := retryBudgetKey(downstream)
+	pipe := rdb.Pipeline()
+	pipe.HIncrBy(ctx, key, "calls", 1)
+	pipe.PExpire(ctx, key, 2*retryBudgetWindow)
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.Debug("Cannot count downstream call", "downstream", downstream, "error", err)
+	}
+}
+
+// spendRetry reports whether the fleet's budget still allows a retry against downstream.
+func spendRetry(ctx context.Context, rdb *redis.Client, downstream string) bool {
+	percent := retryBudgetPercent.Get()
+	if percent == 0 {
+		return true
+	}
+	ttl := strconv.FormatInt((2 * retryBudgetWindow).Milliseconds(), 10)
+	allowed, err := spendRetryScript.Run(ctx, rdb, []string{retryBudgetKey(downstream)}, percent, retryBudgetMin.Get(), ttl).Int()
+	if err != nil {
+		slog.Debug("Cannot read retry budget", "downstream", downstream, "error", err)
+		return true
+	}
+	if allowed == 0 {
+		CounterDownstreamRetriesDenied.WithLabelValues(downstream).Inc()
+		return false
+	}
+	return true
+}
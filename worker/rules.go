@@ -0,0 +1,189 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Validation Rules ---
+
+// RuleSpec is one rule as configured, in a JSON array loaded from RULES_FILE or the Redis key
+// validate:rules (RULES_SOURCE=file|redis):
+//
+//	{"id": "short", "type": "length", "min": 1, "max": 100}
+//	{"id": "no-digits", "type": "regex", "pattern": "^\\D*$"}
+//	{"id": "known", "type": "list", "values": ["a", "b"], "mode": "allow"}
+//	{"id": "cel", "type": "cel", "expr": "content.startsWith('A')"} (needs -tags cel)
+type RuleSpec struct {
+	ID      string   `json:"id"`
+	Type    string   `json:"type"`
+	Min     int      `json:"min,omitempty"`
+	Max     int      `json:"max,omitempty"`
+	Pattern string   `json:"pattern,omitempty"`
+	Values  []string `json:"values,omitempty"`
+	Mode    string   `json:"mode,omitempty"` // "allow" (default) or "deny" for list rules
+	Expr    string   `json:"expr,omitempty"`
+}
+
+// RuleResult is the outcome of one rule, returned to the caller in Data.Rules.
+type RuleResult struct {
+	ID      string `json:"id"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message,omitempty"`
+}
+
+// rule reports "" when content passes, or why it failed.
+type rule struct {
+	id    string
+	check func(content string) string
+}
+
+const rulesKey = "validate:rules"
+
+func compileRules(specs []RuleSpec) ([]rule, error) {
+	rules := make([]rule, 0, len(specs))
+	for _, spec := range specs {
+		check, err := compileRule(spec)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", spec.ID, err)
+		}
+		rules = append(rules, rule{id: spec.ID, check: check})
+	}
+	return rules, nil
+}
+
+func compileRule(spec RuleSpec) (func(string) string, error) {
+	switch spec.Type {
+	case "length":
+		return func(content string) string {
+			length := utf8.RuneCountInString(content)
+			if length < spec.Min {
+				return fmt.Sprintf("shorter than %d", spec.Min)
+			}
+			if spec.Max > 0 && length > spec.Max {
+				return fmt.Sprintf("longer than %d", spec.Max)
+			}
+			return ""
+		}, nil
+
+	case "regex":
+		re, err := regexp.Compile(spec.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		return func(content string) string {
+			if !re.MatchString(content) {
+				return "does not match " + spec.Pattern
+			}
+			return ""
+		}, nil
+
+	case "list":
+		values := make(map[string]bool, len(spec.Values))
+		for _, value := range spec.Values {
+			values[value] = true
+		}
+		deny := spec.Mode == "deny"
+		if !deny && spec.Mode != "" && spec.Mode != "allow" {
+			return nil, fmt.Errorf("unknown list mode %q", spec.Mode)
+		}
+		return func(content string) string {
+			if values[content] == deny {
+				if deny {
+					return "is on the deny list"
+				}
+				return "is not on the allow list"
+			}
+			return ""
+		}, nil
+
+	case "cel":
+		return compileCELRule(spec.Expr)
+	}
+	return nil, fmt.Errorf("unknown rule type %q", spec.Type)
+}
+
+// loadRuleSpecs reads the rule specs from the configured source.
+func loadRuleSpecs(rdb *redis.Client) ([]RuleSpec, error) {
+	var raw []byte
+	switch source := envString("RULES_SOURCE", "file"); source {
+	case "file":
+		data, err := os.ReadFile(envString("RULES_FILE", "rules.json"))
+		if err != nil {
+			return nil, err
+		}
+		raw = data
+	case "redis":
+		data, err := rdb.Get(ctx, rulesKey).Bytes()
+		if err != nil {
+			return nil, fmt.Errorf("cannot read %s: %w", rulesKey, err)
+		}
+		raw = data
+	default:
+		return nil, fmt.Errorf("unknown RULES_SOURCE %q", source)
+	}
+
+	var specs []RuleSpec
+	if err := codec.Unmarshal(raw, &specs); err != nil {
+		return nil, err
+	}
+	return specs, nil
+}
+
+// newRulesHandler evaluates every configured rule against Data.Content. The rule set is
+// reloaded every RULES_REFRESH; a broken update is reported and the previous set stays active.
+func newRulesHandler(rdb *redis.Client) (Handler, error) {
+	specs, err := loadRuleSpecs(rdb)
+	if err != nil {
+		return nil, err
+	}
+	compiled, err := compileRules(specs)
+	if err != nil {
+		return nil, err
+	}
+
+	var active atomic.Pointer[[]rule]
+	active.Store(&compiled)
+
+	if refresh := envDuration("RULES_REFRESH", 30*time.Second); refresh > 0 {
+		go func() {
+			for range time.Tick(refresh) {
+				specs, err := loadRuleSpecs(rdb)
+				if err == nil {
+					var next []rule
+					if next, err = compileRules(specs); err == nil {
+						active.Store(&next)
+						continue
+					}
+				}
+				slog.Error("Rules reload failed, keeping previous rules", "error", err)
+			}
+		}()
+	}
+
+	return func(_ context.Context, msg *Message) error {
+		data := &msg.Data
+		if !data.text() {
+			return errBinaryUnsupported
+		}
+		rules := *active.Load()
+		data.Rules = make([]RuleResult, 0, len(rules))
+		data.Result = true
+		for _, r := range rules {
+			reason := r.check(data.Content)
+			data.Rules = append(data.Rules, RuleResult{ID: r.id, Passed: reason == "", Message: reason})
+			if reason != "" {
+				data.Result = false
+			}
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,40 @@
+//go:build cel
+
+package worker
+
+import (
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+)
+
+// compileCELRule compiles a CEL expression over the string variable "content".
+// Needs the cel build tag: go get github.com/google/cel-go && go build -tags cel
+func compileCELRule(expr string) (func(string) string, error) {
+	env, err := cel.NewEnv(cel.Variable("content", cel.StringType))
+	if err != nil {
+		return nil, err
+	}
+	ast, issues := env.Compile(expr)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if ast.OutputType() != cel.BoolType {
+		return nil, fmt.Errorf("expression must return bool, got %s", ast.OutputType())
+	}
+	program, err := env.Program(ast)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(content string) string {
+		out, _, err := program.Eval(map[string]any{"content": content})
+		if err != nil {
+			return err.Error()
+		}
+		if passed, _ := out.Value().(bool); !passed {
+			return "expression is false"
+		}
+		return ""
+	}, nil
+}
@@ -0,0 +1,13 @@
+//go:build !cel
+
+package worker
+
+import (
+	"errors"
+)
+
+// compileCELRule is unavailable unless the worker is built with the cel tag,
+// which keeps cel-go and its dependencies out of the default binary.
+func compileCELRule(string) (func(string) string, error) {
+	return nil, errors.New("cel rules need a worker built with -tags cel")
+}
@@ -0,0 +1,179 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Secrets ---
+
+// Credentials are read through SECRETS_PROVIDER rather than straight from the environment:
+//
+//	env    the environment variable named after the secret (default)
+//	file   the file named after the secret in SECRETS_DIR (default /run/secrets), e.g. a mounted
+//	       Kubernetes secret, or an AWS Secrets Manager secret synced by the Secrets Store CSI driver
+//	vault  the field named after the secret in the KV v2 secret VAULT_SECRET_PATH (e.g.
+//	       secret/data/validate) at VAULT_ADDR, with VAULT_TOKEN or the token in VAULT_TOKEN_FILE
+//
+// A secret the provider doesn't have falls back to its environment variable. Secrets are read
+// again every SECRETS_REFRESH_INTERVAL, so a rotated Redis password is used on the next new
+// connection without a restart.
+//
+// The Redis password is the worker's only credential: callouts go to CALLOUT_URL
+// unauthenticated, and there are no HMAC or JWT keys. The vault provider's own VAULT_TOKEN is
+// read from the environment or its file.
+
+// secretNames are the secrets the worker reads.
+var secretNames = []string{"REDIS_PASSWORD"}
+
+// secretsProvider fetches the secrets it has among names.
+type secretsProvider interface {
+	fetch(names []string) (map[string]string, error)
+}
+
+var secrets = struct {
+	sync.RWMutex
+	provider secretsProvider
+	values   map[string]string
+}{values: map[string]string{}}
+
+// secret returns the current value of the secret name, or fallback when it has none.
+func secret(name, fallback string) string {
+	secrets.RLock()
+	value, ok := secrets.values[name]
+	secrets.RUnlock()
+	if ok && value != "" {
+		return value
+	}
+	return envString(name, fallback)
+}
+
+func initSecrets() error {
+	switch kind := envString("SECRETS_PROVIDER", "env"); kind {
+	case "env":
+		return nil
+	case "file":
+		secrets.provider = fileSecrets{dir: envString("SECRETS_DIR", "/run/secrets")}
+	case "vault":
+		provider := vaultSecrets{
+			addr:      strings.TrimSuffix(envString("VAULT_ADDR", ""), "/"),
+			path:      strings.Trim(envString("VAULT_SECRET_PATH", ""), "/"),
+			tokenFile: envString("VAULT_TOKEN_FILE", ""),
+			client:    &http.Client{Timeout: 5 * time.Second},
+		}
+		if provider.addr == "" || provider.path == "" {
+			return errors.New("SECRETS_PROVIDER=vault needs VAULT_ADDR and VAULT_SECRET_PATH")
+		}
+		secrets.provider = provider
+	default:
+		return fmt.Errorf("SECRETS_PROVIDER must be env, file or vault, got %q", kind)
+	}
+	if err := refreshSecrets(); err != nil {
+		return err
+	}
+	go func() {
+		interval := envDuration("SECRETS_REFRESH_INTERVAL", time.Minute)
+		for range time.Tick(interval) {
+			if err := refreshSecrets(); err != nil {
+				CounterSecretRefreshFailures.Inc()
+				slog.Warn("Cannot refresh secrets, keeping the previous ones", "error", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// refreshSecrets fetches every secret again and logs the ones that were rotated.
+func refreshSecrets() error {
+	values, err := secrets.provider.fetch(secretNames)
+	if err != nil {
+		return err
+	}
+	secrets.Lock()
+	defer secrets.Unlock()
+	for name, value := range values {
+		if previous, ok := secrets.values[name]; ok && previous != value {
+			slog.Info("Secret rotated", "name", name)
+		}
+	}
+	secrets.values = values
+	return nil
+}
+
+// fileSecrets reads one file per secret, missing files are secrets it doesn't have.
+type fileSecrets struct {
+	dir string
+}
+
+func (p fileSecrets) fetch(names []string) (map[string]string, error) {
+	values := map[string]string{}
+	for _, name := range names {
+		raw, err := os.ReadFile(filepath.Join(p.dir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		values[name] = strings.TrimRight(string(raw), "\r\n")
+	}
+	return values, nil
+}
+
+// vaultSecrets reads one KV v2 secret holding a field per secret.
+type vaultSecrets struct {
+	addr, path, tokenFile string
+	client                *http.Client
+}
+
+func (p vaultSecrets) fetch(names []string) (map[string]string, error) {
+	token := envString("VAULT_TOKEN", "")
+	if p.tokenFile != "" {
+		// Re-read every time, agents renew the token in place
+		raw, err := os.ReadFile(p.tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		token = strings.TrimSpace(string(raw))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.addr+"/v1/"+p.path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault responded %d", resp.StatusCode)
+	}
+	var body struct {
+		Data struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+	}
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err := codec.Unmarshal(raw, &body); err != nil {
+		return nil, fmt.Errorf("invalid vault response: %w", err)
+	}
+	values := map[string]string{}
+	for _, name := range names {
+		if value, ok := body.Data.Data[name].(string); ok {
+			values[name] = value
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,115 @@
+package worker
+
+import (
+	"container/list"
+	"context"
+	"sync"
+)
+
+// --- Session Cache ---
+
+// Affinity routing sends every job of a key to the same worker, so handlers can keep state per
+// key between jobs (compiled rules of a customer, say): sessionFrom(ctx) is the job's session,
+// a map of named values that lives in a per-worker LRU of SESSION_CACHE_SIZE sessions. Jobs
+// without an affinity key get no session. The gateway drops sessions fleet-wide through the
+// control channel, e.g. when the state they were built from changed.
+
+var sessionCacheSize = envInt("SESSION_CACHE_SIZE", 1024)
+
+// Session holds a handler's values for one affinity key. A nil *Session, the session of a job
+// without a key, misses every Get and drops every Set.
+type Session struct {
+	mu     sync.Mutex
+	values map[string]any
+}
+
+// Get returns the value stored under name.
+func (s *Session) Get(name string) (any, bool) {
+	if s == nil {
+		CounterSessionLookups.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+	s.mu.Lock()
+	value, ok := s.values[name]
+	s.mu.Unlock()
+	if ok {
+		CounterSessionLookups.WithLabelValues("hit").Inc()
+	} else {
+		CounterSessionLookups.WithLabelValues("miss").Inc()
+	}
+	return value, ok
+}
+
+// Set stores value under name.
+func (s *Session) Set(name string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.values[name] = value
+	s.mu.Unlock()
+}
+
+type sessionEntry struct {
+	key     string
+	session *Session
+}
+
+// sessionLRU keeps the most recently used sessions.
+type sessionLRU struct {
+	mu    sync.Mutex
+	order *list.List // front is the most recently used
+	byKey map[string]*list.Element
+}
+
+var sessions = &sessionLRU{order: list.New(), byKey: map[string]*list.Element{}}
+
+// get returns the session of key, creating it and evicting the least recently used one if needed.
+func (l *sessionLRU) get(key string) *Session {
+	if key == "" || sessionCacheSize <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if elem, ok := l.byKey[key]; ok {
+		l.order.MoveToFront(elem)
+		return elem.Value.(*sessionEntry).session
+	}
+	session := &Session{values: map[string]any{}}
+	l.byKey[key] = l.order.PushFront(&sessionEntry{key: key, session: session})
+	if l.order.Len() > sessionCacheSize {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.byKey, oldest.Value.(*sessionEntry).key)
+		CounterSessionEvictions.Inc()
+	}
+	GaugeSessions.Set(float64(l.order.Len()))
+	return session
+}
+
+// invalidate drops the session of key, or every session when key is "".
+func (l *sessionLRU) invalidate(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if key == "" {
+		l.order.Init()
+		clear(l.byKey)
+	} else if elem, ok := l.byKey[key]; ok {
+		l.order.Remove(elem)
+		delete(l.byKey, key)
+	}
+	GaugeSessions.Set(float64(l.order.Len()))
+}
+
+type sessionKey struct{}
+
+// withSession carries the session of the job's affinity key in ctx.
+func withSession(ctx context.Context, msg *Message) context.Context {
+	return context.WithValue(ctx, sessionKey{}, sessions.get(msg.Affinity))
+}
+
+// sessionFrom returns the job's session, nil when it has none.
+func sessionFrom(ctx context.Context) *Session {
+	session, _ := ctx.Value(sessionKey{}).(*Session)
+	return session
+}
@@ -0,0 +1,130 @@
+package worker
+
+import "strconv"
+
+// --- Pipeline Stages ---
+
+// StageEvent is one timestamped step of a message's trip through the pipeline, see the REST
+// service for the full list of stages. The gateway observes the stage histograms when it
+// answers, so a job whose caller timed out or hung up never reaches them; the worker observes
+// the stages it sees itself (worker_stage_queue_wait_ms, worker_stage_pull_to_push_ms) for
+// every job it pulls.
+type StageEvent struct {
+	Name string `json:"name"`
+	TsNs int64  `json:"ts_ns"`
+}
+
+const (
+	stageRestRequestReceived  = "rest_request_received"
+	stageRestRequestEnriched  = "rest_request_enriched"
+	stageRestRequestPushed    = "rest_request_pushed"
+	stageWorkerRequestPulled  = "worker_request_pulled"
+	stageWorkerResponsePushed = "worker_response_pushed"
+	stageRestResponsePulled   = "rest_response_pulled"
+)
+
+// Mark records the stage name now.
+func (m *Meta) Mark(name string) {
+	m.Stages = append(m.Stages, StageEvent{Name: name, TsNs: nowNs()})
+}
+
+// At returns the timestamp of the last occurrence of the stage name, or 0 when absent.
+func (m *Meta) At(name string) int64 {
+	for i := len(m.Stages) - 1; i >= 0; i-- {
+		if m.Stages[i].Name == name {
+			return m.Stages[i].TsNs
+		}
+	}
+	return 0
+}
+
+// legacyStageTimes are the per-stage timestamps Meta carried before Stages. They are still
+// written next to Stages, and read when Stages is missing, so a gateway and a worker on either
+// side of that change understand each other during a rolling deploy.
+type legacyStageTimes struct {
+	RestRequestReceived  int64 `json:"rest_request_received_ns,omitempty"`
+	RestRequestEnriched  int64 `json:"rest_request_enriched_ns,omitempty"`
+	RestRequestPushed    int64 `json:"rest_request_pushed_ns,omitempty"`
+	WorkerRequestPulled  int64 `json:"worker_request_pulled_ns,omitempty"`
+	WorkerResponsePushed int64 `json:"worker_response_pushed_ns,omitempty"`
+	RestResponsePulled   int64 `json:"rest_response_pulled_ns,omitempty"`
+}
+
+// legacyStages are the stages legacyStageTimes has a field for, in pipeline order.
+var legacyStages = []string{
+	stageRestRequestReceived,
+	stageRestRequestEnriched,
+	stageRestRequestPushed,
+	stageWorkerRequestPulled,
+	stageWorkerResponsePushed,
+	stageRestResponsePulled,
+}
+
+// fields returns a pointer to the field of every stage in legacyStages, in the same order.
+func (l *legacyStageTimes) fields() []*int64 {
+	return []*int64{
+		&l.RestRequestReceived,
+		&l.RestRequestEnriched,
+		&l.RestRequestPushed,
+		&l.WorkerRequestPulled,
+		&l.WorkerResponsePushed,
+		&l.RestResponsePulled,
+	}
+}
+
+// metaFields is Meta without its methods, so (un)marshaling it does not recurse.
+type metaFields Meta
+
+// MarshalJSON writes Meta with the legacy per-stage timestamps next to Stages.
+func (m Meta) MarshalJSON() ([]byte, error) {
+	var legacy legacyStageTimes
+	for i, ts := range legacy.fields() {
+		*ts = m.At(legacyStages[i])
+	}
+	return codec.Marshal(struct {
+		metaFields
+		legacyStageTimes
+	}{metaFields(m), legacy})
+}
+
+// UnmarshalJSON reads Meta, taking the stages from the legacy per-stage timestamps when the
+// sender did not write Stages.
+func (m *Meta) UnmarshalJSON(data []byte) error {
+	var v struct {
+		metaFields
+		legacyStageTimes
+	}
+	if err := codec.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*m = Meta(v.metaFields)
+	if len(m.Stages) == 0 {
+		for i, ts := range v.legacyStageTimes.fields() {
+			if *ts != 0 {
+				m.Stages = append(m.Stages, StageEvent{Name: legacyStages[i], TsNs: *ts})
+			}
+		}
+	}
+	return nil
+}
+
+// queueWait returns the milliseconds msg waited between the gateway's push and this worker's
+// pull. Clock skew between the two hosts can put the pull before the push; such a wait, like
+// one of a job without a (positive) push stamp, is not ok.
+func queueWait(msg *Message) (float64, bool) {
+	pushed, pulled := msg.Meta.At(stageRestRequestPushed), msg.Meta.At(stageWorkerRequestPulled)
+	if pushed <= 0 || pulled < pushed {
+		return 0, false
+	}
+	return float64(pulled-pushed) / 1e6, true
+}
+
+// observePullToPush records how long the worker held msg, once its response is marked pushed;
+// the queue wait is observed at pull by observeQueueWait.
+func observePullToPush(msg *Message) {
+	if pulled := msg.Meta.At(stageWorkerRequestPulled); pulled != 0 {
+		ms := float64(msg.Meta.At(stageWorkerResponsePushed)-pulled) / 1e6
+		HistogramStagePullToPush.WithLabelValues(strconv.FormatBool(msg.Data.Result)).Observe(ms)
+		observeJobTime(ms)
+	}
+}
@@ -0,0 +1,27 @@
+package worker
+
+import (
+	"testing"
+	"testing/quick"
+)
+
+func TestQueueWaitIsNeverNegative(t *testing.T) {
+	property := func(pushed, pulled int64, missing uint8) bool {
+		var msg Message
+		if missing&1 == 0 {
+			msg.Meta.Stages = append(msg.Meta.Stages, StageEvent{Name: stageRestRequestPushed, TsNs: pushed})
+		}
+		if missing&2 == 0 {
+			msg.Meta.Stages = append(msg.Meta.Stages, StageEvent{Name: stageWorkerRequestPulled, TsNs: pulled})
+		}
+		observeQueueWait(&msg)
+		wait, ok := queueWait(&msg)
+		if !ok {
+			return wait == 0
+		}
+		return wait >= 0 && msg.Meta.At(stageRestRequestPushed) > 0
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 10000}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,130 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// --- Downstream Limits ---
+
+// CALLOUT_LIMITS keeps a flood of queued jobs from overwhelming the services the callout
+// handler calls: a JSON object of downstream host to DownstreamLimit ("*" applies to hosts
+// without an entry of their own). A call waits for a token of its downstream's bucket and for a
+// free concurrency slot; when it would wait longer than MaxWaitMs it is shed instead, failing
+// the job without calling the downstream or counting against its circuit breaker.
+
+// DownstreamLimit caps the calls to one downstream: at most Rate calls per second (0 = no cap)
+// with bursts of up to Burst (default 1), at most Concurrency calls at once (0 = no cap), and
+// waits of at most MaxWaitMs for either (0 = wait for as long as the job may).
+type DownstreamLimit struct {
+	Rate        float64 `json:"rate"`
+	Burst       int     `json:"burst"`
+	Concurrency int     `json:"concurrency"`
+	MaxWaitMs   int     `json:"max_wait_ms"`
+}
+
+// downstreamShedError is returned without calling a downstream saturated past its limits.
+type downstreamShedError struct {
+	downstream string
+	limit      string
+}
+
+func (e *downstreamShedError) Error() string {
+	return fmt.Sprintf("shed: downstream %s is over its %s limit", e.downstream, e.limit)
+}
+
+// downstreamLimiter enforces the DownstreamLimit of one downstream.
+type downstreamLimiter struct {
+	name    string
+	bucket  *tokenBucket
+	slots   chan struct{}
+	maxWait time.Duration
+}
+
+// parseDownstreamLimits reads CALLOUT_LIMITS.
+func parseDownstreamLimits() (map[string]DownstreamLimit, error) {
+	raw := envString("CALLOUT_LIMITS", "")
+	if raw == "" {
+		return nil, nil
+	}
+	var limits map[string]DownstreamLimit
+	if err := codec.Unmarshal([]byte(raw), &limits); err != nil {
+		return nil, fmt.Errorf("invalid CALLOUT_LIMITS: %w", err)
+	}
+	for host, limit := range limits {
+		if limit.Rate < 0 || limit.Burst < 0 || limit.Concurrency < 0 || limit.MaxWaitMs < 0 {
+			return nil, fmt.Errorf("invalid CALLOUT_LIMITS for %q: values must not be negative", host)
+		}
+	}
+	return limits, nil
+}
+
+// newDownstreamLimiter returns the limiter of the downstream name, nil when it is not limited.
+func newDownstreamLimiter(name string, limits map[string]DownstreamLimit) *downstreamLimiter {
+	limit, ok := limits[name]
+	if !ok {
+		if limit, ok = limits["*"]; !ok {
+			return nil
+		}
+	}
+	l := &downstreamLimiter{name: name, maxWait: time.Duration(limit.MaxWaitMs) * time.Millisecond}
+	if limit.Rate > 0 {
+		l.bucket = newTokenBucket(limit.Rate, limit.Burst)
+	}
+	if limit.Concurrency > 0 {
+		l.slots = make(chan struct{}, limit.Concurrency)
+	}
+	return l
+}
+
+// acquire blocks until a call may be made and returns the function releasing its slot, or sheds
+// the call when it would have to wait past the limiter's maximum wait or ctx.
+func (l *downstreamLimiter) acquire(ctx context.Context) (func(), error) {
+	started := clock.Now()
+	if l.bucket != nil {
+		if wait := l.bucket.take(); wait > 0 {
+			if l.maxWait > 0 && wait > l.maxWait {
+				l.bucket.refund()
+				return nil, l.shed("rate")
+			}
+			CounterDownstreamThrottled.WithLabelValues(l.name, "rate").Inc()
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+	}
+	if l.slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+	default:
+		CounterDownstreamThrottled.WithLabelValues(l.name, "concurrency").Inc()
+		var timeout <-chan time.Time
+		if l.maxWait > 0 {
+			timer := time.NewTimer(max(l.maxWait-since(started), 0))
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case l.slots <- struct{}{}:
+		case <-timeout:
+			return nil, l.shed("concurrency")
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	GaugeDownstreamInFlight.WithLabelValues(l.name).Inc()
+	return func() {
+		GaugeDownstreamInFlight.WithLabelValues(l.name).Dec()
+		<-l.slots
+	}, nil
+}
+
+func (l *downstreamLimiter) shed(limit string) error {
+	CounterDownstreamShed.WithLabelValues(l.name, limit).Inc()
+	return &downstreamShedError{downstream: l.name, limit: limit}
+}
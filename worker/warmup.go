@@ -0,0 +1,53 @@
+package worker
+
+import "time"
+
+// --- Slow Start ---
+
+// A freshly started worker has cold caches, a cold handler and few Redis connections, so the
+// jobs it takes right after a deploy show up as a latency spike. For WARMUP_DURATION after
+// startup (0, the default, starts at full speed) the worker ramps up its claim rate: its
+// WORKER_CONCURRENCY consumers join one after another over the window, and each consumer pauses
+// before every pull for WARMUP_CLAIM_DELAY scaled by the share of the window left.
+
+var (
+	warmupDuration   = envDuration("WARMUP_DURATION", 0)
+	warmupClaimDelay = envDuration("WARMUP_CLAIM_DELAY", 500*time.Millisecond)
+
+	// warmupStarted is when the ramp began, set by startWarmup.
+	warmupStarted time.Time
+)
+
+// startWarmup begins the slow-start window.
+func startWarmup() {
+	warmupStarted = clock.Now()
+	if warmupDuration <= 0 {
+		GaugeWarmupProgress.Set(1)
+	}
+}
+
+// warmupProgress is how far the worker is into its slow-start window, from 0 to 1.
+func warmupProgress() float64 {
+	if warmupDuration <= 0 {
+		return 1
+	}
+	return min(float64(since(warmupStarted))/float64(warmupDuration), 1)
+}
+
+// warmUp holds consumer slot (0 to WORKER_CONCURRENCY-1) until it may pull its next job.
+func warmUp(slot int) {
+	for {
+		progress := warmupProgress()
+		GaugeWarmupProgress.Set(progress)
+		if progress >= 1 {
+			return
+		}
+		// Slot i joins after i/concurrency of the window
+		joinAt := float64(slot) / float64(concurrency)
+		if progress >= joinAt {
+			time.Sleep(time.Duration((1 - progress) * float64(warmupClaimDelay)))
+			return
+		}
+		time.Sleep(time.Duration((joinAt - progress) * float64(warmupDuration)))
+	}
+}
@@ -0,0 +1,120 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// --- WASM Handler ---
+
+// Tenants upload WASI modules through the gateway (PUT /modules/:type). They are stored in
+// validate:wasm:modules under "<tenant>/<job_type>", with their sha256 in validate:wasm:digests
+// so workers only refetch and recompile a module after it changed.
+//
+// A module reads the content from stdin and writes the new content to stdout; exit code 0
+// means valid. Every job gets a fresh instance, bounded by WASM_TIMEOUT and WASM_MEMORY_PAGES
+// (64 KiB each), and has no filesystem, network or environment access.
+
+const (
+	wasmModulesKey = "validate:wasm:modules"
+	wasmDigestsKey = "validate:wasm:digests"
+)
+
+type wasmModule struct {
+	digest   string
+	compiled wazero.CompiledModule
+}
+
+func newWasmHandler(rdb *redis.Client) (Handler, error) {
+	timeout := envDuration("WASM_TIMEOUT", 5*time.Second)
+	maxOutput := envInt("WASM_MAX_OUTPUT", 1<<20)
+
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithCloseOnContextDone(true).
+		WithMemoryLimitPages(uint32(envInt("WASM_MEMORY_PAGES", 256))))
+	wasi_snapshot_preview1.MustInstantiate(ctx, runtime)
+
+	var mu sync.Mutex
+	modules := map[string]*wasmModule{}
+
+	// load returns the compiled module for key, recompiling when the stored digest changed.
+	load := func(key string) (wazero.CompiledModule, error) {
+		digest, err := rdb.HGet(ctx, wasmDigestsKey, key).Result()
+		if err == redis.Nil {
+			return nil, fmt.Errorf("no wasm module uploaded for %q", key)
+		} else if err != nil {
+			return nil, err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if cached, ok := modules[key]; ok && cached.digest == digest {
+			return cached.compiled, nil
+		}
+
+		binary, err := rdb.HGet(ctx, wasmModulesKey, key).Bytes()
+		if err != nil {
+			return nil, err
+		}
+		compiled, err := runtime.CompileModule(ctx, binary)
+		if err != nil {
+			return nil, fmt.Errorf("cannot compile wasm module %q: %w", key, err)
+		}
+		if cached, ok := modules[key]; ok {
+			_ = cached.compiled.Close(ctx)
+		}
+		modules[key] = &wasmModule{digest: digest, compiled: compiled}
+		return compiled, nil
+	}
+
+	return func(ctx context.Context, msg *Message) error {
+		compiled, err := load(msg.Tenant + "/" + msg.JobType)
+		if err != nil {
+			return err
+		}
+
+		ctxTimeout, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		input, err := msg.Data.open(ctx, rdb)
+		if err != nil {
+			return fmt.Errorf("invalid content: %w", err)
+		}
+
+		stdout := &limitedBuffer{max: maxOutput}
+		config := wazero.NewModuleConfig().
+			WithName("").
+			WithStdin(input).
+			WithStdout(stdout)
+
+		module, err := runtime.InstantiateModule(ctxTimeout, compiled, config)
+		if module != nil {
+			defer module.Close(ctx)
+		}
+
+		var exitErr *sys.ExitError
+		switch {
+		case ctxTimeout.Err() != nil:
+			return fmt.Errorf("wasm module timed out after %s", timeout)
+		case stdout.truncated:
+			return fmt.Errorf("wasm module output exceeds %d bytes", maxOutput)
+		case err == nil, errors.As(err, &exitErr) && exitErr.ExitCode() == 0:
+			msg.Data.setBytes(stdout.Bytes())
+			msg.Data.Result = true
+		case errors.As(err, &exitErr):
+			msg.Data.setBytes(stdout.Bytes())
+			msg.Data.Result = false
+		default:
+			return err
+		}
+		return nil
+	}, nil
+}
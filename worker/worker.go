@@ -1,81 +1,300 @@
-package main
+package worker
 
 import (
 	"context"
 	"fmt"
-	jsoniter "github.com/json-iterator/go"
 	"github.com/redis/go-redis/v9"
-	"strings"
+	"log/slog"
+	"os"
+	"strconv"
 	"time"
 )
 
 var (
-	ctx  = context.Background()
-	json = jsoniter.ConfigFastest
+	ctx = context.Background()
 )
 
+// Meta records the pipeline stages the message went through, in order. Its JSON carries the
+// older per-stage timestamps as well, see legacyStageTimes.
 type Meta struct {
-	RestRequestReceived  int64 `json:"rest_request_received_ns"`
-	RestRequestPushed    int64 `json:"rest_request_pushed_ns"`
-	WorkerRequestPulled  int64 `json:"worker_request_pulled_ns"`
-	WorkerResponsePushed int64 `json:"worker_response_pushed_ns"`
-	RestResponsePulled   int64 `json:"rest_response_pulled_ns"`
-	RoundtripDurationNs  int64 `json:"rest_roundtrip_duration_ns"`
+	Stages              []StageEvent `json:"stages,omitempty"`
+	Attempts            []Attempt    `json:"attempts,omitempty"`
+	RoundtripDurationNs int64        `json:"rest_roundtrip_duration_ns"`
 }
 
 type Data struct {
 	Content string `json:"content"`
-	Result  bool   `json:"result"`
+	// Binary payloads carry their content base64 encoded.
+	Binary bool `json:"binary,omitempty"`
+	// File references an uploaded file stored in Redis, streamed instead of Content.
+	File *FileRef `json:"file,omitempty"`
+	// Chunks references a result too large to send inline, see publishChunks.
+	Chunks *ChunkRef    `json:"chunks,omitempty"`
+	Result bool         `json:"result"`
+	Rules  []RuleResult `json:"rules,omitempty"`
+}
+
+// FileRef points at an uploaded file the rest service stored in Redis.
+type FileRef struct {
+	Key         string `json:"key"`
+	Name        string `json:"name"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type,omitempty"`
 }
 
 type Message struct {
 	RequestID string `json:"request_id"`
-	Meta      Meta   `json:"meta"`
-	Data      Data   `json:"data"`
+	// TraceID correlates the request's log lines across services.
+	TraceID  string `json:"trace_id,omitempty"`
+	ReplyTo  string `json:"reply_to,omitempty"`
+	ReplyVia string `json:"reply_via,omitempty"`
+	Tenant   string `json:"tenant,omitempty"`
+	JobType  string `json:"job_type,omitempty"`
+	Geo      string `json:"geo,omitempty"`
+	// Region is the data region the job must be processed in, see checkResidency.
+	Region string `json:"region,omitempty"`
+	// Shadow copies are processed for comparison only, their results are dropped.
+	Shadow bool `json:"shadow,omitempty"`
+	// Flags are the feature flags the gateway turned on for this request.
+	Flags []string `json:"flags,omitempty"`
+	// Cost attributes the job to a team and cost center; it must survive the round trip.
+	Cost *CostLabels `json:"cost,omitempty"`
+	// Affinity is the key the gateway routed the job by; its jobs share a session, see Session.
+	Affinity string `json:"affinity,omitempty"`
+	// DependsOn lists the jobs the gateway held this one for; it must survive the round trip.
+	DependsOn []string `json:"depends_on,omitempty"`
+	// QueueDeadlineNs is when the gateway stops wanting the job processed, 0 for never.
+	QueueDeadlineNs int64 `json:"queue_deadline_ns,omitempty"`
+	// Worker is stamped by the worker that produced the result.
+	Worker *WorkerInfo `json:"worker,omitempty"`
+	Meta   Meta        `json:"meta"`
+	Data   Data        `json:"data"`
+}
+
+// hasFlag reports whether the gateway turned flag on for this message.
+func (m *Message) hasFlag(flag string) bool {
+	for _, name := range m.Flags {
+		if name == flag {
+			return true
+		}
+	}
+	return false
 }
 
 func nowNs() int64 {
-	return time.Now().UnixNano()
+	return clock.Now().UnixNano()
 }
 
-func main() {
+// Main runs the worker until the process exits: the worker binary (cmd/worker) calls it, and the
+// all-in-one binary runs it next to the gateway.
+func Main() {
+	concurrency = max(envInt("WORKER_CONCURRENCY", 1), 1)
+	initLogging()
+	if err := initCodec(); err != nil {
+		slog.Error("Cannot init codec", "error", err)
+		os.Exit(1)
+	}
+
+	if err := initRedaction(); err != nil {
+		slog.Error("Cannot init redaction", "error", err)
+		os.Exit(1)
+	}
+
+	initBuildInfo()
+	if err := initSecrets(); err != nil {
+		slog.Error("Cannot init secrets", "error", err)
+		os.Exit(1)
+	}
+
+	// Every consumer holds a connection in BLPOP, on top of the ones handlers use
 	rdb := redis.NewClient(&redis.Options{
-		Addr: "redis:6379",
+		Addr:     envString("REDIS_ADDR", "redis:6379"),
+		PoolSize: concurrency + 10,
+		// Asked on every new connection, so a rotated password is picked up
+		CredentialsProvider: func() (string, string) {
+			return envString("REDIS_USERNAME", ""), secret("REDIS_PASSWORD", "")
+		},
 	})
 
+	if err := preflight(rdb); err != nil {
+		slog.Error("Preflight failed", "error", err)
+		os.Exit(1)
+	}
+
+	startConfigReloader(rdb)
+
+	handler, err := initHandler(rdb)
+	if err != nil {
+		slog.Error("Cannot init handler", "error", err)
+		os.Exit(1)
+	}
+
+	startHeartbeat(rdb)
+	startControlListener(rdb)
+	startMetricsServer()
+
+	if err := initJobLimits(); err != nil {
+		slog.Error("Cannot init job limits", "error", err)
+		os.Exit(1)
+	}
+	if err := initFairScheduling(); err != nil {
+		slog.Error("Cannot init fair scheduling", "error", err)
+		os.Exit(1)
+	}
+	if err := initBulkheads(rdb, handler); err != nil {
+		slog.Error("Cannot init bulkheads", "error", err)
+		os.Exit(1)
+	}
+	logStartupBacklog(rdb)
+	startWarmup()
+	for i := 0; i < concurrency; i++ {
+		go consume(rdb, handler, i)
+	}
+	select {}
+}
+
+// consume pulls and processes jobs one at a time; WORKER_CONCURRENCY of them run side by side,
+// each in its own slot.
+func consume(rdb *redis.Client, handler Handler, slot int) {
 	for {
-		result, err := rdb.BLPop(ctx, 0, "validate:queue").Result()
+		waitWhileDraining()
+		warmUp(slot)
+		queue, payload, err := popJob(rdb)
 		if err != nil {
-			fmt.Println("Queue error:", err)
+			slog.Error("Queue pop failed", "queue", queue, "error", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		if draining.Load() {
+			// Drained while blocked on the pop: the job goes back to the head of its queue
+			if err := requeueJob(rdb, queue, []byte(payload)).Err(); err != nil {
+				slog.Error("Cannot requeue job on drain", "queue", queue, "error", err)
+			}
 			continue
 		}
 
 		var msg Message
-		if err := json.Unmarshal([]byte(result[1]), &msg); err != nil {
-			fmt.Println("Invalid message:", err)
+		if err := codec.Unmarshal([]byte(payload), &msg); err != nil {
+			slog.Warn("Invalid message", "queue", queue, "error", err)
 			continue
 		}
 
-		msg.Meta.WorkerRequestPulled = nowNs()
-
-		// Simulate processing
-		msg.Data.Content = strings.ToUpper(msg.Data.Content)
-		msg.Data.Result = true
+		msg.Meta.Mark(stageWorkerRequestPulled)
+		observeQueueWait(&msg)
+		observeAffinity(queue)
+		jobCtx := withSession(withLogger(ctx, jobLogger(&msg)), &msg)
+		if b := bulkheadFor(msg.JobType); b != nil {
+			b.submit(jobCtx, &msg, queue, payload)
+			continue
+		}
+		runJob(jobCtx, rdb, handler, &msg)
+	}
+}
 
-		msg.Meta.WorkerResponsePushed = nowNs()
+// runJob processes msg, dead-lettering it when processing panicked.
+func runJob(ctx context.Context, rdb *redis.Client, handler Handler, msg *Message) {
+	if err := safeProcessJob(ctx, rdb, handler, msg); err != nil {
+		loggerFrom(ctx).Error("Job processing panicked", "error", err)
+		deadLetter(ctx, rdb, msg, err)
+	}
+}
 
-		// Redis pipeline: RPush + Expire
-		resultKey := fmt.Sprintf("validate:response:%s", msg.RequestID)
-		payload, _ := json.Marshal(msg)
+// safeProcessJob keeps a panic outside the handler (e.g. in a codec) from killing the consumer.
+func safeProcessJob(ctx context.Context, rdb *redis.Client, handler Handler, msg *Message) (err error) {
+	defer recoverPanic(&err)
+	processJob(ctx, rdb, handler, msg)
+	return nil
+}
 
-		pipe := rdb.Pipeline()
-		pipe.RPush(ctx, resultKey, payload)
-		pipe.Expire(ctx, resultKey, time.Hour)
-		if _, err := pipe.Exec(ctx); err != nil {
-			fmt.Println("Pipeline push failed:", err)
-			continue
+// processJob handles one job; ctx carries the job's logger.
+func processJob(ctx context.Context, rdb *redis.Client, handler Handler, msg *Message) {
+	logger := loggerFrom(ctx)
+	err := checkStaleJob(msg)
+	if stale, ok := err.(*staleJobError); ok && stale.archived() {
+		archiveStaleJob(ctx, rdb, msg, stale)
+		return
+	}
+	if err == nil {
+		err = checkQueueDeadline(msg)
+	}
+	if err == nil {
+		err = checkCancelled(rdb, msg)
+	}
+	if err == nil {
+		err = checkResidency(msg)
+	}
+	if err != nil {
+		skipJob(ctx, msg, err)
+	} else {
+		if limiter := limiterFor(msg.JobType); limiter != nil {
+			release := limiter.acquire()
+			defer release()
 		}
+		reportProgress(rdb, msg, progressProcessing)
+		if err := runAttempts(ctx, handler, msg); err != nil {
+			logger.Warn("Handler failed", "error", err)
+			msg.Data.Result = false
+			deadLetter(ctx, rdb, msg, err)
+		}
+	}
+	respond(ctx, rdb, msg)
+}
 
-		fmt.Println("Processed:", msg.RequestID)
+// skipJob fails msg without running the handler, recording err as its only attempt.
+func skipJob(ctx context.Context, msg *Message, err error) {
+	loggerFrom(ctx).Warn("Job not processed", "error", err)
+	now := nowNs()
+	msg.Meta.Attempts = append(msg.Meta.Attempts, Attempt{WorkerID: workerID, StartNs: now, EndNs: now, Error: err.Error()})
+	msg.Data.Result = false
+}
+
+// respond stamps msg and answers the gateway with it.
+func respond(ctx context.Context, rdb *redis.Client, msg *Message) {
+	logger := loggerFrom(ctx)
+	msg.Worker = workerInfo
+	msg.Meta.Mark(stageWorkerResponsePushed)
+	observePullToPush(msg)
+	recordCost(msg)
+
+	if msg.Shadow {
+		CounterShadowResults.WithLabelValues(strconv.FormatBool(msg.Data.Result)).Inc()
+		logger.Info("Shadowed", "result", msg.Data.Result)
+		return
 	}
+
+	if err := publishChunks(rdb, msg); err != nil {
+		logger.Error("Chunk publish failed", "error", err)
+		return
+	}
+	payload, _ := codec.Marshal(msg)
+	if err := pushResponse(rdb, msg, payload); err != nil {
+		logger.Error("Response push failed", "error", err)
+		return
+	}
+
+	logger.Info("Processed", "result", msg.Data.Result, "attempts", len(msg.Meta.Attempts), "version", version)
+}
+
+// pushResponse answers the gateway the way the request asked for. Pub/Sub replies fall back to
+// the request's own response key when no gateway is subscribed anymore, so the gateway's
+// janitor can still apply its late result policy.
+func pushResponse(rdb *redis.Client, msg *Message, payload []byte) error {
+	resultKey := msg.ReplyTo
+	if msg.ReplyVia == "pubsub" {
+		receivers, err := rdb.Publish(ctx, msg.ReplyTo, payload).Result()
+		if err == nil && receivers > 0 {
+			return nil
+		}
+		resultKey = ""
+	}
+	if resultKey == "" {
+		resultKey = fmt.Sprintf("validate:response:%s", msg.RequestID)
+	}
+
+	// Redis transaction: RPush + Expire, so a response key never exists without a TTL
+	pipe := rdb.TxPipeline()
+	pipe.RPush(ctx, resultKey, payload)
+	pipe.Expire(ctx, resultKey, responseTTL.Get())
+	_, err := pipe.Exec(ctx)
+	return err
 }